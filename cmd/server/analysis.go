@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"mailcatch/internal/blob"
+	"mailcatch/internal/config"
+	"mailcatch/internal/mailauth"
+	"mailcatch/internal/models"
+	"mailcatch/internal/secure"
+	"mailcatch/internal/smtp"
+	"mailcatch/internal/spam"
+	"mailcatch/internal/textcheck"
+)
+
+// spamdTimeout bounds the time spamd takes to score a message
+const spamdTimeout = 2 * time.Second
+
+// newSMTPOptions returns the message limits of the configuration, shared
+// by the SMTP server and the importers
+func newSMTPOptions(cfg *config.Config, blobs *blob.Store) smtp.Options {
+	return smtp.Options{
+		Blobs:          blobs,
+		MaxMessageSize: cfg.MaxMessageSize,
+		InlineLimit:    cfg.InlineLimit,
+	}
+}
+
+// newAnalyzer returns the checks received and imported messages go
+// through: authentication, S/MIME and OpenPGP, spam scoring and text
+// checks
+func newAnalyzer(cfg *config.Config, blobs *blob.Store) (func(*models.Email), error) {
+	// DNS answers come from local files unless live DNS is explicitly
+	// enabled
+	staticResolver := mailauth.NewStaticResolver()
+	if cfg.DNSZone != "" {
+		if err := staticResolver.LoadZoneFile(cfg.DNSZone); err != nil {
+			return nil, fmt.Errorf("failed to load DNS zone file: %w", err)
+		}
+	}
+	if cfg.DKIMKeys != "" {
+		if err := staticResolver.LoadKeyMap(cfg.DKIMKeys); err != nil {
+			return nil, fmt.Errorf("failed to load DKIM key map: %w", err)
+		}
+	}
+	var resolver mailauth.Resolver = staticResolver
+	if cfg.LiveDNS {
+		resolver = mailauth.ChainResolver{staticResolver, mailauth.NewSystemResolver(5 * time.Second)}
+	}
+	verifier := mailauth.NewVerifier(resolver)
+
+	inspector := secure.NewInspector()
+	if cfg.SMIMEKeys != "" {
+		for _, path := range strings.Split(cfg.SMIMEKeys, ",") {
+			if err := inspector.LoadSMIMEKeys(strings.TrimSpace(path)); err != nil {
+				return nil, fmt.Errorf("failed to load S/MIME keys: %w", err)
+			}
+		}
+	}
+	if cfg.SMIMERoots != "" {
+		if err := inspector.LoadSMIMERoots(cfg.SMIMERoots); err != nil {
+			return nil, fmt.Errorf("failed to load S/MIME roots: %w", err)
+		}
+	}
+	if cfg.PGPKeyring != "" {
+		if err := inspector.LoadPGPKeyring(cfg.PGPKeyring, cfg.PGPPassphrase); err != nil {
+			return nil, fmt.Errorf("failed to load PGP keyring: %w", err)
+		}
+	}
+
+	// Messages are scored before the SMTP reply, so a slow spamd must not
+	// hold up senders; past the timeout the built-in rules score them
+	scorer := spam.NewScorer(cfg.SpamThreshold)
+	if cfg.Spamd != "" {
+		scorer.UseSpamd(cfg.Spamd, spamdTimeout)
+	}
+
+	return func(email *models.Email) {
+		// Each check streams the raw message, which may live in the blob store
+		for _, check := range []func(*models.Email, io.Reader){verifier.Verify, inspector.Inspect, scorer.Score} {
+			raw, err := blobs.OpenRaw(email)
+			if err != nil {
+				log.Printf("Error opening raw message: %v", err)
+				break
+			}
+			check(email, raw)
+			raw.Close()
+		}
+		textcheck.Check(email)
+	}, nil
+}
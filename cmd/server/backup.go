@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"mailcatch/internal/backup"
+	"mailcatch/internal/storage"
+)
+
+// runBackup writes a backup of the database and its blobs, read directly
+// or downloaded from a running server
+func runBackup(args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	var db storageFlags
+	db.register(flags)
+	output := flags.String("output", "-", "Backup file (- for standard output)")
+	server := flags.String("server", "", "Download the backup from the server at this URL, e.g. http://localhost:8080, instead of opening the database")
+	db.parse(flags, args)
+
+	writer, closeOutput, err := createOutput(*output)
+	if err != nil {
+		return err
+	}
+
+	destination := *output
+	if destination == "-" {
+		destination = "standard output"
+	}
+
+	if *server != "" {
+		err := downloadBackup(*server, writer)
+		if err := closeOutput(err == nil); err != nil {
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		log.Printf("Downloaded backup from %s to %s", *server, destination)
+		return nil
+	}
+
+	store, blobs, err := db.open()
+	if err != nil {
+		closeOutput(false)
+		return err
+	}
+	defer store.Close()
+
+	manifest, err := backup.Write(writer, store, blobs)
+	if err := closeOutput(err == nil); err != nil {
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if manifest.MissingBlobs > 0 {
+		log.Printf("%d blobs were deleted during the backup along with their emails", manifest.MissingBlobs)
+	}
+	log.Printf("Backed up %d emails and %d blobs to %s", manifest.Emails, manifest.Blobs, destination)
+	return nil
+}
+
+// downloadBackup copies the backup served by GET /api/admin/backup to w
+func downloadBackup(server string, w io.Writer) error {
+	resp, err := http.Get(strings.TrimSuffix(server, "/") + "/api/admin/backup")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var body struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&body) == nil && body.Error != "" {
+			return fmt.Errorf("server returned %s: %s", resp.Status, body.Error)
+		}
+		return fmt.Errorf("server returned %s", resp.Status)
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// runRestore replaces the database and its blobs with a backup. The
+// server must be stopped.
+func runRestore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	var db storageFlags
+	db.register(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s restore [options] backup.zip|-\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	db.parse(flags, args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("expected one backup file")
+	}
+
+	options, err := storage.ParseOptions(db.options)
+	if err != nil {
+		return err
+	}
+
+	file, err := openBackup(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	manifest, backend, err := backup.Restore(file, info.Size(), backup.Target{
+		Backend: db.backend,
+		Path:    db.dbPath,
+		Options: options,
+		BlobDir: db.blobPath(),
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Restored %d emails and %d blobs from a backup of %s to the %s backend",
+		manifest.Emails, manifest.Blobs, manifest.CreatedAt.Format("2006-01-02 15:04:05"), backend.Name)
+	return nil
+}
+
+// openBackup opens a backup file. Zip files are read from their end, so
+// standard input is spooled to a temporary file, removed once closed.
+func openBackup(path string) (*os.File, error) {
+	if path != "-" {
+		return os.Open(path)
+	}
+
+	spool, err := os.CreateTemp("", "mailcatch-restore-*.zip")
+	if err != nil {
+		return nil, err
+	}
+	// The open file stays readable after its name is removed
+	os.Remove(spool.Name())
+	if _, err := io.Copy(spool, os.Stdin); err != nil {
+		spool.Close()
+		return nil, err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		spool.Close()
+		return nil, err
+	}
+	return spool, nil
+}
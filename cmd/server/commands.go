@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"mailcatch/internal/blob"
+	"mailcatch/internal/storage"
+)
+
+// commands run instead of the server when named as the first argument
+var commands = map[string]func(args []string) error{
+	"export":  runExport,
+	"import":  runImport,
+	"backup":  runBackup,
+	"restore": runRestore,
+}
+
+// storageFlags select the database of a command the way the server
+// options do. As for the server, environment variables override flags.
+type storageFlags struct {
+	dbPath  string
+	blobDir string
+	backend string
+	options string
+}
+
+func (f *storageFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&f.dbPath, "db-path", "./data/emails.db", "Database file path")
+	flags.StringVar(&f.blobDir, "blob-dir", "", "Blob directory (default: blobs next to the database)")
+	flags.StringVar(&f.backend, "storage", "auto", "Storage backend")
+	flags.StringVar(&f.options, "storage-opts", "", "Comma-separated key=value options of the storage backend")
+}
+
+// parse parses the command line and applies the environment variables
+func (f *storageFlags) parse(flags *flag.FlagSet, args []string) {
+	flags.Parse(args)
+
+	if path := os.Getenv("DB_PATH"); path != "" {
+		f.dbPath = path
+	}
+	if path := os.Getenv("BLOB_DIR"); path != "" {
+		f.blobDir = path
+	}
+	if backend := os.Getenv("STORAGE"); backend != "" {
+		f.backend = backend
+	}
+	if options := os.Getenv("STORAGE_OPTS"); options != "" {
+		f.options = options
+	}
+}
+
+// open opens the storage and blob store selected by the flags
+func (f *storageFlags) open() (storage.Storage, *blob.Store, error) {
+	options, err := storage.ParseOptions(f.options)
+	if err != nil {
+		return nil, nil, err
+	}
+	instance, backend, err := openStorage(f.backend, f.dbPath, options)
+	if errors.Is(err, storage.ErrInUse) {
+		return nil, nil, fmt.Errorf("%w; stop the server or go through it: POST /api/import, GET /api/export or backup --server", err)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	blobs := blob.NewMemoryStore()
+	if !backend.Ephemeral {
+		blobs, err = blob.NewStore(f.blobPath())
+		if err != nil {
+			instance.Close()
+			return nil, nil, fmt.Errorf("failed to open blob store: %w", err)
+		}
+	}
+	deleteEvictedBlobs(instance, blobs)
+	return instance, blobs, nil
+}
+
+// blobPath returns the blob directory, by default next to the database
+func (f *storageFlags) blobPath() string {
+	if f.blobDir != "" {
+		return f.blobDir
+	}
+	return filepath.Join(filepath.Dir(f.dbPath), "blobs")
+}
+
+// createOutput opens an output file, or standard output for "-".
+// closeOutput releases it, removing a partly written file unless the
+// command completed.
+func createOutput(output string) (io.Writer, func(complete bool) error, error) {
+	if output == "-" {
+		return os.Stdout, func(bool) error { return nil }, nil
+	}
+
+	file, err := os.Create(output)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, func(complete bool) error {
+		err := file.Close()
+		if !complete {
+			os.Remove(output)
+		}
+		return err
+	}, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"mailcatch/internal/export"
+	"mailcatch/internal/storage"
+)
+
+// runExport writes the emails matching a list filter as mbox, Maildir or
+// a zip of .eml files
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	var db storageFlags
+	db.register(flags)
+	format := flags.String("format", export.FormatMbox, "Output format: "+strings.Join(export.Formats, ", "))
+	output := flags.String("output", "-", "Output file, or directory for maildir (- for standard output)")
+	query := flags.String("query", "", "Filter in the query syntax of GET /api/emails, e.g. 'to=ci@example.com&since=2024-01-01'")
+	db.parse(flags, args)
+
+	values, err := url.ParseQuery(*query)
+	if err != nil {
+		return fmt.Errorf("invalid query: %w", err)
+	}
+	filter, err := storage.ParseFilter(values)
+	if err != nil {
+		return err
+	}
+
+	if *format == export.FormatMaildir && *output == "-" {
+		return fmt.Errorf("maildir export needs an --output directory")
+	}
+	writer, closeOutput, err := exportOutput(*format, *output)
+	if err != nil {
+		return err
+	}
+
+	store, blobs, err := db.open()
+	if err != nil {
+		closeOutput(false)
+		return err
+	}
+	defer store.Close()
+
+	count, err := export.Export(store, blobs, filter, writer)
+	if err == nil {
+		err = writer.Close()
+	}
+	if err := closeOutput(err == nil); err != nil {
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	destination := *output
+	if destination == "-" {
+		destination = "standard output"
+	}
+	log.Printf("Exported %d emails to %s", count, destination)
+	return nil
+}
+
+// exportOutput opens the writer of an export. closeOutput releases the
+// output, removing a partly written file unless the export completed.
+func exportOutput(format, output string) (export.Writer, func(complete bool) error, error) {
+	if format == export.FormatMaildir {
+		writer, err := export.NewMaildirWriter(output)
+		return writer, func(bool) error { return nil }, err
+	}
+
+	file, closeOutput, err := createOutput(output)
+	if err != nil {
+		return nil, nil, err
+	}
+	writer, err := export.NewWriter(format, file)
+	if err != nil {
+		closeOutput(false)
+		return nil, nil, err
+	}
+	return writer, closeOutput, nil
+}
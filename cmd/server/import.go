@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"mailcatch/internal/config"
+	"mailcatch/internal/importer"
+)
+
+// runImport loads mbox, Maildir, .eml and zip files into the database.
+// It takes the server options, so imported emails are checked and stored
+// the way received ones are.
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	format := flags.String("format", "", "Input format: mbox, maildir, eml or zip (default: detected)")
+	preserveDates := flags.Bool("preserve-dates", false, "Keep the original dates of the messages instead of the import time")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s import [options] file|maildir|- ...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	cfg := config.Parse(flags, args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("no files to import")
+	}
+
+	db := storageFlags{dbPath: cfg.DBPath, blobDir: cfg.BlobDir, backend: cfg.Storage, options: cfg.StorageOptions}
+	store, blobs, err := db.open()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	analyze, err := newAnalyzer(cfg, blobs)
+	if err != nil {
+		return err
+	}
+	mailImporter := importer.New(store, newSMTPOptions(cfg, blobs), analyze)
+	options := importer.Options{Format: *format, PreserveDates: *preserveDates}
+
+	imported := 0
+	for _, path := range flags.Args() {
+		var result *importer.Result
+		if path == "-" {
+			result, err = mailImporter.Import(os.Stdin, "stdin", options, nil)
+		} else {
+			result, err = mailImporter.ImportPath(path, options, nil)
+		}
+		if result != nil {
+			imported += result.Imported
+			for _, failure := range result.Failed {
+				if failure.Index > 0 {
+					log.Printf("Skipped message %d of %s: %s", failure.Index, failure.File, failure.Error)
+				} else {
+					log.Printf("Skipped %s: %s", failure.File, failure.Error)
+				}
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w (%d emails imported)", path, err, imported)
+		}
+	}
+
+	log.Printf("Imported %d emails", imported)
+	return nil
+}
@@ -6,16 +6,34 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
+	"time"
 
+	"mailcatch/internal/blob"
+	"mailcatch/internal/compat"
 	"mailcatch/internal/config"
+	"mailcatch/internal/extract"
+	"mailcatch/internal/importer"
+	"mailcatch/internal/links"
 	"mailcatch/internal/models"
+	"mailcatch/internal/retention"
 	"mailcatch/internal/smtp"
 	"mailcatch/internal/storage"
 	"mailcatch/internal/web"
 )
 
 func main() {
+	// Subcommands work on the database instead of running the server
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				log.Fatalf("%s failed: %v", os.Args[1], err)
+			}
+			return
+		}
+	}
+	
 	// Load configuration
 	cfg := config.Load()
 	
@@ -31,29 +49,101 @@ func main() {
 		log.Printf("Log file: %s", cfg.LogPath)
 	}
 	
-	// Initialize storage (try SQLite first, fallback to BoltDB)
-	var storageInstance storage.Storage
-	
-	sqliteStorage, err := storage.NewSQLiteStorage(cfg.DBPath)
+	storageOptions, err := storage.ParseOptions(cfg.StorageOptions)
 	if err != nil {
-		log.Printf("SQLite not available (CGO disabled), trying BoltDB: %v", err)
-		boltStorage, err := storage.NewBoltStorage(cfg.DBPath)
+		log.Fatalf("Failed to parse storage options: %v", err)
+	}
+	
+	// Report pending schema migrations without touching the database
+	if cfg.MigrateDryRun {
+		status, err := migrationStatus(cfg.Storage, cfg.DBPath, storageOptions)
 		if err != nil {
-			log.Fatalf("Failed to initialize storage: %v", err)
+			log.Fatalf("Failed to check migrations: %v", err)
 		}
-		storageInstance = boltStorage
-		log.Println("Using BoltDB storage")
-	} else {
-		storageInstance = sqliteStorage
-		log.Println("Using SQLite storage")
+		reportMigrations(status)
+		return
+	}
+	
+	// Initialize storage
+	storageInstance, backend, err := openStorage(cfg.Storage, cfg.DBPath, storageOptions)
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
 	}
+	log.Printf("Using %s storage", backend.Name)
 	defer storageInstance.Close()
 	
+	// Initialize the blob store for spooled messages and large attachments,
+	// kept in memory along with ephemeral storage
+	blobs := blob.NewMemoryStore()
+	if !backend.Ephemeral {
+		blobs, err = blob.NewStore(cfg.BlobDir)
+		if err != nil {
+			log.Fatalf("Failed to initialize blob store: %v", err)
+		}
+	}
+	deleteEvictedBlobs(storageInstance, blobs)
+	
+	// Load the email client compatibility dataset
+	dataset, err := compat.DefaultDataset()
+	if cfg.CompatData != "" {
+		dataset, err = compat.LoadDataset(cfg.CompatData)
+	}
+	if err != nil {
+		log.Fatalf("Failed to load compatibility data: %v", err)
+	}
+	
+	// Link checking only probes the allow-listed base URLs
+	var linkChecker *links.Checker
+	if cfg.LinkCheckBase != "" {
+		linkChecker, err = links.NewChecker(strings.Split(cfg.LinkCheckBase, ","), 10*time.Second)
+		if err != nil {
+			log.Fatalf("Failed to configure link checker: %v", err)
+		}
+	}
+	
+	// Load the extract presets used by tests to find codes and links
+	extractor := extract.NewExtractor()
+	if cfg.Extractors != "" {
+		if err := extractor.LoadPresets(cfg.Extractors); err != nil {
+			log.Fatalf("Failed to load extract presets: %v", err)
+		}
+	}
+	
+	// Retention limits are enforced by a janitor, only when some are set
+	retentionOptions := retention.Options{
+		Default: retention.Policy{
+			MaxAge:   cfg.RetentionMaxAge,
+			MaxCount: cfg.RetentionMaxCount,
+			MaxBytes: cfg.RetentionMaxSize,
+		},
+		Interval: cfg.RetentionInterval,
+	}
+	if cfg.RetentionMailboxes != "" {
+		retentionOptions.Mailboxes, err = retention.LoadMailboxes(cfg.RetentionMailboxes, retentionOptions.Default)
+		if err != nil {
+			log.Fatalf("Failed to load retention mailboxes: %v", err)
+		}
+	}
+	var janitor *retention.Janitor
+	if retentionOptions.Enabled() {
+		janitor = retention.NewJanitor(storageInstance, blobs, retentionOptions)
+		janitor.Start()
+	}
+	
+	// Received and imported messages go through the same checks
+	analyze, err := newAnalyzer(cfg, blobs)
+	if err != nil {
+		log.Fatalf("Failed to initialize message checks: %v", err)
+	}
+	smtpOptions := newSMTPOptions(cfg, blobs)
+	
 	// Initialize web server
-	webServer := web.NewServer(storageInstance)
+	mailImporter := importer.New(storageInstance, smtpOptions, analyze)
+	webServer := web.NewServer(storageInstance, blobs, compat.NewLinter(dataset), linkChecker, extractor, janitor, mailImporter)
 	
 	// Initialize SMTP server with email handler
-	smtpServer := smtp.NewServer(cfg.SMTPPort, func(email *models.Email) {
+	smtpServer := smtp.NewServer(cfg.SMTPPort, smtpOptions, func(email *models.Email) {
+		analyze(email)
 		webServer.GetEmailHandler()(email)
 	})
 	
@@ -79,12 +169,19 @@ func main() {
 	
 	log.Println("Shutting down servers...")
 	smtpServer.Stop()
+	if janitor != nil {
+		janitor.Stop()
+	}
 	
 	// Clear all test emails on shutdown (if enabled)
 	if cfg.ClearOnShutdown {
 		log.Println("Clearing all test emails...")
-		if err := storageInstance.ClearEmails(); err != nil {
+		if err := deleteSnapshots(storageInstance); err != nil {
+			log.Printf("Error deleting snapshots: %v", err)
+		} else if err := storageInstance.ClearEmails(); err != nil {
 			log.Printf("Error clearing emails: %v", err)
+		} else if err := blobs.Clear(); err != nil {
+			log.Printf("Error clearing blobs: %v", err)
 		} else {
 			log.Println("All test emails cleared")
 		}
@@ -93,6 +190,96 @@ func main() {
 	log.Println("Servers stopped")
 }
 
+// deleteEvictedBlobs removes the blobs of the emails a storage evicts on
+// its own, as the retention janitor does for the emails it evicts
+func deleteEvictedBlobs(store storage.Storage, blobs *blob.Store) {
+	if evicter, ok := store.(storage.Evicter); ok {
+		evicter.OnEvict(func(email *models.Email) {
+			retention.DeleteBlobs(blobs, email)
+		})
+	}
+}
+
+// deleteSnapshots deletes the snapshots of the storage, so clearing it
+// leaves no emails behind
+func deleteSnapshots(store storage.Storage) error {
+	snapshotter, ok := store.(storage.Snapshotter)
+	if !ok {
+		return nil
+	}
+	snapshots, err := snapshotter.ListSnapshots()
+	if err != nil {
+		return err
+	}
+	for _, snapshot := range snapshots {
+		if _, err := snapshotter.DeleteSnapshot(snapshot.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openStorage opens the named backend. The auto backend is SQLite, or
+// BoltDB in builds without CGO.
+func openStorage(name, dbPath string, options storage.Options) (storage.Storage, *storage.Backend, error) {
+	if name == "auto" {
+		if len(options) > 0 {
+			return nil, nil, fmt.Errorf("storage options need an explicit --storage backend")
+		}
+		name = autoBackend()
+	}
+
+	backend, err := storage.Lookup(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	instance, err := storage.Open(name, dbPath, options)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s storage: %w", name, err)
+	}
+	return instance, backend, nil
+}
+
+// autoBackend returns the backend of --storage=auto. Only a build without
+// SQLite falls back to BoltDB; any other SQLite error is reported, rather
+// than silently switching to an empty BoltDB database.
+func autoBackend() string {
+	if storage.SQLiteAvailable {
+		return "sqlite"
+	}
+	log.Println("SQLite not available (CGO disabled), using BoltDB")
+	return "bolt"
+}
+
+// migrationStatus checks the database of the named backend the way
+// openStorage would open it
+func migrationStatus(name, dbPath string, options storage.Options) (*storage.MigrationStatus, error) {
+	if name == "auto" {
+		name = autoBackend()
+	}
+
+	backend, err := storage.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if backend.MigrationStatus == nil {
+		return &storage.MigrationStatus{Backend: backend.Name, Pending: []storage.Migration{}}, nil
+	}
+	return backend.MigrationStatus(dbPath, options)
+}
+
+// reportMigrations logs the migrations opening the database would apply
+func reportMigrations(status *storage.MigrationStatus) {
+	log.Printf("%s schema version %d, latest %d", status.Backend, status.Current, status.Latest)
+	if len(status.Pending) == 0 {
+		log.Println("Database is up to date")
+		return
+	}
+	for _, migration := range status.Pending {
+		log.Printf("Would migrate to version %d: %s", migration.Version, migration.Description)
+	}
+}
+
 func setupLogging(logPath string, daemon bool) error {
 	// Create log directory if it doesn't exist
 	logDir := filepath.Dir(logPath)
@@ -0,0 +1,217 @@
+// Package backup writes a consistent copy of the database and of the blobs
+// its emails reference to a zip file, and restores it.
+package backup
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"mailcatch/internal/blob"
+	"mailcatch/internal/storage"
+)
+
+// Version is the version of the backup layout
+const Version = 1
+
+// ContentType is the media type of backups
+const ContentType = "application/zip"
+
+const (
+	manifestName = "manifest.json"
+	databaseName = "database"
+	blobsDir     = "blobs/"
+)
+
+// Manifest describes a backup
+type Manifest struct {
+	Version int `json:"version"`
+	// Format is the storage backup format of the database
+	Format string `json:"format"`
+	Emails int    `json:"emails"`
+	Blobs  int    `json:"blobs"`
+	// MissingBlobs counts the blobs deleted after the database was
+	// copied, along with their emails, before they could be copied too
+	MissingBlobs int       `json:"missing_blobs,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Filename returns a name for a backup made at t
+func Filename(t time.Time) string {
+	return "mailcatch-backup-" + t.Format("20060102-150405") + ".zip"
+}
+
+// Write writes a backup of the storage and its blobs to w. The storage
+// stays usable meanwhile; the backup holds the database as of the moment
+// it was copied.
+func Write(w io.Writer, store storage.Storage, blobs *blob.Store) (*Manifest, error) {
+	backuper, ok := store.(storage.Backuper)
+	if !ok {
+		return nil, storage.ErrBackupUnsupported
+	}
+
+	archive := zip.NewWriter(w)
+	manifest := &Manifest{Version: Version, CreatedAt: time.Now()}
+	entry, err := archive.CreateHeader(&zip.FileHeader{Name: databaseName, Method: zip.Deflate, Modified: manifest.CreatedAt})
+	if err != nil {
+		return nil, err
+	}
+	info, err := backuper.Backup(entry)
+	if err != nil {
+		return nil, err
+	}
+	manifest.Format = info.Format
+	manifest.Emails = info.Emails
+
+	// Blobs never change, so copying them after the database is consistent
+	// as long as they still exist
+	for _, key := range info.Blobs {
+		content, err := blobs.Open(key)
+		if os.IsNotExist(err) {
+			manifest.MissingBlobs++
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to open blob %s: %w", key, err)
+		}
+		err = addEntry(archive, blobsDir+key, manifest.CreatedAt, content)
+		content.Close()
+		if err != nil {
+			return nil, err
+		}
+		manifest.Blobs++
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := addEntry(archive, manifestName, manifest.CreatedAt, strings.NewReader(string(data))); err != nil {
+		return nil, err
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func addEntry(archive *zip.Writer, name string, modified time.Time, r io.Reader) error {
+	entry, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, r)
+	return err
+}
+
+// Target is where a backup is restored
+type Target struct {
+	// Backend is the storage backend name, or auto for the backend that
+	// restores the format of the backup
+	Backend string
+	Path    string
+	Options storage.Options
+	BlobDir string
+}
+
+// Restore replaces the database and the blobs of target with the content
+// of a backup, and returns its manifest and the backend restored to. The
+// database must not be in use.
+func Restore(r io.ReaderAt, size int64, target Target) (*Manifest, *storage.Backend, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid backup file: %w", err)
+	}
+
+	manifest, err := readManifest(archive)
+	if err != nil {
+		return nil, nil, err
+	}
+	backend, err := restoreBackend(target.Backend, manifest.Format)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	database, err := archive.Open(databaseName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid backup file: %w", err)
+	}
+	err = backend.Restore(target.Path, target.Options, database)
+	database.Close()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to restore database: %w", err)
+	}
+
+	blobs, err := blob.NewStore(target.BlobDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := blobs.Clear(); err != nil {
+		return nil, nil, fmt.Errorf("failed to clear blob store: %w", err)
+	}
+	for _, file := range archive.File {
+		key, ok := strings.CutPrefix(file.Name, blobsDir)
+		if !ok || key == "" {
+			continue
+		}
+		content, err := file.Open()
+		if err != nil {
+			return nil, nil, err
+		}
+		err = blobs.Restore(key, content)
+		content.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to restore blob %s: %w", key, err)
+		}
+	}
+	return manifest, backend, nil
+}
+
+func readManifest(archive *zip.Reader) (*Manifest, error) {
+	file, err := archive.Open(manifestName)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("not a mailcatch backup, there is no %s", manifestName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var manifest Manifest
+	if err := json.NewDecoder(file).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("invalid backup manifest: %w", err)
+	}
+	if manifest.Version > Version {
+		return nil, fmt.Errorf("backup version %d is newer than the supported version %d", manifest.Version, Version)
+	}
+	return &manifest, nil
+}
+
+// restoreBackend returns the backend a backup of format is restored to
+func restoreBackend(name, format string) (*storage.Backend, error) {
+	if name == "" || name == "auto" {
+		for _, backend := range storage.Backends() {
+			if backend.BackupFormat == format && backend.Restore != nil {
+				return backend, nil
+			}
+		}
+		return nil, fmt.Errorf("no storage backend restores %s backups", format)
+	}
+
+	backend, err := storage.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if backend.Restore == nil {
+		return nil, fmt.Errorf("storage backend %s cannot be restored", name)
+	}
+	if backend.BackupFormat != format {
+		return nil, fmt.Errorf("a %s backup cannot be restored to the %s backend", format, name)
+	}
+	return backend, nil
+}
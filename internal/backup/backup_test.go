@@ -0,0 +1,262 @@
+package backup
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"mailcatch/internal/blob"
+	"mailcatch/internal/models"
+	"mailcatch/internal/storage"
+)
+
+// attachmentBytes spans the bytes text handling tends to mangle
+var attachmentBytes = []byte("PK\x03\x04\x00\r\n\x80\xff binary\r")
+
+// populate saves an email kept inline and one whose raw message and
+// attachment are blobs
+func populate(t *testing.T, store storage.Storage, blobs *blob.Store) {
+	t.Helper()
+	rawKey, _, err := blobs.Put(strings.NewReader("From: b@example.com\r\nSubject: blob\r\n\r\nkept as a blob\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	attachmentKey, _, err := blobs.Put(bytes.NewReader(attachmentBytes))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	emails := []*models.Email{
+		{
+			From: "a@example.com", To: "b@example.com", Subject: "inline", Body: "kept inline\r\n",
+			Raw:       []byte("From: a@example.com\r\nSubject: inline\r\n\r\nkept inline\r\n"),
+			CreatedAt: created,
+		},
+		{
+			From: "b@example.com", To: "c@example.com, d@example.com", Subject: "blob", Body: "kept as a blob\r\n",
+			RawBlob:     rawKey,
+			Attachments: []*models.Attachment{{Filename: "data.zip", ContentType: "application/zip", Size: int64(len(attachmentBytes)), Blob: attachmentKey}},
+			CreatedAt:   created.Add(time.Minute),
+		},
+	}
+	for _, email := range emails {
+		if err := store.SaveEmail(email); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// storedEmail is the part of an email a backup must keep
+type storedEmail struct {
+	ID                 int
+	From, To, Subject  string
+	Body, Raw, RawBlob string
+	CreatedAt          time.Time
+	Attachments        []string
+}
+
+func storedEmails(t *testing.T, store storage.Storage) []storedEmail {
+	t.Helper()
+	page, err := store.GetEmails(storage.EmailFilter{}, storage.PageRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var emails []storedEmail
+	for _, summary := range page.Emails {
+		email, err := store.GetEmail(summary.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored := storedEmail{
+			ID: email.ID, From: email.From, To: email.To, Subject: email.Subject,
+			Body: email.Body, Raw: string(email.Raw), RawBlob: email.RawBlob,
+			CreatedAt: email.CreatedAt.UTC(),
+		}
+		for _, attachment := range email.Attachments {
+			stored.Attachments = append(stored.Attachments, attachment.Filename+" "+attachment.Blob)
+		}
+		emails = append(emails, stored)
+	}
+	return emails
+}
+
+// blobContents returns the content of each blob in a directory by key
+func blobContents(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := map[string]string{}
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents[entry.Name()] = string(data)
+	}
+	return contents
+}
+
+func TestWriteRestore(t *testing.T) {
+	for _, name := range []string{"sqlite", "bolt", "memory-persistent"} {
+		t.Run(name, func(t *testing.T) {
+			source := t.TempDir()
+			store, err := storage.Open(name, filepath.Join(source, "emails.db"), nil)
+			if err != nil {
+				// SQLite needs CGO
+				t.Skip(err)
+			}
+			blobs, err := blob.NewStore(filepath.Join(source, "blobs"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			populate(t, store, blobs)
+			want := storedEmails(t, store)
+			wantBlobs := blobContents(t, blobs.Dir())
+
+			var buf bytes.Buffer
+			manifest, err := Write(&buf, store, blobs)
+			store.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if manifest.Version != Version || manifest.Emails != 2 || manifest.Blobs != 2 || manifest.MissingBlobs != 0 {
+				t.Errorf("manifest %+v", manifest)
+			}
+
+			// Restoring picks the backend from the format of the backup
+			target := t.TempDir()
+			restored, backend, err := Restore(bytes.NewReader(buf.Bytes()), int64(buf.Len()), Target{
+				Backend: "auto",
+				Path:    filepath.Join(target, "emails.db"),
+				BlobDir: filepath.Join(target, "blobs"),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if backend.Name != name {
+				t.Errorf("restored to %s", backend.Name)
+			}
+			if restored.Format != manifest.Format || restored.Emails != 2 {
+				t.Errorf("restored manifest %+v, want %+v", restored, manifest)
+			}
+
+			reopened, err := backend.Open(filepath.Join(target, "emails.db"), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reopened.Close()
+			if got := storedEmails(t, reopened); !reflect.DeepEqual(got, want) {
+				t.Errorf("restored emails\n%+v\nwant\n%+v", got, want)
+			}
+			got := blobContents(t, filepath.Join(target, "blobs"))
+			if !reflect.DeepEqual(got, wantBlobs) {
+				t.Errorf("restored blobs %q, want %q", got, wantBlobs)
+			}
+			email, err := reopened.GetEmail(2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(email.Attachments) != 1 || got[email.Attachments[0].Blob] != string(attachmentBytes) {
+				t.Errorf("attachment blob not restored byte for byte")
+			}
+		})
+	}
+}
+
+// A backup is not restored over a database a running server has open, and
+// the blobs are left alone
+func TestRestoreInUse(t *testing.T) {
+	for _, name := range []string{"sqlite", "bolt", "memory-persistent"} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "emails.db")
+			store, err := storage.Open(name, path, nil)
+			if err != nil {
+				t.Skip(err)
+			}
+			defer store.Close()
+			blobs, err := blob.NewStore(filepath.Join(dir, "blobs"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			populate(t, store, blobs)
+			before := blobContents(t, blobs.Dir())
+
+			var buf bytes.Buffer
+			if _, err := Write(&buf, store, blobs); err != nil {
+				t.Fatal(err)
+			}
+			_, _, err = Restore(bytes.NewReader(buf.Bytes()), int64(buf.Len()), Target{Backend: name, Path: path, BlobDir: blobs.Dir()})
+			if err == nil || !strings.Contains(err.Error(), "in use") {
+				t.Errorf("restore over the open database = %v, want in use", err)
+			}
+			if after := blobContents(t, blobs.Dir()); !reflect.DeepEqual(after, before) {
+				t.Errorf("blobs changed by the failed restore")
+			}
+			if count, _ := store.GetEmailCount(); count != 2 {
+				t.Errorf("count %d after the failed restore, want 2", count)
+			}
+		})
+	}
+}
+
+func TestRestoreManifest(t *testing.T) {
+	archive := func(manifest any) []byte {
+		var buf bytes.Buffer
+		w := zip.NewWriter(&buf)
+		if manifest != nil {
+			entry, err := w.Create(manifestName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := json.NewEncoder(entry).Encode(manifest); err != nil {
+				t.Fatal(err)
+			}
+		}
+		entry, err := w.Create(databaseName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(entry, `{"next_id": 1, "emails": []}`)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"newer version", archive(Manifest{Version: Version + 1, Format: storage.BackupFormatJSON}), "newer than the supported version"},
+		{"no manifest", archive(nil), "not a mailcatch backup"},
+		{"unknown format", archive(Manifest{Version: Version, Format: "tape"}), "no storage backend restores tape backups"},
+		{"not a zip file", []byte("not a zip file"), "invalid backup file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			_, _, err := Restore(bytes.NewReader(tt.data), int64(len(tt.data)), Target{
+				Path:    filepath.Join(dir, "emails.db"),
+				BlobDir: filepath.Join(dir, "blobs"),
+			})
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Restore = %v, want %q", err, tt.err)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "emails.json")); !os.IsNotExist(err) {
+				t.Errorf("database written: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package blob
+
+import (
+	"bytes"
+	"io"
+
+	"mailcatch/internal/models"
+)
+
+// OpenRaw returns the raw message, whether it is kept inline or as a blob
+func (s *Store) OpenRaw(email *models.Email) (io.ReadCloser, error) {
+	if email.RawBlob == "" {
+		return io.NopCloser(bytes.NewReader(email.Raw)), nil
+	}
+	return s.Open(email.RawBlob)
+}
+
+// OpenAttachment returns the content of an attachment
+func (s *Store) OpenAttachment(attachment *models.Attachment) (io.ReadCloser, error) {
+	if attachment.Blob == "" {
+		return io.NopCloser(bytes.NewReader(attachment.Content)), nil
+	}
+	return s.Open(attachment.Blob)
+}
+
+// Keys returns the keys of the blobs referenced by an email
+func Keys(email *models.Email) []string {
+	var keys []string
+	if email.RawBlob != "" {
+		keys = append(keys, email.RawBlob)
+	}
+	for _, attachment := range email.Attachments {
+		if attachment.Blob != "" {
+			keys = append(keys, attachment.Blob)
+		}
+	}
+	return keys
+}
+
+// DeleteEmail removes the blobs referenced by an email
+func (s *Store) DeleteEmail(email *models.Email) error {
+	return s.DeleteKeys(Keys(email))
+}
+
+// DeleteKeys removes blobs, returning the first error
+func (s *Store) DeleteKeys(keys []string) error {
+	var firstErr error
+	for _, key := range keys {
+		if err := s.Delete(key); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,278 @@
+// Package blob keeps large message content on disk so it does not have to
+// be held in memory or stored inside the database.
+package blob
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// tempSuffix marks blobs that are still being written
+const tempSuffix = ".tmp"
+
+// ErrInvalidKey is returned for keys that do not name a blob
+var ErrInvalidKey = errors.New("blob: invalid key")
+
+// Store is a directory of immutable blobs addressed by random keys
+type Store struct {
+	dir string
+	// memory holds the blobs of stores created by NewMemoryStore
+	memory map[string][]byte
+	mutex  sync.RWMutex
+}
+
+// NewStore opens the blob directory, creating it if needed. Blobs left
+// unfinished by a previous run are removed.
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory: %w", err)
+	}
+
+	leftovers, err := filepath.Glob(filepath.Join(dir, "*"+tempSuffix))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range leftovers {
+		os.Remove(path)
+	}
+
+	return &Store{dir: dir}, nil
+}
+
+// NewMemoryStore returns a store that keeps its blobs in memory, for
+// setups that must not write to disk
+func NewMemoryStore() *Store {
+	return &Store{memory: make(map[string][]byte)}
+}
+
+// Dir returns the directory the blobs are kept in, empty for memory stores
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// Create starts a new blob. The content is only kept if Commit is called.
+func (s *Store) Create() (*Blob, error) {
+	if s.memory != nil {
+		return &Blob{store: s, buffer: &bytes.Buffer{}}, nil
+	}
+
+	file, err := os.CreateTemp(s.dir, "*"+tempSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Blob{
+		store:  s,
+		file:   file,
+		writer: bufio.NewWriterSize(file, 64*1024),
+	}, nil
+}
+
+// Put stores the content of r as a new blob
+func (s *Store) Put(r io.Reader) (string, int64, error) {
+	b, err := s.Create()
+	if err != nil {
+		return "", 0, err
+	}
+
+	if _, err := io.Copy(b, r); err != nil {
+		b.Discard()
+		return "", 0, err
+	}
+
+	key, err := b.Commit()
+	if err != nil {
+		return "", 0, err
+	}
+	return key, b.Size(), nil
+}
+
+// Restore stores the content of r under the key it had in the store it
+// was backed up from
+func (s *Store) Restore(key string, r io.Reader) error {
+	if _, err := s.path(key); err != nil {
+		return err
+	}
+
+	b, err := s.Create()
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(b, r); err != nil {
+		b.Discard()
+		return err
+	}
+	return b.commitAs(key)
+}
+
+// Open returns a reader for a committed blob
+func (s *Store) Open(key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	if s.memory != nil {
+		s.mutex.RLock()
+		data, ok := s.memory[key]
+		s.mutex.RUnlock()
+		if !ok {
+			return nil, &os.PathError{Op: "open", Path: key, Err: os.ErrNotExist}
+		}
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	return os.Open(path)
+}
+
+// Delete removes a blob; deleting a missing blob is not an error
+func (s *Store) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if s.memory != nil {
+		s.mutex.Lock()
+		delete(s.memory, key)
+		s.mutex.Unlock()
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Clear removes every committed blob
+func (s *Store) Clear() error {
+	if s.memory != nil {
+		s.mutex.Lock()
+		s.memory = make(map[string][]byte)
+		s.mutex.Unlock()
+		return nil
+	}
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), tempSuffix) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.dir, entry.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) path(key string) (string, error) {
+	if _, err := hex.DecodeString(key); err != nil || key == "" {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(s.dir, key), nil
+}
+
+// Blob is a blob being written, to a temporary file or, in memory
+// stores, to a buffer
+type Blob struct {
+	store  *Store
+	file   *os.File
+	writer *bufio.Writer
+	buffer *bytes.Buffer
+	size   int64
+}
+
+func (b *Blob) Write(p []byte) (int, error) {
+	var n int
+	var err error
+	if b.buffer != nil {
+		n, err = b.buffer.Write(p)
+	} else {
+		n, err = b.writer.Write(p)
+	}
+	b.size += int64(n)
+	return n, err
+}
+
+// Size returns the number of bytes written so far
+func (b *Blob) Size() int64 {
+	return b.size
+}
+
+// Open returns a reader over the content written so far
+func (b *Blob) Open() (io.ReadCloser, error) {
+	if b.buffer != nil {
+		return io.NopCloser(bytes.NewReader(b.buffer.Bytes())), nil
+	}
+	if err := b.writer.Flush(); err != nil {
+		return nil, err
+	}
+	return os.Open(b.file.Name())
+}
+
+// Commit finishes the blob and returns its key
+func (b *Blob) Commit() (string, error) {
+	key, err := newKey()
+	if err != nil {
+		b.Discard()
+		return "", err
+	}
+	if err := b.commitAs(key); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// commitAs finishes the blob under the given key
+func (b *Blob) commitAs(key string) error {
+	if b.buffer != nil {
+		b.store.mutex.Lock()
+		b.store.memory[key] = b.buffer.Bytes()
+		b.store.mutex.Unlock()
+		return nil
+	}
+
+	if err := b.writer.Flush(); err != nil {
+		b.Discard()
+		return err
+	}
+	if err := b.file.Close(); err != nil {
+		os.Remove(b.file.Name())
+		return err
+	}
+
+	if err := os.Rename(b.file.Name(), filepath.Join(b.store.dir, key)); err != nil {
+		os.Remove(b.file.Name())
+		return err
+	}
+	return nil
+}
+
+// Discard drops the blob
+func (b *Blob) Discard() error {
+	if b.buffer != nil {
+		b.buffer.Reset()
+		return nil
+	}
+	b.file.Close()
+	return os.Remove(b.file.Name())
+}
+
+// newKey returns a random blob key
+func newKey() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
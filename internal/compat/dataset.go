@@ -0,0 +1,109 @@
+// Package compat checks HTML email bodies for features that common email
+// clients strip or do not render.
+package compat
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+//go:embed data/features.json
+var bundledDataset []byte
+
+// Feature kinds and how their Match entries are interpreted
+const (
+	KindHTMLElement   = "html-element"   // element names
+	KindHTMLAttribute = "html-attribute" // attribute names
+	KindCSSProperty   = "css-property"   // property names
+	KindCSSValue      = "css-value"      // "property:value", matched on the first value token
+	KindCSSFunction   = "css-function"   // function names used in values
+	KindCSSAtRule     = "css-at-rule"    // at-rule names without the @
+	KindCSSSelector   = "css-selector"   // substrings of selectors
+	KindImageFormat   = "image-format"   // file extensions of <img> sources
+)
+
+// Support levels
+const (
+	Supported   = "y"
+	Partial     = "a"
+	Unsupported = "n"
+)
+
+// Dataset describes which features each email client supports
+type Dataset struct {
+	Updated  string     `json:"updated"`
+	Source   string     `json:"source,omitempty"`
+	Clients  []*Client  `json:"clients"`
+	Features []*Feature `json:"features"`
+}
+
+// Client is an email client family
+type Client struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Feature is an HTML or CSS feature with per client support. Clients
+// missing from Support are treated as unknown and not reported.
+type Feature struct {
+	ID      string            `json:"id"`
+	Title   string            `json:"title"`
+	Kind    string            `json:"kind"`
+	Match   []string          `json:"match"`
+	Support map[string]string `json:"support"`
+	Notes   map[string]string `json:"notes,omitempty"`
+}
+
+// DefaultDataset returns the dataset bundled with mailcatch
+func DefaultDataset() (*Dataset, error) {
+	return parseDataset(bundledDataset)
+}
+
+// LoadDataset reads a dataset in the bundled JSON format, e.g. a newer
+// copy of the bundled file
+func LoadDataset(path string) (*Dataset, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	dataset, err := parseDataset(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return dataset, nil
+}
+
+func parseDataset(data []byte) (*Dataset, error) {
+	var dataset Dataset
+	if err := json.Unmarshal(data, &dataset); err != nil {
+		return nil, err
+	}
+
+	clients := make(map[string]bool, len(dataset.Clients))
+	for _, client := range dataset.Clients {
+		clients[client.ID] = true
+	}
+
+	for _, feature := range dataset.Features {
+		switch feature.Kind {
+		case KindHTMLElement, KindHTMLAttribute, KindCSSProperty, KindCSSValue,
+			KindCSSFunction, KindCSSAtRule, KindCSSSelector, KindImageFormat:
+		default:
+			return nil, fmt.Errorf("feature %s: unknown kind %q", feature.ID, feature.Kind)
+		}
+
+		for client, level := range feature.Support {
+			if !clients[client] {
+				return nil, fmt.Errorf("feature %s: unknown client %q", feature.ID, client)
+			}
+			if level != Supported && level != Partial && level != Unsupported {
+				return nil, fmt.Errorf("feature %s: invalid support level %q for %s", feature.ID, level, client)
+			}
+		}
+	}
+
+	return &dataset, nil
+}
@@ -0,0 +1,175 @@
+package compat
+
+import (
+	"sort"
+	"strings"
+)
+
+// Linter checks HTML bodies against a compatibility dataset
+type Linter struct {
+	dataset *Dataset
+	// index maps kind and key to the features matching them; selector
+	// features are matched by substring and kept separately
+	index     map[string]map[string][]*Feature
+	selectors []*Feature
+}
+
+// Report lists the features of an HTML body that are not fully supported
+// by every client
+type Report struct {
+	DatasetUpdated string          `json:"dataset_updated"`
+	Clients        []*ClientReport `json:"clients"`
+	Issues         []*Issue        `json:"issues"`
+}
+
+// ClientReport summarizes the issues affecting one client
+type ClientReport struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Unsupported int    `json:"unsupported"`
+	Partial     int    `json:"partial"`
+}
+
+// Issue is a feature used by the HTML body that some clients do not
+// support or only partially support
+type Issue struct {
+	Feature     string            `json:"feature"`
+	Title       string            `json:"title"`
+	Kind        string            `json:"kind"`
+	Occurrences int               `json:"occurrences"`
+	Example     string            `json:"example"`
+	Unsupported []string          `json:"unsupported,omitempty"`
+	Partial     []string          `json:"partial,omitempty"`
+	Notes       map[string]string `json:"notes,omitempty"`
+}
+
+func NewLinter(dataset *Dataset) *Linter {
+	linter := &Linter{
+		dataset: dataset,
+		index:   make(map[string]map[string][]*Feature),
+	}
+
+	for _, feature := range dataset.Features {
+		if feature.Kind == KindCSSSelector {
+			linter.selectors = append(linter.selectors, feature)
+			continue
+		}
+
+		keys := linter.index[feature.Kind]
+		if keys == nil {
+			keys = make(map[string][]*Feature)
+			linter.index[feature.Kind] = keys
+		}
+		for _, match := range feature.Match {
+			key := strings.ToLower(strings.ReplaceAll(match, " ", ""))
+			keys[key] = append(keys[key], feature)
+		}
+	}
+
+	return linter
+}
+
+// Check lints an HTML body. Issues are ordered by the number of clients
+// they affect.
+func (l *Linter) Check(document string) *Report {
+	issues := make(map[string]*Issue)
+	var order []*Issue
+
+	scanHTML(document, func(u usage) {
+		for _, feature := range l.match(u) {
+			issue, ok := issues[feature.ID]
+			if !ok {
+				issue = l.newIssue(feature, u.example)
+				issues[feature.ID] = issue
+				order = append(order, issue)
+			}
+			issue.Occurrences++
+		}
+	})
+
+	report := &Report{
+		DatasetUpdated: l.dataset.Updated,
+		Issues:         make([]*Issue, 0, len(order)),
+	}
+	for _, issue := range order {
+		if len(issue.Unsupported) > 0 || len(issue.Partial) > 0 {
+			report.Issues = append(report.Issues, issue)
+		}
+	}
+	sort.SliceStable(report.Issues, func(i, j int) bool {
+		a, b := report.Issues[i], report.Issues[j]
+		if len(a.Unsupported) != len(b.Unsupported) {
+			return len(a.Unsupported) > len(b.Unsupported)
+		}
+		return len(a.Partial) > len(b.Partial)
+	})
+
+	for _, client := range l.dataset.Clients {
+		summary := &ClientReport{ID: client.ID, Name: client.Name}
+		for _, issue := range report.Issues {
+			if contains(issue.Unsupported, client.ID) {
+				summary.Unsupported++
+			} else if contains(issue.Partial, client.ID) {
+				summary.Partial++
+			}
+		}
+		report.Clients = append(report.Clients, summary)
+	}
+
+	return report
+}
+
+func (l *Linter) match(u usage) []*Feature {
+	if u.kind != KindCSSSelector {
+		return l.index[u.kind][u.key]
+	}
+
+	var matched []*Feature
+	for _, feature := range l.selectors {
+		for _, pattern := range feature.Match {
+			if strings.Contains(u.key, strings.ToLower(pattern)) {
+				matched = append(matched, feature)
+				break
+			}
+		}
+	}
+	return matched
+}
+
+func (l *Linter) newIssue(feature *Feature, example string) *Issue {
+	issue := &Issue{
+		Feature: feature.ID,
+		Title:   feature.Title,
+		Kind:    feature.Kind,
+		Example: example,
+	}
+
+	// Clients are listed in dataset order
+	for _, client := range l.dataset.Clients {
+		switch feature.Support[client.ID] {
+		case Unsupported:
+			issue.Unsupported = append(issue.Unsupported, client.ID)
+		case Partial:
+			issue.Partial = append(issue.Partial, client.ID)
+		default:
+			continue
+		}
+		if note, ok := feature.Notes[client.ID]; ok {
+			if issue.Notes == nil {
+				issue.Notes = make(map[string]string)
+			}
+			issue.Notes[client.ID] = note
+		}
+	}
+
+	return issue
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package compat
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLinterFlagsUnsupportedProperty(t *testing.T) {
+	dataset, err := DefaultDataset()
+	if err != nil {
+		t.Fatal(err)
+	}
+	linter := NewLinter(dataset)
+
+	report := linter.Check(`<html><head><style>.badge { POSITION : absolute; color: red }</style></head>
+<body><div style="position: relative; color: blue">one</div><p style="color: green">two</p></body></html>`)
+
+	var position *Issue
+	for _, issue := range report.Issues {
+		if issue.Feature == "css-position" {
+			position = issue
+		}
+		if issue.Title == "color" {
+			t.Errorf("supported property reported: %+v", issue)
+		}
+	}
+	if position == nil {
+		t.Fatalf("position not flagged, issues %+v", report.Issues)
+	}
+	if position.Kind != KindCSSProperty || position.Occurrences != 2 {
+		t.Errorf("issue %+v, want a css-property used twice", position)
+	}
+	if want := []string{"gmail", "outlook-windows", "outlook-com"}; !reflect.DeepEqual(position.Unsupported, want) {
+		t.Errorf("unsupported by %v, want %v", position.Unsupported, want)
+	}
+	if want := []string{"yahoo"}; !reflect.DeepEqual(position.Partial, want) {
+		t.Errorf("partially supported by %v, want %v", position.Partial, want)
+	}
+
+	for _, client := range report.Clients {
+		switch client.ID {
+		case "gmail":
+			if client.Unsupported != 1 {
+				t.Errorf("gmail: %d unsupported features, want 1", client.Unsupported)
+			}
+		case "apple-mail":
+			if client.Unsupported != 0 || client.Partial != 0 {
+				t.Errorf("apple-mail: %+v, want no issues", client)
+			}
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package compat
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// usage is one use of a feature in the scanned HTML
+type usage struct {
+	kind    string
+	key     string
+	example string
+}
+
+// maxExample bounds the length of the excerpts shown in reports
+const maxExample = 80
+
+var cssFunctionPattern = regexp.MustCompile(`([a-zA-Z-]+)\(`)
+
+// scanHTML reports the elements, attributes, image formats and CSS used by
+// an HTML document
+func scanHTML(document string, visit func(usage)) {
+	tokenizer := html.NewTokenizer(strings.NewReader(document))
+	inStyle := false
+
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return
+		case html.TextToken:
+			if inStyle {
+				scanStyleSheet(string(tokenizer.Text()), visit)
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			if string(name) == "style" {
+				inStyle = false
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			name := strings.ToLower(token.Data)
+			visit(usage{kind: KindHTMLElement, key: name, example: "<" + name + ">"})
+			inStyle = name == "style"
+
+			for _, attr := range token.Attr {
+				key := strings.ToLower(attr.Key)
+				visit(usage{kind: KindHTMLAttribute, key: key, example: excerpt(key + `="` + attr.Val + `"`)})
+
+				switch {
+				case key == "style":
+					scanDeclarations(attr.Val, visit)
+				case key == "src" && name == "img":
+					scanImage(attr.Val, visit)
+				}
+			}
+		}
+	}
+}
+
+// scanImage reports the format of an image by its file extension
+func scanImage(src string, visit func(usage)) {
+	if strings.HasPrefix(src, "data:") {
+		// data:image/webp;base64,...
+		mediaType, _, _ := strings.Cut(strings.TrimPrefix(src, "data:"), ";")
+		if format, ok := strings.CutPrefix(mediaType, "image/"); ok {
+			format = strings.TrimSuffix(format, "+xml")
+			visit(usage{kind: KindImageFormat, key: strings.ToLower(format), example: excerpt(src)})
+		}
+		return
+	}
+
+	src, _, _ = strings.Cut(src, "?")
+	src, _, _ = strings.Cut(src, "#")
+	if ext := strings.TrimPrefix(path.Ext(src), "."); ext != "" {
+		visit(usage{kind: KindImageFormat, key: strings.ToLower(ext), example: excerpt(src)})
+	}
+}
+
+// scanStyleSheet reports the at-rules, selectors and declarations of a
+// style sheet. Nested rules, e.g. inside @media, are scanned as well.
+func scanStyleSheet(css string, visit func(usage)) {
+	css = stripComments(css)
+
+	for {
+		css = strings.TrimSpace(css)
+		if css == "" {
+			return
+		}
+
+		open := strings.IndexByte(css, '{')
+		semicolon := strings.IndexByte(css, ';')
+
+		// Statement at-rules such as @import end with a semicolon
+		if css[0] == '@' && semicolon != -1 && (open == -1 || semicolon < open) {
+			visitAtRule(css[:semicolon], visit)
+			css = css[semicolon+1:]
+			continue
+		}
+		if open == -1 {
+			return
+		}
+
+		prelude := strings.TrimSpace(css[:open])
+		end := matchingBrace(css, open)
+		body := css[open+1 : end]
+		if end < len(css) {
+			css = css[end+1:]
+		} else {
+			css = ""
+		}
+
+		if strings.HasPrefix(prelude, "@") {
+			name := visitAtRule(prelude, visit)
+			switch name {
+			case "font-face", "page":
+				scanDeclarations(body, visit)
+			default:
+				scanStyleSheet(body, visit)
+			}
+			continue
+		}
+
+		visit(usage{kind: KindCSSSelector, key: strings.ToLower(prelude), example: excerpt(prelude)})
+		scanDeclarations(body, visit)
+	}
+}
+
+// visitAtRule reports an at-rule and returns its lowercase name
+func visitAtRule(prelude string, visit func(usage)) string {
+	prelude = strings.TrimSpace(prelude)
+	name := strings.TrimPrefix(prelude, "@")
+	if i := strings.IndexAny(name, " \t\r\n({"); i != -1 {
+		name = name[:i]
+	}
+	name = strings.ToLower(name)
+
+	visit(usage{kind: KindCSSAtRule, key: name, example: excerpt(prelude)})
+	return name
+}
+
+// scanDeclarations reports the properties, values and functions of a
+// declaration block or style attribute
+func scanDeclarations(block string, visit func(usage)) {
+	for _, declaration := range splitDeclarations(block) {
+		property, value, ok := strings.Cut(declaration, ":")
+		if !ok {
+			continue
+		}
+		property = strings.ToLower(strings.TrimSpace(property))
+		value = strings.TrimSpace(value)
+		value = strings.TrimSpace(strings.TrimSuffix(strings.ToLower(value), "!important"))
+		if property == "" {
+			continue
+		}
+
+		example := excerpt(property + ": " + value)
+		visit(usage{kind: KindCSSProperty, key: property, example: example})
+
+		if fields := strings.Fields(value); len(fields) > 0 {
+			visit(usage{kind: KindCSSValue, key: property + ":" + fields[0], example: example})
+		}
+		for _, match := range cssFunctionPattern.FindAllStringSubmatch(value, -1) {
+			visit(usage{kind: KindCSSFunction, key: strings.ToLower(match[1]), example: example})
+		}
+	}
+}
+
+// splitDeclarations splits a declaration block on semicolons outside of
+// parentheses and quotes, e.g. in url(data:image/png;base64,...)
+func splitDeclarations(block string) []string {
+	var declarations []string
+	depth := 0
+	var quote byte
+	start := 0
+
+	for i := 0; i < len(block); i++ {
+		c := block[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case c == ';' && depth == 0:
+			declarations = append(declarations, block[start:i])
+			start = i + 1
+		}
+	}
+	return append(declarations, block[start:])
+}
+
+// matchingBrace returns the index of the brace closing the one at open, or
+// the length of css if it is never closed
+func matchingBrace(css string, open int) int {
+	depth := 0
+	for i := open; i < len(css); i++ {
+		switch css[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(css)
+}
+
+func stripComments(css string) string {
+	var b strings.Builder
+	for {
+		start := strings.Index(css, "/*")
+		if start == -1 {
+			b.WriteString(css)
+			return b.String()
+		}
+		b.WriteString(css[:start])
+
+		end := strings.Index(css[start+2:], "*/")
+		if end == -1 {
+			return b.String()
+		}
+		css = css[start+2+end+2:]
+	}
+}
+
+func excerpt(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if runes := []rune(s); len(runes) > maxExample {
+		return string(runes[:maxExample-3]) + "..."
+	}
+	return s
+}
@@ -4,30 +4,85 @@ import (
 	"flag"
 	"os"
 	"path/filepath"
+	"strconv"
+	"time"
 )
 
 type Config struct {
-	SMTPPort        string
-	HTTPPort        string
-	DBPath          string
-	LogPath         string
-	ClearOnShutdown bool
-	Daemon          bool
+	SMTPPort           string
+	HTTPPort           string
+	DBPath             string
+	LogPath            string
+	ClearOnShutdown    bool
+	Daemon             bool
+	DKIMKeys           string
+	DNSZone            string
+	LiveDNS            bool
+	SMIMEKeys          string
+	SMIMERoots         string
+	PGPKeyring         string
+	PGPPassphrase      string
+	BlobDir            string
+	MaxMessageSize     int64
+	InlineLimit        int64
+	CompatData         string
+	LinkCheckBase      string
+	SpamThreshold      float64
+	Spamd              string
+	Extractors         string
+	MigrateDryRun      bool
+	Storage            string
+	StorageOptions     string
+	RetentionMaxAge    time.Duration
+	RetentionMaxCount  int
+	RetentionMaxSize   int64
+	RetentionInterval  time.Duration
+	RetentionMailboxes string
 }
 
+// Load reads the configuration of the server from the command line and
+// the environment
 func Load() *Config {
+	return Parse(flag.CommandLine, os.Args[1:])
+}
+
+// Parse reads the configuration from args, registering its flags on flags
+// next to the ones the caller registered
+func Parse(flags *flag.FlagSet, args []string) *Config {
 	cfg := &Config{}
 	
 	// Default log path to temp directory
 	defaultLogPath := filepath.Join(os.TempDir(), "mailcatch.log")
 	
-	flag.StringVar(&cfg.SMTPPort, "smtp-port", "2525", "SMTP server port")
-	flag.StringVar(&cfg.HTTPPort, "http-port", "8080", "HTTP server port")
-	flag.StringVar(&cfg.DBPath, "db-path", "./data/emails.db", "Database file path")
-	flag.StringVar(&cfg.LogPath, "log-path", defaultLogPath, "Log file path (default: temp directory)")
-	flag.BoolVar(&cfg.ClearOnShutdown, "clear-on-shutdown", true, "Clear all emails when shutting down")
-	flag.BoolVar(&cfg.Daemon, "daemon", false, "Run in background as daemon")
-	flag.Parse()
+	flags.StringVar(&cfg.SMTPPort, "smtp-port", "2525", "SMTP server port")
+	flags.StringVar(&cfg.HTTPPort, "http-port", "8080", "HTTP server port")
+	flags.StringVar(&cfg.DBPath, "db-path", "./data/emails.db", "Database file path")
+	flags.StringVar(&cfg.LogPath, "log-path", defaultLogPath, "Log file path (default: temp directory)")
+	flags.BoolVar(&cfg.ClearOnShutdown, "clear-on-shutdown", true, "Clear all emails when shutting down")
+	flags.BoolVar(&cfg.Daemon, "daemon", false, "Run in background as daemon")
+	flags.StringVar(&cfg.DKIMKeys, "dkim-keys", "", "DKIM key map file used to verify signatures (selector domain key per line)")
+	flags.StringVar(&cfg.DNSZone, "dns-zone", "", "Zone file used instead of live DNS for authentication checks")
+	flags.BoolVar(&cfg.LiveDNS, "live-dns", false, "Fall back to live DNS for names missing from the zone file")
+	flags.StringVar(&cfg.SMIMEKeys, "smime-keys", "", "Comma-separated PEM files with S/MIME certificates and private keys for decryption")
+	flags.StringVar(&cfg.SMIMERoots, "smime-roots", "", "PEM file with CA certificates trusted for S/MIME signers")
+	flags.StringVar(&cfg.PGPKeyring, "pgp-keyring", "", "OpenPGP keyring used to verify and decrypt messages")
+	flags.StringVar(&cfg.BlobDir, "blob-dir", "", "Directory for spooled messages and large attachments (default: blobs next to the database)")
+	flags.Int64Var(&cfg.MaxMessageSize, "max-message-size", 0, "Largest accepted message in bytes (0 for no limit)")
+	flags.Int64Var(&cfg.InlineLimit, "inline-limit", 1<<20, "Messages and attachments larger than this many bytes are kept in the blob directory")
+	flags.StringVar(&cfg.CompatData, "compat-data", "", "Email client compatibility dataset in JSON (default: bundled dataset)")
+	flags.StringVar(&cfg.LinkCheckBase, "link-check-base", "", "Comma-separated base URLs whose links may be probed by the link checker")
+	flags.Float64Var(&cfg.SpamThreshold, "spam-threshold", 5.0, "Spam score from which an email is reported as spam")
+	flags.StringVar(&cfg.Spamd, "spamd", "", "Address of a spamd to score emails with instead of the built-in rules (host:port)")
+	flags.StringVar(&cfg.Extractors, "extractors", "", "JSON file with additional extract presets")
+	flags.StringVar(&cfg.Storage, "storage", "auto", "Storage backend: sqlite, bolt, postgres, memory, memory-persistent, or auto for SQLite with a BoltDB fallback")
+	flags.StringVar(&cfg.StorageOptions, "storage-opts", "", "Comma-separated key=value options of the storage backend")
+	flags.BoolVar(&cfg.MigrateDryRun, "migrate-dry-run", false, "Report the schema migrations the database needs and exit without applying them")
+	flags.DurationVar(&cfg.RetentionMaxAge, "retention-max-age", 0, "Evict emails older than this, e.g. 24h (0 for no limit)")
+	flags.IntVar(&cfg.RetentionMaxCount, "retention-max-count", 0, "Keep at most this many emails, evicting the oldest (0 for no limit)")
+	flags.Int64Var(&cfg.RetentionMaxSize, "retention-max-size", 0, "Keep at most this many bytes of messages, evicting the oldest (0 for no limit)")
+	flags.DurationVar(&cfg.RetentionInterval, "retention-interval", time.Minute, "Time between retention sweeps")
+	flags.StringVar(&cfg.RetentionMailboxes, "retention-mailboxes", "", "JSON file with retention limits per mailbox address or *@domain")
+	flags.Parse(args)
 
 	// Environment variables override flags
 	if port := os.Getenv("SMTP_PORT"); port != "" {
@@ -48,6 +103,79 @@ func Load() *Config {
 	if daemon := os.Getenv("DAEMON"); daemon == "true" {
 		cfg.Daemon = true
 	}
+	if path := os.Getenv("DKIM_KEYS"); path != "" {
+		cfg.DKIMKeys = path
+	}
+	if path := os.Getenv("DNS_ZONE"); path != "" {
+		cfg.DNSZone = path
+	}
+	if live := os.Getenv("LIVE_DNS"); live == "true" {
+		cfg.LiveDNS = true
+	}
+	if keys := os.Getenv("SMIME_KEYS"); keys != "" {
+		cfg.SMIMEKeys = keys
+	}
+	if path := os.Getenv("SMIME_ROOTS"); path != "" {
+		cfg.SMIMERoots = path
+	}
+	if path := os.Getenv("PGP_KEYRING"); path != "" {
+		cfg.PGPKeyring = path
+	}
+	if path := os.Getenv("BLOB_DIR"); path != "" {
+		cfg.BlobDir = path
+	}
+	if size, err := strconv.ParseInt(os.Getenv("MAX_MESSAGE_SIZE"), 10, 64); err == nil {
+		cfg.MaxMessageSize = size
+	}
+	if size, err := strconv.ParseInt(os.Getenv("INLINE_LIMIT"), 10, 64); err == nil {
+		cfg.InlineLimit = size
+	}
+	if path := os.Getenv("COMPAT_DATA"); path != "" {
+		cfg.CompatData = path
+	}
+	if bases := os.Getenv("LINK_CHECK_BASE"); bases != "" {
+		cfg.LinkCheckBase = bases
+	}
+	if threshold, err := strconv.ParseFloat(os.Getenv("SPAM_THRESHOLD"), 64); err == nil {
+		cfg.SpamThreshold = threshold
+	}
+	if addr := os.Getenv("SPAMD_ADDR"); addr != "" {
+		cfg.Spamd = addr
+	}
+	if path := os.Getenv("EXTRACTORS"); path != "" {
+		cfg.Extractors = path
+	}
+	if backend := os.Getenv("STORAGE"); backend != "" {
+		cfg.Storage = backend
+	}
+	if options := os.Getenv("STORAGE_OPTS"); options != "" {
+		cfg.StorageOptions = options
+	}
+	if dryRun := os.Getenv("MIGRATE_DRY_RUN"); dryRun == "true" {
+		cfg.MigrateDryRun = true
+	}
+	if age, err := time.ParseDuration(os.Getenv("RETENTION_MAX_AGE")); err == nil {
+		cfg.RetentionMaxAge = age
+	}
+	if count, err := strconv.Atoi(os.Getenv("RETENTION_MAX_COUNT")); err == nil {
+		cfg.RetentionMaxCount = count
+	}
+	if size, err := strconv.ParseInt(os.Getenv("RETENTION_MAX_SIZE"), 10, 64); err == nil {
+		cfg.RetentionMaxSize = size
+	}
+	if interval, err := time.ParseDuration(os.Getenv("RETENTION_INTERVAL")); err == nil {
+		cfg.RetentionInterval = interval
+	}
+	if path := os.Getenv("RETENTION_MAILBOXES"); path != "" {
+		cfg.RetentionMailboxes = path
+	}
+	// The keyring passphrase is only read from the environment so it does
+	// not show up in process listings
+	cfg.PGPPassphrase = os.Getenv("PGP_PASSPHRASE")
+
+	if cfg.BlobDir == "" {
+		cfg.BlobDir = filepath.Join(filepath.Dir(cfg.DBPath), "blobs")
+	}
 
 	return cfg
 }
\ No newline at end of file
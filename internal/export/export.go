@@ -0,0 +1,110 @@
+// Package export writes captured emails as mbox, Maildir or a zip of .eml
+// files for other mail tools, always from the message exactly as received.
+package export
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"mailcatch/internal/blob"
+	"mailcatch/internal/models"
+	"mailcatch/internal/storage"
+)
+
+// Export formats
+const (
+	FormatMbox    = "mbox"
+	FormatMaildir = "maildir"
+	FormatZip     = "zip"
+)
+
+// Formats lists the export formats
+var Formats = []string{FormatMbox, FormatMaildir, FormatZip}
+
+// Writer receives the exported messages. Close completes the output but
+// does not close the underlying writer.
+type Writer interface {
+	Add(email *models.Email, raw io.Reader) error
+	Close() error
+}
+
+// NewWriter returns a writer of format to a stream. Maildir, being a
+// directory, is written as a zip of the Maildir tree.
+func NewWriter(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case FormatMbox:
+		return NewMboxWriter(w), nil
+	case FormatMaildir:
+		return NewMaildirZipWriter(w), nil
+	case FormatZip:
+		return NewZipWriter(w), nil
+	}
+	return nil, fmt.Errorf("unknown export format %q (formats: mbox, maildir, zip)", format)
+}
+
+// ContentType returns the media type of a stream written by NewWriter
+func ContentType(format string) string {
+	if format == FormatMbox {
+		return "application/mbox"
+	}
+	return "application/zip"
+}
+
+// Filename returns a name for a stream written by NewWriter at t
+func Filename(format string, t time.Time) string {
+	name := "mailcatch-" + t.Format("20060102-150405")
+	switch format {
+	case FormatMbox:
+		return name + ".mbox"
+	case FormatMaildir:
+		return name + "-maildir.zip"
+	}
+	return name + ".zip"
+}
+
+// pageSize is the number of summaries read at a time
+const pageSize = 500
+
+// Export adds the emails matching filter to w, oldest first, and returns
+// how many were added
+func Export(store storage.Storage, blobs *blob.Store, filter storage.EmailFilter, w Writer) (int, error) {
+	count := 0
+	page := storage.PageRequest{Limit: pageSize, Sort: storage.SortReceived}
+	for {
+		result, err := store.GetEmails(filter, page)
+		if err != nil {
+			return count, fmt.Errorf("failed to list emails: %w", err)
+		}
+
+		for _, summary := range result.Emails {
+			email, err := store.GetEmail(summary.ID)
+			if err != nil {
+				// Deleted meanwhile
+				continue
+			}
+			if err := add(w, blobs, email); err != nil {
+				return count, fmt.Errorf("failed to export email %d: %w", email.ID, err)
+			}
+			count++
+		}
+
+		if result.NextCursor == "" {
+			return count, nil
+		}
+		if page.After, err = storage.DecodeCursor(result.NextCursor); err != nil {
+			return count, err
+		}
+	}
+}
+
+// add streams the raw message of email to w
+func add(w Writer, blobs *blob.Store, email *models.Email) error {
+	raw, err := blobs.OpenRaw(email)
+	if err != nil {
+		return err
+	}
+	defer raw.Close()
+
+	return w.Add(email, raw)
+}
@@ -0,0 +1,99 @@
+package export
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"mailcatch/internal/models"
+)
+
+// maildirName returns a unique Maildir file name for an email, following
+// the time.MusecQdelivery.host convention
+func maildirName(email *models.Email) string {
+	return fmt.Sprintf("%d.M%dQ%d.mailcatch", email.CreatedAt.Unix(), email.CreatedAt.Nanosecond()/1000, email.ID)
+}
+
+// maildirWriter delivers messages to a Maildir directory, writing each to
+// tmp and moving it to new once complete
+type maildirWriter struct {
+	dir string
+}
+
+// NewMaildirWriter returns a writer of a Maildir, creating its directories
+func NewMaildirWriter(dir string) (Writer, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create maildir: %w", err)
+		}
+	}
+	return &maildirWriter{dir: dir}, nil
+}
+
+func (m *maildirWriter) Add(email *models.Email, raw io.Reader) error {
+	name := maildirName(email)
+	tmp := filepath.Join(m.dir, "tmp", name)
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, raw)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	os.Chtimes(tmp, email.CreatedAt, email.CreatedAt)
+	return os.Rename(tmp, filepath.Join(m.dir, "new", name))
+}
+
+func (m *maildirWriter) Close() error {
+	return nil
+}
+
+// maildirZipWriter writes a zip of a Maildir tree
+type maildirZipWriter struct {
+	zip     *zip.Writer
+	started bool
+}
+
+// NewMaildirZipWriter returns a writer of a zip holding a Maildir
+// directory
+func NewMaildirZipWriter(w io.Writer) Writer {
+	return &maildirZipWriter{zip: zip.NewWriter(w)}
+}
+
+// start adds the Maildir directories, which must exist even when empty
+func (m *maildirZipWriter) start() error {
+	if m.started {
+		return nil
+	}
+	m.started = true
+
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if _, err := m.zip.Create("Maildir/" + sub + "/"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *maildirZipWriter) Add(email *models.Email, raw io.Reader) error {
+	if err := m.start(); err != nil {
+		return err
+	}
+	return addZipEntry(m.zip, "Maildir/new/"+maildirName(email), email, raw)
+}
+
+func (m *maildirZipWriter) Close() error {
+	if err := m.start(); err != nil {
+		return err
+	}
+	return m.zip.Close()
+}
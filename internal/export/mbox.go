@@ -0,0 +1,82 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/mail"
+	"strings"
+	"time"
+
+	"mailcatch/internal/models"
+)
+
+// mboxWriter writes the mboxrd variant, where lines of the message that
+// look like a From separator, possibly quoted already, get one more '>'.
+// Readers remove that quoting again, so messages come back byte for byte
+// as long as they end with a newline; mboxrd has no way to mark a message
+// without one, which comes back with a final newline added.
+type mboxWriter struct {
+	w *bufio.Writer
+}
+
+// NewMboxWriter returns a writer of an mboxrd mailbox
+func NewMboxWriter(w io.Writer) Writer {
+	return &mboxWriter{w: bufio.NewWriter(w)}
+}
+
+func (m *mboxWriter) Add(email *models.Email, raw io.Reader) error {
+	fmt.Fprintf(m.w, "From %s %s\n", envelopeSender(email.From), email.CreatedAt.UTC().Format(time.ANSIC))
+
+	r := bufio.NewReader(raw)
+	lineStart := true
+	for {
+		line, err := r.ReadSlice('\n')
+		if len(line) > 0 {
+			if lineStart && isFromLine(line) {
+				m.w.WriteByte('>')
+			}
+			m.w.Write(line)
+			lineStart = line[len(line)-1] == '\n'
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// Each message ends with a blank line before the next separator
+	if !lineStart {
+		m.w.WriteByte('\n')
+	}
+	_, err := m.w.WriteString("\n")
+	return err
+}
+
+func (m *mboxWriter) Close() error {
+	return m.w.Flush()
+}
+
+// isFromLine reports whether a line would be read as a separator once
+// unquoted: any number of '>' followed by "From "
+func isFromLine(line []byte) bool {
+	return bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From "))
+}
+
+// envelopeSender returns the address of the separator line, which cannot
+// contain spaces
+func envelopeSender(from string) string {
+	if address, err := mail.ParseAddress(from); err == nil {
+		from = address.Address
+	}
+	if fields := strings.Fields(from); len(fields) > 0 {
+		return fields[0]
+	}
+	return "MAILER-DAEMON"
+}
@@ -0,0 +1,44 @@
+package export
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"mailcatch/internal/models"
+)
+
+func TestMboxWriter(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"CRLF", "Subject: a\r\n\r\nbody\r\n", "Subject: a\r\n\r\nbody\r\n\n"},
+		{"From lines", "Subject: a\n\nFrom here\n>From quoted\n>>From twice\nFromage\n",
+			"Subject: a\n\n>From here\n>>From quoted\n>>>From twice\nFromage\n\n"},
+		{"From only at line start", "Subject: a\n\nsent From here\n", "Subject: a\n\nsent From here\n\n"},
+		{"no final newline", "Subject: a\n\nbody", "Subject: a\n\nbody\n\n"},
+		{"long From line", "Subject: a\n\nFrom " + strings.Repeat("x", 5000) + "\nFrom b\n",
+			"Subject: a\n\n>From " + strings.Repeat("x", 5000) + "\n>From b\n\n"},
+	}
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewMboxWriter(&buf)
+			email := &models.Email{From: "Alice <alice@example.com>", CreatedAt: created}
+			if err := w.Add(email, strings.NewReader(tt.raw)); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			want := "From alice@example.com Tue Jan  2 03:04:05 2024\n" + tt.want
+			if buf.String() != want {
+				t.Errorf("mbox\n%q\nwant\n%q", buf.String(), want)
+			}
+		})
+	}
+}
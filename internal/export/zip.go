@@ -0,0 +1,40 @@
+package export
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+
+	"mailcatch/internal/models"
+)
+
+// zipWriter writes one .eml entry per message
+type zipWriter struct {
+	zip *zip.Writer
+}
+
+// NewZipWriter returns a writer of a zip of .eml files
+func NewZipWriter(w io.Writer) Writer {
+	return &zipWriter{zip: zip.NewWriter(w)}
+}
+
+func (z *zipWriter) Add(email *models.Email, raw io.Reader) error {
+	return addZipEntry(z.zip, fmt.Sprintf("email-%d.eml", email.ID), email, raw)
+}
+
+func (z *zipWriter) Close() error {
+	return z.zip.Close()
+}
+
+func addZipEntry(w *zip.Writer, name string, email *models.Email, raw io.Reader) error {
+	f, err := w.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: email.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, raw)
+	return err
+}
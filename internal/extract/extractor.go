@@ -0,0 +1,213 @@
+// Package extract pulls one-time codes, magic links and other values out of
+// captured emails for end-to-end tests.
+package extract
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"mailcatch/internal/links"
+	"mailcatch/internal/models"
+	"mailcatch/internal/textcheck"
+)
+
+// Preset is a named extractor. Patterns are tried in order and the first
+// one with matches wins; the first capture group, if any, is the value.
+// Link presets yield the URLs the patterns match.
+type Preset struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Links matches the patterns against the URLs of the email instead of
+	// its text
+	Links    bool     `json:"links,omitempty"`
+	Patterns []string `json:"patterns"`
+
+	compiled []*regexp.Regexp
+}
+
+// Result is the outcome of an extraction
+type Result struct {
+	Preset  string   `json:"preset,omitempty"`
+	EmailID int      `json:"email_id"`
+	Value   string   `json:"value"`
+	Matches []string `json:"matches"`
+}
+
+var builtinPresets = []*Preset{
+	{
+		Name:        "otp",
+		Description: "Numeric one-time code, preferring one next to a word like code or PIN",
+		Patterns: []string{
+			`(?i)(?:code|otp|pin|passcode|password|token|verification)[^0-9\n]{0,40}\b([0-9]{4,8})\b`,
+			`\b([0-9]{6})\b`,
+			`\b([0-9]{4,8})\b`,
+		},
+	},
+	{
+		Name:        "magic-link",
+		Description: "Sign-in, verification or reset link",
+		Links:       true,
+		Patterns: []string{
+			`(?i)^https?://.*(?:magic|login|signin|sign-in|verify|confirm|activate|reset|token|auth)`,
+		},
+	},
+	{
+		Name:        "link",
+		Description: "Any http(s) link; narrow it down with the match parameter",
+		Links:       true,
+		Patterns:    []string{`(?i)^https?://`},
+	},
+}
+
+// Extractor holds the available presets
+type Extractor struct {
+	presets map[string]*Preset
+}
+
+// NewExtractor returns an extractor with the built-in presets
+func NewExtractor() *Extractor {
+	e := &Extractor{presets: make(map[string]*Preset)}
+	for _, preset := range builtinPresets {
+		if err := e.add(preset); err != nil {
+			panic(err)
+		}
+	}
+	return e
+}
+
+// LoadPresets adds the presets of a JSON file, a list in the format of
+// Preset. Presets named like a built-in one replace it.
+func (e *Extractor) LoadPresets(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var presets []*Preset
+	if err := json.Unmarshal(data, &presets); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for _, preset := range presets {
+		if err := e.add(preset); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func (e *Extractor) add(preset *Preset) error {
+	if preset.Name == "" || len(preset.Patterns) == 0 {
+		return fmt.Errorf("preset %q needs a name and at least one pattern", preset.Name)
+	}
+
+	preset.compiled = nil
+	for _, pattern := range preset.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("preset %s: %w", preset.Name, err)
+		}
+		preset.compiled = append(preset.compiled, re)
+	}
+	e.presets[preset.Name] = preset
+	return nil
+}
+
+// Presets returns the available presets ordered by name
+func (e *Extractor) Presets() []*Preset {
+	presets := make([]*Preset, 0, len(e.presets))
+	for _, preset := range e.presets {
+		presets = append(presets, preset)
+	}
+	sort.Slice(presets, func(i, j int) bool {
+		return presets[i].Name < presets[j].Name
+	})
+	return presets
+}
+
+// Preset returns the preset with the given name
+func (e *Extractor) Preset(name string) (*Preset, bool) {
+	preset, ok := e.presets[name]
+	return preset, ok
+}
+
+// Custom builds a one-off preset from a regular expression. With links
+// set it is matched against the URLs of the email.
+func Custom(pattern string, links bool) (*Preset, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &Preset{Links: links, Patterns: []string{pattern}, compiled: []*regexp.Regexp{re}}, nil
+}
+
+// Extract applies a preset to an email. match, if set, keeps only values
+// containing it. The result is nil when nothing matched.
+func Extract(email *models.Email, preset *Preset, match string) *Result {
+	var candidates []string
+	if preset.Links {
+		seen := make(map[string]bool)
+		for _, link := range links.Extract(nil, email.Body, email.HTML) {
+			if !seen[link.URL] && (link.Source == links.SourceText || link.Source == links.SourceAnchor) {
+				seen[link.URL] = true
+				candidates = append(candidates, link.URL)
+			}
+		}
+	} else {
+		candidates = []string{email.Subject, email.Body, htmlText(email)}
+	}
+
+	for _, re := range preset.compiled {
+		var matches []string
+		for _, candidate := range candidates {
+			// Link presets select whole URLs
+			if preset.Links {
+				if re.MatchString(candidate) && strings.Contains(candidate, match) {
+					matches = append(matches, candidate)
+				}
+				continue
+			}
+
+			for _, found := range re.FindAllStringSubmatch(candidate, -1) {
+				value := found[0]
+				if len(found) > 1 {
+					value = found[1]
+				}
+				if value != "" && strings.Contains(value, match) {
+					matches = append(matches, value)
+				}
+			}
+		}
+		if len(matches) > 0 {
+			return &Result{Preset: preset.Name, EmailID: email.ID, Value: matches[0], Matches: unique(matches)}
+		}
+	}
+	return nil
+}
+
+// htmlText returns the text of the HTML body, reusing the rendering done
+// when the email was received
+func htmlText(email *models.Email) string {
+	if email.TextCheck != nil {
+		return email.TextCheck.Generated
+	}
+	if email.HTML == "" {
+		return ""
+	}
+	return textcheck.Render(email.HTML)
+}
+
+func unique(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	result := values[:0]
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+	return result
+}
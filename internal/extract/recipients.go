@@ -0,0 +1,54 @@
+package extract
+
+import (
+	"net/mail"
+	"strings"
+
+	"mailcatch/internal/models"
+)
+
+// Addressed reports whether address is one of the recipients of the email,
+// from the SMTP envelope or the To and Cc headers. Addresses are compared
+// whole and case-insensitively, so bob@example.com does not match
+// jimbob@example.com.
+func Addressed(email *models.Email, address string) bool {
+	address = strings.ToLower(strings.TrimSpace(address))
+	if address == "" {
+		return false
+	}
+
+	lists := []string{email.To}
+	lists = append(lists, email.Headers["To"]...)
+	lists = append(lists, email.Headers["Cc"]...)
+	for _, list := range lists {
+		for _, recipient := range recipients(list) {
+			if recipient == address {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// recipients returns the lowercased addresses of an address list. Lists
+// that do not parse as a whole are split on commas.
+func recipients(list string) []string {
+	var addresses []string
+	if parsed, err := mail.ParseAddressList(list); err == nil {
+		for _, address := range parsed {
+			addresses = append(addresses, strings.ToLower(address.Address))
+		}
+		return addresses
+	}
+
+	for _, recipient := range strings.Split(list, ",") {
+		recipient = strings.TrimSpace(recipient)
+		if parsed, err := mail.ParseAddress(recipient); err == nil {
+			recipient = parsed.Address
+		}
+		if recipient != "" {
+			addresses = append(addresses, strings.ToLower(recipient))
+		}
+	}
+	return addresses
+}
@@ -0,0 +1,35 @@
+package extract
+
+import (
+	"testing"
+
+	"mailcatch/internal/models"
+)
+
+func TestAddressed(t *testing.T) {
+	tests := []struct {
+		name    string
+		email   *models.Email
+		address string
+		want    bool
+	}{
+		{"envelope", &models.Email{To: "bob@example.com"}, "bob@example.com", true},
+		{"case-insensitive", &models.Email{To: "Bob@Example.COM"}, "bob@example.com", true},
+		{"one of several", &models.Email{To: "alice@example.com, bob@example.com"}, "bob@example.com", true},
+		{"suffix of another address", &models.Email{To: "jimbob@example.com"}, "bob@example.com", false},
+		{"prefix of another address", &models.Email{To: "bob@example.com.evil"}, "bob@example.com", false},
+		{"subdomain", &models.Email{To: "bob@mail.example.com"}, "bob@example.com", false},
+		{"To header with a name", &models.Email{Headers: map[string][]string{"To": {`"Bob" <bob@example.com>`}}}, "bob@example.com", true},
+		{"Cc header", &models.Email{To: "alice@example.com", Headers: map[string][]string{"Cc": {"jimbob@example.com, bob@example.com"}}}, "bob@example.com", true},
+		{"Cc header of another address", &models.Email{Headers: map[string][]string{"Cc": {"Jim <jimbob@example.com>"}}}, "bob@example.com", false},
+		{"empty address", &models.Email{To: "bob@example.com"}, "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Addressed(test.email, test.address); got != test.want {
+				t.Errorf("Addressed(%q) = %v, want %v", test.address, got, test.want)
+			}
+		})
+	}
+}
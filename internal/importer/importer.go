@@ -0,0 +1,338 @@
+// Package importer loads existing mail from mbox, Maildir, .eml and zip
+// files, parsing each message as if it had been received over SMTP.
+package importer
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/mail"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"mailcatch/internal/models"
+	"mailcatch/internal/smtp"
+	"mailcatch/internal/storage"
+)
+
+// Import formats
+const (
+	FormatMbox    = "mbox"
+	FormatMaildir = "maildir"
+	FormatEML     = "eml"
+	// FormatZip is a zip of .eml files or of a Maildir, as written by the
+	// export
+	FormatZip = "zip"
+)
+
+// Options control an import
+type Options struct {
+	// Format is one of the Format constants, detected from the input
+	// when empty
+	Format string
+	// PreserveDates keeps the original date of each message as the
+	// received time: the mbox separator date, else the Date header, else
+	// the file time. Otherwise the time of the import is used.
+	PreserveDates bool
+}
+
+// Result reports the outcome of an import
+type Result struct {
+	Imported int       `json:"imported"`
+	IDs      []int     `json:"ids"`
+	Failed   []Failure `json:"failed,omitempty"`
+}
+
+// Failure is a message that could not be imported
+type Failure struct {
+	File  string `json:"file"`
+	Index int    `json:"index,omitempty"`
+	Error string `json:"error"`
+}
+
+// Importer parses messages and saves them to a storage
+type Importer struct {
+	storage storage.Storage
+	parse   smtp.Options
+	analyze func(*models.Email)
+}
+
+// New returns an importer parsing messages with the options of the SMTP
+// server. analyze, if set, runs the checks of received messages on each
+// imported one before it is saved.
+func New(storage storage.Storage, parse smtp.Options, analyze func(*models.Email)) *Importer {
+	return &Importer{storage: storage, parse: parse, analyze: analyze}
+}
+
+// Import reads the messages of a stream named name. saved, if set, is
+// called with each saved email.
+func (i *Importer) Import(r io.Reader, name string, options Options, saved func(*models.Email)) (*Result, error) {
+	reader := bufio.NewReader(r)
+	format := options.Format
+	if format == "" {
+		head, _ := reader.Peek(5)
+		format = detect(name, head)
+	}
+
+	run := &run{importer: i, options: options, saved: saved, result: &Result{IDs: []int{}}, now: time.Now()}
+	switch format {
+	case FormatMbox:
+		return run.result, run.mbox(reader, name)
+	case FormatEML:
+		return run.result, run.message(reader, smtp.Envelope{}, models.ImportSource{Format: FormatEML, File: name}, time.Time{}, time.Time{})
+	case FormatZip:
+		// Zip files are read from their end, so the stream is spooled
+		spool, err := os.CreateTemp("", "mailcatch-import-*.zip")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+
+		size, err := io.Copy(spool, reader)
+		if err != nil {
+			return nil, err
+		}
+		return run.result, run.zip(spool, size)
+	case FormatMaildir:
+		return nil, fmt.Errorf("a maildir can only be imported from a directory or a zip")
+	}
+	return nil, fmt.Errorf("unknown import format %q (formats: mbox, maildir, eml, zip)", format)
+}
+
+// ImportPath reads the messages of a file, or of a Maildir directory
+func (i *Importer) ImportPath(filename string, options Options, saved func(*models.Email)) (*Result, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		if options.Format != "" && options.Format != FormatMaildir {
+			return nil, fmt.Errorf("a directory can only be imported as a maildir")
+		}
+		run := &run{importer: i, options: options, saved: saved, result: &Result{IDs: []int{}}, now: time.Now()}
+		return run.result, run.maildir(os.DirFS(filename), filename)
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if options.Format == FormatEML || options.Format == "" && detect(filename, peek(file)) == FormatEML {
+		// A single message dates from its file when it has no Date header
+		run := &run{importer: i, options: options, saved: saved, result: &Result{IDs: []int{}}, now: time.Now()}
+		return run.result, run.message(file, smtp.Envelope{}, models.ImportSource{Format: FormatEML, File: filename}, time.Time{}, info.ModTime())
+	}
+	return i.Import(file, filename, options, saved)
+}
+
+// detect guesses the format of a stream from its name and first bytes
+func detect(name string, head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")) || strings.EqualFold(path.Ext(name), ".zip"):
+		return FormatZip
+	case bytes.HasPrefix(head, []byte("From ")):
+		return FormatMbox
+	}
+	return FormatEML
+}
+
+// peek returns the first bytes of a file and rewinds it
+func peek(file *os.File) []byte {
+	head := make([]byte, 5)
+	n, _ := io.ReadFull(file, head)
+	file.Seek(0, io.SeekStart)
+	return head[:n]
+}
+
+// run is the state of one import
+type run struct {
+	importer *Importer
+	options  Options
+	saved    func(*models.Email)
+	result   *Result
+	now      time.Time
+}
+
+// message parses and saves one message, delivered at the time recorded by
+// the mailbox format, if any, and read from a file last modified at
+// modified. Parse and storage failures of a message are recorded in the
+// result; only read errors of the input stop the import.
+func (r *run) message(reader io.Reader, envelope smtp.Envelope, from models.ImportSource, delivered, modified time.Time) error {
+	email, err := smtp.ParseMessage(reader, envelope, r.importer.parse)
+	if err != nil {
+		r.result.Failed = append(r.result.Failed, Failure{File: from.File, Index: from.Index, Error: err.Error()})
+		// Skip the rest of a message over the size limit
+		_, err := io.Copy(io.Discard, reader)
+		return err
+	}
+
+	from.ImportedAt = r.now
+	email.Source = &from
+	email.CreatedAt = r.now
+	if r.options.PreserveDates {
+		email.CreatedAt = originalDate(email, delivered, modified, r.now)
+	}
+
+	if r.importer.analyze != nil {
+		r.importer.analyze(email)
+	}
+	if err := r.importer.storage.SaveEmail(email); err != nil {
+		r.importer.parse.Blobs.DeleteEmail(email)
+		r.result.Failed = append(r.result.Failed, Failure{File: from.File, Index: from.Index, Error: err.Error()})
+		return nil
+	}
+
+	r.result.Imported++
+	r.result.IDs = append(r.result.IDs, email.ID)
+	if r.saved != nil {
+		r.saved(email)
+	}
+	return nil
+}
+
+// originalDate returns the delivery time of an email if known, else its
+// Date header, else the file time, else now
+func originalDate(email *models.Email, delivered, modified, now time.Time) time.Time {
+	if !delivered.IsZero() {
+		return delivered
+	}
+	if dates := email.Headers["Date"]; len(dates) > 0 {
+		if t, err := mail.ParseDate(dates[0]); err == nil {
+			return t
+		}
+	}
+	if !modified.IsZero() {
+		return modified
+	}
+	return now
+}
+
+func (r *run) mbox(reader io.Reader, name string) error {
+	mbox, err := newMboxReader(reader)
+	if err != nil {
+		return err
+	}
+
+	for index := 1; ; index++ {
+		message, err := mbox.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// The separator carries the envelope sender
+		envelope := smtp.Envelope{}
+		if message.Sender != "MAILER-DAEMON" {
+			envelope.From = message.Sender
+		}
+		if err := r.message(message, envelope, models.ImportSource{Format: FormatMbox, File: name, Index: index}, message.Date, time.Time{}); err != nil {
+			return err
+		}
+	}
+}
+
+// maildir imports the messages in the cur and new directories of a
+// Maildir, in the order of their names, which start with the delivery
+// time
+func (r *run) maildir(dir fs.FS, name string) error {
+	if !isMaildir(dir) {
+		return fmt.Errorf("not a maildir, there is no cur or new directory")
+	}
+
+	var files []string
+	for _, sub := range []string{"cur", "new"} {
+		entries, err := fs.ReadDir(dir, sub)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.Type().IsRegular() {
+				files = append(files, path.Join(sub, entry.Name()))
+			}
+		}
+	}
+	sort.Slice(files, func(a, b int) bool { return path.Base(files[a]) < path.Base(files[b]) })
+
+	for _, file := range files {
+		if err := r.fsMessage(dir, file, models.ImportSource{Format: FormatMaildir, File: filepath.Join(name, file)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isMaildir reports whether dir has the cur or new directory of a Maildir
+func isMaildir(dir fs.FS) bool {
+	for _, sub := range []string{"cur", "new"} {
+		if info, err := fs.Stat(dir, sub); err == nil && info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// fsMessage imports a message file, dated by the Date header or else its
+// modification time
+func (r *run) fsMessage(dir fs.FS, name string, from models.ImportSource) error {
+	file, err := dir.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var fileTime time.Time
+	if info, err := file.Stat(); err == nil {
+		fileTime = info.ModTime()
+	}
+	return r.message(file, smtp.Envelope{}, from, time.Time{}, fileTime)
+}
+
+// zip imports the files of a zip archive: a Maildir tree, as the export
+// writes it, or any number of .eml files
+func (r *run) zip(file io.ReaderAt, size int64) error {
+	archive, err := zip.NewReader(file, size)
+	if err != nil {
+		return fmt.Errorf("invalid zip file: %w", err)
+	}
+
+	// A Maildir is recognized by its cur and new directories, which may
+	// be implied by the files in them
+	for _, entry := range archive.File {
+		name := strings.TrimSuffix(entry.Name, "/")
+		if !entry.FileInfo().IsDir() {
+			name = path.Dir(name)
+		}
+		if sub := path.Base(name); sub != "cur" && sub != "new" {
+			continue
+		}
+		dir := path.Dir(name)
+		if maildir, err := fs.Sub(archive, dir); err == nil && isMaildir(maildir) {
+			return r.maildir(maildir, dir)
+		}
+	}
+
+	for _, entry := range archive.File {
+		if entry.FileInfo().IsDir() || !strings.EqualFold(path.Ext(entry.Name), ".eml") {
+			continue
+		}
+		if err := r.fsMessage(archive, entry.Name, models.ImportSource{Format: FormatEML, File: entry.Name}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
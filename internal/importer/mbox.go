@@ -0,0 +1,158 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// mboxReader splits an mboxrd mailbox into messages without holding them
+// in memory. The quoting of From lines is removed and the blank line
+// before each separator dropped, so messages written by the export come
+// back byte for byte, except that one written without a final newline
+// ends with one.
+type mboxReader struct {
+	r *bufio.Reader
+	// separator is the From line of the next message, nil at the end
+	separator []byte
+}
+
+// mboxMessage is a message of a mailbox with its separator line
+type mboxMessage struct {
+	Sender string
+	// Date is the delivery time of the separator line, zero if it cannot
+	// be parsed
+	Date time.Time
+	body *mboxBody
+}
+
+func (m *mboxMessage) Read(p []byte) (int, error) {
+	return m.body.Read(p)
+}
+
+func newMboxReader(r io.Reader) (*mboxReader, error) {
+	m := &mboxReader{r: bufio.NewReader(r)}
+	line, err := m.readLine()
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(line) > 0 && !bytes.HasPrefix(line, []byte("From ")) {
+		return nil, errors.New("not an mbox file, it does not start with a From line")
+	}
+	if len(line) > 0 {
+		m.separator = line
+	}
+	return m, nil
+}
+
+// readLine reads a whole line, however long
+func (m *mboxReader) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := m.r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if err != bufio.ErrBufferFull {
+			return line, err
+		}
+	}
+}
+
+// Next returns the next message, which must be read to the end before
+// calling Next again, or io.EOF after the last one
+func (m *mboxReader) Next() (*mboxMessage, error) {
+	if m.separator == nil {
+		return nil, io.EOF
+	}
+
+	message := &mboxMessage{body: &mboxBody{mbox: m, lineStart: true}}
+	fields := strings.Fields(strings.TrimSpace(string(m.separator)))
+	if len(fields) > 1 {
+		message.Sender = fields[1]
+	}
+	if len(fields) > 2 {
+		date := strings.Join(fields[2:], " ")
+		for _, layout := range []string{time.ANSIC, time.UnixDate, time.RubyDate} {
+			if t, err := time.Parse(layout, date); err == nil {
+				message.Date = t
+				break
+			}
+		}
+	}
+	m.separator = nil
+	return message, nil
+}
+
+// mboxBody reads one message up to the next separator
+type mboxBody struct {
+	mbox      *mboxReader
+	pending   []byte
+	lineStart bool
+	// blank is a blank line held back, since the one before a separator
+	// is not part of the message
+	blank bool
+	done  bool
+	err   error
+}
+
+func (b *mboxBody) Read(p []byte) (int, error) {
+	for len(b.pending) == 0 {
+		if b.done {
+			return 0, b.err
+		}
+		b.fill()
+	}
+
+	n := copy(p, b.pending)
+	b.pending = b.pending[n:]
+	return n, nil
+}
+
+// fill reads the next chunk of the message into pending
+func (b *mboxBody) fill() {
+	chunk, err := b.mbox.r.ReadSlice('\n')
+	if err != nil && err != bufio.ErrBufferFull && err != io.EOF {
+		b.done, b.err = true, err
+		return
+	}
+	complete := err == nil
+
+	if b.lineStart && bytes.HasPrefix(chunk, []byte("From ")) {
+		// The separator of the next message ends this one
+		separator := append([]byte(nil), chunk...)
+		if err == bufio.ErrBufferFull {
+			rest, err := b.mbox.readLine()
+			if err != nil && err != io.EOF {
+				b.done, b.err = true, err
+				return
+			}
+			separator = append(separator, rest...)
+		}
+		b.mbox.separator = separator
+		b.done, b.err = true, io.EOF
+		return
+	}
+
+	if b.lineStart && complete && string(chunk) == "\n" {
+		if b.blank {
+			b.pending = append(b.pending, '\n')
+		}
+		b.blank = true
+	} else if len(chunk) > 0 {
+		if b.blank {
+			b.pending = append(b.pending, '\n')
+			b.blank = false
+		}
+		if b.lineStart && bytes.HasPrefix(bytes.TrimLeft(chunk, ">"), []byte("From ")) {
+			chunk = chunk[1:]
+		}
+		b.pending = append(b.pending, chunk...)
+	}
+	b.lineStart = complete
+
+	if err == io.EOF {
+		b.done, b.err = true, io.EOF
+	}
+}
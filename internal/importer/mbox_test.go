@@ -0,0 +1,78 @@
+package importer
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"mailcatch/internal/export"
+	"mailcatch/internal/models"
+)
+
+// Messages exported to an mbox file are imported back as they were
+func TestMboxRoundTrip(t *testing.T) {
+	messages := []struct {
+		name string
+		raw  string
+		// want is the imported message when it differs from raw
+		want string
+	}{
+		{"CRLF", "Subject: crlf\r\n\r\nline one\r\nline two\r\n", ""},
+		{"CRLF with blank lines", "Subject: crlf\r\n\r\nbody\r\n\r\n\r\n", ""},
+		{"From lines", "Subject: from\n\nFrom here\n>From quoted\n>>From twice\nFromage\n", ""},
+		{"CRLF From lines", "Subject: from\r\n\r\nFrom here\r\n>From quoted\r\n>>From twice\r\n", ""},
+		{"blank lines at the end", "Subject: blank\n\nbody\n\n\n", ""},
+		{"long From line", "Subject: long\n\n>>From " + strings.Repeat("x", 10000) + "\nFrom end\n", ""},
+		{"no final newline", "Subject: unterminated\n\nbody", "Subject: unterminated\n\nbody\n"},
+		{"last", "Subject: last\r\n\r\nFrom the last one\r\n", ""},
+	}
+
+	var mbox bytes.Buffer
+	w := export.NewMboxWriter(&mbox)
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, message := range messages {
+		email := &models.Email{From: "alice@example.com", CreatedAt: created}
+		if err := w.Add(email, strings.NewReader(message.raw)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := newMboxReader(&mbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, message := range messages {
+		next, err := r.Next()
+		if err != nil {
+			t.Fatalf("%s: %v", message.name, err)
+		}
+		if next.Sender != "alice@example.com" || !next.Date.Equal(created) {
+			t.Errorf("%s: separator from %q at %v", message.name, next.Sender, next.Date)
+		}
+		raw, err := io.ReadAll(next)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := message.want
+		if want == "" {
+			want = message.raw
+		}
+		if string(raw) != want {
+			t.Errorf("%s: imported\n%q\nwant\n%q", message.name, raw, want)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("after the last message: %v, want EOF", err)
+	}
+}
+
+func TestMboxReaderRejectsOtherFiles(t *testing.T) {
+	if _, err := newMboxReader(strings.NewReader("Subject: not mbox\n\nbody\n")); err == nil {
+		t.Error("a file without a From line was read as mbox")
+	}
+}
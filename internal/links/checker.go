@@ -0,0 +1,134 @@
+package links
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxConcurrentChecks bounds the requests a single Check sends at once
+const maxConcurrentChecks = 4
+
+// Checker probes links that point at allow-listed base URLs, e.g. a locally
+// running application, so that broken links are caught in tests. Other
+// links are never requested.
+type Checker struct {
+	bases  []*url.URL
+	client *http.Client
+}
+
+func NewChecker(bases []string, timeout time.Duration) (*Checker, error) {
+	checker := &Checker{
+		client: &http.Client{
+			Timeout: timeout,
+			// Redirects are reported rather than followed, so a reset link
+			// that bounces to a login page does not pass as 200
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+
+	for _, base := range bases {
+		u, err := url.Parse(strings.TrimSpace(base))
+		if err != nil {
+			return nil, fmt.Errorf("invalid base URL %q: %w", base, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid base URL %q: must be an absolute http(s) URL", base)
+		}
+		checker.bases = append(checker.bases, u)
+	}
+	return checker, nil
+}
+
+// Allowed reports whether a URL is under one of the base URLs. Dot
+// segments are resolved first, as the server would, so /app/../admin is
+// not under /app.
+func (c *Checker) Allowed(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	urlPath := u.Path
+	if urlPath != "" {
+		urlPath = path.Clean(urlPath)
+	}
+	for _, base := range c.bases {
+		if !strings.EqualFold(u.Scheme, base.Scheme) || !strings.EqualFold(u.Host, base.Host) {
+			continue
+		}
+		prefix := strings.TrimSuffix(base.Path, "/")
+		if urlPath == prefix || strings.HasPrefix(urlPath, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Check requests the allowed links and records the outcome on them. Each
+// distinct URL is requested once.
+func (c *Checker) Check(ctx context.Context, links []*Link) {
+	byURL := make(map[string][]*Link)
+	var urls []string
+	for _, link := range links {
+		if !c.Allowed(link.URL) {
+			continue
+		}
+		if _, ok := byURL[link.URL]; !ok {
+			urls = append(urls, link.URL)
+		}
+		byURL[link.URL] = append(byURL[link.URL], link)
+	}
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, maxConcurrentChecks)
+	for _, u := range urls {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(u string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			result := c.probe(ctx, u)
+			for _, link := range byURL[u] {
+				link.Checked = true
+				link.Status = result.Status
+				link.Location = result.Location
+				link.Error = result.Error
+			}
+		}(u)
+	}
+	wg.Wait()
+}
+
+func (c *Checker) probe(ctx context.Context, u string) Link {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return Link{Error: err.Error()}
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return Link{Error: err.Error()}
+	}
+	resp.Body.Close()
+
+	return Link{Status: resp.StatusCode, Location: resp.Header.Get("Location")}
+}
+
+// Broken counts the checked links that failed or returned an error status
+func Broken(links []*Link) int {
+	broken := 0
+	for _, link := range links {
+		if link.Checked && (link.Error != "" || link.Status >= 400) {
+			broken++
+		}
+	}
+	return broken
+}
@@ -0,0 +1,40 @@
+package links
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckerAllowed(t *testing.T) {
+	checker, err := NewChecker([]string{"http://localhost:3000/app/", "https://staging.example.com"}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"http://localhost:3000/app", true},
+		{"http://localhost:3000/app/reset?token=1", true},
+		{"HTTP://LOCALHOST:3000/app/", true},
+		{"http://localhost:3000/app/./reset", true},
+		{"http://localhost:3000/app/x/../reset", true},
+		{"http://localhost:3000/application", false},
+		{"http://localhost:3000/app/../admin", false},
+		{"http://localhost:3000/app/%2e%2e/admin", false},
+		{"http://localhost:3000/app/x/../../admin", false},
+		{"http://localhost:3001/app/reset", false},
+		{"https://localhost:3000/app/reset", false},
+		{"https://staging.example.com", true},
+		{"https://staging.example.com/../anything", true},
+		{"https://example.com/", false},
+		{"mailto:a@example.com", false},
+		{"://invalid", false},
+	}
+	for _, tt := range tests {
+		if got := checker.Allowed(tt.url); got != tt.want {
+			t.Errorf("Allowed(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
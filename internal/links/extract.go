@@ -0,0 +1,214 @@
+// Package links extracts the URLs of an email and optionally checks them
+// against an allow-listed application.
+package links
+
+import (
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Link sources
+const (
+	SourceText            = "text"
+	SourceAnchor          = "anchor"
+	SourceImage           = "image"
+	SourceTrackingPixel   = "tracking-pixel"
+	SourceListUnsubscribe = "list-unsubscribe"
+)
+
+// Link is a URL found in an email
+type Link struct {
+	URL    string `json:"url"`
+	Source string `json:"source"`
+	// Text is the anchor text, or the alt text of an image
+	Text string `json:"text,omitempty"`
+	// DomainMismatch is set when the anchor text shows a domain other than
+	// the one the link points to
+	DomainMismatch bool `json:"domain_mismatch"`
+
+	// Set by Checker for links under an allowed base URL
+	Checked  bool   `json:"checked"`
+	Status   int    `json:"status,omitempty"`
+	Location string `json:"location,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+var (
+	textURLPattern = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"']+`)
+	// domainPattern matches anchor text that looks like a host name or URL
+	domainPattern = regexp.MustCompile(`(?i)^(?:[a-z][a-z0-9+.-]*://)?((?:[a-z0-9-]+\.)+[a-z]{2,})(?:[:/?#]\S*)?$`)
+)
+
+// Extract returns the links of the List-Unsubscribe header and of the text
+// and HTML bodies, in that order. header may be nil.
+func Extract(header mail.Header, text, htmlBody string) []*Link {
+	var links []*Link
+	if header != nil {
+		links = append(links, listUnsubscribe(header.Get("List-Unsubscribe"))...)
+	}
+	links = append(links, extractText(text)...)
+	return append(links, extractHTML(htmlBody)...)
+}
+
+// listUnsubscribe parses the angle bracketed URLs of RFC 2369
+func listUnsubscribe(value string) []*Link {
+	var links []*Link
+	for {
+		start := strings.IndexByte(value, '<')
+		if start == -1 {
+			return links
+		}
+		end := strings.IndexByte(value[start:], '>')
+		if end == -1 {
+			return links
+		}
+		if u := strings.Join(strings.Fields(value[start+1:start+end]), ""); u != "" {
+			links = append(links, &Link{URL: u, Source: SourceListUnsubscribe})
+		}
+		value = value[start+end+1:]
+	}
+}
+
+func extractText(text string) []*Link {
+	var links []*Link
+	for _, u := range textURLPattern.FindAllString(text, -1) {
+		links = append(links, &Link{URL: trimURL(u), Source: SourceText})
+	}
+	return links
+}
+
+// trimURL drops punctuation that usually ends the sentence rather than
+// the URL, keeping closing parentheses that have an opening one
+func trimURL(u string) string {
+	for {
+		trimmed := strings.TrimRight(u, ".,;:!?'\"")
+		if strings.HasSuffix(trimmed, ")") && strings.Count(trimmed, "(") < strings.Count(trimmed, ")") {
+			trimmed = trimmed[:len(trimmed)-1]
+		}
+		if trimmed == u {
+			return u
+		}
+		u = trimmed
+	}
+}
+
+func extractHTML(document string) []*Link {
+	var links []*Link
+	var anchor *Link
+	var anchorText strings.Builder
+
+	tokenizer := html.NewTokenizer(strings.NewReader(document))
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if anchor != nil {
+				finishAnchor(anchor, anchorText.String())
+			}
+			return links
+		case html.TextToken:
+			if anchor != nil {
+				anchorText.Write(tokenizer.Text())
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			if string(name) == "a" && anchor != nil {
+				finishAnchor(anchor, anchorText.String())
+				anchor = nil
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			attrs := make(map[string]string, len(token.Attr))
+			for _, attr := range token.Attr {
+				attrs[strings.ToLower(attr.Key)] = strings.TrimSpace(attr.Val)
+			}
+
+			switch token.Data {
+			case "a", "area":
+				href := attrs["href"]
+				if href == "" || strings.HasPrefix(href, "#") {
+					continue
+				}
+				link := &Link{URL: href, Source: SourceAnchor}
+				links = append(links, link)
+				if token.Data == "a" && token.Type == html.StartTagToken {
+					if anchor != nil {
+						finishAnchor(anchor, anchorText.String())
+					}
+					anchor = link
+					anchorText.Reset()
+				} else {
+					link.Text = attrs["alt"]
+				}
+			case "img":
+				src := attrs["src"]
+				if src == "" || strings.HasPrefix(src, "data:") || strings.HasPrefix(src, "cid:") {
+					continue
+				}
+				source := SourceImage
+				if isTrackingPixel(attrs) {
+					source = SourceTrackingPixel
+				}
+				links = append(links, &Link{URL: src, Source: source, Text: attrs["alt"]})
+				if anchor != nil && attrs["alt"] != "" {
+					anchorText.WriteString(" " + attrs["alt"] + " ")
+				}
+			}
+		}
+	}
+}
+
+func finishAnchor(link *Link, text string) {
+	link.Text = strings.Join(strings.Fields(text), " ")
+	link.DomainMismatch = domainMismatch(link.Text, link.URL)
+}
+
+// isTrackingPixel reports whether an image is at most 1x1 pixels or hidden
+func isTrackingPixel(attrs map[string]string) bool {
+	style := strings.ToLower(strings.ReplaceAll(attrs["style"], " ", ""))
+	if strings.Contains(style, "display:none") || strings.Contains(style, "visibility:hidden") {
+		return true
+	}
+
+	width, height := attrs["width"], attrs["height"]
+	for _, declaration := range strings.Split(style, ";") {
+		if value, ok := strings.CutPrefix(declaration, "width:"); ok {
+			width = value
+		} else if value, ok := strings.CutPrefix(declaration, "height:"); ok {
+			height = value
+		}
+	}
+	return tiny(width) && tiny(height)
+}
+
+func tiny(size string) bool {
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(size), "px"))
+	return err == nil && n <= 1
+}
+
+// domainMismatch reports whether anchor text that reads like a domain or
+// URL names a different registrable domain than the link target
+func domainMismatch(text, href string) bool {
+	match := domainPattern.FindStringSubmatch(text)
+	if match == nil {
+		return false
+	}
+	target, err := url.Parse(href)
+	if err != nil || target.Hostname() == "" {
+		return false
+	}
+	return registrableDomain(match[1]) != registrableDomain(target.Hostname())
+}
+
+func registrableDomain(host string) string {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if domain, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+		return domain
+	}
+	return host
+}
@@ -0,0 +1,108 @@
+package mailauth
+
+import (
+	"io"
+	"log"
+	"net/mail"
+	"strings"
+
+	"mailcatch/internal/models"
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+// verifyDKIM checks every DKIM-Signature header of the raw message
+func (v *Verifier) verifyDKIM(header mail.Header, raw io.Reader) []*models.DKIMResult {
+	if header == nil {
+		return nil
+	}
+
+	signatures := header["Dkim-Signature"]
+	if len(signatures) == 0 {
+		return nil
+	}
+
+	verifications, err := dkim.VerifyWithOptions(raw, &dkim.VerifyOptions{
+		LookupTXT: v.resolver.LookupTXT,
+	})
+	if err != nil && len(verifications) == 0 {
+		log.Printf("Error verifying DKIM signatures: %v", err)
+		results := make([]*models.DKIMResult, len(signatures))
+		for i, signature := range signatures {
+			results[i] = newDKIMResult(signature)
+			results[i].Result = "permerror"
+			results[i].Reason = trimDKIMError(err)
+		}
+		return results
+	}
+
+	results := make([]*models.DKIMResult, len(verifications))
+	for i, verification := range verifications {
+		result := &models.DKIMResult{}
+		if i < len(signatures) {
+			result = newDKIMResult(signatures[i])
+		}
+		if verification.Domain != "" {
+			result.Domain = verification.Domain
+		}
+		result.Identifier = verification.Identifier
+
+		switch {
+		case verification.Err == nil:
+			result.Result = "pass"
+		case dkim.IsTempFail(verification.Err):
+			result.Result = "temperror"
+		case dkim.IsPermFail(verification.Err):
+			result.Result = "permerror"
+		default:
+			result.Result = "fail"
+		}
+		if verification.Err != nil {
+			result.Reason = trimDKIMError(verification.Err)
+		}
+
+		results[i] = result
+	}
+
+	return results
+}
+
+// newDKIMResult fills in the signature tags that are reported even when
+// verification fails
+func newDKIMResult(signature string) *models.DKIMResult {
+	tags := parseTags(signature)
+	return &models.DKIMResult{
+		Domain:    tags["d"],
+		Selector:  tags["s"],
+		Algorithm: tags["a"],
+	}
+}
+
+// parseTags parses a DKIM style "tag=value; tag=value" list
+func parseTags(s string) map[string]string {
+	tags := make(map[string]string)
+	for _, part := range strings.Split(s, ";") {
+		key, value, found := strings.Cut(part, "=")
+		if !found {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		tags[key] = strings.Join(strings.Fields(value), "")
+	}
+	return tags
+}
+
+func dkimVerdict(results []*models.DKIMResult) string {
+	if len(results) == 0 {
+		return "none"
+	}
+	for _, result := range results {
+		if result.Result == "pass" {
+			return "pass"
+		}
+	}
+	return "fail"
+}
+
+func trimDKIMError(err error) string {
+	return strings.TrimPrefix(err.Error(), "dkim: ")
+}
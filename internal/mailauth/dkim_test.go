@@ -0,0 +1,155 @@
+package mailauth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"mailcatch/internal/models"
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+const dkimFixture = "From: Alice <alice@example.com>\r\n" +
+	"To: bob@example.net\r\n" +
+	"Subject: Quarterly numbers\r\n" +
+	"Date: Mon, 01 Jan 2024 12:00:00 +0000\r\n" +
+	"\r\n" +
+	"The numbers are in.\r\n" +
+	"See you on Monday.\r\n"
+
+func dkimSign(t *testing.T, message, domain, selector string, signer crypto.Signer) []byte {
+	t.Helper()
+	var signed bytes.Buffer
+	err := dkim.Sign(&signed, strings.NewReader(message), &dkim.SignOptions{
+		Domain:     domain,
+		Selector:   selector,
+		Signer:     signer,
+		HeaderKeys: []string{"From", "To", "Subject", "Date"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed.Bytes()
+}
+
+func TestVerifyDKIM(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The RSA key is served from a key map naming a PEM file, the ed25519
+	// key as a literal record
+	dir := t.TempDir()
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, "rsa.pem"), keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keyMap := "rsa example.com rsa.pem\n" +
+		"ed example.com v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(edPublic) + "\n" +
+		"bad example.com v=DKIM1; k=rsa; p=bm90IGEga2V5\n" +
+		"revoked example.com v=DKIM1; k=rsa; p=\n"
+	if err := os.WriteFile(filepath.Join(dir, "keys"), []byte(keyMap), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	zone := NewStaticResolver()
+	if err := zone.LoadKeyMap(filepath.Join(dir, "keys")); err != nil {
+		t.Fatal(err)
+	}
+	verifier := NewVerifier(brokenResolver{
+		Resolver: zone,
+		broken:   map[string]bool{"down._domainkey.example.com": true},
+	})
+
+	mutate := func(signed []byte) []byte {
+		mutated := bytes.Replace(signed, []byte("Monday"), []byte("monday"), 1)
+		if bytes.Equal(mutated, signed) {
+			t.Fatal("body not mutated")
+		}
+		return mutated
+	}
+
+	tests := []struct {
+		name    string
+		raw     []byte
+		result  string
+		verdict string
+	}{
+		{"rsa", dkimSign(t, dkimFixture, "example.com", "rsa", rsaKey), "pass", "pass"},
+		{"ed25519", dkimSign(t, dkimFixture, "example.com", "ed", edKey), "pass", "pass"},
+		{"body changed", mutate(dkimSign(t, dkimFixture, "example.com", "rsa", rsaKey)), "fail", "fail"},
+		{"wrong key", dkimSign(t, dkimFixture, "example.com", "ed", rsaKey), "permerror", "fail"},
+		{"missing key", dkimSign(t, dkimFixture, "example.com", "nokey", rsaKey), "permerror", "fail"},
+		{"malformed key", dkimSign(t, dkimFixture, "example.com", "bad", rsaKey), "permerror", "fail"},
+		{"revoked key", dkimSign(t, dkimFixture, "example.com", "revoked", rsaKey), "permerror", "fail"},
+		{"key lookup failed", dkimSign(t, dkimFixture, "example.com", "down", rsaKey), "temperror", "fail"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email := &models.Email{From: "alice@example.com", ClientIP: "192.0.2.1"}
+			verifier.Verify(email, bytes.NewReader(tt.raw))
+
+			if email.Auth.DKIMResult != tt.verdict {
+				t.Errorf("verdict %q, want %q", email.Auth.DKIMResult, tt.verdict)
+			}
+			if len(email.Auth.DKIM) != 1 {
+				t.Fatalf("got %d results, want 1", len(email.Auth.DKIM))
+			}
+			result := email.Auth.DKIM[0]
+			if result.Result != tt.result {
+				t.Errorf("result %q (%s), want %q", result.Result, result.Reason, tt.result)
+			}
+			if result.Domain != "example.com" {
+				t.Errorf("domain %q, want example.com", result.Domain)
+			}
+			if tt.result != "pass" && result.Reason == "" {
+				t.Error("no reason given")
+			}
+		})
+	}
+
+	email := &models.Email{ClientIP: "192.0.2.1"}
+	verifier.Verify(email, strings.NewReader(dkimFixture))
+	if email.Auth.DKIMResult != "none" || len(email.Auth.DKIM) != 0 {
+		t.Errorf("unsigned message: verdict %q with %d results", email.Auth.DKIMResult, len(email.Auth.DKIM))
+	}
+}
+
+func TestDKIMRecordFromPEM(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "private.pem")
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := dkimRecordFromPEM(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if want := "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der); record != want {
+		t.Errorf("record %q, want the public half of the key", record)
+	}
+}
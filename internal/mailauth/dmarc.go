@@ -0,0 +1,138 @@
+package mailauth
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+
+	"mailcatch/internal/models"
+	"github.com/emersion/go-msgauth/dmarc"
+	"golang.org/x/net/publicsuffix"
+)
+
+// checkDMARC evaluates the DMARC policy of the header From domain using
+// the DKIM and SPF results already computed for the email
+func (v *Verifier) checkDMARC(header mail.Header, auth *models.AuthResults) *models.DMARCResult {
+	result := &models.DMARCResult{}
+
+	if header == nil {
+		result.Result = "permerror"
+		result.Reason = "unparseable message header"
+		return result
+	}
+
+	domain, err := headerFromDomain(header)
+	if err != nil {
+		result.Result = "permerror"
+		result.Reason = err.Error()
+		return result
+	}
+	result.Domain = domain
+
+	// Fall back to the organizational domain when the From domain has no
+	// policy of its own (RFC 7489 section 6.6.3)
+	policyDomain := domain
+	record, err := dmarc.LookupWithOptions(domain, &dmarc.LookupOptions{
+		LookupTXT: v.resolver.LookupTXT,
+	})
+	if err == dmarc.ErrNoPolicy {
+		if org := organizationalDomain(domain); org != domain {
+			policyDomain = org
+			record, err = dmarc.LookupWithOptions(org, &dmarc.LookupOptions{
+				LookupTXT: v.resolver.LookupTXT,
+			})
+		}
+	}
+
+	switch {
+	case err == dmarc.ErrNoPolicy:
+		result.Result = "none"
+		result.Reason = "no DMARC policy published"
+		return result
+	case dmarc.IsTempFail(err):
+		result.Result = "temperror"
+		result.Reason = strings.TrimPrefix(err.Error(), "dmarc: ")
+		return result
+	case err != nil:
+		result.Result = "permerror"
+		result.Reason = strings.TrimPrefix(err.Error(), "dmarc: ")
+		return result
+	}
+
+	result.PolicyDomain = policyDomain
+	result.Policy = string(record.Policy)
+	if policyDomain != domain && record.SubdomainPolicy != "" {
+		result.Policy = string(record.SubdomainPolicy)
+	}
+
+	for _, dkimResult := range auth.DKIM {
+		if dkimResult.Result == "pass" && domainsAligned(dkimResult.Domain, domain, record.DKIMAlignment) {
+			result.DKIMAligned = true
+			break
+		}
+	}
+	if auth.SPF != nil && auth.SPF.Result == "pass" && domainsAligned(auth.SPF.Domain, domain, record.SPFAlignment) {
+		result.SPFAligned = true
+	}
+
+	if result.DKIMAligned || result.SPFAligned {
+		result.Result = "pass"
+		result.Disposition = "none"
+		return result
+	}
+
+	result.Result = "fail"
+	result.Disposition = result.Policy
+	result.Reason = "neither DKIM nor SPF produced an aligned pass"
+	return result
+}
+
+// headerFromDomain returns the domain of the single RFC 5322 From author
+func headerFromDomain(header mail.Header) (string, error) {
+	from := header.Get("From")
+	if from == "" {
+		return "", errors.New("missing From header")
+	}
+
+	addresses, err := header.AddressList("From")
+	if err != nil {
+		return "", errors.New("invalid From header: " + err.Error())
+	}
+
+	domain := ""
+	for _, address := range addresses {
+		at := strings.LastIndex(address.Address, "@")
+		if at == -1 {
+			return "", errors.New("From address without a domain")
+		}
+		d := canonicalName(address.Address[at+1:])
+		if domain != "" && d != domain {
+			return "", errors.New("From header names several domains")
+		}
+		domain = d
+	}
+
+	return domain, nil
+}
+
+// organizationalDomain returns the registrable domain for name
+func organizationalDomain(name string) string {
+	org, err := publicsuffix.EffectiveTLDPlusOne(name)
+	if err != nil {
+		return name
+	}
+	return org
+}
+
+// domainsAligned compares an authenticated domain with the From domain
+// using strict or relaxed alignment
+func domainsAligned(authenticated, from string, mode dmarc.AlignmentMode) bool {
+	authenticated = canonicalName(authenticated)
+	if authenticated == "" {
+		return false
+	}
+	if mode == dmarc.AlignmentStrict {
+		return authenticated == from
+	}
+	return organizationalDomain(authenticated) == organizationalDomain(from)
+}
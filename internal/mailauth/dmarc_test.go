@@ -0,0 +1,111 @@
+package mailauth
+
+import (
+	"net/mail"
+	"strings"
+	"testing"
+
+	"mailcatch/internal/models"
+	"github.com/emersion/go-msgauth/dmarc"
+)
+
+func dmarcZone() *StaticResolver {
+	r := NewStaticResolver()
+	r.AddTXT("_dmarc.example.com", "v=DMARC1; p=reject; sp=quarantine")
+	r.AddTXT("_dmarc.strict.example", "v=DMARC1; p=reject; adkim=s; aspf=s")
+	r.AddTXT("_dmarc.example.co.uk", "v=DMARC1; p=quarantine")
+	r.AddTXT("_dmarc.own.example.com", "v=DMARC1; p=none")
+	r.AddTXT("_dmarc.broken.example", "v=DMARC1; p=bogus")
+	return r
+}
+
+func fromHeader(t *testing.T, from string) mail.Header {
+	t.Helper()
+	msg, err := mail.ReadMessage(strings.NewReader("From: " + from + "\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg.Header
+}
+
+func TestCheckDMARC(t *testing.T) {
+	verifier := NewVerifier(brokenResolver{
+		Resolver: dmarcZone(),
+		broken:   map[string]bool{"_dmarc.down.example": true},
+	})
+
+	dkim := func(domain, result string) *models.AuthResults {
+		return &models.AuthResults{DKIM: []*models.DKIMResult{{Domain: domain, Result: result}}}
+	}
+	spf := func(domain, result string) *models.AuthResults {
+		return &models.AuthResults{SPF: &models.SPFResult{Domain: domain, Result: result}}
+	}
+
+	tests := []struct {
+		name         string
+		from         string
+		auth         *models.AuthResults
+		result       string
+		policyDomain string
+		policy       string
+		dkimAligned  bool
+		spfAligned   bool
+		disposition  string
+	}{
+		{"DKIM exact domain", "a@example.com", dkim("example.com", "pass"), "pass", "example.com", "reject", true, false, "none"},
+		{"DKIM relaxed subdomain", "a@example.com", dkim("mail.example.com", "pass"), "pass", "example.com", "reject", true, false, "none"},
+		{"DKIM failed signature", "a@example.com", dkim("example.com", "fail"), "fail", "example.com", "reject", false, false, "reject"},
+		{"DKIM other domain", "a@example.com", dkim("example.net", "pass"), "fail", "example.com", "reject", false, false, "reject"},
+		{"SPF relaxed subdomain", "a@example.com", spf("bounces.example.com", "pass"), "pass", "example.com", "reject", false, true, "none"},
+		{"SPF softfail", "a@example.com", spf("example.com", "softfail"), "fail", "example.com", "reject", false, false, "reject"},
+		{"strict DKIM subdomain", "a@strict.example", dkim("mail.strict.example", "pass"), "fail", "strict.example", "reject", false, false, "reject"},
+		{"strict SPF subdomain", "a@strict.example", spf("mail.strict.example", "pass"), "fail", "strict.example", "reject", false, false, "reject"},
+		{"strict exact domain", "a@strict.example", spf("strict.example", "pass"), "pass", "strict.example", "reject", false, true, "none"},
+		{"subdomain takes sp", "a@news.example.com", &models.AuthResults{}, "fail", "example.com", "quarantine", false, false, "quarantine"},
+		{"subdomain with its own policy", "a@own.example.com", &models.AuthResults{}, "fail", "own.example.com", "none", false, false, "none"},
+		{"public suffix with two labels", "a@news.example.co.uk", dkim("example.co.uk", "pass"), "pass", "example.co.uk", "quarantine", true, false, "none"},
+		{"no policy", "a@example.org", dkim("example.org", "pass"), "none", "", "", false, false, ""},
+		{"invalid policy", "a@broken.example", &models.AuthResults{}, "permerror", "", "", false, false, ""},
+		{"temporary failure", "a@down.example", &models.AuthResults{}, "temperror", "", "", false, false, ""},
+		{"several From domains", "a@example.com, b@example.net", &models.AuthResults{}, "permerror", "", "", false, false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := verifier.checkDMARC(fromHeader(t, tt.from), tt.auth)
+			if got.Result != tt.result || got.PolicyDomain != tt.policyDomain || got.Policy != tt.policy ||
+				got.DKIMAligned != tt.dkimAligned || got.SPFAligned != tt.spfAligned || got.Disposition != tt.disposition {
+				t.Errorf("got %+v", got)
+			}
+		})
+	}
+
+	if got := verifier.checkDMARC(nil, &models.AuthResults{}); got.Result != "permerror" {
+		t.Errorf("unparseable header: got %q, want permerror", got.Result)
+	}
+	if got := verifier.checkDMARC(mail.Header{}, &models.AuthResults{}); got.Result != "permerror" {
+		t.Errorf("missing From: got %q, want permerror", got.Result)
+	}
+}
+
+func TestDomainsAligned(t *testing.T) {
+	tests := []struct {
+		authenticated, from string
+		mode                dmarc.AlignmentMode
+		want                bool
+	}{
+		{"example.com", "example.com", dmarc.AlignmentStrict, true},
+		{"EXAMPLE.com.", "example.com", dmarc.AlignmentStrict, true},
+		{"mail.example.com", "example.com", dmarc.AlignmentRelaxed, true},
+		{"mail.example.com", "example.com", dmarc.AlignmentStrict, false},
+		{"example.com", "news.example.com", dmarc.AlignmentRelaxed, true},
+		{"a.example.co.uk", "b.example.co.uk", dmarc.AlignmentRelaxed, true},
+		{"example.co.uk", "other.co.uk", dmarc.AlignmentRelaxed, false},
+		{"", "example.com", dmarc.AlignmentRelaxed, false},
+	}
+	for _, tt := range tests {
+		if got := domainsAligned(tt.authenticated, tt.from, tt.mode); got != tt.want {
+			t.Errorf("domainsAligned(%q, %q, %s) = %v, want %v", tt.authenticated, tt.from, tt.mode, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,550 @@
+package mailauth
+
+import (
+	"bufio"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Resolver answers the DNS queries needed by the authentication checks.
+// Names that do not exist are reported with a *net.DNSError whose
+// IsNotFound field is set, matching the standard library resolver.
+type Resolver interface {
+	LookupTXT(name string) ([]string, error)
+	LookupIP(host string) ([]net.IP, error)
+	// LookupMX returns the exchange host names ordered by preference
+	LookupMX(name string) ([]string, error)
+}
+
+// IsNotFound reports whether err means the queried name has no records
+func IsNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+func notFound(name string) error {
+	return &net.DNSError{Err: "no such record", Name: name, IsNotFound: true}
+}
+
+// maxCNAMEChain bounds alias resolution in static zones
+const maxCNAMEChain = 8
+
+type mxRecord struct {
+	preference int
+	host       string
+}
+
+// StaticResolver serves records loaded from zone files and key maps, so
+// captured mail can be verified offline
+type StaticResolver struct {
+	txt   map[string][]string
+	ips   map[string][]net.IP
+	mx    map[string][]mxRecord
+	cname map[string]string
+}
+
+func NewStaticResolver() *StaticResolver {
+	return &StaticResolver{
+		txt:   make(map[string][]string),
+		ips:   make(map[string][]net.IP),
+		mx:    make(map[string][]mxRecord),
+		cname: make(map[string]string),
+	}
+}
+
+// resolveAlias follows CNAME records starting at name
+func (r *StaticResolver) resolveAlias(name string) string {
+	name = canonicalName(name)
+	for i := 0; i < maxCNAMEChain; i++ {
+		target, ok := r.cname[name]
+		if !ok {
+			break
+		}
+		name = target
+	}
+	return name
+}
+
+func (r *StaticResolver) LookupTXT(name string) ([]string, error) {
+	records, ok := r.txt[r.resolveAlias(name)]
+	if !ok {
+		return nil, notFound(name)
+	}
+	return records, nil
+}
+
+func (r *StaticResolver) LookupIP(host string) ([]net.IP, error) {
+	ips, ok := r.ips[r.resolveAlias(host)]
+	if !ok {
+		return nil, notFound(host)
+	}
+	return ips, nil
+}
+
+func (r *StaticResolver) LookupMX(name string) ([]string, error) {
+	records, ok := r.mx[r.resolveAlias(name)]
+	if !ok {
+		return nil, notFound(name)
+	}
+
+	sorted := make([]mxRecord, len(records))
+	copy(sorted, records)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].preference < sorted[j].preference
+	})
+
+	hosts := make([]string, len(sorted))
+	for i, record := range sorted {
+		hosts[i] = record.host
+	}
+	return hosts, nil
+}
+
+// AddTXT registers a TXT record for name
+func (r *StaticResolver) AddTXT(name, value string) {
+	name = canonicalName(name)
+	r.txt[name] = append(r.txt[name], value)
+}
+
+// AddIP registers an A or AAAA record for host
+func (r *StaticResolver) AddIP(host string, ip net.IP) {
+	host = canonicalName(host)
+	r.ips[host] = append(r.ips[host], ip)
+}
+
+// AddMX registers an MX record for name
+func (r *StaticResolver) AddMX(name string, preference int, host string) {
+	name = canonicalName(name)
+	r.mx[name] = append(r.mx[name], mxRecord{preference: preference, host: canonicalName(host)})
+}
+
+// AddCNAME registers an alias from name to target
+func (r *StaticResolver) AddCNAME(name, target string) {
+	r.cname[canonicalName(name)] = canonicalName(target)
+}
+
+// LoadZoneFile reads records from a zone file in RFC 1035 master file
+// format. $ORIGIN, relative names, "@", parentheses and comments are
+// supported; TXT, A, AAAA, MX and CNAME records are served, other record
+// types are ignored.
+func (r *StaticResolver) LoadZoneFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	origin := ""
+	lastName := ""
+	for _, entry := range splitZoneEntries(string(data)) {
+		fields := tokenizeZoneEntry(entry.text)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0].value) {
+		case "$ORIGIN":
+			if len(fields) < 2 {
+				return fmt.Errorf("%s:%d: $ORIGIN without a name", path, entry.line)
+			}
+			origin = canonicalName(fields[1].value)
+			continue
+		case "$TTL", "$INCLUDE", "$GENERATE":
+			continue
+		}
+
+		// A record starting with whitespace reuses the previous owner name
+		name := lastName
+		if !entry.continued {
+			name = resolveZoneName(fields[0].value, origin)
+			fields = fields[1:]
+		}
+		if name == "" {
+			return fmt.Errorf("%s:%d: record without an owner name", path, entry.line)
+		}
+		lastName = name
+
+		// Skip the optional TTL and class in either order
+		for len(fields) > 0 && !fields[0].quoted && isZoneTTLOrClass(fields[0].value) {
+			fields = fields[1:]
+		}
+		if len(fields) == 0 {
+			return fmt.Errorf("%s:%d: record without a type", path, entry.line)
+		}
+
+		recordType := strings.ToUpper(fields[0].value)
+		rdata := fields[1:]
+
+		switch recordType {
+		case "TXT", "SPF":
+			var value strings.Builder
+			for _, field := range rdata {
+				value.WriteString(field.value)
+			}
+			r.AddTXT(name, value.String())
+		case "A", "AAAA":
+			if len(rdata) < 1 {
+				return fmt.Errorf("%s:%d: %s record without an address", path, entry.line, recordType)
+			}
+			ip := net.ParseIP(rdata[0].value)
+			if ip == nil || (recordType == "A") != (ip.To4() != nil) {
+				return fmt.Errorf("%s:%d: invalid %s address %q", path, entry.line, recordType, rdata[0].value)
+			}
+			r.AddIP(name, ip)
+		case "MX":
+			if len(rdata) < 2 {
+				return fmt.Errorf("%s:%d: MX record needs a preference and a host", path, entry.line)
+			}
+			preference, err := strconv.Atoi(rdata[0].value)
+			if err != nil {
+				return fmt.Errorf("%s:%d: invalid MX preference %q", path, entry.line, rdata[0].value)
+			}
+			r.AddMX(name, preference, resolveZoneName(rdata[1].value, origin))
+		case "CNAME":
+			if len(rdata) < 1 {
+				return fmt.Errorf("%s:%d: CNAME record without a target", path, entry.line)
+			}
+			r.AddCNAME(name, resolveZoneName(rdata[0].value, origin))
+		}
+	}
+
+	return nil
+}
+
+// LoadKeyMap reads DKIM public keys from a key map file. Each non-empty
+// line has the form
+//
+//	selector domain key
+//
+// where key is either the path to a PEM encoded public or private key, or
+// a literal DKIM key record such as "v=DKIM1; k=rsa; p=...". Relative
+// paths are resolved against the directory of the key map.
+func (r *StaticResolver) LoadKeyMap(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return fmt.Errorf("%s:%d: expected \"selector domain key\"", path, lineNo)
+		}
+
+		selector, domain := fields[0], fields[1]
+		key := strings.Join(fields[2:], " ")
+
+		record := key
+		if !strings.Contains(key, "p=") {
+			keyPath := key
+			if !filepath.IsAbs(keyPath) {
+				keyPath = filepath.Join(filepath.Dir(path), keyPath)
+			}
+			record, err = dkimRecordFromPEM(keyPath)
+			if err != nil {
+				return fmt.Errorf("%s:%d: %w", path, lineNo, err)
+			}
+		}
+
+		r.AddTXT(selector+"._domainkey."+domain, record)
+	}
+
+	return scanner.Err()
+}
+
+// dkimRecordFromPEM builds a DKIM key record from a PEM file holding a
+// public key, a certificate or a private key
+func dkimRecordFromPEM(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return "", fmt.Errorf("%s: no PEM data found", path)
+	}
+
+	var public crypto.PublicKey
+	switch block.Type {
+	case "PUBLIC KEY":
+		public, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		public, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			public = cert.PublicKey
+		}
+	case "RSA PRIVATE KEY":
+		var key *rsa.PrivateKey
+		if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+			public = key.Public()
+		}
+	case "PRIVATE KEY":
+		var key interface{}
+		if key, err = x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+			if signer, ok := key.(crypto.Signer); ok {
+				public = signer.Public()
+			}
+		}
+	default:
+		return "", fmt.Errorf("%s: unsupported PEM block %q", path, block.Type)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			return "", err
+		}
+		return "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der), nil
+	case ed25519.PublicKey:
+		return "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(key), nil
+	case *ecdsa.PublicKey:
+		return "", fmt.Errorf("%s: ECDSA keys are not supported by DKIM", path)
+	default:
+		return "", fmt.Errorf("%s: unsupported key type %T", path, public)
+	}
+}
+
+type zoneEntry struct {
+	text      string
+	line      int
+	continued bool
+}
+
+// splitZoneEntries joins parenthesized multi-line records and strips
+// comments, returning one entry per logical record
+func splitZoneEntries(data string) []zoneEntry {
+	var entries []zoneEntry
+	var current strings.Builder
+	depth := 0
+	start := 0
+	continued := false
+
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimRight(stripZoneComment(line), "\r")
+		if depth == 0 {
+			current.Reset()
+			start = i + 1
+			continued = len(line) > 0 && (line[0] == ' ' || line[0] == '\t')
+		}
+
+		inQuotes := false
+		for j := 0; j < len(line); j++ {
+			switch c := line[j]; {
+			case c == '\\' && inQuotes:
+				current.WriteByte(c)
+				if j+1 < len(line) {
+					j++
+					current.WriteByte(line[j])
+				}
+				continue
+			case c == '"':
+				inQuotes = !inQuotes
+			case c == '(' && !inQuotes:
+				depth++
+				current.WriteByte(' ')
+				continue
+			case c == ')' && !inQuotes:
+				if depth > 0 {
+					depth--
+				}
+				current.WriteByte(' ')
+				continue
+			}
+			current.WriteByte(line[j])
+		}
+		current.WriteByte(' ')
+
+		if depth == 0 && strings.TrimSpace(current.String()) != "" {
+			entries = append(entries, zoneEntry{
+				text:      current.String(),
+				line:      start,
+				continued: continued,
+			})
+		}
+	}
+
+	return entries
+}
+
+func stripZoneComment(line string) string {
+	inQuotes := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '"':
+			inQuotes = !inQuotes
+		case ';':
+			if !inQuotes {
+				return line[:i]
+			}
+		}
+	}
+	return line
+}
+
+type zoneField struct {
+	value  string
+	quoted bool
+}
+
+// tokenizeZoneEntry splits a record into whitespace separated fields,
+// keeping quoted character strings intact and unescaped
+func tokenizeZoneEntry(text string) []zoneField {
+	var fields []zoneField
+	i := 0
+	for i < len(text) {
+		switch text[i] {
+		case ' ', '\t':
+			i++
+			continue
+		case '"':
+			var value strings.Builder
+			i++
+			for i < len(text) && text[i] != '"' {
+				if text[i] == '\\' && i+1 < len(text) {
+					i++
+				}
+				value.WriteByte(text[i])
+				i++
+			}
+			i++
+			fields = append(fields, zoneField{value: value.String(), quoted: true})
+			continue
+		}
+
+		start := i
+		for i < len(text) && text[i] != ' ' && text[i] != '\t' {
+			i++
+		}
+		fields = append(fields, zoneField{value: text[start:i]})
+	}
+	return fields
+}
+
+func resolveZoneName(name, origin string) string {
+	if name == "@" {
+		return origin
+	}
+	if strings.HasSuffix(name, ".") || origin == "" {
+		return canonicalName(name)
+	}
+	return canonicalName(name + "." + origin)
+}
+
+func isZoneTTLOrClass(field string) bool {
+	switch strings.ToUpper(field) {
+	case "IN", "CH", "HS", "CS":
+		return true
+	}
+	return field != "" && strings.Trim(field, "0123456789smhdwSMHDW") == "" && field[0] >= '0' && field[0] <= '9'
+}
+
+// canonicalName lower-cases a domain name and strips the trailing dot
+func canonicalName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
+
+// SystemResolver performs live DNS queries through the operating system
+// resolver
+type SystemResolver struct {
+	resolver *net.Resolver
+	timeout  time.Duration
+}
+
+func NewSystemResolver(timeout time.Duration) *SystemResolver {
+	return &SystemResolver{
+		resolver: net.DefaultResolver,
+		timeout:  timeout,
+	}
+}
+
+func (r *SystemResolver) LookupTXT(name string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	return r.resolver.LookupTXT(ctx, name)
+}
+
+func (r *SystemResolver) LookupIP(host string) ([]net.IP, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	return r.resolver.LookupIP(ctx, "ip", host)
+}
+
+func (r *SystemResolver) LookupMX(name string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	records, err := r.resolver.LookupMX(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := make([]string, len(records))
+	for i, record := range records {
+		hosts[i] = canonicalName(record.Host)
+	}
+	return hosts, nil
+}
+
+// ChainResolver asks each resolver in turn and returns the first answer
+// for a name that exists
+type ChainResolver []Resolver
+
+func (c ChainResolver) LookupTXT(name string) ([]string, error) {
+	return chainLookup(c, name, func(r Resolver) ([]string, error) {
+		return r.LookupTXT(name)
+	})
+}
+
+func (c ChainResolver) LookupIP(host string) ([]net.IP, error) {
+	return chainLookup(c, host, func(r Resolver) ([]net.IP, error) {
+		return r.LookupIP(host)
+	})
+}
+
+func (c ChainResolver) LookupMX(name string) ([]string, error) {
+	return chainLookup(c, name, func(r Resolver) ([]string, error) {
+		return r.LookupMX(name)
+	})
+}
+
+func chainLookup[T any](c ChainResolver, name string, lookup func(Resolver) ([]T, error)) ([]T, error) {
+	for _, resolver := range c {
+		records, err := lookup(resolver)
+		if err == nil || !IsNotFound(err) {
+			return records, err
+		}
+	}
+	return nil, notFound(name)
+}
@@ -0,0 +1,552 @@
+package mailauth
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"mailcatch/internal/models"
+)
+
+const (
+	spfNone      = "none"
+	spfNeutral   = "neutral"
+	spfPass      = "pass"
+	spfFail      = "fail"
+	spfSoftFail  = "softfail"
+	spfTempError = "temperror"
+	spfPermError = "permerror"
+)
+
+// Limits from RFC 7208 section 4.6.4
+const (
+	spfMaxLookups     = 10
+	spfMaxVoidLookups = 2
+	spfMaxMXHosts     = 10
+)
+
+// spfError aborts an evaluation with a temperror or permerror result
+type spfError struct {
+	result string
+	reason string
+}
+
+func (e *spfError) Error() string {
+	return e.reason
+}
+
+func spfTempErr(format string, args ...interface{}) error {
+	return &spfError{result: spfTempError, reason: fmt.Sprintf(format, args...)}
+}
+
+func spfPermErr(format string, args ...interface{}) error {
+	return &spfError{result: spfPermError, reason: fmt.Sprintf(format, args...)}
+}
+
+// spfCheck holds the state of a single check_host() evaluation
+type spfCheck struct {
+	resolver    Resolver
+	ip          net.IP
+	sender      string
+	helo        string
+	lookups     int
+	voidLookups int
+}
+
+// checkSPF evaluates the SPF policy of the MAIL FROM domain (or the HELO
+// name for null senders) for the client that delivered the email
+func (v *Verifier) checkSPF(email *models.Email) *models.SPFResult {
+	sender := email.From
+	if sender == "" || !strings.Contains(sender, "@") {
+		sender = "postmaster@" + email.Helo
+	}
+	domain := canonicalName(sender[strings.LastIndex(sender, "@")+1:])
+
+	result := &models.SPFResult{
+		Domain:   domain,
+		ClientIP: email.ClientIP,
+	}
+
+	ip := net.ParseIP(email.ClientIP)
+	if ip == nil {
+		result.Result = spfNone
+		result.Reason = "client IP unknown"
+		return result
+	}
+	if domain == "" {
+		result.Result = spfNone
+		result.Reason = "no sender domain"
+		return result
+	}
+
+	check := &spfCheck{
+		resolver: v.resolver,
+		ip:       ip,
+		sender:   sender,
+		helo:     email.Helo,
+	}
+
+	result.Result, result.Mechanism, result.Reason = check.checkHost(domain, 0)
+	return result
+}
+
+// checkHost implements the check_host() function of RFC 7208 section 4
+func (c *spfCheck) checkHost(domain string, depth int) (result, mechanism, reason string) {
+	if depth > spfMaxLookups {
+		return spfPermError, "", "include/redirect loop"
+	}
+
+	record, err := c.lookupRecord(domain)
+	if err != nil {
+		var spfErr *spfError
+		if errors.As(err, &spfErr) {
+			return spfErr.result, "", spfErr.reason
+		}
+		return spfTempError, "", err.Error()
+	}
+	if record == "" {
+		return spfNone, "", "no SPF record for " + domain
+	}
+
+	terms := strings.Fields(record)[1:]
+	redirect := ""
+	for _, term := range terms {
+		if name, value, ok := parseSPFModifier(term); ok {
+			switch name {
+			case "redirect":
+				if redirect != "" {
+					return spfPermError, "", "duplicate redirect modifier"
+				}
+				redirect = value
+			}
+			continue
+		}
+
+		qualifier := spfPass
+		switch term[0] {
+		case '+':
+			term = term[1:]
+		case '-':
+			qualifier = spfFail
+			term = term[1:]
+		case '~':
+			qualifier = spfSoftFail
+			term = term[1:]
+		case '?':
+			qualifier = spfNeutral
+			term = term[1:]
+		}
+
+		matched, err := c.matchMechanism(term, domain, depth)
+		if err != nil {
+			var spfErr *spfError
+			if errors.As(err, &spfErr) {
+				return spfErr.result, term, spfErr.reason
+			}
+			return spfPermError, term, err.Error()
+		}
+		if matched {
+			return qualifier, term, fmt.Sprintf("%s matched %s", c.ip, term)
+		}
+	}
+
+	if redirect != "" {
+		if err := c.countLookup(); err != nil {
+			return spfPermError, "redirect=" + redirect, err.Error()
+		}
+		target, err := c.expand(redirect, domain)
+		if err != nil {
+			return spfPermError, "redirect=" + redirect, err.Error()
+		}
+
+		result, mechanism, reason := c.checkHost(target, depth+1)
+		if result == spfNone {
+			return spfPermError, "redirect=" + redirect, "redirect target has no SPF record"
+		}
+		return result, mechanism, reason
+	}
+
+	return spfNeutral, "", "no mechanism matched"
+}
+
+// lookupRecord returns the single SPF record published for domain, or an
+// empty string if there is none
+func (c *spfCheck) lookupRecord(domain string) (string, error) {
+	txts, err := c.resolver.LookupTXT(domain)
+	if err != nil {
+		if IsNotFound(err) {
+			return "", nil
+		}
+		return "", spfTempErr("TXT lookup for %s failed: %v", domain, err)
+	}
+
+	var records []string
+	for _, txt := range txts {
+		lower := strings.ToLower(txt)
+		if lower == "v=spf1" || strings.HasPrefix(lower, "v=spf1 ") {
+			records = append(records, txt)
+		}
+	}
+
+	switch len(records) {
+	case 0:
+		return "", nil
+	case 1:
+		return records[0], nil
+	default:
+		return "", spfPermErr("multiple SPF records for %s", domain)
+	}
+}
+
+// parseSPFModifier splits "name=value" terms; mechanisms never contain an
+// equals sign before their first colon or slash
+func parseSPFModifier(term string) (string, string, bool) {
+	eq := strings.Index(term, "=")
+	if eq <= 0 {
+		return "", "", false
+	}
+	if i := strings.IndexAny(term, ":/"); i != -1 && i < eq {
+		return "", "", false
+	}
+	return strings.ToLower(term[:eq]), term[eq+1:], true
+}
+
+func (c *spfCheck) countLookup() error {
+	c.lookups++
+	if c.lookups > spfMaxLookups {
+		return spfPermErr("too many DNS lookups")
+	}
+	return nil
+}
+
+// countVoid records a lookup that returned no records
+func (c *spfCheck) countVoid() error {
+	c.voidLookups++
+	if c.voidLookups > spfMaxVoidLookups {
+		return spfPermErr("too many void DNS lookups")
+	}
+	return nil
+}
+
+func (c *spfCheck) matchMechanism(term, domain string, depth int) (bool, error) {
+	name, arg := term, ""
+	if i := strings.IndexAny(term, ":/"); i != -1 {
+		name, arg = term[:i], term[i:]
+	}
+	name = strings.ToLower(name)
+	arg = strings.TrimPrefix(arg, ":")
+
+	switch name {
+	case "all":
+		return true, nil
+
+	case "ip4", "ip6":
+		if !strings.Contains(arg, "/") {
+			if name == "ip4" {
+				arg += "/32"
+			} else {
+				arg += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(arg)
+		if err != nil {
+			return false, spfPermErr("invalid %s network %q", name, arg)
+		}
+		if (name == "ip4") != (c.ip.To4() != nil) {
+			return false, nil
+		}
+		return network.Contains(c.ip), nil
+
+	case "a", "mx":
+		if err := c.countLookup(); err != nil {
+			return false, err
+		}
+		spec, cidr4, cidr6, err := parseSPFDualCIDR(arg)
+		if err != nil {
+			return false, err
+		}
+		target := domain
+		if spec != "" {
+			if target, err = c.expand(spec, domain); err != nil {
+				return false, err
+			}
+		}
+
+		hosts := []string{target}
+		if name == "mx" {
+			mxHosts, err := c.resolver.LookupMX(target)
+			if err != nil {
+				if !IsNotFound(err) {
+					return false, spfTempErr("MX lookup for %s failed: %v", target, err)
+				}
+				return false, c.countVoid()
+			}
+			if len(mxHosts) > spfMaxMXHosts {
+				return false, spfPermErr("too many MX hosts for %s", target)
+			}
+			hosts = mxHosts
+		}
+
+		for _, host := range hosts {
+			ips, err := c.resolver.LookupIP(host)
+			if err != nil {
+				if !IsNotFound(err) {
+					return false, spfTempErr("address lookup for %s failed: %v", host, err)
+				}
+				if name == "a" {
+					return false, c.countVoid()
+				}
+				continue
+			}
+			for _, ip := range ips {
+				if ipMatches(c.ip, ip, cidr4, cidr6) {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+
+	case "include":
+		if arg == "" {
+			return false, spfPermErr("include without a domain")
+		}
+		if err := c.countLookup(); err != nil {
+			return false, err
+		}
+		target, err := c.expand(arg, domain)
+		if err != nil {
+			return false, err
+		}
+
+		result, _, reason := c.checkHost(target, depth+1)
+		switch result {
+		case spfPass:
+			return true, nil
+		case spfFail, spfSoftFail, spfNeutral:
+			return false, nil
+		case spfTempError:
+			return false, spfTempErr("include:%s: %s", target, reason)
+		default:
+			return false, spfPermErr("include:%s: %s", target, reason)
+		}
+
+	case "exists":
+		if arg == "" {
+			return false, spfPermErr("exists without a domain")
+		}
+		if err := c.countLookup(); err != nil {
+			return false, err
+		}
+		target, err := c.expand(arg, domain)
+		if err != nil {
+			return false, err
+		}
+		ips, err := c.resolver.LookupIP(target)
+		if err != nil {
+			if !IsNotFound(err) {
+				return false, spfTempErr("address lookup for %s failed: %v", target, err)
+			}
+			return false, c.countVoid()
+		}
+		for _, ip := range ips {
+			if ip.To4() != nil {
+				return true, nil
+			}
+		}
+		return false, nil
+
+	case "ptr":
+		// ptr is deprecated and needs reverse DNS, which local zones do
+		// not provide; it counts as a lookup but never matches
+		return false, c.countLookup()
+
+	default:
+		return false, spfPermErr("unknown mechanism %q", name)
+	}
+}
+
+// parseSPFDualCIDR splits "domain/cidr4//cidr6" as used by a and mx
+func parseSPFDualCIDR(arg string) (spec string, cidr4, cidr6 int, err error) {
+	cidr4, cidr6 = 32, 128
+
+	if i := strings.Index(arg, "//"); i != -1 {
+		if cidr6, err = strconv.Atoi(arg[i+2:]); err != nil || cidr6 < 0 || cidr6 > 128 {
+			return "", 0, 0, spfPermErr("invalid IPv6 prefix length in %q", arg)
+		}
+		arg = arg[:i]
+	}
+	if i := strings.LastIndex(arg, "/"); i != -1 {
+		if cidr4, err = strconv.Atoi(arg[i+1:]); err != nil || cidr4 < 0 || cidr4 > 32 {
+			return "", 0, 0, spfPermErr("invalid IPv4 prefix length in %q", arg)
+		}
+		arg = arg[:i]
+	}
+
+	return arg, cidr4, cidr6, nil
+}
+
+func ipMatches(client, candidate net.IP, cidr4, cidr6 int) bool {
+	if client4 := client.To4(); client4 != nil {
+		candidate4 := candidate.To4()
+		if candidate4 == nil {
+			return false
+		}
+		mask := net.CIDRMask(cidr4, 32)
+		return client4.Mask(mask).Equal(candidate4.Mask(mask))
+	}
+
+	if candidate.To4() != nil {
+		return false
+	}
+	mask := net.CIDRMask(cidr6, 128)
+	return client.Mask(mask).Equal(candidate.Mask(mask))
+}
+
+// expand performs macro expansion on a domain-spec (RFC 7208 section 7)
+func (c *spfCheck) expand(spec, domain string) (string, error) {
+	if !strings.Contains(spec, "%") {
+		return canonicalName(spec), nil
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(spec); i++ {
+		if spec[i] != '%' {
+			out.WriteByte(spec[i])
+			continue
+		}
+		if i+1 >= len(spec) {
+			return "", spfPermErr("truncated macro in %q", spec)
+		}
+
+		i++
+		switch spec[i] {
+		case '%':
+			out.WriteByte('%')
+		case '_':
+			out.WriteByte(' ')
+		case '-':
+			out.WriteString("%20")
+		case '{':
+			end := strings.IndexByte(spec[i:], '}')
+			if end == -1 {
+				return "", spfPermErr("unterminated macro in %q", spec)
+			}
+			value, err := c.expandMacro(spec[i+1:i+end], domain)
+			if err != nil {
+				return "", err
+			}
+			out.WriteString(value)
+			i += end
+		default:
+			return "", spfPermErr("invalid macro in %q", spec)
+		}
+	}
+
+	return canonicalName(out.String()), nil
+}
+
+// expandMacro expands the body of a single %{...} macro
+func (c *spfCheck) expandMacro(macro, domain string) (string, error) {
+	if macro == "" {
+		return "", spfPermErr("empty macro")
+	}
+
+	local, senderDomain := c.sender, ""
+	if at := strings.LastIndex(c.sender, "@"); at != -1 {
+		local, senderDomain = c.sender[:at], c.sender[at+1:]
+	}
+
+	var value string
+	switch strings.ToLower(macro[:1]) {
+	case "s":
+		value = c.sender
+	case "l":
+		value = local
+	case "o":
+		value = senderDomain
+	case "d":
+		value = domain
+	case "i":
+		value = spfMacroIP(c.ip)
+	case "c":
+		value = c.ip.String()
+	case "p":
+		value = "unknown"
+	case "h":
+		value = c.helo
+	case "r":
+		value = "mailcatch"
+	case "t":
+		value = strconv.FormatInt(time.Now().Unix(), 10)
+	case "v":
+		value = "in-addr"
+		if c.ip.To4() == nil {
+			value = "ip6"
+		}
+	default:
+		return "", spfPermErr("unknown macro letter %q", macro[:1])
+	}
+
+	// Transformers: an optional number of labels to keep, an optional
+	// "r" to reverse and an optional set of delimiters
+	rest := macro[1:]
+	digits := 0
+	for digits < len(rest) && rest[digits] >= '0' && rest[digits] <= '9' {
+		digits++
+	}
+	keep := 0
+	if digits > 0 {
+		keep, _ = strconv.Atoi(rest[:digits])
+		if keep == 0 {
+			return "", spfPermErr("invalid macro transformer in %q", macro)
+		}
+	}
+	rest = rest[digits:]
+	reverse := false
+	if strings.HasPrefix(strings.ToLower(rest), "r") {
+		reverse = true
+		rest = rest[1:]
+	}
+	delimiters := "."
+	if rest != "" {
+		if strings.Trim(rest, ".-+,/_=") != "" {
+			return "", spfPermErr("invalid macro delimiter in %q", macro)
+		}
+		delimiters = rest
+	}
+
+	if keep == 0 && !reverse && delimiters == "." {
+		return value, nil
+	}
+
+	parts := strings.FieldsFunc(value, func(r rune) bool {
+		return strings.ContainsRune(delimiters, r)
+	})
+	if reverse {
+		for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+			parts[i], parts[j] = parts[j], parts[i]
+		}
+	}
+	if keep > 0 && keep < len(parts) {
+		parts = parts[len(parts)-keep:]
+	}
+
+	return strings.Join(parts, "."), nil
+}
+
+// spfMacroIP formats the client IP for the "i" macro: dotted quad for
+// IPv4 and dot separated nibbles for IPv6
+func spfMacroIP(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String()
+	}
+
+	nibbles := make([]string, 0, 32)
+	for _, b := range ip.To16() {
+		nibbles = append(nibbles, strconv.FormatInt(int64(b>>4), 16), strconv.FormatInt(int64(b&0x0f), 16))
+	}
+	return strings.Join(nibbles, ".")
+}
@@ -0,0 +1,184 @@
+package mailauth
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"mailcatch/internal/models"
+)
+
+// brokenResolver answers like the wrapped resolver except for the names
+// whose lookups fail with a temporary DNS error
+type brokenResolver struct {
+	Resolver
+	broken map[string]bool
+}
+
+func (r brokenResolver) LookupTXT(name string) ([]string, error) {
+	if r.broken[canonicalName(name)] {
+		return nil, &net.DNSError{Err: "server misbehaving", Name: name, IsTemporary: true}
+	}
+	return r.Resolver.LookupTXT(name)
+}
+
+func spfZone() *StaticResolver {
+	r := NewStaticResolver()
+	r.AddTXT("pass.example", "v=spf1 ip4:192.0.2.0/24 -all")
+	r.AddTXT("soft.example", "v=spf1 ip4:192.0.2.1 ~all")
+	r.AddTXT("neutral.example", "v=spf1 ?all")
+	r.AddTXT("nomatch.example", "v=spf1 ip4:203.0.113.1")
+	r.AddTXT("ip6.example", "v=spf1 ip6:2001:db8::/32 -all")
+	r.AddTXT("a.example", "v=spf1 a/24 -all")
+	r.AddIP("a.example", net.ParseIP("192.0.2.200"))
+	r.AddTXT("mx.example", "v=spf1 mx -all")
+	r.AddMX("mx.example", 10, "mail.mx.example")
+	r.AddIP("mail.mx.example", net.ParseIP("192.0.2.10"))
+	r.AddTXT("two.example", "v=spf1 -all")
+	r.AddTXT("two.example", "v=spf1 +all")
+	r.AddTXT("other.example", "google-site-verification=abc")
+	r.AddTXT("unknown.example", "v=spf1 foo:bar -all")
+	r.AddTXT("badnet.example", "v=spf1 ip4:192.0.2.0/33 -all")
+
+	// include only matches on a pass; redirect hands over the whole result
+	r.AddTXT("include.example", "v=spf1 include:soft.example include:pass.example -all")
+	r.AddTXT("include-none.example", "v=spf1 include:nothing.example -all")
+	r.AddTXT("redirect.example", "v=spf1 redirect=soft.example")
+	r.AddTXT("redirect-none.example", "v=spf1 redirect=nothing.example")
+	r.AddTXT("redirect-late.example", "v=spf1 redirect=soft.example ip4:192.0.2.0/24")
+
+	// Eleven chained includes need eleven lookups
+	for i := 0; i < 11; i++ {
+		r.AddTXT(fmt.Sprintf("chain%d.example", i), fmt.Sprintf("v=spf1 include:chain%d.example -all", i+1))
+	}
+	r.AddTXT("chain11.example", "v=spf1 +all")
+	r.AddTXT("void.example", "v=spf1 a:v1.example a:v2.example a:v3.example +all")
+	r.AddTXT("loop.example", "v=spf1 include:loop.example -all")
+
+	// The client may send if 192.0.2.10.macro.example resolves
+	r.AddTXT("macro.example", "v=spf1 exists:%{i}.%{d} -all")
+	r.AddIP("192.0.2.10.macro.example", net.ParseIP("127.0.0.2"))
+	r.AddTXT("macro-bad.example", "v=spf1 exists:%{z}.%{d} -all")
+
+	r.AddTXT("temp.example", "v=spf1 include:down.example -all")
+	return r
+}
+
+func TestCheckSPF(t *testing.T) {
+	verifier := NewVerifier(brokenResolver{
+		Resolver: spfZone(),
+		broken:   map[string]bool{"down.example": true, "dead.example": true},
+	})
+
+	tests := []struct {
+		name      string
+		from      string
+		ip        string
+		result    string
+		mechanism string
+		reason    string
+	}{
+		{"ip4 pass", "a@pass.example", "192.0.2.10", spfPass, "ip4:192.0.2.0/24", ""},
+		{"ip4 fail", "a@pass.example", "198.51.100.1", spfFail, "all", ""},
+		{"softfail", "a@soft.example", "198.51.100.1", spfSoftFail, "all", ""},
+		{"neutral qualifier", "a@neutral.example", "192.0.2.10", spfNeutral, "all", ""},
+		{"no mechanism matched", "a@nomatch.example", "192.0.2.10", spfNeutral, "", "no mechanism matched"},
+		{"no record", "a@nothing.example", "192.0.2.10", spfNone, "", ""},
+		{"TXT without SPF", "a@other.example", "192.0.2.10", spfNone, "", ""},
+		{"null sender uses HELO", "", "192.0.2.10", spfPass, "ip4:192.0.2.0/24", ""},
+		{"ip6 pass", "a@ip6.example", "2001:db8::1", spfPass, "ip6:2001:db8::/32", ""},
+		{"ip6 outside", "a@ip6.example", "2001:db9::1", spfFail, "all", ""},
+		{"ip4 client against ip6", "a@ip6.example", "192.0.2.10", spfFail, "all", ""},
+		{"a with prefix", "a@a.example", "192.0.2.7", spfPass, "a/24", ""},
+		{"a outside prefix", "a@a.example", "192.0.3.7", spfFail, "all", ""},
+		{"mx", "a@mx.example", "192.0.2.10", spfPass, "mx", ""},
+		{"mx other host", "a@mx.example", "192.0.2.11", spfFail, "all", ""},
+		{"include pass", "a@include.example", "192.0.2.10", spfPass, "include:pass.example", ""},
+		{"include softfail does not match", "a@include.example", "198.51.100.1", spfFail, "all", ""},
+		{"include without record", "a@include-none.example", "192.0.2.10", spfPermError, "include:nothing.example", "no SPF record"},
+		{"redirect takes the target result", "a@redirect.example", "198.51.100.1", spfSoftFail, "all", ""},
+		{"redirect target pass", "a@redirect.example", "192.0.2.1", spfPass, "ip4:192.0.2.1", ""},
+		{"redirect without record", "a@redirect-none.example", "192.0.2.10", spfPermError, "redirect=nothing.example", "no SPF record"},
+		{"mechanisms before redirect", "a@redirect-late.example", "192.0.2.10", spfPass, "ip4:192.0.2.0/24", ""},
+		{"multiple records", "a@two.example", "192.0.2.10", spfPermError, "", "multiple SPF records"},
+		{"unknown mechanism", "a@unknown.example", "192.0.2.10", spfPermError, "foo:bar", "unknown mechanism"},
+		{"invalid network", "a@badnet.example", "192.0.2.10", spfPermError, "ip4:192.0.2.0/33", "invalid ip4 network"},
+		{"lookup limit", "a@chain0.example", "192.0.2.10", spfPermError, "include:chain1.example", "too many DNS lookups"},
+		{"void lookup limit", "a@void.example", "192.0.2.10", spfPermError, "a:v3.example", "too many void DNS lookups"},
+		{"include loop", "a@loop.example", "192.0.2.10", spfPermError, "include:loop.example", "too many DNS lookups"},
+		{"macro exists", "a@macro.example", "192.0.2.10", spfPass, "exists:%{i}.%{d}", ""},
+		{"macro exists miss", "a@macro.example", "192.0.2.11", spfFail, "all", ""},
+		{"unknown macro", "a@macro-bad.example", "192.0.2.10", spfPermError, "exists:%{z}.%{d}", "unknown macro letter"},
+		{"temporary failure", "a@dead.example", "192.0.2.10", spfTempError, "", "TXT lookup for dead.example failed"},
+		{"temporary failure in include", "a@temp.example", "192.0.2.10", spfTempError, "include:down.example", "TXT lookup for down.example failed"},
+		{"unknown client", "a@pass.example", "", spfNone, "", "client IP unknown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := verifier.checkSPF(&models.Email{From: tt.from, ClientIP: tt.ip, Helo: "pass.example"})
+			if result.Result != tt.result || result.Mechanism != tt.mechanism {
+				t.Errorf("got %s (%q, %s), want %s (%q)", result.Result, result.Mechanism, result.Reason, tt.result, tt.mechanism)
+			}
+			if !strings.Contains(result.Reason, tt.reason) {
+				t.Errorf("reason %q does not mention %q", result.Reason, tt.reason)
+			}
+		})
+	}
+}
+
+// TestSPFMacros checks the expansions from RFC 7208 section 7.4
+func TestSPFMacros(t *testing.T) {
+	check := &spfCheck{
+		ip:     net.ParseIP("192.0.2.3"),
+		sender: "strong-bad@email.example.com",
+		helo:   "mx.example.org",
+	}
+	check6 := &spfCheck{
+		ip:     net.ParseIP("2001:db8::cb01"),
+		sender: check.sender,
+	}
+
+	tests := []struct {
+		check *spfCheck
+		spec  string
+		want  string
+	}{
+		{check, "%{s}", "strong-bad@email.example.com"},
+		{check, "%{o}", "email.example.com"},
+		{check, "%{d}", "email.example.com"},
+		{check, "%{d4}", "email.example.com"},
+		{check, "%{d3}", "email.example.com"},
+		{check, "%{d2}", "example.com"},
+		{check, "%{d1}", "com"},
+		{check, "%{dr}", "com.example.email"},
+		{check, "%{d2r}", "example.email"},
+		{check, "%{l}", "strong-bad"},
+		{check, "%{l-}", "strong.bad"},
+		{check, "%{lr}", "strong-bad"},
+		{check, "%{lr-}", "bad.strong"},
+		{check, "%{l1r-}", "strong"},
+		{check, "%{h}", "mx.example.org"},
+		{check, "%{ir}.%{v}._spf.%{d2}", "3.2.0.192.in-addr._spf.example.com"},
+		{check, "%{lr-}.lp._spf.%{d2}", "bad.strong.lp._spf.example.com"},
+		{check, "%{d}%%%_%-", "email.example.com% %20"},
+		{check6, "%{ir}.%{v}._spf.%{d2}", "1.0.b.c.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6._spf.example.com"},
+	}
+	for _, tt := range tests {
+		got, err := tt.check.expand(tt.spec, "email.example.com")
+		if err != nil {
+			t.Errorf("expand(%q): %v", tt.spec, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("expand(%q) = %q, want %q", tt.spec, got, tt.want)
+		}
+	}
+
+	for _, spec := range []string{"%", "%{d", "%x", "%{}", "%{d0}", "%{d!}"} {
+		if _, err := check.expand(spec, "email.example.com"); err == nil {
+			t.Errorf("expand(%q) succeeded", spec)
+		}
+	}
+}
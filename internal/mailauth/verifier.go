@@ -0,0 +1,70 @@
+package mailauth
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/mail"
+
+	"mailcatch/internal/models"
+)
+
+// maxHeaderSize bounds the header block read ahead of DKIM verification
+const maxHeaderSize = 1 << 20
+
+// Verifier runs the message authentication checks on captured emails
+type Verifier struct {
+	resolver Resolver
+}
+
+func NewVerifier(resolver Resolver) *Verifier {
+	return &Verifier{resolver: resolver}
+}
+
+// Verify authenticates the email read from raw and stores the results on
+// it. DMARC depends on the DKIM and SPF results, so the checks run in that
+// order.
+func (v *Verifier) Verify(email *models.Email, raw io.Reader) {
+	auth := &models.AuthResults{}
+
+	// Only the header block is buffered; the body is streamed through the
+	// DKIM verifier
+	reader := bufio.NewReader(raw)
+	headerBlock, err := readHeaderBlock(reader)
+	var header mail.Header
+	if err == nil {
+		if msg, err := mail.ReadMessage(bytes.NewReader(headerBlock)); err == nil {
+			header = msg.Header
+		}
+	}
+
+	auth.DKIM = v.verifyDKIM(header, io.MultiReader(bytes.NewReader(headerBlock), reader))
+	auth.DKIMResult = dkimVerdict(auth.DKIM)
+	auth.SPF = v.checkSPF(email)
+	auth.DMARC = v.checkDMARC(header, auth)
+
+	email.Auth = auth
+}
+
+// readHeaderBlock reads the message header up to and including the empty
+// line that ends it
+func readHeaderBlock(r *bufio.Reader) ([]byte, error) {
+	var block []byte
+	for len(block) < maxHeaderSize {
+		line, err := r.ReadSlice('\n')
+		block = append(block, line...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF {
+			return block, nil
+		}
+		if err != nil {
+			return block, err
+		}
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			return block, nil
+		}
+	}
+	return block, nil
+}
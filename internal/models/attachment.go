@@ -0,0 +1,16 @@
+package models
+
+// Attachment is a file carried by an email, either as a MIME part or
+// extracted from a container such as winmail.dat
+type Attachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	ContentID   string `json:"content_id,omitempty"`
+	Size        int64  `json:"size"`
+	// Source names the container the attachment was extracted from, e.g.
+	// "winmail.dat"; it is empty for regular MIME attachments
+	Source  string `json:"source,omitempty"`
+	Content []byte `json:"content,omitempty"`
+	// Blob is the blob store key of attachments too large to keep inline
+	Blob string `json:"blob,omitempty"`
+}
@@ -0,0 +1,52 @@
+package models
+
+// AuthResults holds the message authentication checks performed on a
+// captured email
+type AuthResults struct {
+	// DKIMResult is the overall verdict: "pass" when at least one
+	// signature verifies, "fail" when none do and "none" when the message
+	// is unsigned
+	DKIMResult string        `json:"dkim_result"`
+	DKIM       []*DKIMResult `json:"dkim,omitempty"`
+	SPF        *SPFResult    `json:"spf,omitempty"`
+	DMARC      *DMARCResult  `json:"dmarc,omitempty"`
+}
+
+// DKIMResult is the verification outcome of a single DKIM-Signature header
+type DKIMResult struct {
+	Domain     string `json:"domain"`
+	Selector   string `json:"selector"`
+	Algorithm  string `json:"algorithm"`
+	Identifier string `json:"identifier,omitempty"`
+	// Result is one of "pass", "fail", "temperror" or "permerror"
+	Result string `json:"result"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// SPFResult is the outcome of evaluating the sending domain's SPF policy
+// for the connecting client
+type SPFResult struct {
+	// Domain is the MAIL FROM domain, or the HELO name for null senders
+	Domain   string `json:"domain"`
+	ClientIP string `json:"client_ip"`
+	// Result is one of "pass", "fail", "softfail", "neutral", "none",
+	// "temperror" or "permerror"
+	Result    string `json:"result"`
+	Mechanism string `json:"mechanism,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// DMARCResult is the outcome of the DMARC alignment check for the header
+// From domain
+type DMARCResult struct {
+	Domain       string `json:"domain"`
+	PolicyDomain string `json:"policy_domain,omitempty"`
+	Policy       string `json:"policy,omitempty"`
+	// Result is one of "pass", "fail", "none", "temperror" or "permerror"
+	Result      string `json:"result"`
+	DKIMAligned bool   `json:"dkim_aligned"`
+	SPFAligned  bool   `json:"spf_aligned"`
+	// Disposition is the action the policy requests for this message
+	Disposition string `json:"disposition,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+}
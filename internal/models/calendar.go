@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// CalendarEvent is a structured summary of a VEVENT found in a
+// text/calendar (iCalendar) part of an email
+type CalendarEvent struct {
+	Method       string              `json:"method,omitempty"`
+	UID          string              `json:"uid"`
+	Summary      string              `json:"summary,omitempty"`
+	Location     string              `json:"location,omitempty"`
+	Status       string              `json:"status,omitempty"`
+	Sequence     int                 `json:"sequence"`
+	Organizer    *CalendarAttendee   `json:"organizer,omitempty"`
+	Attendees    []*CalendarAttendee `json:"attendees,omitempty"`
+	Start        *CalendarTime       `json:"start,omitempty"`
+	End          *CalendarTime       `json:"end,omitempty"`
+	RecurRule    string              `json:"rrule,omitempty"`
+	RecurrenceID *CalendarTime       `json:"recurrence_id,omitempty"`
+}
+
+// CalendarAttendee describes an ORGANIZER or ATTENDEE property
+type CalendarAttendee struct {
+	Email    string `json:"email"`
+	Name     string `json:"name,omitempty"`
+	Role     string `json:"role,omitempty"`
+	PartStat string `json:"partstat,omitempty"`
+	RSVP     bool   `json:"rsvp,omitempty"`
+}
+
+// CalendarTime is a DTSTART/DTEND value together with the time zone it
+// was expressed in
+type CalendarTime struct {
+	Time   time.Time `json:"time"`
+	TZID   string    `json:"tzid,omitempty"`
+	AllDay bool      `json:"all_day,omitempty"`
+	Value  string    `json:"value,omitempty"`
+}
@@ -3,20 +3,61 @@ package models
 import "time"
 
 type Email struct {
-	ID        int       `json:"id" db:"id"`
-	From      string    `json:"from" db:"from_addr"`
-	To        string    `json:"to" db:"to_addr"`
-	Subject   string    `json:"subject" db:"subject"`
-	Body      string    `json:"body" db:"body"`
-	HTML      string    `json:"html" db:"html"`
-	Raw       string    `json:"raw" db:"raw"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	ID      int    `json:"id" db:"id"`
+	From    string `json:"from" db:"from_addr"`
+	To      string `json:"to" db:"to_addr"`
+	Subject string `json:"subject" db:"subject"`
+	Body    string `json:"body" db:"body"`
+	HTML    string `json:"html" db:"html"`
+	// Raw is the message exactly as received. It is not part of the JSON
+	// representation; storage backends keep it separately and the API
+	// serves it from /api/emails/:id/raw.
+	Raw []byte `json:"-" db:"raw"`
+	// RawBlob is the blob store key of messages too large to keep inline,
+	// in which case Raw is empty
+	RawBlob string `json:"raw_blob,omitempty" db:"raw_blob"`
+	Size    int64  `json:"size" db:"size"`
+	// Headers holds the top-level header fields with encoded words
+	// decoded, keyed by canonical field name
+	Headers     map[string][]string `json:"headers,omitempty" db:"headers"`
+	ClientIP    string              `json:"client_ip,omitempty" db:"client_ip"`
+	Helo        string              `json:"helo,omitempty" db:"helo"`
+	Attachments []*Attachment       `json:"attachments,omitempty" db:"attachments"`
+	Calendar    []*CalendarEvent    `json:"calendar,omitempty" db:"calendar"`
+	Auth        *AuthResults        `json:"auth,omitempty" db:"auth"`
+	Secure      *SecureMessage      `json:"secure,omitempty" db:"secure"`
+	Warnings    []*ParseWarning     `json:"warnings,omitempty" db:"warnings"`
+	Spam        *SpamReport         `json:"spam,omitempty" db:"spam"`
+	TextCheck   *TextCheck          `json:"text_check,omitempty" db:"text_check"`
+	// Source is set on emails loaded from files instead of received over
+	// SMTP
+	Source    *ImportSource `json:"source,omitempty" db:"source"`
+	CreatedAt time.Time     `json:"created_at" db:"created_at"`
+}
+
+// ImportSource describes where an imported email came from
+type ImportSource struct {
+	// Format is mbox, maildir or eml
+	Format string `json:"format"`
+	// File is the file the message was read from, within the uploaded
+	// archive for imports over the API
+	File string `json:"file"`
+	// Index is the position of the message in an mbox file, from 1
+	Index      int       `json:"index,omitempty"`
+	ImportedAt time.Time `json:"imported_at"`
 }
 
 type EmailSummary struct {
-	ID        int       `json:"id"`
-	From      string    `json:"from"`
-	To        string    `json:"to"`
-	Subject   string    `json:"subject"`
+	ID      int    `json:"id"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	// WarningCount is the number of parse warnings of the email
+	WarningCount int `json:"warning_count"`
+	// Size is the size of the raw message in bytes
+	Size int64 `json:"size"`
+	// Snippet is an HTML excerpt with the matches of a full-text search
+	// in <mark> elements
+	Snippet   string    `json:"snippet,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
-}
\ No newline at end of file
+}
@@ -0,0 +1,40 @@
+package models
+
+// SecureMessage describes the S/MIME or OpenPGP protection of an email
+// and the content recovered from it
+type SecureMessage struct {
+	// Type is "smime" or "pgp"
+	Type           string          `json:"type"`
+	Signed         bool            `json:"signed"`
+	Encrypted      bool            `json:"encrypted"`
+	Decrypted      bool            `json:"decrypted"`
+	SignatureValid bool            `json:"signature_valid"`
+	Signers        []*SecureSigner `json:"signers,omitempty"`
+	Errors         []string        `json:"errors,omitempty"`
+	// MIMETree is the structure of the innermost (verified or decrypted)
+	// content
+	MIMETree *MIMEPart `json:"mime_tree,omitempty"`
+}
+
+// SecureSigner identifies the holder of a signing certificate or key
+type SecureSigner struct {
+	Name        string `json:"name,omitempty"`
+	Email       string `json:"email,omitempty"`
+	Subject     string `json:"subject,omitempty"`
+	Issuer      string `json:"issuer,omitempty"`
+	KeyID       string `json:"key_id,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Trusted is set when the signer chains to a configured root
+	// certificate or is present in the configured keyring
+	Trusted bool `json:"trusted"`
+}
+
+// MIMEPart is a node of a decoded MIME tree
+type MIMEPart struct {
+	ContentType string      `json:"content_type"`
+	Disposition string      `json:"disposition,omitempty"`
+	Filename    string      `json:"filename,omitempty"`
+	Size        int         `json:"size"`
+	Content     string      `json:"content,omitempty"`
+	Parts       []*MIMEPart `json:"parts,omitempty"`
+}
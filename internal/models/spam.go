@@ -0,0 +1,22 @@
+package models
+
+// SpamReport is the spam score of a captured email and the rules that
+// contributed to it
+type SpamReport struct {
+	// Engine is "builtin" or "spamd"
+	Engine    string      `json:"engine"`
+	Score     float64     `json:"score"`
+	Threshold float64     `json:"threshold"`
+	IsSpam    bool        `json:"is_spam"`
+	Rules     []*SpamRule `json:"rules,omitempty"`
+	// Error explains why spamd could not be used and the built-in rules
+	// were applied instead
+	Error string `json:"error,omitempty"`
+}
+
+// SpamRule is a rule that matched the email
+type SpamRule struct {
+	Name        string  `json:"name"`
+	Score       float64 `json:"score"`
+	Description string  `json:"description"`
+}
@@ -0,0 +1,25 @@
+package models
+
+// TextCheck compares the plain text part of an email with a text rendering
+// of its HTML part
+type TextCheck struct {
+	// Status is "ok", "missing" when there is no plain text part, or
+	// "diverged" when it differs substantially from the HTML part
+	Status string `json:"status"`
+	// Similarity is the share of words the two versions have in common,
+	// from 0 to 1
+	Similarity float64 `json:"similarity"`
+	// Generated is the plain text rendering of the HTML part
+	Generated string `json:"generated"`
+	// Diff is a word diff from the plain text part to Generated; it is
+	// left out for very long texts
+	Diff []*DiffChunk `json:"diff,omitempty"`
+}
+
+// DiffChunk is a run of words in a diff
+type DiffChunk struct {
+	// Op is "equal", "delete" (only in the plain text part) or "insert"
+	// (only in the HTML part)
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
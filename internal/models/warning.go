@@ -0,0 +1,14 @@
+package models
+
+// ParseWarning is a standards compliance problem found while parsing an
+// email. Code is a stable identifier suitable for filtering, e.g. "bare_lf",
+// "line_too_long" or "missing_date".
+type ParseWarning struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Part is the MIME part the problem was found in, e.g. "1.2"; it is
+	// empty for problems with the header or the message as a whole
+	Part string `json:"part,omitempty"`
+	// Line is the first affected line of the message, if known
+	Line int `json:"line,omitempty"`
+}
@@ -0,0 +1,355 @@
+package retention
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"mailcatch/internal/blob"
+	"mailcatch/internal/models"
+	"mailcatch/internal/storage"
+)
+
+// Eviction reasons
+const (
+	ReasonAge   = "age"
+	ReasonCount = "count"
+	ReasonSize  = "size"
+)
+
+// Options configure a Janitor
+type Options struct {
+	// Default applies to emails of mailboxes without their own policy
+	Default Policy
+	// Mailboxes are policies by address or *@domain
+	Mailboxes map[string]Policy
+	// Interval is the time between background sweeps
+	Interval time.Duration
+}
+
+// Enabled reports whether any policy evicts emails
+func (o Options) Enabled() bool {
+	if o.Default.Limited() {
+		return true
+	}
+	for _, policy := range o.Mailboxes {
+		if policy.Limited() {
+			return true
+		}
+	}
+	return false
+}
+
+// Stats are the counters of a Janitor
+type Stats struct {
+	Sweeps       int64            `json:"sweeps"`
+	Evicted      int64            `json:"evicted"`
+	EvictedBytes int64            `json:"evicted_bytes"`
+	ByReason     map[string]int64 `json:"by_reason"`
+	LastSweep    *time.Time       `json:"last_sweep,omitempty"`
+	LastError    string           `json:"last_error,omitempty"`
+}
+
+// Janitor enforces the retention policies in the background and after
+// emails are saved
+type Janitor struct {
+	storage storage.Storage
+	blobs   *blob.Store
+	options Options
+
+	// sweep serializes sweeps
+	sweep   sync.Mutex
+	notify  chan struct{}
+	stop    chan struct{}
+	stopped chan struct{}
+
+	mutex sync.Mutex
+	stats Stats
+	// usages are what the mailboxes keep since the last sweep, counting
+	// the emails added after it; nil when unknown
+	usages map[string]*usage
+	// sweeping is set while a sweep runs, which may miss emails added
+	sweeping bool
+}
+
+// pageSize is the number of summaries read at a time during a sweep
+const pageSize = 500
+
+// notifyDelay is the time a requested sweep waits for further requests,
+// so a burst of emails is swept once
+const notifyDelay = time.Second
+
+// NewJanitor returns a janitor for the emails of storage and their blobs
+func NewJanitor(storage storage.Storage, blobs *blob.Store, options Options) *Janitor {
+	if options.Interval <= 0 {
+		options.Interval = time.Minute
+	}
+	return &Janitor{
+		storage: storage,
+		blobs:   blobs,
+		options: options,
+		notify:  make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+		stats:   Stats{ByReason: make(map[string]int64)},
+	}
+}
+
+// Start runs a sweep every interval and after each Notify until Stop
+func (j *Janitor) Start() {
+	log.Printf("Retention enabled: %s, %d mailbox policies, sweeping every %s", j.options.Default, len(j.options.Mailboxes), j.options.Interval)
+
+	go func() {
+		defer close(j.stopped)
+
+		ticker := time.NewTicker(j.options.Interval)
+		defer ticker.Stop()
+
+		for {
+			j.Sweep()
+			select {
+			case <-ticker.C:
+			case <-j.notify:
+				select {
+				case <-time.After(notifyDelay):
+				case <-j.stop:
+					return
+				}
+			case <-j.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the background sweeps and waits for a running one
+func (j *Janitor) Stop() {
+	close(j.stop)
+	<-j.stopped
+}
+
+// Notify requests a sweep after emails were added. Requests made while a
+// sweep is pending are coalesced, so it never blocks the caller.
+func (j *Janitor) Notify() {
+	select {
+	case j.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Added records an email saved for the recipients to and requests a sweep
+// only when it takes its mailbox over a count or size limit. Age limits
+// are left to the periodic sweeps.
+func (j *Janitor) Added(to string, size int64) {
+	name := mailbox(to, j.options.Mailboxes)
+	policy := j.policy(name)
+
+	j.mutex.Lock()
+	over := j.usages == nil || j.sweeping
+	if !over {
+		used := j.usages[name]
+		if used == nil {
+			used = &usage{}
+			j.usages[name] = used
+		}
+		used.count++
+		used.bytes += size
+		over = (policy.MaxCount > 0 && used.count > policy.MaxCount) ||
+			(policy.MaxBytes > 0 && used.bytes > policy.MaxBytes)
+	}
+	j.mutex.Unlock()
+
+	if over {
+		j.Notify()
+	}
+}
+
+// policy returns the policy of a mailbox, the default one for ""
+func (j *Janitor) policy(name string) Policy {
+	if name != "" {
+		return j.options.Mailboxes[name]
+	}
+	return j.options.Default
+}
+
+// victim is an email to evict
+type victim struct {
+	id     int
+	size   int64
+	reason string
+}
+
+// usage is what a mailbox keeps so far during a sweep
+type usage struct {
+	count int
+	bytes int64
+	// full is set once the size limit is reached, since older emails are
+	// evicted even when they would fit
+	full bool
+}
+
+// Sweep evicts the emails the policies do not keep and returns the number
+// evicted. Each mailbox keeps its newest emails within the limits.
+func (j *Janitor) Sweep() (int, error) {
+	j.sweep.Lock()
+	defer j.sweep.Unlock()
+
+	j.mutex.Lock()
+	j.sweeping = true
+	j.mutex.Unlock()
+
+	evicted := 0
+	victims, usages, err := j.victims(time.Now())
+	if err == nil {
+		evicted, err = j.evict(victims)
+	}
+
+	j.mutex.Lock()
+	j.sweeping = false
+	j.usages = usages
+	if err != nil {
+		j.usages = nil
+	}
+	j.stats.Sweeps++
+	now := time.Now()
+	j.stats.LastSweep = &now
+	j.stats.LastError = ""
+	if err != nil {
+		j.stats.LastError = err.Error()
+	}
+	j.mutex.Unlock()
+
+	if err != nil {
+		log.Printf("Retention sweep failed: %v", err)
+	}
+	return evicted, err
+}
+
+// victims walks the emails from the newest and picks the ones to evict,
+// returning what each mailbox keeps
+func (j *Janitor) victims(now time.Time) ([]victim, map[string]*usage, error) {
+	var victims []victim
+	usages := make(map[string]*usage)
+
+	page := storage.PageRequest{Limit: pageSize, Sort: storage.SortReceived, Desc: true}
+	for {
+		result, err := j.storage.GetEmails(storage.EmailFilter{}, page)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to list emails: %w", err)
+		}
+
+		for _, email := range result.Emails {
+			name := mailbox(email.To, j.options.Mailboxes)
+			policy := j.policy(name)
+			if usages[name] == nil {
+				usages[name] = &usage{}
+			}
+			used := usages[name]
+
+			reason := ""
+			switch {
+			case policy.MaxAge > 0 && now.Sub(email.CreatedAt) > policy.MaxAge:
+				reason = ReasonAge
+			case policy.MaxCount > 0 && used.count >= policy.MaxCount:
+				reason = ReasonCount
+			case policy.MaxBytes > 0 && (used.full || used.bytes+email.Size > policy.MaxBytes):
+				reason = ReasonSize
+				used.full = true
+			}
+			if reason != "" {
+				victims = append(victims, victim{id: email.ID, size: email.Size, reason: reason})
+				continue
+			}
+			used.count++
+			used.bytes += email.Size
+		}
+
+		if result.NextCursor == "" {
+			return victims, usages, nil
+		}
+		page.After, err = storage.DecodeCursor(result.NextCursor)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// evict deletes the victims with their blobs, records them and returns
+// the number deleted
+func (j *Janitor) evict(victims []victim) (int, error) {
+	if len(victims) == 0 {
+		return 0, nil
+	}
+
+	var firstErr error
+	evicted := make(map[string]int)
+	var bytes int64
+	for _, v := range victims {
+		email, err := j.storage.GetEmail(v.id)
+		if err != nil {
+			// Deleted meanwhile
+			continue
+		}
+		err = j.storage.DeleteEmail(v.id)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to delete email %d: %w", v.id, err)
+			}
+			continue
+		}
+		// A snapshot may still hold the email, and its blobs with it
+		if held, err := storage.HoldsEmail(j.storage, v.id); err != nil {
+			log.Printf("Error checking the snapshots of email %d: %v", v.id, err)
+		} else if !held {
+			DeleteBlobs(j.blobs, email)
+		}
+		evicted[v.reason]++
+		bytes += v.size
+	}
+
+	total := 0
+	var reasons []string
+	j.mutex.Lock()
+	for reason, count := range evicted {
+		j.stats.ByReason[reason] += int64(count)
+		total += count
+		reasons = append(reasons, fmt.Sprintf("%d by %s", count, reason))
+	}
+	j.stats.Evicted += int64(total)
+	j.stats.EvictedBytes += bytes
+	j.mutex.Unlock()
+
+	if total > 0 {
+		sort.Strings(reasons)
+		log.Printf("Retention evicted %d emails (%d bytes): %s", total, bytes, strings.Join(reasons, ", "))
+	}
+	return total, firstErr
+}
+
+// Stats returns a copy of the counters
+func (j *Janitor) Stats() Stats {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	stats := j.stats
+	stats.ByReason = make(map[string]int64, len(j.stats.ByReason))
+	for reason, count := range j.stats.ByReason {
+		stats.ByReason[reason] = count
+	}
+	return stats
+}
+
+// DeleteBlobs removes the blobs of an evicted email, logging failures.
+// Storages evicting emails on their own hand them over to it as well.
+func DeleteBlobs(blobs *blob.Store, email *models.Email) {
+	if err := blobs.DeleteEmail(email); err != nil {
+		log.Printf("Error deleting blobs of email %d: %v", email.ID, err)
+	}
+}
@@ -0,0 +1,69 @@
+package retention
+
+import (
+	"testing"
+
+	"mailcatch/internal/blob"
+	"mailcatch/internal/models"
+	"mailcatch/internal/storage"
+)
+
+// notified reports whether a sweep was requested, clearing the request
+func notified(j *Janitor) bool {
+	select {
+	case <-j.notify:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestAddedRequestsSweepOverLimit(t *testing.T) {
+	store, err := storage.NewMemoryStorage("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	j := NewJanitor(store, blob.NewMemoryStore(), Options{
+		Default:   Policy{MaxCount: 3},
+		Mailboxes: map[string]Policy{"ci@example.com": {MaxBytes: 100}},
+	})
+	add := func(to string, size int64) bool {
+		t.Helper()
+		if err := store.SaveEmail(&models.Email{From: "a@example.com", To: to, Size: size}); err != nil {
+			t.Fatal(err)
+		}
+		j.Added(to, size)
+		return notified(j)
+	}
+
+	// Before the first sweep the usage is unknown
+	if !add("bob@example.com", 10) {
+		t.Error("no sweep requested before the first sweep")
+	}
+	if _, err := j.Sweep(); err != nil {
+		t.Fatal(err)
+	}
+
+	if add("bob@example.com", 10) || add("bob@example.com", 10) || add("ci@example.com", 60) {
+		t.Error("sweep requested under the limits")
+	}
+	if !add("bob@example.com", 10) {
+		t.Error("no sweep requested over the count limit")
+	}
+	if !add("ci@example.com", 60) {
+		t.Error("no sweep requested over the size limit")
+	}
+
+	evicted, err := j.Sweep()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evicted != 2 {
+		t.Errorf("evicted %d emails, want 2", evicted)
+	}
+	if add("ci@example.com", 10) {
+		t.Error("sweep requested under the limits after a sweep")
+	}
+}
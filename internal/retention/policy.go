@@ -0,0 +1,116 @@
+// Package retention evicts captured emails by age, count and total size,
+// with limits that can differ per mailbox.
+package retention
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+)
+
+// Policy bounds the emails kept; a zero limit is unlimited
+type Policy struct {
+	MaxAge   time.Duration
+	MaxCount int
+	MaxBytes int64
+}
+
+// Limited reports whether the policy evicts anything
+func (p Policy) Limited() bool {
+	return p.MaxAge > 0 || p.MaxCount > 0 || p.MaxBytes > 0
+}
+
+// String describes the limits of the policy
+func (p Policy) String() string {
+	var limits []string
+	if p.MaxAge > 0 {
+		limits = append(limits, "max age "+p.MaxAge.String())
+	}
+	if p.MaxCount > 0 {
+		limits = append(limits, fmt.Sprintf("max count %d", p.MaxCount))
+	}
+	if p.MaxBytes > 0 {
+		limits = append(limits, fmt.Sprintf("max size %d bytes", p.MaxBytes))
+	}
+	if len(limits) == 0 {
+		return "unlimited"
+	}
+	return strings.Join(limits, ", ")
+}
+
+// override is a mailbox entry of the overrides file. Omitted limits are
+// inherited from the default policy.
+type override struct {
+	MaxAge   *string `json:"max_age"`
+	MaxCount *int    `json:"max_count"`
+	MaxBytes *int64  `json:"max_size"`
+}
+
+// LoadMailboxes reads per-mailbox policies from a JSON object keyed by
+// address, or by *@domain for a whole domain, e.g.
+//
+//	{"ci@example.com": {"max_age": "1h", "max_count": 50, "max_size": 1048576}}
+//
+// Limits missing from an entry are taken from def.
+func LoadMailboxes(path string, def Policy) (map[string]Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var overrides map[string]override
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	mailboxes := make(map[string]Policy, len(overrides))
+	for mailbox, entry := range overrides {
+		policy := def
+		if entry.MaxAge != nil {
+			policy.MaxAge, err = time.ParseDuration(*entry.MaxAge)
+			if err != nil {
+				return nil, fmt.Errorf("%s: mailbox %s: %w", path, mailbox, err)
+			}
+		}
+		if entry.MaxCount != nil {
+			policy.MaxCount = *entry.MaxCount
+		}
+		if entry.MaxBytes != nil {
+			policy.MaxBytes = *entry.MaxBytes
+		}
+		if policy.MaxAge < 0 || policy.MaxCount < 0 || policy.MaxBytes < 0 {
+			return nil, fmt.Errorf("%s: mailbox %s: limits cannot be negative", path, mailbox)
+		}
+		mailboxes[strings.ToLower(mailbox)] = policy
+	}
+	return mailboxes, nil
+}
+
+// mailbox returns the first recipient in to with its own policy, or ""
+// when the default policy applies
+func mailbox(to string, mailboxes map[string]Policy) string {
+	if len(mailboxes) == 0 {
+		return ""
+	}
+
+	for _, recipient := range strings.Split(to, ",") {
+		address := strings.TrimSpace(recipient)
+		if parsed, err := mail.ParseAddress(address); err == nil {
+			address = parsed.Address
+		}
+		address = strings.ToLower(address)
+
+		if _, ok := mailboxes[address]; ok {
+			return address
+		}
+		if at := strings.LastIndex(address, "@"); at >= 0 {
+			if _, ok := mailboxes["*"+address[at:]]; ok {
+				return "*" + address[at:]
+			}
+		}
+	}
+	return ""
+}
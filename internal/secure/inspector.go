@@ -0,0 +1,123 @@
+package secure
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/x509"
+	"io"
+	"mime"
+	"net/textproto"
+	"strings"
+
+	"mailcatch/internal/models"
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// maxLayers bounds the number of nested signature/encryption layers that
+// are unwrapped, e.g. signed-then-encrypted messages have two
+const maxLayers = 4
+
+// Inspector detects S/MIME and OpenPGP structures in captured emails,
+// verifies their signatures and decrypts them with the configured keys
+type Inspector struct {
+	smimeKeys  []smimeKey
+	smimeRoots *x509.CertPool
+	pgpKeyring openpgp.EntityList
+}
+
+func NewInspector() *Inspector {
+	return &Inspector{}
+}
+
+// Inspect records the protection applied to the email read from raw. When
+// a layer is decrypted or unwrapped, the email's text and HTML bodies are
+// replaced with the recovered content.
+func (in *Inspector) Inspect(email *models.Email, raw io.Reader) {
+	// Only protected messages are loaded into memory. Everything read while
+	// peeking at the header is kept so the message can be reassembled.
+	var peeked bytes.Buffer
+	header, err := textproto.NewReader(bufio.NewReader(io.TeeReader(raw, &peeked))).ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	if !isProtected(mediaType) {
+		return
+	}
+
+	rest, err := io.ReadAll(raw)
+	if err != nil {
+		return
+	}
+	current, err := readEntity(append(peeked.Bytes(), rest...))
+	if err != nil {
+		return
+	}
+
+	result := &models.SecureMessage{}
+	unwrapped := false
+
+	for layer := 0; layer < maxLayers; layer++ {
+		var inner *entity
+		var opaque bool
+
+		mediaType, params := current.mediaType()
+		protocol := strings.ToLower(params["protocol"])
+
+		switch {
+		case mediaType == "multipart/signed" && isSMIMESignature(protocol):
+			inner = in.verifySMIMEDetached(current, result)
+		case mediaType == "multipart/signed" && protocol == "application/pgp-signature":
+			inner = in.verifyPGPDetached(current, result)
+		case mediaType == "application/pkcs7-mime" || mediaType == "application/x-pkcs7-mime":
+			inner = in.openSMIME(current, params, result)
+			opaque = true
+		case mediaType == "multipart/encrypted" && protocol == "application/pgp-encrypted":
+			inner = in.decryptPGP(current, result)
+			opaque = true
+		}
+
+		if inner == nil {
+			break
+		}
+		current = inner
+		unwrapped = unwrapped || opaque
+	}
+
+	if result.Type == "" {
+		return
+	}
+
+	result.MIMETree = buildTree(current, 0)
+	if unwrapped {
+		text, html := textBodies(result.MIMETree)
+		email.Body = text
+		email.HTML = html
+		if email.Body == "" {
+			email.Body = html
+		}
+	}
+
+	email.Secure = result
+}
+
+// setType records the protection scheme of the outermost layer
+func setType(result *models.SecureMessage, kind string) {
+	if result.Type == "" {
+		result.Type = kind
+	}
+}
+
+// isProtected reports whether a message's top level media type may carry a
+// signature or encryption layer
+func isProtected(mediaType string) bool {
+	switch mediaType {
+	case "multipart/signed", "multipart/encrypted", "application/pkcs7-mime", "application/x-pkcs7-mime":
+		return true
+	}
+	return false
+}
+
+func isSMIMESignature(protocol string) bool {
+	return protocol == "application/pkcs7-signature" || protocol == "application/x-pkcs7-signature"
+}
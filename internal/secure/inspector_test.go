@@ -0,0 +1,155 @@
+package secure
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"mailcatch/internal/models"
+)
+
+// The fixtures in testdata are made by generate.sh; the PGP secret key is
+// protected with this passphrase
+const testPassphrase = "mailcatch"
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func tamper(t *testing.T, data []byte, old, new string) []byte {
+	t.Helper()
+	tampered := bytes.Replace(data, []byte(old), []byte(new), 1)
+	if bytes.Equal(tampered, data) {
+		t.Fatalf("%q not found in fixture", old)
+	}
+	return tampered
+}
+
+func TestInspect(t *testing.T) {
+	smimeSigned := readFixture(t, "smime-signed.eml")
+	pgpSigned := readFixture(t, "pgp-signed.eml")
+
+	type keys struct{ smime, roots, pgp bool }
+	tests := []struct {
+		name           string
+		raw            []byte
+		keys           keys
+		kind           string
+		signed         bool
+		encrypted      bool
+		decrypted      bool
+		signatureValid bool
+		signer         bool
+		trusted        bool
+		body           string
+		err            string
+	}{
+		{"S/MIME detached", smimeSigned, keys{roots: true}, "smime", true, false, false, true, true, true, "", ""},
+		{"S/MIME detached without roots", smimeSigned, keys{}, "smime", true, false, false, true, true, false, "", ""},
+		{"S/MIME detached tampered", tamper(t, smimeSigned, "Signed with", "Signed by"), keys{roots: true}, "smime", true, false, false, false, true, false, "", "signature verification failed"},
+		{"S/MIME opaque", readFixture(t, "smime-opaque.eml"), keys{roots: true}, "smime", true, false, false, true, true, true, "Signed with S/MIME.\r\n", ""},
+		{"S/MIME encrypted", readFixture(t, "smime-encrypted.eml"), keys{smime: true}, "smime", false, true, true, false, false, false, "Signed with S/MIME.\r\n", ""},
+		{"S/MIME encrypted without keys", readFixture(t, "smime-encrypted.eml"), keys{}, "smime", false, true, false, false, false, false, "", "no S/MIME keys configured"},
+		{"PGP/MIME signed", pgpSigned, keys{pgp: true}, "pgp", true, false, false, true, true, true, "", ""},
+		{"PGP/MIME signed tampered", tamper(t, pgpSigned, "Signed with", "Signed by"), keys{pgp: true}, "pgp", true, false, false, false, false, true, "", "signature verification failed"},
+		{"PGP/MIME signed by unknown key", pgpSigned, keys{}, "pgp", true, false, false, false, false, false, "", "signature verification failed"},
+		{"PGP/MIME encrypted", readFixture(t, "pgp-encrypted.eml"), keys{pgp: true}, "pgp", true, true, true, true, true, true, "Encrypted with OpenPGP.\r\n", ""},
+		{"PGP/MIME encrypted without keys", readFixture(t, "pgp-encrypted.eml"), keys{}, "pgp", false, true, false, false, false, false, "", "decryption failed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inspector := NewInspector()
+			if tt.keys.smime {
+				if err := inspector.LoadSMIMEKeys("testdata/smime-alice.pem"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.keys.roots {
+				if err := inspector.LoadSMIMERoots("testdata/smime-ca.pem"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.keys.pgp {
+				if err := inspector.LoadPGPKeyring("testdata/pgp-keyring.asc", testPassphrase); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			email := &models.Email{Body: "original"}
+			inspector.Inspect(email, bytes.NewReader(tt.raw))
+			result := email.Secure
+			if result == nil {
+				t.Fatal("no secure result")
+			}
+
+			if result.Type != tt.kind || result.Signed != tt.signed || result.Encrypted != tt.encrypted ||
+				result.Decrypted != tt.decrypted || result.SignatureValid != tt.signatureValid {
+				t.Errorf("got type %q signed %v encrypted %v decrypted %v valid %v, errors %q",
+					result.Type, result.Signed, result.Encrypted, result.Decrypted, result.SignatureValid, result.Errors)
+			}
+
+			// A failed PGP/MIME signature check does not name its signer
+			if (len(result.Signers) == 1) != tt.signer || len(result.Signers) > 1 {
+				t.Fatalf("got %d signers, want signer %v", len(result.Signers), tt.signer)
+			}
+			if tt.signer {
+				signer := result.Signers[0]
+				if signer.Email != "alice@example.com" {
+					t.Errorf("signer email %q", signer.Email)
+				}
+				if signer.Trusted != tt.trusted {
+					t.Errorf("signer trusted %v, want %v", signer.Trusted, tt.trusted)
+				}
+			}
+
+			// Only unwrapped opaque layers replace the body
+			want := tt.body
+			if want == "" {
+				want = "original"
+			}
+			if email.Body != want {
+				t.Errorf("body %q, want %q", email.Body, want)
+			}
+
+			errors := strings.Join(result.Errors, "; ")
+			if tt.err == "" && errors != "" {
+				t.Errorf("unexpected errors %q", errors)
+			}
+			if !strings.Contains(errors, tt.err) {
+				t.Errorf("errors %q do not mention %q", errors, tt.err)
+			}
+		})
+	}
+}
+
+func TestInspectSignedContentTree(t *testing.T) {
+	inspector := NewInspector()
+	email := &models.Email{}
+	inspector.Inspect(email, bytes.NewReader(readFixture(t, "smime-signed.eml")))
+
+	tree := email.Secure.MIMETree
+	if tree == nil || tree.ContentType != "text/plain" || tree.Content != "Signed with S/MIME.\r\n" {
+		t.Errorf("MIME tree %+v, want the signed text part", tree)
+	}
+}
+
+func TestInspectPlainMessage(t *testing.T) {
+	email := &models.Email{Body: "hello"}
+	NewInspector().Inspect(email, strings.NewReader("From: a@example.com\r\nContent-Type: text/plain\r\n\r\nhello\r\n"))
+	if email.Secure != nil || email.Body != "hello" {
+		t.Errorf("plain message reported as %+v", email.Secure)
+	}
+}
+
+func TestLoadPGPKeyringWrongPassphrase(t *testing.T) {
+	if err := NewInspector().LoadPGPKeyring("testdata/pgp-keyring.asc", "wrong"); err == nil {
+		t.Error("keyring unlocked with the wrong passphrase")
+	}
+}
@@ -0,0 +1,189 @@
+package secure
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+
+	"mailcatch/internal/models"
+)
+
+// maxTreeDepth bounds recursion into nested multiparts
+const maxTreeDepth = 16
+
+// entity is a MIME entity kept in its raw, undecoded form so signatures
+// can be checked over the exact bytes
+type entity struct {
+	header textproto.MIMEHeader
+	body   []byte
+	raw    []byte
+}
+
+// readEntity splits raw entity bytes into header and body
+func readEntity(raw []byte) (*entity, error) {
+	reader := bufio.NewReader(bytes.NewReader(raw))
+	header, err := textproto.NewReader(reader).ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return &entity{header: header, body: body, raw: raw}, nil
+}
+
+func (e *entity) mediaType() (string, map[string]string) {
+	mediaType, params, err := mime.ParseMediaType(e.header.Get("Content-Type"))
+	if err != nil {
+		return "text/plain", map[string]string{}
+	}
+	return mediaType, params
+}
+
+// decodedBody returns the body with its Content-Transfer-Encoding removed
+func (e *entity) decodedBody() ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(e.header.Get("Content-Transfer-Encoding"))) {
+	case "base64":
+		return io.ReadAll(base64.NewDecoder(base64.StdEncoding, bytes.NewReader(e.body)))
+	case "quoted-printable":
+		return io.ReadAll(quotedprintable.NewReader(bytes.NewReader(e.body)))
+	default:
+		return e.body, nil
+	}
+}
+
+// parts returns the raw child entities of a multipart body
+func (e *entity) parts() ([]*entity, error) {
+	_, params := e.mediaType()
+
+	var children []*entity
+	for _, raw := range splitMultipart(e.body, params["boundary"]) {
+		child, err := readEntity(raw)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+	}
+
+	return children, nil
+}
+
+// splitMultipart returns the exact bytes of each body part. Unlike
+// mime/multipart it neither decodes nor normalizes the parts, which
+// matters for detached signatures. The line break before each delimiter
+// belongs to the delimiter (RFC 2046 section 5.1.1).
+func splitMultipart(body []byte, boundary string) [][]byte {
+	if boundary == "" {
+		return nil
+	}
+
+	delimiter := []byte("--" + boundary)
+	var parts [][]byte
+	start := -1
+	pos := 0
+
+	for pos < len(body) {
+		lineEnd := len(body)
+		if i := bytes.IndexByte(body[pos:], '\n'); i != -1 {
+			lineEnd = pos + i + 1
+		}
+		line := bytes.TrimRight(body[pos:lineEnd], " \t\r\n")
+
+		if bytes.HasPrefix(line, delimiter) {
+			rest := line[len(delimiter):]
+			closing := bytes.Equal(rest, []byte("--"))
+			if len(rest) == 0 || closing {
+				if start >= 0 {
+					end := pos
+					if end > start && body[end-1] == '\n' {
+						end--
+						if end > start && body[end-1] == '\r' {
+							end--
+						}
+					}
+					parts = append(parts, body[start:end])
+				}
+				if closing {
+					return parts
+				}
+				start = lineEnd
+			}
+		}
+
+		pos = lineEnd
+	}
+
+	return parts
+}
+
+// buildTree decodes an entity into a MIME tree
+func buildTree(e *entity, depth int) *models.MIMEPart {
+	mediaType, params := e.mediaType()
+	part := &models.MIMEPart{ContentType: mediaType}
+
+	if disposition, dispParams, err := mime.ParseMediaType(e.header.Get("Content-Disposition")); err == nil {
+		part.Disposition = disposition
+		part.Filename = dispParams["filename"]
+	}
+	if part.Filename == "" {
+		part.Filename = params["name"]
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") && depth < maxTreeDepth {
+		children, err := e.parts()
+		if err == nil {
+			for _, child := range children {
+				node := buildTree(child, depth+1)
+				part.Size += node.Size
+				part.Parts = append(part.Parts, node)
+			}
+			return part
+		}
+	}
+
+	decoded, err := e.decodedBody()
+	if err != nil {
+		decoded = e.body
+	}
+	part.Size = len(decoded)
+	if strings.HasPrefix(mediaType, "text/") {
+		part.Content = string(decoded)
+	}
+
+	return part
+}
+
+// textBodies returns the first inline text/plain and text/html contents
+// found in a MIME tree
+func textBodies(part *models.MIMEPart) (text, html string) {
+	if part == nil {
+		return "", ""
+	}
+	if part.Disposition != "attachment" {
+		switch part.ContentType {
+		case "text/plain":
+			return part.Content, ""
+		case "text/html":
+			return "", part.Content
+		}
+	}
+
+	for _, child := range part.Parts {
+		childText, childHTML := textBodies(child)
+		if text == "" {
+			text = childText
+		}
+		if html == "" {
+			html = childHTML
+		}
+	}
+	return text, html
+}
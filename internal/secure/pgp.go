@@ -0,0 +1,153 @@
+package secure
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"mailcatch/internal/models"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// LoadPGPKeyring reads an armored or binary OpenPGP keyring. Public keys
+// are used to verify signatures and secret keys to decrypt messages;
+// encrypted secret keys are unlocked with passphrase.
+func (in *Inspector) LoadPGPKeyring(path, passphrase string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var entities openpgp.EntityList
+	if bytes.Contains(data, []byte("-----BEGIN PGP")) {
+		entities, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		entities, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	for _, entity := range entities {
+		if passphrase == "" {
+			continue
+		}
+		if err := entity.DecryptPrivateKeys([]byte(passphrase)); err != nil {
+			return fmt.Errorf("%s: unlocking key %X: %w", path, entity.PrimaryKey.KeyId, err)
+		}
+	}
+
+	in.pgpKeyring = append(in.pgpKeyring, entities...)
+	return nil
+}
+
+// verifyPGPDetached checks a PGP/MIME signed message (RFC 3156 section 5)
+// and returns the signed content
+func (in *Inspector) verifyPGPDetached(e *entity, result *models.SecureMessage) *entity {
+	setType(result, "pgp")
+	result.Signed = true
+
+	parts, err := e.parts()
+	if err != nil || len(parts) != 2 {
+		result.Errors = append(result.Errors, "multipart/signed must have exactly two parts")
+		return nil
+	}
+
+	signature, err := parts[1].decodedBody()
+	if err != nil {
+		result.Errors = append(result.Errors, "invalid signature encoding: "+err.Error())
+		return parts[0]
+	}
+
+	signer, err := openpgp.CheckArmoredDetachedSignature(in.pgpKeyring, bytes.NewReader(parts[0].raw), bytes.NewReader(signature), nil)
+	if signer != nil {
+		result.Signers = append(result.Signers, pgpSigner(signer, signer.PrimaryKey.KeyId))
+	}
+	if err != nil {
+		result.Errors = append(result.Errors, "signature verification failed: "+err.Error())
+		return parts[0]
+	}
+
+	result.SignatureValid = true
+	return parts[0]
+}
+
+// decryptPGP decrypts a PGP/MIME encrypted message (RFC 3156 section 4).
+// Signatures inside the encrypted payload are verified as well.
+func (in *Inspector) decryptPGP(e *entity, result *models.SecureMessage) *entity {
+	setType(result, "pgp")
+	result.Encrypted = true
+
+	parts, err := e.parts()
+	if err != nil || len(parts) != 2 {
+		result.Errors = append(result.Errors, "multipart/encrypted must have exactly two parts")
+		return nil
+	}
+
+	payload, err := parts[1].decodedBody()
+	if err != nil {
+		result.Errors = append(result.Errors, "invalid payload encoding: "+err.Error())
+		return nil
+	}
+
+	block, err := armor.Decode(bytes.NewReader(payload))
+	if err != nil {
+		result.Errors = append(result.Errors, "invalid armored payload: "+err.Error())
+		return nil
+	}
+
+	md, err := openpgp.ReadMessage(block.Body, in.pgpKeyring, nil, &packet.Config{})
+	if err != nil {
+		result.Errors = append(result.Errors, "decryption failed: "+err.Error())
+		return nil
+	}
+
+	content, err := io.ReadAll(md.UnverifiedBody)
+	if md.IsSigned {
+		result.Signed = true
+		if md.SignedBy != nil {
+			result.Signers = append(result.Signers, pgpSigner(md.SignedBy.Entity, md.SignedByKeyId))
+		} else {
+			result.Signers = append(result.Signers, &models.SecureSigner{KeyID: fmt.Sprintf("%016X", md.SignedByKeyId)})
+		}
+
+		switch {
+		case md.SignedBy == nil:
+			result.Errors = append(result.Errors, "signing key not in keyring")
+		case md.SignatureError != nil:
+			result.Errors = append(result.Errors, "signature verification failed: "+md.SignatureError.Error())
+		case err == nil:
+			result.SignatureValid = true
+		}
+	}
+	if err != nil {
+		result.Errors = append(result.Errors, "decryption failed: "+err.Error())
+		return nil
+	}
+
+	result.Decrypted = true
+	return parseInner(content, result)
+}
+
+// pgpSigner describes a keyring entity; keys found in the configured
+// keyring are considered trusted
+func pgpSigner(entity *openpgp.Entity, keyID uint64) *models.SecureSigner {
+	signer := &models.SecureSigner{
+		KeyID:       fmt.Sprintf("%016X", keyID),
+		Fingerprint: fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint),
+		Trusted:     true,
+	}
+
+	if identity := entity.PrimaryIdentity(); identity != nil && identity.UserId != nil {
+		signer.Name = identity.UserId.Name
+		signer.Email = identity.UserId.Email
+		signer.Subject = identity.Name
+	}
+	signer.Name = strings.TrimSpace(signer.Name)
+
+	return signer
+}
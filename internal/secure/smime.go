@@ -0,0 +1,220 @@
+package secure
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+
+	"mailcatch/internal/models"
+	"go.mozilla.org/pkcs7"
+)
+
+// smimeKey is a recipient certificate together with its private key
+type smimeKey struct {
+	cert *x509.Certificate
+	key  crypto.PrivateKey
+}
+
+// LoadSMIMEKeys reads certificates and private keys from a PEM file. Each
+// private key is paired with the certificate holding its public key.
+func (in *Inspector) LoadSMIMEKeys(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var certs []*x509.Certificate
+	var keys []crypto.Signer
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		switch block.Type {
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			certs = append(certs, cert)
+		case "RSA PRIVATE KEY":
+			key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			keys = append(keys, key)
+		case "PRIVATE KEY":
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			signer, ok := key.(crypto.Signer)
+			if !ok {
+				return fmt.Errorf("%s: unsupported private key type %T", path, key)
+			}
+			keys = append(keys, signer)
+		}
+	}
+
+	loaded := 0
+	for _, key := range keys {
+		for _, cert := range certs {
+			public, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+			if ok && public.Equal(cert.PublicKey) {
+				in.smimeKeys = append(in.smimeKeys, smimeKey{cert: cert, key: key})
+				loaded++
+				break
+			}
+		}
+	}
+	if loaded == 0 {
+		return fmt.Errorf("%s: no certificate matches a private key", path)
+	}
+
+	return nil
+}
+
+// LoadSMIMERoots reads the CA certificates signers are validated against
+func (in *Inspector) LoadSMIMERoots(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if in.smimeRoots == nil {
+		in.smimeRoots = x509.NewCertPool()
+	}
+	if !in.smimeRoots.AppendCertsFromPEM(data) {
+		return fmt.Errorf("%s: no certificates found", path)
+	}
+
+	return nil
+}
+
+// verifySMIMEDetached checks a multipart/signed message with a detached
+// PKCS#7 signature and returns the signed content
+func (in *Inspector) verifySMIMEDetached(e *entity, result *models.SecureMessage) *entity {
+	setType(result, "smime")
+	result.Signed = true
+
+	parts, err := e.parts()
+	if err != nil || len(parts) != 2 {
+		result.Errors = append(result.Errors, "multipart/signed must have exactly two parts")
+		return nil
+	}
+
+	der, err := parts[1].decodedBody()
+	if err != nil {
+		result.Errors = append(result.Errors, "invalid signature encoding: "+err.Error())
+		return parts[0]
+	}
+
+	p7, err := pkcs7.Parse(der)
+	if err != nil {
+		result.Errors = append(result.Errors, "invalid PKCS#7 signature: "+err.Error())
+		return parts[0]
+	}
+	p7.Content = parts[0].raw
+
+	in.checkSMIMESignature(p7, result)
+	return parts[0]
+}
+
+// openSMIME handles application/pkcs7-mime, which is either an enveloped
+// (encrypted) or an opaque signed message
+func (in *Inspector) openSMIME(e *entity, params map[string]string, result *models.SecureMessage) *entity {
+	setType(result, "smime")
+
+	der, err := e.decodedBody()
+	if err != nil {
+		result.Errors = append(result.Errors, "invalid PKCS#7 encoding: "+err.Error())
+		return nil
+	}
+
+	p7, err := pkcs7.Parse(der)
+	if err != nil {
+		result.Errors = append(result.Errors, "invalid PKCS#7 structure: "+err.Error())
+		return nil
+	}
+
+	smimeType := strings.ToLower(params["smime-type"])
+	if smimeType == "signed-data" || (smimeType == "" && len(p7.Signers) > 0) {
+		result.Signed = true
+		in.checkSMIMESignature(p7, result)
+		return parseInner(p7.Content, result)
+	}
+
+	result.Encrypted = true
+	if len(in.smimeKeys) == 0 {
+		result.Errors = append(result.Errors, "no S/MIME keys configured for decryption")
+		return nil
+	}
+
+	for _, recipient := range in.smimeKeys {
+		content, err := p7.Decrypt(recipient.cert, recipient.key)
+		if err != nil {
+			continue
+		}
+		result.Decrypted = true
+		return parseInner(content, result)
+	}
+
+	result.Errors = append(result.Errors, "no configured S/MIME key can decrypt the message")
+	return nil
+}
+
+// checkSMIMESignature verifies the signature and records the signer. A
+// signature is valid when the content digest and signature match; the
+// signer is trusted only if it also chains to a configured root.
+func (in *Inspector) checkSMIMESignature(p7 *pkcs7.PKCS7, result *models.SecureMessage) {
+	var signer *models.SecureSigner
+	if cert := p7.GetOnlySigner(); cert != nil {
+		signer = smimeSigner(cert)
+		result.Signers = append(result.Signers, signer)
+	}
+
+	if err := p7.Verify(); err != nil {
+		result.Errors = append(result.Errors, "signature verification failed: "+err.Error())
+		return
+	}
+	result.SignatureValid = true
+
+	if signer != nil && in.smimeRoots != nil {
+		if err := p7.VerifyWithChain(in.smimeRoots); err == nil {
+			signer.Trusted = true
+		} else {
+			result.Errors = append(result.Errors, "signer is not trusted: "+err.Error())
+		}
+	}
+}
+
+func smimeSigner(cert *x509.Certificate) *models.SecureSigner {
+	fingerprint := sha256.Sum256(cert.Raw)
+	signer := &models.SecureSigner{
+		Name:        cert.Subject.CommonName,
+		Subject:     cert.Subject.String(),
+		Issuer:      cert.Issuer.String(),
+		Fingerprint: hex.EncodeToString(fingerprint[:]),
+	}
+	if len(cert.EmailAddresses) > 0 {
+		signer.Email = cert.EmailAddresses[0]
+	}
+	return signer
+}
+
+// parseInner parses recovered content as a MIME entity
+func parseInner(content []byte, result *models.SecureMessage) *entity {
+	inner, err := readEntity(content)
+	if err != nil {
+		result.Errors = append(result.Errors, "recovered content is not a MIME entity: "+err.Error())
+		return nil
+	}
+	return inner
+}
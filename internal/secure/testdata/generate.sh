@@ -0,0 +1,59 @@
+#!/bin/sh
+# Regenerates the S/MIME and OpenPGP fixtures with openssl and gpg. The
+# PGP secret key is protected with the passphrase "mailcatch".
+set -e
+cd "$(dirname "$0")"
+work=$(mktemp -d)
+trap 'rm -rf "$work"' EXIT
+
+crlf() { sed 's/\r*$/\r/'; }
+
+# S/MIME: a CA and one user certificate used to sign and to decrypt
+openssl req -x509 -newkey rsa:2048 -nodes -days 36500 -subj "/CN=MailCatch Test CA" \
+	-keyout "$work/ca.key" -out smime-ca.pem
+openssl req -newkey rsa:2048 -nodes -subj "/CN=Alice/emailAddress=alice@example.com" \
+	-keyout "$work/alice.key" -out "$work/alice.csr"
+printf 'subjectAltName=email:alice@example.com\nkeyUsage=digitalSignature,keyEncipherment\nextendedKeyUsage=emailProtection\n' > "$work/ext"
+openssl x509 -req -in "$work/alice.csr" -CA smime-ca.pem -CAkey "$work/ca.key" -CAcreateserial -CAserial "$work/ca.srl" \
+	-days 36500 -extfile "$work/ext" -out "$work/alice.crt"
+cat "$work/alice.crt" "$work/alice.key" > smime-alice.pem
+
+printf 'Content-Type: text/plain; charset=utf-8\n\nSigned with S/MIME.\n' | crlf > "$work/content"
+headers='From: alice@example.com
+To: bob@example.com
+Subject: Protected message
+'
+{ printf '%s' "$headers" | crlf
+  openssl smime -sign -binary -in "$work/content" -signer "$work/alice.crt" -inkey "$work/alice.key" | crlf
+} > smime-signed.eml
+{ printf '%s' "$headers" | crlf
+  openssl smime -sign -nodetach -binary -in "$work/content" -signer "$work/alice.crt" -inkey "$work/alice.key" | crlf
+} > smime-opaque.eml
+{ printf '%s' "$headers" | crlf
+  openssl smime -encrypt -aes256 -binary -in "$work/content" "$work/alice.crt" | crlf
+} > smime-encrypted.eml
+
+# OpenPGP: one key used to sign and to decrypt
+export GNUPGHOME="$work/gnupg"
+mkdir -m 700 "$GNUPGHOME"
+gpg --batch --pinentry-mode loopback --passphrase mailcatch \
+	--quick-generate-key "Alice <alice@example.com>" rsa2048 sign,encrypt never
+gpg --batch --pinentry-mode loopback --passphrase mailcatch --armor --export-secret-keys > pgp-keyring.asc
+
+printf 'Content-Type: text/plain; charset=utf-8\n\nSigned with OpenPGP.\n' | crlf > "$work/signed"
+gpg --batch --pinentry-mode loopback --passphrase mailcatch --armor --detach-sign \
+	-o "$work/signed.asc" "$work/signed"
+{ printf '%sContent-Type: multipart/signed; micalg=pgp-sha256; protocol="application/pgp-signature"; boundary="sig"\n\n--sig\n' "$headers" | crlf
+  cat "$work/signed"
+  printf '\n--sig\nContent-Type: application/pgp-signature\n\n' | crlf
+  crlf < "$work/signed.asc"
+  printf '\n--sig--\n' | crlf
+} > pgp-signed.eml
+
+printf 'Content-Type: text/plain; charset=utf-8\n\nEncrypted with OpenPGP.\n' | crlf > "$work/secret"
+gpg --batch --pinentry-mode loopback --passphrase mailcatch --armor --sign --encrypt \
+	-r alice@example.com -o "$work/secret.asc" "$work/secret"
+{ printf '%sContent-Type: multipart/encrypted; protocol="application/pgp-encrypted"; boundary="enc"\n\n--enc\nContent-Type: application/pgp-encrypted\n\nVersion: 1\n\n--enc\nContent-Type: application/octet-stream\n\n' "$headers" | crlf
+  crlf < "$work/secret.asc"
+  printf '\n--enc--\n' | crlf
+} > pgp-encrypted.eml
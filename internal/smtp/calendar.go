@@ -0,0 +1,547 @@
+package smtp
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"mailcatch/internal/models"
+)
+
+// icalProperty is a single unfolded content line of an iCalendar document
+type icalProperty struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// parseCalendar extracts the VEVENT components of an iCalendar document.
+// method is the Content-Type "method" parameter and is used when the
+// document itself has no METHOD property.
+func parseCalendar(data string, method string) []*models.CalendarEvent {
+	var events []*models.CalendarEvent
+	var current *models.CalendarEvent
+	var duration string
+	var components []string
+
+	// Time zones declared by VTIMEZONE components, used for TZIDs that
+	// are not known to the system time zone database
+	zones := make(map[string]*calendarZone)
+	var zone *calendarZone
+	var observance *calendarObservance
+
+	for _, line := range unfoldCalendar(data) {
+		prop, ok := parseCalendarLine(line)
+		if !ok {
+			continue
+		}
+
+		switch prop.name {
+		case "BEGIN":
+			components = append(components, strings.ToUpper(prop.value))
+			path := strings.Join(components, "/")
+			switch path {
+			case "VCALENDAR/VEVENT":
+				current = &models.CalendarEvent{}
+				duration = ""
+			case "VCALENDAR/VTIMEZONE":
+				zone = &calendarZone{}
+			case "VCALENDAR/VTIMEZONE/STANDARD", "VCALENDAR/VTIMEZONE/DAYLIGHT":
+				observance = &calendarObservance{}
+			}
+			continue
+		case "END":
+			if len(components) == 2 && components[1] == "VEVENT" && current != nil {
+				if current.End == nil && current.Start != nil && duration != "" {
+					if d, ok := parseCalendarDuration(duration); ok {
+						end := *current.Start
+						end.Time = end.Time.Add(d)
+						end.Value = ""
+						current.End = &end
+					}
+				}
+				events = append(events, current)
+				current = nil
+			}
+			if len(components) == 3 && components[1] == "VTIMEZONE" && zone != nil && observance != nil {
+				if observance.valid() {
+					zone.observances = append(zone.observances, observance)
+				}
+				observance = nil
+			}
+			if len(components) == 2 && components[1] == "VTIMEZONE" && zone != nil {
+				if zone.tzid != "" && len(zone.observances) > 0 {
+					zones[zone.tzid] = zone
+				}
+				zone = nil
+			}
+			if len(components) > 0 {
+				components = components[:len(components)-1]
+			}
+			continue
+		}
+
+		path := strings.Join(components, "/")
+		switch {
+		case path == "VCALENDAR" && prop.name == "METHOD":
+			method = prop.value
+		case path == "VCALENDAR/VTIMEZONE" && prop.name == "TZID" && zone != nil:
+			zone.tzid = prop.value
+		case (path == "VCALENDAR/VTIMEZONE/STANDARD" || path == "VCALENDAR/VTIMEZONE/DAYLIGHT") && observance != nil:
+			observance.set(prop)
+		case path == "VCALENDAR/VEVENT" && current != nil:
+			switch prop.name {
+			case "UID":
+				current.UID = prop.value
+			case "SUMMARY":
+				current.Summary = unescapeCalendarText(prop.value)
+			case "LOCATION":
+				current.Location = unescapeCalendarText(prop.value)
+			case "STATUS":
+				current.Status = strings.ToUpper(prop.value)
+			case "SEQUENCE":
+				current.Sequence, _ = strconv.Atoi(prop.value)
+			case "ORGANIZER":
+				current.Organizer = parseCalendarAttendee(prop)
+			case "ATTENDEE":
+				current.Attendees = append(current.Attendees, parseCalendarAttendee(prop))
+			case "DTSTART":
+				current.Start = parseCalendarTime(prop, zones)
+			case "DTEND":
+				current.End = parseCalendarTime(prop, zones)
+			case "DURATION":
+				duration = prop.value
+			case "RRULE":
+				current.RecurRule = prop.value
+			case "RECURRENCE-ID":
+				current.RecurrenceID = parseCalendarTime(prop, zones)
+			}
+		}
+	}
+
+	method = strings.ToUpper(method)
+	for _, event := range events {
+		event.Method = method
+	}
+
+	return events
+}
+
+// unfoldCalendar splits an iCalendar document into logical lines,
+// joining continuation lines that begin with a space or tab
+func unfoldCalendar(data string) []string {
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+
+	var lines []string
+	for _, line := range strings.Split(data, "\n") {
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// parseCalendarLine parses "NAME;PARAM=VALUE;...:value"
+func parseCalendarLine(line string) (icalProperty, bool) {
+	prop := icalProperty{params: make(map[string]string)}
+
+	// Find the name/value separator, skipping colons inside quoted params
+	inQuotes := false
+	sep := -1
+	for i, r := range line {
+		if r == '"' {
+			inQuotes = !inQuotes
+		} else if r == ':' && !inQuotes {
+			sep = i
+			break
+		}
+	}
+	if sep == -1 {
+		return prop, false
+	}
+
+	prop.value = line[sep+1:]
+	head := splitCalendarParams(line[:sep])
+	prop.name = strings.ToUpper(strings.TrimSpace(head[0]))
+
+	for _, param := range head[1:] {
+		key, value, found := strings.Cut(param, "=")
+		if !found {
+			continue
+		}
+		prop.params[strings.ToUpper(key)] = strings.Trim(value, `"`)
+	}
+
+	return prop, prop.name != ""
+}
+
+// splitCalendarParams splits the property name and its parameters on
+// semicolons that are not inside quotes
+func splitCalendarParams(s string) []string {
+	var parts []string
+	inQuotes := false
+	start := 0
+	for i, r := range s {
+		if r == '"' {
+			inQuotes = !inQuotes
+		} else if r == ';' && !inQuotes {
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func parseCalendarAttendee(prop icalProperty) *models.CalendarAttendee {
+	address := prop.value
+	if strings.HasPrefix(strings.ToLower(address), "mailto:") {
+		address = address[len("mailto:"):]
+	}
+
+	return &models.CalendarAttendee{
+		Email:    address,
+		Name:     prop.params["CN"],
+		Role:     strings.ToUpper(prop.params["ROLE"]),
+		PartStat: strings.ToUpper(prop.params["PARTSTAT"]),
+		RSVP:     strings.EqualFold(prop.params["RSVP"], "TRUE"),
+	}
+}
+
+func parseCalendarTime(prop icalProperty, zones map[string]*calendarZone) *models.CalendarTime {
+	value := strings.TrimSpace(prop.value)
+	ct := &models.CalendarTime{
+		Value: value,
+		TZID:  prop.params["TZID"],
+	}
+
+	if strings.EqualFold(prop.params["VALUE"], "DATE") || len(value) == 8 {
+		t, err := time.Parse("20060102", value)
+		if err != nil {
+			return ct
+		}
+		ct.Time = t
+		ct.AllDay = true
+		return ct
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		if t, err := time.Parse("20060102T150405Z", value); err == nil {
+			ct.Time = t
+		}
+		return ct
+	}
+
+	// Local times are interpreted in their TZID, falling back to the
+	// offsets declared in the document's VTIMEZONE and finally to UTC
+	wall, err := time.Parse("20060102T150405", value)
+	if err != nil {
+		return ct
+	}
+	ct.Time = wall
+	if ct.TZID == "" {
+		return ct
+	}
+	if loc, err := time.LoadLocation(ct.TZID); err == nil {
+		ct.Time = time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), 0, loc)
+	} else if zone, ok := zones[ct.TZID]; ok {
+		loc := time.FixedZone(ct.TZID, zone.offsetAt(wall))
+		ct.Time = time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), 0, loc)
+	}
+
+	return ct
+}
+
+// calendarZone is a VTIMEZONE component
+type calendarZone struct {
+	tzid        string
+	observances []*calendarObservance
+}
+
+// calendarObservance is a STANDARD or DAYLIGHT sub-component: the offset
+// in effect from its onsets on. Onsets are kept as wall clock times in
+// UTC.
+type calendarObservance struct {
+	start      time.Time
+	offsetFrom int
+	offsetTo   int
+	rule       map[string]string
+	hasStart   bool
+	hasOffset  bool
+}
+
+func (o *calendarObservance) set(prop icalProperty) {
+	switch prop.name {
+	case "DTSTART":
+		if t, err := time.Parse("20060102T150405", strings.TrimSpace(prop.value)); err == nil {
+			o.start = t
+			o.hasStart = true
+		}
+	case "TZOFFSETFROM":
+		o.offsetFrom, _ = parseCalendarOffset(prop.value)
+	case "TZOFFSETTO":
+		o.offsetTo, o.hasOffset = parseCalendarOffset(prop.value)
+	case "RRULE":
+		o.rule = make(map[string]string)
+		for _, part := range strings.Split(strings.ToUpper(prop.value), ";") {
+			if key, value, found := strings.Cut(part, "="); found {
+				o.rule[key] = value
+			}
+		}
+	}
+}
+
+func (o *calendarObservance) valid() bool {
+	return o.hasStart && o.hasOffset
+}
+
+// lastOnset returns the latest onset of the observance at or before wall
+func (o *calendarObservance) lastOnset(wall time.Time) (time.Time, bool) {
+	if wall.Before(o.start) {
+		return time.Time{}, false
+	}
+	if o.rule == nil || o.rule["FREQ"] != "YEARLY" {
+		return o.start, true
+	}
+
+	until := time.Time{}
+	if value, ok := o.rule["UNTIL"]; ok {
+		if t, err := time.Parse("20060102T150405Z", value); err == nil {
+			// UNTIL is in UTC; onsets are compared as wall clock times
+			until = t.Add(time.Duration(o.offsetFrom) * time.Second)
+		} else if t, err := time.Parse("20060102", value); err == nil {
+			until = t.Add(24*time.Hour - time.Second)
+		}
+	}
+
+	for year := wall.Year(); year >= o.start.Year() && year >= wall.Year()-1; year-- {
+		onset, ok := o.onsetIn(year)
+		if !ok || onset.After(wall) || onset.Before(o.start) {
+			continue
+		}
+		if !until.IsZero() && onset.After(until) {
+			// The rule ended, so its last onset is at or before UNTIL
+			return o.lastOnset(until)
+		}
+		return onset, true
+	}
+	return time.Time{}, false
+}
+
+// onsetIn returns the onset of a yearly rule in year, supporting the
+// BYMONTH, BYMONTHDAY and BYDAY parts used by time zone definitions
+func (o *calendarObservance) onsetIn(year int) (time.Time, bool) {
+	month := o.start.Month()
+	if value, ok := o.rule["BYMONTH"]; ok {
+		m, err := strconv.Atoi(value)
+		if err != nil || m < 1 || m > 12 {
+			return time.Time{}, false
+		}
+		month = time.Month(m)
+	}
+
+	first := time.Date(year, month, 1, o.start.Hour(), o.start.Minute(), o.start.Second(), 0, time.UTC)
+	days := first.AddDate(0, 1, -1).Day()
+
+	var candidates []time.Time
+	for day := 1; day <= days; day++ {
+		candidates = append(candidates, first.AddDate(0, 0, day-1))
+	}
+
+	if value, ok := o.rule["BYMONTHDAY"]; ok {
+		allowed := make(map[int]bool)
+		for _, part := range strings.Split(value, ",") {
+			if day, err := strconv.Atoi(part); err == nil {
+				if day < 0 {
+					day += days + 1
+				}
+				allowed[day] = true
+			}
+		}
+		candidates = filterDays(candidates, func(t time.Time) bool { return allowed[t.Day()] })
+	} else if _, ok := o.rule["BYDAY"]; !ok {
+		candidates = filterDays(candidates, func(t time.Time) bool { return t.Day() == o.start.Day() })
+	}
+
+	if value, ok := o.rule["BYDAY"]; ok {
+		ordinal, weekday, ok := parseCalendarWeekday(value)
+		if !ok {
+			return time.Time{}, false
+		}
+		candidates = filterDays(candidates, func(t time.Time) bool { return t.Weekday() == weekday })
+		switch {
+		case ordinal > 0 && ordinal <= len(candidates):
+			candidates = candidates[ordinal-1 : ordinal]
+		case ordinal < 0 && -ordinal <= len(candidates):
+			candidates = candidates[len(candidates)+ordinal : len(candidates)+ordinal+1]
+		case ordinal != 0:
+			candidates = nil
+		}
+	}
+
+	if len(candidates) == 0 {
+		return time.Time{}, false
+	}
+	return candidates[0], true
+}
+
+func filterDays(days []time.Time, keep func(time.Time) bool) []time.Time {
+	var kept []time.Time
+	for _, day := range days {
+		if keep(day) {
+			kept = append(kept, day)
+		}
+	}
+	return kept
+}
+
+var calendarWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// parseCalendarWeekday parses a BYDAY value such as "2SU" or "-1SU"
+func parseCalendarWeekday(value string) (int, time.Weekday, bool) {
+	if len(value) < 2 {
+		return 0, 0, false
+	}
+	weekday, ok := calendarWeekdays[value[len(value)-2:]]
+	if !ok {
+		return 0, 0, false
+	}
+	ordinal := 0
+	if prefix := value[:len(value)-2]; prefix != "" {
+		n, err := strconv.Atoi(prefix)
+		if err != nil {
+			return 0, 0, false
+		}
+		ordinal = n
+	}
+	return ordinal, weekday, true
+}
+
+// offsetAt returns the UTC offset in effect at a wall clock time: that of
+// the observance with the latest onset before it. Times before every
+// onset take the offset the earliest observance starts from.
+func (z *calendarZone) offsetAt(wall time.Time) int {
+	var latest time.Time
+	offset, found := 0, false
+	for _, observance := range z.observances {
+		if onset, ok := observance.lastOnset(wall); ok && (!found || onset.After(latest)) {
+			latest, offset, found = onset, observance.offsetTo, true
+		}
+	}
+	if found {
+		return offset
+	}
+
+	earliest := z.observances[0]
+	for _, observance := range z.observances[1:] {
+		if observance.start.Before(earliest.start) {
+			earliest = observance
+		}
+	}
+	return earliest.offsetFrom
+}
+
+// parseCalendarOffset parses a UTC offset such as "-0500" or "+053000"
+// into seconds east of UTC
+func parseCalendarOffset(value string) (int, bool) {
+	if len(value) != 5 && len(value) != 7 {
+		return 0, false
+	}
+
+	sign := 1
+	switch value[0] {
+	case '-':
+		sign = -1
+	case '+':
+	default:
+		return 0, false
+	}
+
+	hours, err := strconv.Atoi(value[1:3])
+	if err != nil {
+		return 0, false
+	}
+	minutes, err := strconv.Atoi(value[3:5])
+	if err != nil {
+		return 0, false
+	}
+	seconds := 0
+	if len(value) == 7 {
+		if seconds, err = strconv.Atoi(value[5:7]); err != nil {
+			return 0, false
+		}
+	}
+
+	return sign * (hours*3600 + minutes*60 + seconds), true
+}
+
+// parseCalendarDuration parses an RFC 5545 duration such as "PT1H30M"
+// or "-P1W"
+func parseCalendarDuration(value string) (time.Duration, bool) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+
+	sign := time.Duration(1)
+	if strings.HasPrefix(value, "-") {
+		sign = -1
+		value = value[1:]
+	}
+	value = strings.TrimPrefix(value, "+")
+
+	if !strings.HasPrefix(value, "P") {
+		return 0, false
+	}
+	value = value[1:]
+
+	var total time.Duration
+	var number int
+	inTime := false
+	hasNumber := false
+	for _, r := range value {
+		switch {
+		case r >= '0' && r <= '9':
+			number = number*10 + int(r-'0')
+			hasNumber = true
+			continue
+		case r == 'T':
+			inTime = true
+			continue
+		}
+
+		if !hasNumber {
+			return 0, false
+		}
+
+		n := time.Duration(number)
+		switch {
+		case r == 'W' && !inTime:
+			total += n * 7 * 24 * time.Hour
+		case r == 'D' && !inTime:
+			total += n * 24 * time.Hour
+		case r == 'H' && inTime:
+			total += n * time.Hour
+		case r == 'M' && inTime:
+			total += n * time.Minute
+		case r == 'S' && inTime:
+			total += n * time.Second
+		default:
+			return 0, false
+		}
+		number = 0
+		hasNumber = false
+	}
+
+	return sign * total, true
+}
+
+func unescapeCalendarText(value string) string {
+	replacer := strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`)
+	return replacer.Replace(value)
+}
@@ -0,0 +1,245 @@
+package smtp
+
+import (
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+// calendar joins iCalendar lines with CRLF
+func calendar(lines ...string) string {
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+// customEastern is a VTIMEZONE with a TZID unknown to the time zone
+// database, following the current US rules
+var customEastern = []string{
+	"BEGIN:VTIMEZONE",
+	"TZID:Custom Eastern",
+	"BEGIN:STANDARD",
+	"DTSTART:16011104T020000",
+	"RRULE:FREQ=YEARLY;BYDAY=1SU;BYMONTH=11",
+	"TZOFFSETFROM:-0400",
+	"TZOFFSETTO:-0500",
+	"END:STANDARD",
+	"BEGIN:DAYLIGHT",
+	"DTSTART:16010311T020000",
+	"RRULE:FREQ=YEARLY;BYDAY=2SU;BYMONTH=3",
+	"TZOFFSETFROM:-0500",
+	"TZOFFSETTO:-0400",
+	"END:DAYLIGHT",
+	"END:VTIMEZONE",
+}
+
+func TestParseCalendar(t *testing.T) {
+	lines := append([]string{"BEGIN:VCALENDAR", "VERSION:2.0", "METHOD:REQUEST"}, customEastern...)
+	data := calendar(append(lines,
+		"BEGIN:VEVENT",
+		"UID:folded@example.com",
+		"SUMMARY:Team sync\\, wee",
+		" kly with the",
+		"\t whole team",
+		`ORGANIZER;CN="Smith: Alice":mailto:alice@example.com`,
+		"ATTENDEE;CN=Bob;ROLE=REQ-PARTICIPANT;PARTSTAT=NEEDS-ACTION;RSVP=TRUE:MAILTO:bob@example.com",
+		"DTSTART:20240105T090000Z",
+		"DURATION:PT1H30M",
+		"BEGIN:VALARM",
+		"TRIGGER:-PT15M",
+		"DURATION:PT5M",
+		"END:VALARM",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:berlin@example.com",
+		"DTSTART;TZID=Europe/Berlin:20240701T100000",
+		"DTEND;TZID=Europe/Berlin:20240701T110000",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:summer@example.com",
+		"DTSTART;TZID=Custom Eastern:20240701T100000",
+		"DTEND;TZID=Custom Eastern:20240701T110000",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:winter@example.com",
+		"DTSTART;TZID=Custom Eastern:20240115T100000",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:allday@example.com",
+		"DTSTART;VALUE=DATE:20240315",
+		"DTEND;VALUE=DATE:20240316",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	)...)
+
+	events := parseCalendar(data, "")
+	if len(events) != 5 {
+		t.Fatalf("got %d events, want 5", len(events))
+	}
+	for _, event := range events {
+		if event.Method != "REQUEST" {
+			t.Errorf("%s: method %q, want REQUEST", event.UID, event.Method)
+		}
+	}
+
+	folded := events[0]
+	if folded.Summary != "Team sync, weekly with the whole team" {
+		t.Errorf("summary %q", folded.Summary)
+	}
+	if folded.Organizer == nil || folded.Organizer.Email != "alice@example.com" || folded.Organizer.Name != "Smith: Alice" {
+		t.Errorf("organizer %+v", folded.Organizer)
+	}
+	if len(folded.Attendees) != 1 || folded.Attendees[0].Email != "bob@example.com" ||
+		folded.Attendees[0].PartStat != "NEEDS-ACTION" || !folded.Attendees[0].RSVP {
+		t.Errorf("attendees %+v", folded.Attendees)
+	}
+
+	// The alarm's DURATION does not end the event
+	utc := func(hour, minute int, day time.Time) time.Time {
+		return time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, time.UTC)
+	}
+	jan5 := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+	jul1 := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	jan15 := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		uid        string
+		start, end time.Time
+	}{
+		{"folded@example.com", utc(9, 0, jan5), utc(10, 30, jan5)},
+		{"berlin@example.com", utc(8, 0, jul1), utc(9, 0, jul1)},
+		{"summer@example.com", utc(14, 0, jul1), utc(15, 0, jul1)},
+		{"winter@example.com", utc(15, 0, jan15), time.Time{}},
+	}
+	for i, tt := range tests {
+		event := events[i]
+		if event.UID != tt.uid {
+			t.Fatalf("event %d is %s, want %s", i, event.UID, tt.uid)
+		}
+		if event.Start == nil || !event.Start.Time.Equal(tt.start) {
+			t.Errorf("%s: start %v, want %v", tt.uid, event.Start, tt.start)
+		}
+		if tt.end.IsZero() {
+			if event.End != nil {
+				t.Errorf("%s: end %v, want none", tt.uid, event.End)
+			}
+		} else if event.End == nil || !event.End.Time.Equal(tt.end) {
+			t.Errorf("%s: end %v, want %v", tt.uid, event.End, tt.end)
+		}
+	}
+	if tz := events[2].Start.TZID; tz != "Custom Eastern" {
+		t.Errorf("TZID %q", tz)
+	}
+
+	allDay := events[4]
+	if !allDay.Start.AllDay || !allDay.Start.Time.Equal(time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("all-day start %+v", allDay.Start)
+	}
+	if !allDay.End.AllDay || !allDay.End.Time.Equal(time.Date(2024, 3, 16, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("all-day end %+v", allDay.End)
+	}
+}
+
+func TestParseCalendarMethodParameter(t *testing.T) {
+	events := parseCalendar(calendar("BEGIN:VCALENDAR", "BEGIN:VEVENT", "UID:a", "END:VEVENT", "END:VCALENDAR"), "cancel")
+	if len(events) != 1 || events[0].Method != "CANCEL" {
+		t.Errorf("events %+v, want one CANCEL", events)
+	}
+}
+
+// The offset of a VTIMEZONE switches at the wall clock onsets of its
+// observances
+func TestCalendarZoneOffset(t *testing.T) {
+	custom := calendar(customEastern...)
+	// Outlook names zones after their offset and uses the last Sunday
+	outlook := calendar(
+		"BEGIN:VTIMEZONE",
+		"TZID:W. Europe Standard Time",
+		"BEGIN:STANDARD",
+		"DTSTART:16010101T030000",
+		"TZOFFSETFROM:+0200",
+		"TZOFFSETTO:+0100",
+		"RRULE:FREQ=YEARLY;INTERVAL=1;BYDAY=-1SU;BYMONTH=10",
+		"END:STANDARD",
+		"BEGIN:DAYLIGHT",
+		"DTSTART:16010101T020000",
+		"TZOFFSETFROM:+0100",
+		"TZOFFSETTO:+0200",
+		"RRULE:FREQ=YEARLY;INTERVAL=1;BYDAY=-1SU;BYMONTH=3",
+		"END:DAYLIGHT",
+		"END:VTIMEZONE",
+	)
+	// Rules that changed in 2007, with an UNTIL on the old ones
+	changed := calendar(
+		"BEGIN:VTIMEZONE",
+		"TZID:Old Eastern",
+		"BEGIN:DAYLIGHT",
+		"DTSTART:19870405T020000",
+		"RRULE:FREQ=YEARLY;BYMONTH=4;BYDAY=1SU;UNTIL=20060402T070000Z",
+		"TZOFFSETFROM:-0500",
+		"TZOFFSETTO:-0400",
+		"END:DAYLIGHT",
+		"BEGIN:DAYLIGHT",
+		"DTSTART:20070311T020000",
+		"RRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=2SU",
+		"TZOFFSETFROM:-0500",
+		"TZOFFSETTO:-0400",
+		"END:DAYLIGHT",
+		"BEGIN:STANDARD",
+		"DTSTART:19671029T020000",
+		"RRULE:FREQ=YEARLY;BYMONTH=10;BYDAY=-1SU;UNTIL=20061029T060000Z",
+		"TZOFFSETFROM:-0400",
+		"TZOFFSETTO:-0500",
+		"END:STANDARD",
+		"BEGIN:STANDARD",
+		"DTSTART:20071104T020000",
+		"RRULE:FREQ=YEARLY;BYMONTH=11;BYDAY=1SU",
+		"TZOFFSETFROM:-0400",
+		"TZOFFSETTO:-0500",
+		"END:STANDARD",
+		"END:VTIMEZONE",
+	)
+	// A zone with a single fixed observance
+	fixed := calendar(
+		"BEGIN:VTIMEZONE",
+		"TZID:Fixed",
+		"BEGIN:STANDARD",
+		"DTSTART:19700101T000000",
+		"TZOFFSETFROM:+0530",
+		"TZOFFSETTO:+0530",
+		"END:STANDARD",
+		"END:VTIMEZONE",
+	)
+
+	tests := []struct {
+		zone   string
+		tzid   string
+		wall   string
+		offset string
+	}{
+		{custom, "Custom Eastern", "20240310T015900", "-0500"},
+		{custom, "Custom Eastern", "20240310T030000", "-0400"},
+		{custom, "Custom Eastern", "20241103T015900", "-0400"},
+		{custom, "Custom Eastern", "20241103T020000", "-0500"},
+		{custom, "Custom Eastern", "20241231T120000", "-0500"},
+		{outlook, "W. Europe Standard Time", "20240331T015900", "+0100"},
+		{outlook, "W. Europe Standard Time", "20240331T030000", "+0200"},
+		{outlook, "W. Europe Standard Time", "20240815T120000", "+0200"},
+		{outlook, "W. Europe Standard Time", "20241027T030000", "+0100"},
+		{changed, "Old Eastern", "20060415T120000", "-0400"},
+		{changed, "Old Eastern", "20060315T120000", "-0500"},
+		{changed, "Old Eastern", "20061101T120000", "-0500"},
+		{changed, "Old Eastern", "20080315T120000", "-0400"},
+		{changed, "Old Eastern", "20081101T120000", "-0400"},
+		{fixed, "Fixed", "20240701T120000", "+0530"},
+	}
+	for _, tt := range tests {
+		data := "BEGIN:VCALENDAR\r\n" + tt.zone +
+			calendar("BEGIN:VEVENT", "DTSTART;TZID="+tt.tzid+":"+tt.wall, "END:VEVENT", "END:VCALENDAR")
+		events := parseCalendar(data, "")
+		if len(events) != 1 || events[0].Start == nil {
+			t.Fatalf("%s %s: no event start", tt.tzid, tt.wall)
+		}
+		if got := events[0].Start.Time.Format("-0700"); got != tt.offset {
+			t.Errorf("%s %s: offset %s, want %s", tt.tzid, tt.wall, got, tt.offset)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package smtp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/mail"
+	"strings"
+
+	"mailcatch/internal/models"
+)
+
+// Envelope is the sender and recipients of a message that was not received
+// over SMTP. Left empty, they are taken from the message headers.
+type Envelope struct {
+	From string
+	To   []string
+}
+
+// ParseMessage parses a message read from r exactly like a message
+// received over SMTP, spooling it to the blob store of opts. It is used to
+// load messages from files.
+func ParseMessage(r io.Reader, envelope Envelope, opts Options) (*models.Email, error) {
+	spool, err := opts.Blobs.Create()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spool file: %w", err)
+	}
+
+	lines := &lineChecker{}
+	reader := bufio.NewReader(r)
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if len(chunk) > 0 {
+			lines.write(chunk, err == nil)
+			if opts.MaxMessageSize > 0 && spool.Size()+int64(len(chunk)) > opts.MaxMessageSize {
+				spool.Discard()
+				return nil, fmt.Errorf("message exceeds the maximum size of %d bytes", opts.MaxMessageSize)
+			}
+			if _, err := spool.Write(chunk); err != nil {
+				spool.Discard()
+				return nil, err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != bufio.ErrBufferFull {
+			spool.Discard()
+			return nil, err
+		}
+	}
+
+	sess := &session{
+		server: &Server{opts: opts},
+		from:   envelope.From,
+		to:     envelope.To,
+	}
+	email, err := sess.parseEmail(spool, lines)
+	if err != nil {
+		return nil, err
+	}
+
+	if email.From == "" {
+		email.From = headerSender(email.Headers)
+	}
+	if email.To == "" {
+		email.To = strings.Join(headerRecipients(email.Headers), ", ")
+	}
+	return email, nil
+}
+
+// headerSender returns the sender address of a message from its
+// Return-Path or From header
+func headerSender(headers map[string][]string) string {
+	for _, name := range []string{"Return-Path", "From"} {
+		for _, value := range headers[name] {
+			if address, err := mail.ParseAddress(value); err == nil {
+				return address.Address
+			}
+			if value = strings.Trim(strings.TrimSpace(value), "<>"); value != "" {
+				return value
+			}
+		}
+	}
+	return ""
+}
+
+// headerRecipients returns the recipient addresses of a message from its
+// To, Cc and Bcc headers
+func headerRecipients(headers map[string][]string) []string {
+	var recipients []string
+	for _, name := range []string{"To", "Cc", "Bcc"} {
+		for _, value := range headers[name] {
+			addresses, err := mail.ParseAddressList(value)
+			if err != nil {
+				if value = strings.TrimSpace(value); value != "" {
+					recipients = append(recipients, value)
+				}
+				continue
+			}
+			for _, address := range addresses {
+				recipients = append(recipients, address.Address)
+			}
+		}
+	}
+	return recipients
+}
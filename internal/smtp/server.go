@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -12,45 +13,84 @@ import (
 	"mime/quotedprintable"
 	"net"
 	"net/mail"
+	"net/textproto"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"mailcatch/internal/blob"
 	"mailcatch/internal/models"
+	"mailcatch/internal/tnef"
 )
 
+// defaultInlineLimit is used when Options.InlineLimit is not set
+const defaultInlineLimit = 1 << 20
+
+// Options control how messages are received and stored
+type Options struct {
+	// Blobs holds the spooled messages and any message or attachment over
+	// InlineLimit; it is required
+	Blobs *blob.Store
+	// MaxMessageSize is the largest message accepted, in bytes; zero
+	// means no limit
+	MaxMessageSize int64
+	// InlineLimit is the largest message or attachment kept in memory and
+	// stored inline, in bytes
+	InlineLimit int64
+}
+
 type Server struct {
 	port     string
+	opts     Options
 	listener net.Listener
 	onEmail  func(*models.Email)
 }
 
 type session struct {
+	server *Server
 	conn   net.Conn
 	reader *bufio.Reader
 	writer *bufio.Writer
+	helo   string
 	from   string
 	to     []string
-	data   string
 }
 
-func NewServer(port string, onEmail func(*models.Email)) *Server {
+func NewServer(port string, opts Options, onEmail func(*models.Email)) *Server {
 	return &Server{
 		port:    port,
+		opts:    opts,
 		onEmail: onEmail,
 	}
 }
 
+func (s *Server) inlineLimit() int64 {
+	if s.opts.InlineLimit > 0 {
+		return s.opts.InlineLimit
+	}
+	return defaultInlineLimit
+}
+
 func (s *Server) Start() error {
-	var err error
-	s.listener, err = net.Listen("tcp", ":"+s.port)
+	listener, err := net.Listen("tcp", ":"+s.port)
 	if err != nil {
 		return fmt.Errorf("failed to start SMTP server: %w", err)
 	}
 
 	log.Printf("SMTP server listening on port %s", s.port)
+	return s.Serve(listener)
+}
+
+// Serve accepts connections on listener until the server is stopped
+func (s *Server) Serve(listener net.Listener) error {
+	s.listener = listener
 
 	for {
 		conn, err := s.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
 		if err != nil {
 			log.Printf("Failed to accept connection: %v", err)
 			continue
@@ -71,6 +111,7 @@ func (s *Server) handleConnection(conn net.Conn) {
 	defer conn.Close()
 	
 	sess := &session{
+		server: s,
 		conn:   conn,
 		reader: bufio.NewReader(conn),
 		writer: bufio.NewWriter(conn),
@@ -96,7 +137,7 @@ func (s *Server) handleConnection(conn net.Conn) {
 
 		switch parts[0] {
 		case "HELO", "EHLO":
-			sess.handleHelo()
+			sess.handleHelo(line)
 		case "MAIL":
 			sess.handleMail(line)
 		case "RCPT":
@@ -127,8 +168,45 @@ func (sess *session) writeLine(line string) error {
 	return sess.writer.Flush()
 }
 
-func (sess *session) handleHelo() {
-	sess.writeLine("250 Hello")
+func (sess *session) handleHelo(line string) {
+	// Remember the client's claimed identity for SPF checks
+	fields := strings.Fields(line)
+	if len(fields) > 1 {
+		sess.helo = fields[1]
+	}
+
+	if len(fields) == 0 || !strings.EqualFold(fields[0], "EHLO") {
+		sess.writeLine("250 Hello")
+		return
+	}
+
+	// Messages are stored byte for byte, so 8-bit content (RFC 6152) is
+	// accepted as is. The size limit is advertised per RFC 1870.
+	extensions := []string{"8BITMIME"}
+	if maxSize := sess.server.opts.MaxMessageSize; maxSize > 0 {
+		extensions = append(extensions, fmt.Sprintf("SIZE %d", maxSize))
+	}
+
+	sess.writeLine("250-Hello")
+	for i, extension := range extensions {
+		if i == len(extensions)-1 {
+			sess.writeLine("250 " + extension)
+		} else {
+			sess.writeLine("250-" + extension)
+		}
+	}
+}
+
+// clientIP returns the address of the connected client
+func (sess *session) clientIP() string {
+	// Imported messages have no connection
+	if sess.conn == nil {
+		return ""
+	}
+	if addr, ok := sess.conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP.String()
+	}
+	return ""
 }
 
 func (sess *session) handleMail(line string) {
@@ -137,6 +215,10 @@ func (sess *session) handleMail(line string) {
 	end := strings.Index(line, ">")
 	
 	if start != -1 && end != -1 && end > start {
+		if sess.exceedsSize(line[end+1:]) {
+			sess.writeLine("552 Message size exceeds fixed maximum message size")
+			return
+		}
 		sess.from = line[start+1 : end]
 		sess.writeLine("250 OK")
 	} else {
@@ -144,6 +226,23 @@ func (sess *session) handleMail(line string) {
 	}
 }
 
+// exceedsSize reports whether the SIZE parameter of a MAIL command is over
+// the size limit
+func (sess *session) exceedsSize(params string) bool {
+	maxSize := sess.server.opts.MaxMessageSize
+	if maxSize <= 0 {
+		return false
+	}
+
+	for _, param := range strings.Fields(params) {
+		if key, value, ok := strings.Cut(param, "="); ok && strings.EqualFold(key, "SIZE") {
+			size, err := strconv.ParseInt(value, 10, 64)
+			return err == nil && size > maxSize
+		}
+	}
+	return false
+}
+
 func (sess *session) handleRcpt(line string) {
 	// Extract email from "RCPT TO:<email@example.com>"
 	start := strings.Index(line, "<")
@@ -159,54 +258,166 @@ func (sess *session) handleRcpt(line string) {
 }
 
 func (sess *session) handleData(onEmail func(*models.Email)) {
+	// The message is spooled to disk while it is received so memory use
+	// does not grow with the message size
+	spool, err := sess.server.opts.Blobs.Create()
+	if err != nil {
+		log.Printf("Error creating spool file: %v", err)
+		sess.writeLine("451 Requested action aborted: local error in processing")
+		return
+	}
+
 	sess.writeLine("354 Start mail input; end with <CRLF>.<CRLF>")
-	
-	var data strings.Builder
-	for {
-		line, err := sess.readLine()
-		if err != nil {
-			log.Printf("Error reading data: %v", err)
-			return
-		}
-		
-		// Check for end of data
-		if strings.TrimSpace(line) == "." {
-			break
-		}
-		
-		data.WriteString(line)
+
+	lines := &lineChecker{}
+	tooLarge, err := sess.receiveData(spool, lines)
+	if err != nil {
+		log.Printf("Error reading data: %v", err)
+		spool.Discard()
+		return
 	}
-	
-	sess.data = data.String()
-	
+	if tooLarge {
+		spool.Discard()
+		sess.writeLine("552 Message size exceeds fixed maximum message size")
+		sess.reset()
+		return
+	}
+
 	// Parse email and create model
-	email := sess.parseEmail()
+	email, err := sess.parseEmail(spool, lines)
+	if err != nil {
+		log.Printf("Error reading spooled email: %v", err)
+		sess.writeLine("451 Requested action aborted: local error in processing")
+		sess.reset()
+		return
+	}
 	if onEmail != nil {
 		onEmail(email)
 	}
-	
+
 	sess.writeLine("250 OK: Message accepted")
 	sess.reset()
 }
 
-func (sess *session) parseEmail() *models.Email {
-	email := &models.Email{
+// receiveData copies the DATA section to the spool file, checking line
+// endings and lengths on the way. Messages over the size limit are read to
+// the end but not kept.
+func (sess *session) receiveData(spool *blob.Blob, lines *lineChecker) (bool, error) {
+	maxSize := sess.server.opts.MaxMessageSize
+	tooLarge := false
+	lineStart := true
+
+	for {
+		// Lines are read in chunks so a long line cannot exhaust memory
+		chunk, err := sess.reader.ReadSlice('\n')
+		if err != nil && err != bufio.ErrBufferFull {
+			return false, err
+		}
+
+		if lineStart {
+			// Check for end of data
+			if err == nil && bytes.Equal(bytes.TrimSpace(chunk), []byte(".")) {
+				return tooLarge, nil
+			}
+
+			// Undo dot-stuffing (RFC 5321 section 4.5.2) so the stored
+			// message matches what the client signed
+			if chunk[0] == '.' {
+				chunk = chunk[1:]
+			}
+		}
+		lineStart = err == nil
+		lines.write(chunk, lineStart)
+
+		if tooLarge || (maxSize > 0 && spool.Size()+int64(len(chunk)) > maxSize) {
+			tooLarge = true
+			continue
+		}
+		if _, err := spool.Write(chunk); err != nil {
+			return false, err
+		}
+	}
+}
+
+// parseEmail builds the email from the spooled message. Messages within
+// the inline limit are kept in Raw, larger ones stay in the blob store.
+// The spool is discarded if the email cannot be built.
+func (sess *session) parseEmail(spool *blob.Blob, lines *lineChecker) (email *models.Email, err error) {
+	defer func() {
+		if err != nil {
+			spool.Discard()
+		}
+	}()
+
+	email = &models.Email{
 		From:      sess.from,
 		To:        strings.Join(sess.to, ", "),
-		Raw:       sess.data,
+		ClientIP:  sess.clientIP(),
+		Helo:      sess.helo,
+		Size:      spool.Size(),
+		Warnings:  lines.warnings(),
 		CreatedAt: time.Now(),
 	}
 
-	// Parse using net/mail
-	msg, err := mail.ReadMessage(strings.NewReader(sess.data))
+	file, err := spool.Open()
+	if err != nil {
+		return nil, err
+	}
+	parseErr := sess.parseMessage(file, email)
+	file.Close()
+
+	if parseErr != nil {
+		log.Printf("Error parsing email: %v", parseErr)
+		addWarning(email, "malformed_message", "", "Message cannot be parsed, the raw data is shown as the body: %v", parseErr)
+		// Fallback to simple parsing. Messages over the inline limit are
+		// shown up to the limit, read from the spool before it moves to the
+		// blob store.
+		file, err := spool.Open()
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(io.LimitReader(file, sess.server.inlineLimit()))
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		email.Body = string(body)
+	}
+
+	if spool.Size() <= sess.server.inlineLimit() {
+		file, err := spool.Open()
+		if err != nil {
+			return nil, err
+		}
+		raw, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		spool.Discard()
+		email.Raw = raw
+	} else {
+		key, err := spool.Commit()
+		if err != nil {
+			return nil, err
+		}
+		email.RawBlob = key
+	}
+
+	return email, nil
+}
+
+// parseMessage reads the headers and body parts of a message. Parts are
+// decoded as they are read, so only content within the inline limit is
+// held in memory.
+func (sess *session) parseMessage(r io.Reader, email *models.Email) error {
+	msg, err := mail.ReadMessage(bufio.NewReader(r))
 	if err != nil {
-		log.Printf("Error parsing email: %v", err)
-		// Fallback to simple parsing
-		email.Body = sess.data
-		return email
+		return err
 	}
 
 	// Extract headers
+	checkHeader(msg.Header, email)
 	email.Subject = msg.Header.Get("Subject")
 
 	// Decode subject if needed
@@ -215,100 +426,349 @@ func (sess *session) parseEmail() *models.Email {
 		email.Subject = decodedSubject
 	}
 
+	// Keep the header fields for searching
+	email.Headers = make(map[string][]string, len(msg.Header))
+	for name, values := range msg.Header {
+		for _, value := range values {
+			if decoded, err := dec.DecodeHeader(value); err == nil {
+				value = decoded
+			}
+			email.Headers[name] = append(email.Headers[name], value)
+		}
+	}
+
 	// Parse body
+	header := textproto.MIMEHeader(msg.Header)
 	contentType := msg.Header.Get("Content-Type")
-	if contentType == "" {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil && contentType != "" {
+		addWarning(email, "invalid_content_type", "", "Content-Type header cannot be parsed: %v", err)
+	}
+	if contentType == "" || err != nil {
 		// Plain text email
-		body, _ := io.ReadAll(msg.Body)
-		email.Body = string(body)
-		return email
+		content, err := sess.readContent(msg.Body)
+		if err != nil {
+			return err
+		}
+		if content.blob != "" {
+			email.Attachments = append(email.Attachments, newAttachment(header, "text/plain", "", content))
+		} else {
+			email.Body = string(content.data)
+		}
+		return nil
 	}
 
-	mediaType, params, err := mime.ParseMediaType(contentType)
-	if err != nil {
-		body, _ := io.ReadAll(msg.Body)
-		email.Body = string(body)
-		return email
+	if strings.HasPrefix(mediaType, "multipart/") {
+		sess.parseMultipart(msg.Body, params["boundary"], "", email)
+		return nil
 	}
 
-	if strings.HasPrefix(mediaType, "multipart/") {
-		sess.parseMultipart(msg.Body, params["boundary"], email)
+	// Single part message
+	body := newPartReader(msg.Body, msg.Header.Get("Content-Transfer-Encoding"))
+	content, err := sess.readContent(body)
+	if err != nil {
+		return err
+	}
+	body.report(email, "1")
+	if content.blob != "" {
+		// Too large to be shown as a body, keep it as an attachment
+		_, dispParams, _ := mime.ParseMediaType(msg.Header.Get("Content-Disposition"))
+		email.Attachments = append(email.Attachments, newAttachment(header, mediaType, partFilename(dispParams, params), content))
+		return nil
+	}
+	decoded := string(content.data)
+
+	if isTNEFType(mediaType, "") {
+		sess.parseTNEF(content.data, "winmail.dat", email)
+	} else if strings.HasPrefix(mediaType, "text/html") {
+		email.HTML = decoded
+		email.Body = decoded
+	} else if isCalendarType(mediaType) {
+		email.Calendar = append(email.Calendar, parseCalendar(decoded, params["method"])...)
+		email.Body = decoded
 	} else {
-		// Single part message
-		body, _ := io.ReadAll(msg.Body)
-		decoded := sess.decodeContent(string(body), msg.Header.Get("Content-Transfer-Encoding"))
-
-		if strings.HasPrefix(mediaType, "text/html") {
-			email.HTML = decoded
-			email.Body = decoded
-		} else {
-			email.Body = decoded
-		}
+		email.Body = decoded
 	}
 
-	return email
+	return nil
 }
 
-func (sess *session) parseMultipart(body io.Reader, boundary string, email *models.Email) {
+// parseMultipart reads the parts of a multipart body; path identifies the
+// multipart within the message for warnings
+func (sess *session) parseMultipart(body io.Reader, boundary, path string, email *models.Email) {
+	if boundary == "" {
+		addWarning(email, "missing_boundary", path, "Multipart content has no boundary parameter")
+		return
+	}
+
 	mr := multipart.NewReader(body, boundary)
 
-	for {
+	for n := 1; ; n++ {
 		part, err := mr.NextPart()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			log.Printf("Error reading multipart: %v", err)
+			if n == 1 {
+				addWarning(email, "malformed_multipart", path, "No part delimited by boundary %q was found: %v", boundary, err)
+			} else {
+				addWarning(email, "unterminated_multipart", path, "Multipart content ends without its closing boundary")
+			}
 			break
 		}
+		childPath := partPath(path, n)
 
 		contentType := part.Header.Get("Content-Type")
-		mediaType, _, _ := mime.ParseMediaType(contentType)
+		mediaType, partParams, _ := mime.ParseMediaType(contentType)
 		transferEncoding := part.Header.Get("Content-Transfer-Encoding")
 
-		partBody, _ := io.ReadAll(part)
-		decoded := sess.decodeContent(string(partBody), transferEncoding)
+		disposition, dispParams, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+		filename := partFilename(dispParams, partParams)
 
-		if strings.HasPrefix(mediaType, "text/plain") {
+		if strings.HasPrefix(mediaType, "multipart/") {
+			// Nested multipart
+			sess.parseMultipart(part, partParams["boundary"], childPath, email)
+			continue
+		}
+
+		reader := newPartReader(part, transferEncoding)
+		content, err := sess.readContent(reader)
+		if err != nil {
+			log.Printf("Error reading part: %v", err)
+			continue
+		}
+		reader.report(email, childPath)
+		decoded := string(content.data)
+
+		if content.blob != "" {
+			// Too large to be shown as a body, keep it as an attachment
+			email.Attachments = append(email.Attachments, newAttachment(part.Header, mediaType, filename, content))
+		} else if isTNEFType(mediaType, filename) {
+			sess.parseTNEF(content.data, filename, email)
+		} else if disposition == "attachment" {
+			if isCalendarType(mediaType) {
+				email.Calendar = append(email.Calendar, parseCalendar(decoded, partParams["method"])...)
+			}
+			email.Attachments = append(email.Attachments, newAttachment(part.Header, mediaType, filename, content))
+		} else if strings.HasPrefix(mediaType, "text/plain") || mediaType == "" {
 			if email.Body == "" {
 				email.Body = decoded
 			}
 		} else if strings.HasPrefix(mediaType, "text/html") {
 			email.HTML = decoded
-		} else if strings.HasPrefix(mediaType, "multipart/") {
-			// Nested multipart
-			_, params, _ := mime.ParseMediaType(contentType)
-			sess.parseMultipart(bytes.NewReader(partBody), params["boundary"], email)
+		} else if isCalendarType(mediaType) {
+			email.Calendar = append(email.Calendar, parseCalendar(decoded, partParams["method"])...)
+		} else {
+			// Inline parts that are not text, e.g. images referenced by
+			// Content-ID from the HTML body
+			email.Attachments = append(email.Attachments, newAttachment(part.Header, mediaType, filename, content))
 		}
 	}
 }
 
-func (sess *session) decodeContent(content string, encoding string) string {
-	encoding = strings.ToLower(strings.TrimSpace(encoding))
+// partContent is the decoded content of a MIME part. Content over the
+// inline limit is kept in the blob store instead of data.
+type partContent struct {
+	data []byte
+	blob string
+	size int64
+}
 
-	switch encoding {
-	case "base64":
-		decoded, err := base64.StdEncoding.DecodeString(content)
-		if err != nil {
-			log.Printf("Error decoding base64: %v", err)
-			return content
+// readContent reads a part up to the inline limit and streams anything
+// larger to the blob store
+func (sess *session) readContent(r io.Reader) (*partContent, error) {
+	limit := sess.server.inlineLimit()
+
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) <= limit {
+		return &partContent{data: data, size: int64(len(data))}, nil
+	}
+
+	key, size, err := sess.server.opts.Blobs.Put(io.MultiReader(bytes.NewReader(data), r))
+	if err != nil {
+		return nil, err
+	}
+	return &partContent{blob: key, size: size}, nil
+}
+
+// storeContent wraps content that is already in memory, moving it to the
+// blob store if it is over the inline limit
+func (sess *session) storeContent(data []byte) *partContent {
+	content := &partContent{data: data, size: int64(len(data))}
+	if content.size <= sess.server.inlineLimit() {
+		return content
+	}
+
+	key, _, err := sess.server.opts.Blobs.Put(bytes.NewReader(data))
+	if err != nil {
+		log.Printf("Error storing attachment: %v", err)
+		return content
+	}
+	return &partContent{blob: key, size: content.size}
+}
+
+// newAttachment builds an attachment from a decoded MIME part
+func newAttachment(header textproto.MIMEHeader, mediaType, filename string, content *partContent) *models.Attachment {
+	if mediaType == "" {
+		mediaType = "application/octet-stream"
+	}
+
+	return &models.Attachment{
+		Filename:    filename,
+		ContentType: mediaType,
+		ContentID:   strings.Trim(header.Get("Content-ID"), "<> "),
+		Size:        content.size,
+		Content:     content.data,
+		Blob:        content.blob,
+	}
+}
+
+// partFilename returns the decoded file name of a part, preferring the
+// Content-Disposition filename over the Content-Type name
+func partFilename(dispParams, typeParams map[string]string) string {
+	filename := dispParams["filename"]
+	if filename == "" {
+		filename = typeParams["name"]
+	}
+
+	dec := new(mime.WordDecoder)
+	if decoded, err := dec.DecodeHeader(filename); err == nil {
+		filename = decoded
+	}
+	return filename
+}
+
+// isTNEFType reports whether a part is an Outlook winmail.dat container
+func isTNEFType(mediaType, filename string) bool {
+	return mediaType == "application/ms-tnef" ||
+		mediaType == "application/vnd.ms-tnef" ||
+		strings.EqualFold(filename, "winmail.dat")
+}
+
+// parseTNEF unpacks a winmail.dat container into the email's body and
+// attachments. If the container cannot be decoded it is kept as a regular
+// attachment.
+func (sess *session) parseTNEF(data []byte, filename string, email *models.Email) {
+	if filename == "" {
+		filename = "winmail.dat"
+	}
+
+	msg, err := tnef.Decode(data)
+	if err != nil {
+		log.Printf("Error decoding TNEF attachment: %v", err)
+		if msg == nil {
+			header := textproto.MIMEHeader{}
+			email.Attachments = append(email.Attachments, newAttachment(header, "application/ms-tnef", filename, sess.storeContent(data)))
+			return
 		}
-		return string(decoded)
-	case "quoted-printable":
-		reader := quotedprintable.NewReader(strings.NewReader(content))
-		decoded, err := io.ReadAll(reader)
-		if err != nil {
-			log.Printf("Error decoding quoted-printable: %v", err)
-			return content
+	}
+
+	if email.Body == "" {
+		email.Body = msg.Body
+	}
+	if email.HTML == "" {
+		email.HTML = msg.HTML
+	}
+
+	for _, attachment := range msg.Attachments {
+		contentType := attachment.MIMEType
+		if contentType == "" {
+			contentType = mime.TypeByExtension(filepath.Ext(attachment.Filename))
+		}
+
+		extracted := newAttachment(textproto.MIMEHeader{}, contentType, attachment.Filename, sess.storeContent(attachment.Data))
+		extracted.ContentID = attachment.ContentID
+		extracted.Source = filename
+		email.Attachments = append(email.Attachments, extracted)
+	}
+
+	// The rich text body has no direct MIME equivalent, so it is exposed
+	// as an attachment
+	if len(msg.RTF) > 0 {
+		rtf := newAttachment(textproto.MIMEHeader{}, "application/rtf", "body.rtf", sess.storeContent(msg.RTF))
+		rtf.Source = filename
+		email.Attachments = append(email.Attachments, rtf)
+	}
+}
+
+// isCalendarType reports whether a part carries an iCalendar document
+func isCalendarType(mediaType string) bool {
+	return mediaType == "text/calendar" || mediaType == "application/ics"
+}
+
+// partReader undoes the Content-Transfer-Encoding of a part while it is
+// read. Decoding errors end the part, keeping the content decoded so far
+// instead of dropping it; they are reported as warnings afterwards.
+type partReader struct {
+	encoding  string
+	decoded   io.Reader
+	sourceErr error
+	decodeErr error
+	unknown   bool
+}
+
+func newPartReader(r io.Reader, encoding string) *partReader {
+	pr := &partReader{encoding: strings.ToLower(strings.TrimSpace(encoding))}
+
+	// Errors of the underlying part, e.g. a truncated multipart, are told
+	// apart from errors in the encoded content
+	source := readerFunc(func(p []byte) (int, error) {
+		n, err := r.Read(p)
+		if err != nil && err != io.EOF {
+			pr.sourceErr = err
 		}
-		return string(decoded)
+		return n, err
+	})
+
+	switch pr.encoding {
+	case "base64":
+		pr.decoded = base64.NewDecoder(base64.StdEncoding, source)
+	case "quoted-printable":
+		pr.decoded = quotedprintable.NewReader(source)
+	case "", "7bit", "8bit", "binary":
+		pr.decoded = source
 	default:
-		return content
+		pr.unknown = true
+		pr.decoded = source
 	}
+	return pr
+}
+
+func (pr *partReader) Read(p []byte) (int, error) {
+	n, err := pr.decoded.Read(p)
+	if err != nil && err != io.EOF {
+		if pr.sourceErr == nil {
+			pr.decodeErr = err
+		}
+		err = io.EOF
+	}
+	return n, err
+}
+
+// report records the problems found while reading the part. A truncated
+// multipart is reported by the multipart reader itself.
+func (pr *partReader) report(email *models.Email, part string) {
+	if pr.unknown {
+		addWarning(email, "invalid_encoding", part, "Unknown Content-Transfer-Encoding %q", pr.encoding)
+	}
+	if pr.decodeErr != nil {
+		addWarning(email, "invalid_encoding", part, "Invalid %s content: %v", pr.encoding, pr.decodeErr)
+	}
+	if pr.sourceErr != nil && pr.sourceErr != io.ErrUnexpectedEOF {
+		addWarning(email, "invalid_encoding", part, "Part cannot be read: %v", pr.sourceErr)
+	}
+}
+
+type readerFunc func([]byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
 }
 
 func (sess *session) reset() {
 	sess.from = ""
 	sess.to = make([]string, 0)
-	sess.data = ""
 }
\ No newline at end of file
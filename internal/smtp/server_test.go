@@ -0,0 +1,143 @@
+package smtp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"mailcatch/internal/blob"
+	"mailcatch/internal/models"
+)
+
+// testSession returns a session reading the client's side of DATA from
+// input with the smallest bufio buffer, so lines span several ReadSlice
+// chunks, and collecting the replies in output
+func testSession(t *testing.T, opts Options, input string) (*session, *bytes.Buffer) {
+	t.Helper()
+	output := &bytes.Buffer{}
+	return &session{
+		server: &Server{opts: opts},
+		reader: bufio.NewReaderSize(strings.NewReader(input), 16),
+		writer: bufio.NewWriter(output),
+		from:   "a@example.com",
+		to:     []string{"b@example.com"},
+	}, output
+}
+
+// blobFiles returns the names of the files in a blob directory
+func blobFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestReceiveLargeMessage(t *testing.T) {
+	dir := t.TempDir()
+	blobs, err := blob.NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The buffer holds 16 bytes: "0123456789abcdef" fills it, so the
+	// dots after it start a chunk but not a line
+	data := "Subject: large\r\n" +
+		"\r\n" +
+		"..stuffed line\r\n" +
+		"0123456789abcdef.not stuffed\r\n" +
+		"0123456789abcdef.\r\n" +
+		"0123456789abcdef..\r\n" +
+		"..\r\n" +
+		strings.Repeat("padding to go over the inline limit\r\n", 4) +
+		".\r\n"
+	want := "Subject: large\r\n" +
+		"\r\n" +
+		".stuffed line\r\n" +
+		"0123456789abcdef.not stuffed\r\n" +
+		"0123456789abcdef.\r\n" +
+		"0123456789abcdef..\r\n" +
+		".\r\n" +
+		strings.Repeat("padding to go over the inline limit\r\n", 4)
+
+	sess, output := testSession(t, Options{Blobs: blobs, InlineLimit: 64}, data)
+	var received *models.Email
+	sess.handleData(func(email *models.Email) { received = email })
+
+	if !strings.Contains(output.String(), "250 OK") {
+		t.Fatalf("replies %q", output)
+	}
+	if received == nil {
+		t.Fatal("no email received")
+	}
+	if received.Raw != nil || received.RawBlob == "" {
+		t.Fatalf("raw kept inline (%d bytes), blob %q", len(received.Raw), received.RawBlob)
+	}
+	if received.Size != int64(len(want)) {
+		t.Errorf("size %d, want %d", received.Size, len(want))
+	}
+
+	r, err := blobs.Open(received.RawBlob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != want {
+		t.Errorf("raw message\n%q\nwant\n%q", raw, want)
+	}
+
+	// The body is over the inline limit as well, so it is kept as an
+	// attachment; nothing else is left in the blob directory
+	if len(received.Attachments) != 1 || received.Attachments[0].Blob == "" {
+		t.Fatalf("attachments %+v, want the body in a blob", received.Attachments)
+	}
+	files := blobFiles(t, dir)
+	sort.Strings(files)
+	keys := []string{received.RawBlob, received.Attachments[0].Blob}
+	sort.Strings(keys)
+	if !reflect.DeepEqual(files, keys) {
+		t.Errorf("blob files %v, want %v", files, keys)
+	}
+}
+
+func TestReceiveOverMaxMessageSize(t *testing.T) {
+	dir := t.TempDir()
+	blobs, err := blob.NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := "Subject: too large\r\n\r\n" + strings.Repeat("more than a hundred bytes\r\n", 8) + ".\r\nQUIT\r\n"
+	sess, output := testSession(t, Options{Blobs: blobs, InlineLimit: 64, MaxMessageSize: 100}, data)
+	received := false
+	sess.handleData(func(email *models.Email) { received = true })
+
+	if !strings.Contains(output.String(), "552 ") {
+		t.Errorf("replies %q, want 552", output)
+	}
+	if received {
+		t.Error("email over the size limit was received")
+	}
+	if files := blobFiles(t, dir); len(files) != 0 {
+		t.Errorf("files left in the blob directory: %v", files)
+	}
+
+	// The whole message was read, so the session continues after it
+	if line, err := sess.readLine(); err != nil || line != "QUIT\r\n" {
+		t.Errorf("next line %q (%v), want QUIT", line, err)
+	}
+}
@@ -0,0 +1,117 @@
+package smtp
+
+import (
+	"fmt"
+	"net/mail"
+
+	"mailcatch/internal/models"
+)
+
+// maxLineLength is the line length limit of RFC 5322 section 2.1.1,
+// excluding the CRLF
+const maxLineLength = 998
+
+// addWarning records a compliance problem on the email
+func addWarning(email *models.Email, code, part, format string, args ...interface{}) {
+	email.Warnings = append(email.Warnings, &models.ParseWarning{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+		Part:    part,
+	})
+}
+
+// lineChecker tracks line level problems while DATA is received, without
+// holding the lines themselves
+type lineChecker struct {
+	line   int
+	length int
+	// cr is set when the last chunk ended in the CR of a CRLF the chunks
+	// split
+	cr      bool
+	bareLF  lineProblem
+	tooLong lineProblem
+}
+
+// lineProblem counts the lines with a problem and remembers the first one
+type lineProblem struct {
+	first int
+	count int
+}
+
+func (p *lineProblem) add(line int) {
+	if p.count == 0 {
+		p.first = line
+	}
+	p.count++
+}
+
+// write inspects a chunk of the message; complete reports whether the
+// chunk ends a line
+func (c *lineChecker) write(chunk []byte, complete bool) {
+	c.length += len(chunk)
+	if !complete {
+		c.cr = len(chunk) > 0 && chunk[len(chunk)-1] == '\r'
+		return
+	}
+
+	c.line++
+	length := c.length - 1
+	if len(chunk) >= 2 && chunk[len(chunk)-2] == '\r' || len(chunk) == 1 && c.cr {
+		length--
+	} else {
+		c.bareLF.add(c.line)
+	}
+	if length > maxLineLength {
+		c.tooLong.add(c.line)
+	}
+	c.length = 0
+	c.cr = false
+}
+
+// warnings returns the problems found in the message
+func (c *lineChecker) warnings() []*models.ParseWarning {
+	var warnings []*models.ParseWarning
+	if c.bareLF.count > 0 {
+		warnings = append(warnings, &models.ParseWarning{
+			Code:    "bare_lf",
+			Message: fmt.Sprintf("%d line(s) end with a bare LF instead of CRLF", c.bareLF.count),
+			Line:    c.bareLF.first,
+		})
+	}
+	if c.tooLong.count > 0 {
+		warnings = append(warnings, &models.ParseWarning{
+			Code:    "line_too_long",
+			Message: fmt.Sprintf("%d line(s) exceed %d characters", c.tooLong.count, maxLineLength),
+			Line:    c.tooLong.first,
+		})
+	}
+	return warnings
+}
+
+// checkHeader flags missing or malformed header fields required by
+// RFC 5322 section 3.6
+func checkHeader(header mail.Header, email *models.Email) {
+	if header.Get("Date") == "" {
+		addWarning(email, "missing_date", "", "Date header is missing")
+	} else if _, err := header.Date(); err != nil {
+		addWarning(email, "invalid_date", "", "Date header cannot be parsed: %v", err)
+	}
+
+	if header.Get("From") == "" {
+		addWarning(email, "missing_from", "", "From header is missing")
+	} else if _, err := header.AddressList("From"); err != nil {
+		addWarning(email, "invalid_address", "", "From header cannot be parsed: %v", err)
+	}
+
+	if header.Get("Message-ID") == "" {
+		addWarning(email, "missing_message_id", "", "Message-ID header is missing")
+	}
+}
+
+// partPath returns the path of the n-th child (1-based) of a MIME part
+func partPath(parent string, n int) string {
+	if parent == "" {
+		return fmt.Sprint(n)
+	}
+	return fmt.Sprintf("%s.%d", parent, n)
+}
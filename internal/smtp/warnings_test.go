@@ -0,0 +1,107 @@
+package smtp
+
+import (
+	"bufio"
+	"net/mail"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mailcatch/internal/models"
+)
+
+// checkLines runs a message through a lineChecker in the chunks ReadSlice
+// returns from the smallest bufio buffer. Chunks start at the start of a
+// line, so a line whose length is 15 modulo 16 ends its chunk with the CR
+// and leaves the LF for the next one.
+func checkLines(message string) []*models.ParseWarning {
+	lines := &lineChecker{}
+	reader := bufio.NewReaderSize(strings.NewReader(message), 16)
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if len(chunk) > 0 {
+			lines.write(chunk, err == nil)
+		}
+		if err != nil && err != bufio.ErrBufferFull {
+			return lines.warnings()
+		}
+	}
+}
+
+func TestLineChecker(t *testing.T) {
+	x := func(n int) string { return strings.Repeat("x", n) }
+	tests := []struct {
+		name    string
+		message string
+		want    []models.ParseWarning
+	}{
+		{"compliant", "Subject: ok\r\n\r\n" + x(998) + "\r\n", nil},
+		{"CRLF across chunks", "Subject: ok\r\n\r\n" + x(15) + "\r\n" + x(991) + "\r\n", nil},
+		{"bare LF", "Subject: lf\r\n\r\none\ntwo\r\nthree\n", []models.ParseWarning{
+			{Code: "bare_lf", Message: "2 line(s) end with a bare LF instead of CRLF", Line: 3},
+		}},
+		{"too long", "Subject: long\r\n\r\n" + x(999) + "\r\nshort\r\n" + x(2000) + "\r\n", []models.ParseWarning{
+			{Code: "line_too_long", Message: "2 line(s) exceed 998 characters", Line: 3},
+		}},
+		{"too long with CRLF across chunks", "Subject: long\r\n\r\n" + x(1007) + "\r\n", []models.ParseWarning{
+			{Code: "line_too_long", Message: "1 line(s) exceed 998 characters", Line: 3},
+		}},
+		{"too long with a bare LF", "Subject: long\r\n\r\nok\r\n" + x(999) + "\n", []models.ParseWarning{
+			{Code: "bare_lf", Message: "1 line(s) end with a bare LF instead of CRLF", Line: 4},
+			{Code: "line_too_long", Message: "1 line(s) exceed 998 characters", Line: 4},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []models.ParseWarning
+			for _, warning := range checkLines(tt.message) {
+				got = append(got, *warning)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("warnings %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckHeader(t *testing.T) {
+	const (
+		date = "Date: Mon, 01 Jan 2024 12:00:00 +0000\r\n"
+		from = "From: Alice <alice@example.com>\r\n"
+		id   = "Message-ID: <1@example.com>\r\n"
+	)
+	tests := []struct {
+		name   string
+		header string
+		want   []string
+	}{
+		{"complete", date + from + id, nil},
+		{"missing date", from + id, []string{"missing_date"}},
+		{"invalid date", "Date: yesterday\r\n" + from + id, []string{"invalid_date"}},
+		{"missing from", date + id, []string{"missing_from"}},
+		{"invalid from", date + "From: Alice <alice@>\r\n" + id, []string{"invalid_address"}},
+		{"missing message ID", date + from, []string{"missing_message_id"}},
+		{"empty", "Subject: nothing else\r\n", []string{"missing_date", "missing_from", "missing_message_id"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := mail.ReadMessage(strings.NewReader(tt.header + "\r\nbody\r\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			email := &models.Email{}
+			checkHeader(msg.Header, email)
+
+			var got []string
+			for _, warning := range email.Warnings {
+				got = append(got, warning.Code)
+				if warning.Message == "" || warning.Part != "" {
+					t.Errorf("%s: message %q part %q", warning.Code, warning.Message, warning.Part)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("warnings %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package spam
+
+import (
+	"net"
+	"net/mail"
+	"net/url"
+	"strings"
+	"unicode"
+
+	"mailcatch/internal/links"
+	"mailcatch/internal/models"
+	"golang.org/x/net/html"
+)
+
+// rule is a built-in test; names and scores follow SpamAssassin where it
+// has an equivalent rule
+type rule struct {
+	name        string
+	score       float64
+	description string
+	test        func(m *message) bool
+}
+
+// message is what the rules inspect
+type message struct {
+	email  *models.Email
+	header mail.Header
+	links  []*links.Link
+	// htmlText is the visible text of the HTML body
+	htmlText string
+	images   int
+}
+
+var shorteners = map[string]bool{
+	"bit.ly": true, "bitly.com": true, "tinyurl.com": true, "goo.gl": true,
+	"t.co": true, "ow.ly": true, "is.gd": true, "buff.ly": true,
+	"rebrand.ly": true, "cutt.ly": true, "shorturl.at": true, "tiny.cc": true,
+}
+
+var spamPhrases = []string{
+	"act now", "100% free", "click here", "limited time", "risk-free",
+	"winner", "no credit check", "earn money", "cash bonus", "order now",
+}
+
+var rules = []*rule{
+	{"MISSING_DATE", 1.4, "Missing Date: header", func(m *message) bool {
+		return m.header != nil && m.header.Get("Date") == ""
+	}},
+	{"MISSING_MID", 0.5, "Missing Message-Id: header", func(m *message) bool {
+		return m.header != nil && m.header.Get("Message-ID") == ""
+	}},
+	{"MISSING_FROM", 1.0, "Missing From: header", func(m *message) bool {
+		return m.header != nil && m.header.Get("From") == ""
+	}},
+	{"MISSING_SUBJECT", 1.8, "Missing Subject: header", func(m *message) bool {
+		return m.header != nil && m.header.Get("Subject") == ""
+	}},
+	{"MISSING_HEADERS", 1.0, "Missing To: header", func(m *message) bool {
+		return m.header != nil && m.header.Get("To") == "" && m.header.Get("Cc") == ""
+	}},
+	{"SUBJ_ALL_CAPS", 1.5, "Subject is all capitals", func(m *message) bool {
+		return allCaps(m.email.Subject)
+	}},
+	{"SUBJ_EXCLAIM", 0.5, "Subject has repeated exclamation marks", func(m *message) bool {
+		return strings.Contains(m.email.Subject, "!!")
+	}},
+	{"MIME_HTML_ONLY", 0.7, "Message only has text/html MIME parts", func(m *message) bool {
+		return m.email.HTML != "" && strings.TrimSpace(m.email.Body) == ""
+	}},
+	{"HTML_IMAGE_ONLY", 1.5, "HTML: images with little text", func(m *message) bool {
+		return m.images > 0 && len(m.htmlText) < 400
+	}},
+	{"HTML_TEXT_RATIO_LOW", 1.0, "HTML: visible text is under 10% of the markup", func(m *message) bool {
+		return len(m.email.HTML) > 2000 && len(m.htmlText)*10 < len(m.email.HTML)
+	}},
+	{"URI_SHORTENER", 1.0, "Message contains a URL shortener link", func(m *message) bool {
+		return anyLink(m, func(host string, _ *links.Link) bool {
+			return shorteners[strings.TrimPrefix(host, "www.")]
+		})
+	}},
+	{"NUMERIC_HTTP_ADDR", 1.2, "URI to a numeric IP address", func(m *message) bool {
+		return anyLink(m, func(host string, _ *links.Link) bool {
+			return net.ParseIP(host) != nil
+		})
+	}},
+	{"URI_TEXT_MISMATCH", 1.5, "Link text names a different domain than the link", func(m *message) bool {
+		return anyLink(m, func(_ string, link *links.Link) bool {
+			return link.DomainMismatch
+		})
+	}},
+	{"SPAM_PHRASES", 1.0, "Body contains common spam phrases", func(m *message) bool {
+		text := strings.ToLower(m.email.Body + " " + m.htmlText)
+		found := 0
+		for _, phrase := range spamPhrases {
+			if strings.Contains(text, phrase) {
+				found++
+			}
+		}
+		return found >= 2
+	}},
+}
+
+// evaluate runs the built-in rules; header is nil when the raw message
+// could not be parsed, in which case header rules are skipped
+func evaluate(email *models.Email, header mail.Header) []*models.SpamRule {
+	m := &message{
+		email:  email,
+		header: header,
+		links:  links.Extract(nil, email.Body, email.HTML),
+	}
+	m.htmlText, m.images = scanHTML(email.HTML)
+
+	var matched []*models.SpamRule
+	for _, r := range rules {
+		if r.test(m) {
+			matched = append(matched, &models.SpamRule{Name: r.name, Score: r.score, Description: r.description})
+		}
+	}
+	return matched
+}
+
+// scanHTML returns the visible text of an HTML body, with collapsed
+// whitespace, and the number of images
+func scanHTML(document string) (string, int) {
+	var text strings.Builder
+	images := 0
+	skip := 0
+
+	tokenizer := html.NewTokenizer(strings.NewReader(document))
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return strings.Join(strings.Fields(text.String()), " "), images
+		case html.TextToken:
+			if skip == 0 {
+				text.Write(tokenizer.Text())
+				text.WriteByte(' ')
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := tokenizer.TagName()
+			switch string(name) {
+			case "img":
+				images++
+			case "style", "script", "head", "title":
+				skip++
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			switch string(name) {
+			case "style", "script", "head", "title":
+				if skip > 0 {
+					skip--
+				}
+			}
+		}
+	}
+}
+
+// allCaps reports whether text has at least ten letters, all upper case
+func allCaps(text string) bool {
+	letters := 0
+	for _, r := range text {
+		if unicode.IsLower(r) {
+			return false
+		}
+		if unicode.IsUpper(r) {
+			letters++
+		}
+	}
+	return letters >= 10
+}
+
+func anyLink(m *message, test func(host string, link *links.Link) bool) bool {
+	for _, link := range m.links {
+		u, err := url.Parse(link.URL)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+		if test(strings.ToLower(u.Hostname()), link) {
+			return true
+		}
+	}
+	return false
+}
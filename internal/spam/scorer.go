@@ -0,0 +1,80 @@
+// Package spam scores captured emails with SpamAssassin style rules, either
+// built in or by delegating to a local spamd.
+package spam
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/mail"
+	"time"
+
+	"mailcatch/internal/models"
+)
+
+// DefaultThreshold is the score from which an email is considered spam,
+// the SpamAssassin default
+const DefaultThreshold = 5.0
+
+// Scorer rates captured emails
+type Scorer struct {
+	threshold float64
+	spamd     *spamdClient
+}
+
+func NewScorer(threshold float64) *Scorer {
+	return &Scorer{threshold: threshold}
+}
+
+// UseSpamd delegates scoring to the spamd listening on addr. The built-in
+// rules are still applied when spamd cannot be reached.
+func (s *Scorer) UseSpamd(addr string, timeout time.Duration) {
+	s.spamd = &spamdClient{addr: addr, timeout: timeout}
+}
+
+// Score rates the email read from raw and stores the report on it
+func (s *Scorer) Score(email *models.Email, raw io.Reader) {
+	var spamdErr error
+	if s.spamd != nil {
+		// spamd needs the length up front, so the message is buffered;
+		// spamc skips messages over the same limit
+		data, err := io.ReadAll(io.LimitReader(raw, maxSpamdSize+1))
+		switch {
+		case err != nil:
+			spamdErr = err
+		case len(data) > maxSpamdSize:
+			spamdErr = fmt.Errorf("message exceeds %d bytes", maxSpamdSize)
+		default:
+			report, err := s.spamd.check(data)
+			if err == nil {
+				email.Spam = report
+				return
+			}
+			spamdErr = err
+		}
+		raw = bytes.NewReader(data)
+	}
+
+	var header mail.Header
+	if msg, err := mail.ReadMessage(raw); err == nil {
+		header = msg.Header
+	}
+
+	report := &models.SpamReport{
+		Engine:    "builtin",
+		Threshold: s.threshold,
+		Rules:     evaluate(email, header),
+	}
+	for _, rule := range report.Rules {
+		report.Score += rule.Score
+	}
+	// Scores have one decimal, as in SpamAssassin reports
+	report.Score = math.Round(report.Score*10) / 10
+	report.IsSpam = report.Score >= s.threshold
+	if spamdErr != nil {
+		report.Error = fmt.Sprintf("spamd: %v", spamdErr)
+	}
+
+	email.Spam = report
+}
@@ -0,0 +1,156 @@
+package spam
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"mailcatch/internal/models"
+)
+
+// spammy matches the header, subject, link and phrase rules
+var spammy = &models.Email{
+	From:    "winner@example.com",
+	To:      "bob@example.com",
+	Subject: "FREE MONEY INSIDE!!",
+	Body:    "Act now, this is a limited time offer: http://bit.ly/3abc or http://192.0.2.7/claim\r\n",
+	HTML:    `<p>Act now! <a href="http://bit.ly/3abc">Claim here</a></p>`,
+}
+
+const spammyRaw = "From: winner@example.com\r\n" +
+	"To: bob@example.com\r\n" +
+	"Subject: FREE MONEY INSIDE!!\r\n" +
+	"\r\n" +
+	"Act now\r\n"
+
+func ruleNames(report *models.SpamReport) []string {
+	var names []string
+	for _, rule := range report.Rules {
+		names = append(names, rule.Name)
+	}
+	return names
+}
+
+func TestBuiltinRules(t *testing.T) {
+	email := *spammy
+	NewScorer(DefaultThreshold).Score(&email, strings.NewReader(spammyRaw))
+
+	report := email.Spam
+	want := []string{"MISSING_DATE", "MISSING_MID", "SUBJ_ALL_CAPS", "SUBJ_EXCLAIM", "URI_SHORTENER", "NUMERIC_HTTP_ADDR", "SPAM_PHRASES"}
+	if got := ruleNames(report); !reflect.DeepEqual(got, want) {
+		t.Errorf("rules %v, want %v", got, want)
+	}
+	if report.Engine != "builtin" || report.Score != 7.1 || !report.IsSpam || report.Error != "" {
+		t.Errorf("report %+v, want builtin spam scoring 7.1", report)
+	}
+
+	clean := &models.Email{From: "app@example.com", To: "bob@example.com", Subject: "Your receipt", Body: "Thanks for your order\r\n"}
+	raw := "Date: Mon, 01 Jan 2024 12:00:00 +0000\r\n" +
+		"Message-ID: <1@example.com>\r\n" +
+		"From: app@example.com\r\n" +
+		"To: bob@example.com\r\n" +
+		"Subject: Your receipt\r\n" +
+		"\r\n" +
+		"Thanks for your order\r\n"
+	NewScorer(DefaultThreshold).Score(clean, strings.NewReader(raw))
+	if clean.Spam.Score != 0 || clean.Spam.IsSpam || len(clean.Spam.Rules) != 0 {
+		t.Errorf("clean email report %+v, rules %v", clean.Spam, ruleNames(clean.Spam))
+	}
+}
+
+// fakeSpamd accepts one connection on a local port and hands it to serve
+func fakeSpamd(t *testing.T, serve func(conn net.Conn)) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		serve(conn)
+	}()
+	return listener.Addr().String()
+}
+
+func TestSpamdReport(t *testing.T) {
+	addr := fakeSpamd(t, func(conn net.Conn) {
+		reader := textproto.NewReader(bufio.NewReader(conn))
+		if line, _ := reader.ReadLine(); line != "REPORT SPAMC/1.5" {
+			return
+		}
+		reader.ReadMIMEHeader()
+		body := "Content analysis details:   (6.2 points, 5.0 required)\r\n\r\n" +
+			" pts rule name              description\r\n" +
+			"---- ---------------------- --------------------------------------------------\r\n" +
+			" 1.4 MISSING_DATE           Missing Date: header\r\n" +
+			" 4.8 URIBL_BLACK            Contains an URL listed in the URIBL blocklist\r\n" +
+			"                            [URIs: bit.ly]\r\n"
+		fmt.Fprintf(conn, "SPAMD/1.1 0 EX_OK\r\nContent-length: %d\r\nSpam: True ; 6.2 / 5.0\r\n\r\n%s", len(body), body)
+	})
+
+	scorer := NewScorer(DefaultThreshold)
+	scorer.UseSpamd(addr, 5*time.Second)
+	email := *spammy
+	scorer.Score(&email, strings.NewReader(spammyRaw))
+
+	report := email.Spam
+	if report.Engine != "spamd" || report.Score != 6.2 || report.Threshold != 5.0 || !report.IsSpam {
+		t.Errorf("report %+v, want spamd spam scoring 6.2 of 5.0", report)
+	}
+	if got, want := ruleNames(report), []string{"MISSING_DATE", "URIBL_BLACK"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("rules %v, want %v", got, want)
+	}
+	if desc := report.Rules[1].Description; desc != "Contains an URL listed in the URIBL blocklist [URIs: bit.ly]" {
+		t.Errorf("continued description %q", desc)
+	}
+}
+
+// A spamd that does not answer in time, or cannot be reached, leaves the
+// scoring to the built-in rules
+func TestSpamdFallback(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	silent := fakeSpamd(t, func(conn net.Conn) {
+		// Read the request and hold the connection open without answering
+		bufio.NewReader(conn).ReadString(0)
+		<-done
+	})
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachable := closed.Addr().String()
+	closed.Close()
+
+	for _, addr := range []string{silent, unreachable} {
+		timeout := addr == silent
+		scorer := NewScorer(DefaultThreshold)
+		scorer.UseSpamd(addr, 200*time.Millisecond)
+		email := *spammy
+
+		start := time.Now()
+		scorer.Score(&email, strings.NewReader(spammyRaw))
+		elapsed := time.Since(start)
+		if elapsed > 2*time.Second || timeout && elapsed < 200*time.Millisecond {
+			t.Errorf("%s: scoring took %v with a 200ms timeout", addr, elapsed)
+		}
+
+		report := email.Spam
+		if report.Engine != "builtin" || report.Score != 7.1 || !strings.HasPrefix(report.Error, "spamd: ") {
+			t.Errorf("%s: report %+v, want the built-in score with the spamd error", addr, report)
+		}
+		if timeout && !strings.Contains(report.Error, "timeout") {
+			t.Errorf("%s: error %q, want a timeout", addr, report.Error)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package spam
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"mailcatch/internal/models"
+)
+
+// maxSpamdSize is the largest message sent to spamd, the spamc default
+const maxSpamdSize = 500 * 1024
+
+var (
+	// Spam: True ; 15.2 / 5.0
+	spamHeaderPattern = regexp.MustCompile(`^(True|False|Yes|No)\s*;\s*(-?[0-9.]+)\s*/\s*(-?[0-9.]+)`)
+	// " 1.4 MISSING_DATE           Missing Date: header"
+	reportLinePattern = regexp.MustCompile(`^\s*(-?[0-9]+\.[0-9]+)\s+([A-Za-z0-9_]+)\s+(.*)$`)
+)
+
+// spamdClient speaks the spamc protocol to a spamd over TCP
+type spamdClient struct {
+	addr    string
+	timeout time.Duration
+}
+
+// check sends a REPORT request and parses the score and matched rules
+func (c *spamdClient) check(message []byte) (*models.SpamReport, error) {
+	// The timeout bounds the whole exchange, connecting included
+	deadline := time.Now().Add(c.timeout)
+	dialer := net.Dialer{Deadline: deadline}
+	conn, err := dialer.Dial("tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(deadline)
+
+	if _, err := fmt.Fprintf(conn, "REPORT SPAMC/1.5\r\nContent-length: %d\r\n\r\n", len(message)); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(message); err != nil {
+		return nil, err
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.CloseWrite()
+	}
+
+	reader := textproto.NewReader(bufio.NewReader(conn))
+	status, err := reader.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	// SPAMD/1.1 0 EX_OK
+	fields := strings.Fields(status)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "SPAMD/") {
+		return nil, fmt.Errorf("unexpected response %q", status)
+	}
+	if fields[1] != "0" {
+		return nil, fmt.Errorf("spamd error: %s", strings.Join(fields[1:], " "))
+	}
+
+	header, err := reader.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	match := spamHeaderPattern.FindStringSubmatch(header.Get("Spam"))
+	if match == nil {
+		return nil, fmt.Errorf("missing Spam header in response")
+	}
+
+	report := &models.SpamReport{
+		Engine: "spamd",
+		IsSpam: match[1] == "True" || match[1] == "Yes",
+	}
+	report.Score, _ = strconv.ParseFloat(match[2], 64)
+	report.Threshold, _ = strconv.ParseFloat(match[3], 64)
+
+	body, err := io.ReadAll(reader.R)
+	if err != nil {
+		return nil, err
+	}
+	report.Rules = parseReport(string(body))
+	return report, nil
+}
+
+// parseReport reads the rule table of a spamd report. Long descriptions
+// continue on indented lines without a score.
+func parseReport(text string) []*models.SpamRule {
+	var rules []*models.SpamRule
+	inTable := false
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.HasPrefix(line, "----") {
+			inTable = true
+			continue
+		}
+		if !inTable || strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if match := reportLinePattern.FindStringSubmatch(line); match != nil {
+			score, _ := strconv.ParseFloat(match[1], 64)
+			rules = append(rules, &models.SpamRule{
+				Name:        match[2],
+				Score:       score,
+				Description: strings.TrimSpace(match[3]),
+			})
+		} else if len(rules) > 0 {
+			last := rules[len(rules)-1]
+			last.Description += " " + strings.TrimSpace(line)
+		}
+	}
+	return rules
+}
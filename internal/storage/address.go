@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"net/mail"
+	"strings"
+
+	"mailcatch/internal/models"
+)
+
+// From and To filters naming a whole address match that address among the
+// addresses of the field, so bob@example.com does not match
+// jimbob@example.com; other values match substrings of the field. A To
+// filter naming a whole address matches the recipients of the To and Cc
+// headers as well as those of the envelope.
+
+// filterAddress returns the lowercased address a From or To filter names,
+// and whether the filter is a whole address
+func filterAddress(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	parsed, err := mail.ParseAddress(value)
+	if err != nil || !strings.EqualFold(parsed.Address, value) {
+		return "", false
+	}
+	return strings.ToLower(parsed.Address), true
+}
+
+// fieldAddresses returns the distinct lowercased addresses of a sender or
+// recipient field. Fields that do not parse as an address list are split
+// on commas.
+func fieldAddresses(field string) []string {
+	var addresses []string
+	add := func(address string) {
+		address = strings.ToLower(address)
+		if address == "" {
+			return
+		}
+		for _, seen := range addresses {
+			if seen == address {
+				return
+			}
+		}
+		addresses = append(addresses, address)
+	}
+
+	if parsed, err := mail.ParseAddressList(field); err == nil {
+		for _, address := range parsed {
+			add(address.Address)
+		}
+		return addresses
+	}
+	for _, address := range strings.Split(field, ",") {
+		address = strings.TrimSpace(address)
+		if parsed, err := mail.ParseAddress(address); err == nil {
+			address = parsed.Address
+		}
+		add(address)
+	}
+	return addresses
+}
+
+// recipientAddresses returns the distinct lowercased recipients of an
+// email: those of the envelope, then those of the To and Cc headers
+func recipientAddresses(email *models.Email) []string {
+	fields := append([]string{email.To}, email.Headers["To"]...)
+	fields = append(fields, email.Headers["Cc"]...)
+
+	var addresses []string
+	seen := map[string]bool{}
+	for _, field := range fields {
+		for _, address := range fieldAddresses(field) {
+			if !seen[address] {
+				seen[address] = true
+				addresses = append(addresses, address)
+			}
+		}
+	}
+	return addresses
+}
+
+// matchRecipient reports whether an email passes a To filter
+func matchRecipient(email *models.Email, filter string) bool {
+	address, ok := filterAddress(filter)
+	if !ok {
+		return containsFold(email.To, filter)
+	}
+	for _, candidate := range recipientAddresses(email) {
+		if candidate == address {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAddress reports whether a sender field passes a From filter
+func matchAddress(field, filter string) bool {
+	address, ok := filterAddress(filter)
+	if !ok {
+		return containsFold(field, filter)
+	}
+	for _, candidate := range fieldAddresses(field) {
+		if candidate == address {
+			return true
+		}
+	}
+	return false
+}
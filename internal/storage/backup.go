@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"mailcatch/internal/models"
+)
+
+// ErrInUse is returned when another process holds a database that only
+// one process can use at a time
+var ErrInUse = errors.New("database is in use by another process")
+
+// ErrBackupUnsupported is returned when the storage cannot copy its
+// database while in use
+var ErrBackupUnsupported = errors.New("the storage backend does not support online backups")
+
+// Backup formats, written by Backup and read by the Restore of the
+// backends of the same format
+const (
+	BackupFormatSQLite = "sqlite"
+	BackupFormatBolt   = "bolt"
+	BackupFormatJSON   = "json"
+)
+
+// Backuper is implemented by storages that can copy their database while
+// it is in use
+type Backuper interface {
+	// Backup writes a consistent copy of the database to w
+	Backup(w io.Writer) (*BackupInfo, error)
+}
+
+// BackupInfo describes the copy written by Backup
+type BackupInfo struct {
+	Format string
+	Emails int
+	// Blobs are the blob store keys the copied emails reference
+	Blobs []string
+}
+
+// blobKeys appends the blob store keys an email references
+func blobKeys(keys []string, email *models.Email) []string {
+	if email.RawBlob != "" {
+		keys = append(keys, email.RawBlob)
+	}
+	for _, attachment := range email.Attachments {
+		if attachment.Blob != "" {
+			keys = append(keys, attachment.Blob)
+		}
+	}
+	return keys
+}
+
+// Databases without a lock of their own are locked through a file next to
+// them: processes using the database share the lock, and a restore takes
+// it exclusively, so it refuses to replace a database in use. Databases
+// kept in memory by the process using them are locked exclusively.
+
+// useLock takes the shared lock of the database at path
+func useLock(path string) (*os.File, error) {
+	lock, err := lockFile(path+".lock", false)
+	if err != nil {
+		return nil, fmt.Errorf("database is being restored: %w", err)
+	}
+	return lock, nil
+}
+
+// ownLock takes the exclusive lock of the database at path for a process
+// that keeps it in memory
+func ownLock(path string) (*os.File, error) {
+	lock, err := lockFile(path+".lock", true)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInUse, err)
+	}
+	return lock, nil
+}
+
+// restoreLock takes the exclusive lock of the database at path of the
+// named backend
+func restoreLock(backend, path string) (*os.File, error) {
+	lock, err := lockFile(path+".lock", true)
+	if err != nil {
+		return nil, fmt.Errorf("%s database is in use: %w", backend, err)
+	}
+	return lock, nil
+}
+
+// restoreFile replaces the file at path with the content of r, written to
+// a temporary file first so a failed restore leaves the file as it was
+func restoreFile(path string, r io.Reader) error {
+	tmp := path + ".restore"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -1,11 +1,13 @@
 package storage
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
-	"sort"
+	"reflect"
 	"strconv"
 	"time"
 
@@ -13,17 +15,73 @@ import (
 	"go.etcd.io/bbolt"
 )
 
+// BoltStorage keeps emails in a bbolt database. Records are keyed by the
+// big-endian email ID, and the list is served from small summary records
+// walked through indexes ordered by receive time, so a page costs about
+// as much as its size.
 type BoltStorage struct {
 	db *bbolt.DB
 }
 
 var (
-	emailsBucket = []byte("emails")
-	metaBucket   = []byte("meta")
-	nextIDKey    = []byte("next_id")
+	emailsBucket    = []byte("emails")    // ID -> email without the raw message
+	rawBucket       = []byte("raw")       // ID -> raw message
+	summariesBucket = []byte("summaries") // ID -> boltSummary
+	byTimeBucket    = []byte("by_time")   // position -> nothing
+	byFromBucket     = []byte("by_from")    // sender, NUL, position -> nothing
+	byToBucket       = []byte("by_to")      // recipient, NUL, position -> nothing
+	fromCountsBucket = []byte("from_count") // sender, NUL -> entries in by_from
+	toCountsBucket   = []byte("to_count")   // recipient, NUL -> entries in by_to
+	searchBucket     = []byte("search")     // word, NUL, ID -> nothing
+	metaBucket      = []byte("meta")
+	nextIDKey       = []byte("next_id")
+	countKey        = []byte("count")
+	versionKey      = []byte("schema_version")
+
+	// Deleted emails a snapshot holds are moved out of the indexed buckets
+	snapshotsBucket    = []byte("snapshots")     // name -> snapshotRecord
+	tombstonesBucket   = []byte("tombstones")    // ID -> email without the raw message
+	tombstoneRawBucket = []byte("tombstone_raw") // ID -> raw message
 )
 
+// dataBuckets hold the emails and their indexes
+var dataBuckets = [][]byte{emailsBucket, rawBucket, summariesBucket, byTimeBucket, byFromBucket, byToBucket, fromCountsBucket, toCountsBucket, searchBucket}
+
+func init() {
+	Register(&Backend{
+		Name:        "bolt",
+		Description: "BoltDB file next to the database path, no CGO required",
+		Options:     []string{"timeout", "no_sync"},
+		Open: func(path string, options Options) (Storage, error) {
+			timeout, err := options.Duration("timeout", 1*time.Second)
+			if err != nil {
+				return nil, err
+			}
+			noSync, err := options.Bool("no_sync", false)
+			if err != nil {
+				return nil, err
+			}
+			return openBolt(path, &bbolt.Options{Timeout: timeout, NoSync: noSync})
+		},
+		MigrationStatus: func(path string, options Options) (*MigrationStatus, error) {
+			return BoltMigrationStatus(path)
+		},
+		BackupFormat: BackupFormatBolt,
+		Restore: func(path string, options Options, r io.Reader) error {
+			return restoreBolt(path, r)
+		},
+	})
+}
+
 func NewBoltStorage(dbPath string) (*BoltStorage, error) {
+	return openBolt(dbPath, &bbolt.Options{
+		Timeout: 1 * time.Second,
+	})
+}
+
+// openBolt opens the database with the given bbolt options; the timeout
+// bounds the wait for the file lock held by another process
+func openBolt(dbPath string, options *bbolt.Options) (*BoltStorage, error) {
 	// Create directory if it doesn't exist
 	dir := filepath.Dir(dbPath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
@@ -33,45 +91,334 @@ func NewBoltStorage(dbPath string) (*BoltStorage, error) {
 	// Change extension to .bolt
 	boltPath := dbPath[:len(dbPath)-len(filepath.Ext(dbPath))] + ".bolt"
 
-	db, err := bbolt.Open(boltPath, 0644, &bbolt.Options{
-		Timeout: 1 * time.Second,
-	})
+	db, err := bbolt.Open(boltPath, 0644, options)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open bolt database: %w", err)
 	}
 
 	storage := &BoltStorage{db: db}
 
-	// Create buckets if they don't exist
-	err = db.Update(func(tx *bbolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(emailsBucket)
+	var current int
+	db.View(func(tx *bbolt.Tx) error {
+		current = boltSchemaVersion(tx)
+		return nil
+	})
+	err = runMigrations("BoltDB", current, boltMigrationList(), func(migration Migration) error {
+		return db.Update(func(tx *bbolt.Tx) error {
+			if err := boltMigrations[migration.Version-1].up(tx); err != nil {
+				return err
+			}
+			return tx.Bucket(metaBucket).Put(versionKey, []byte(strconv.Itoa(migration.Version)))
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	return storage, nil
+}
+
+// boltMigrations is the history of the bucket layout
+var boltMigrations = []struct {
+	Migration
+	up func(tx *bbolt.Tx) error
+}{
+	{Migration{1, "create the emails and meta buckets"}, func(tx *bbolt.Tx) error {
+		for _, bucket := range [][]byte{emailsBucket, rawBucket, metaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+
+		// Initialize next ID if it doesn't exist
+		meta := tx.Bucket(metaBucket)
+		if meta.Get(nextIDKey) == nil {
+			return meta.Put(nextIDKey, []byte("1"))
+		}
+		return nil
+	}},
+	{Migration{2, "index emails by time, sender and recipient"}, migrateStringKeys},
+	{Migration{3, "record message sizes in summaries"}, migrateSummarySizes},
+	{Migration{4, "add the snapshot and tombstone buckets"}, func(tx *bbolt.Tx) error {
+		for _, bucket := range [][]byte{snapshotsBucket, tombstonesBucket, tombstoneRawBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{Migration{5, "index the words of emails for full-text search"}, func(tx *bbolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(searchBucket); err != nil {
+			return err
+		}
+		return tx.Bucket(emailsBucket).ForEach(func(k, v []byte) error {
+			var email models.Email
+			if err := json.Unmarshal(v, &email); err != nil {
+				return nil // Skip corrupted entries
+			}
+			return putSearchWords(tx, &email)
+		})
+	}},
+	{Migration{6, "index senders and recipients by address with counts"}, migrateAddressIndexes},
+	{Migration{7, "index the recipients of the To and Cc headers"}, migrateHeaderRecipients},
+}
+
+func boltMigrationList() []Migration {
+	migrations := make([]Migration, len(boltMigrations))
+	for i, step := range boltMigrations {
+		migrations[i] = step.Migration
+	}
+	return migrations
+}
+
+// boltSchemaVersion reads the version recorded in the meta bucket. Before
+// versioning, databases with an emails bucket were at version 1, and the
+// indexed layout carried its own marker.
+func boltSchemaVersion(tx *bbolt.Tx) int {
+	meta := tx.Bucket(metaBucket)
+	if meta != nil {
+		if version, err := strconv.Atoi(string(meta.Get(versionKey))); err == nil {
+			return version
+		}
+		if string(meta.Get([]byte("layout"))) == "2" {
+			return 2
+		}
+	}
+	if tx.Bucket(emailsBucket) != nil {
+		return 1
+	}
+	return 0
+}
+
+// BoltMigrationStatus reports the migrations opening the database at
+// dbPath would apply, without modifying it
+func BoltMigrationStatus(dbPath string) (*MigrationStatus, error) {
+	boltPath := dbPath[:len(dbPath)-len(filepath.Ext(dbPath))] + ".bolt"
+
+	current := 0
+	if _, err := os.Stat(boltPath); err == nil {
+		db, err := bbolt.Open(boltPath, 0644, &bbolt.Options{Timeout: 1 * time.Second, ReadOnly: true})
 		if err != nil {
+			return nil, fmt.Errorf("failed to open bolt database: %w", err)
+		}
+		defer db.Close()
+		db.View(func(tx *bbolt.Tx) error {
+			current = boltSchemaVersion(tx)
+			return nil
+		})
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return newMigrationStatus("BoltDB", current, boltMigrationList())
+}
+
+// migrateStringKeys rewrites emails stored under decimal string keys, with
+// the raw message possibly embedded in the JSON record, in the indexed
+// layout
+func migrateStringKeys(tx *bbolt.Tx) error {
+	old := tx.Bucket(emailsBucket)
+	if old == nil {
+		return nil
+	}
+	oldRaw := tx.Bucket(rawBucket)
+
+	var emails []*models.Email
+	err := old.ForEach(func(k, v []byte) error {
+		id, err := strconv.Atoi(string(k))
+		if err != nil {
+			return nil // Skip keys of no known layout
+		}
+		email := &models.Email{}
+		if err := json.Unmarshal(v, email); err != nil {
+			return nil // Skip corrupted entries
+		}
+		email.ID = id
+
+		// Values are only valid during the transaction, so the raw
+		// message is copied
+		email.Raw = legacyRaw(v)
+		if oldRaw != nil {
+			if raw := oldRaw.Get(k); raw != nil {
+				email.Raw = append([]byte(nil), raw...)
+			}
+		}
+		emails = append(emails, email)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, bucket := range dataBuckets {
+		if tx.Bucket(bucket) == nil {
+			continue
+		}
+		if err := tx.DeleteBucket(bucket); err != nil {
 			return err
 		}
-		_, err = tx.CreateBucketIfNotExists(metaBucket)
+	}
+	for _, bucket := range dataBuckets {
+		if _, err := tx.CreateBucket(bucket); err != nil {
+			return err
+		}
+	}
+
+	for _, email := range emails {
+		if err := putEmail(tx, email); err != nil {
+			return err
+		}
+	}
+	return putCount(tx.Bucket(metaBucket), len(emails))
+}
+
+// migrateSummarySizes adds the message size to summaries written before
+// it was recorded
+func migrateSummarySizes(tx *bbolt.Tx) error {
+	summaries := tx.Bucket(summariesBucket)
+	if summaries == nil {
+		return nil
+	}
+
+	updates := make(map[string][]byte)
+	err := summaries.ForEach(func(k, v []byte) error {
+		var summary boltSummary
+		if err := json.Unmarshal(v, &summary); err != nil || summary.Size != 0 {
+			return nil
+		}
+
+		var email models.Email
+		if data := tx.Bucket(emailsBucket).Get(k); data != nil {
+			json.Unmarshal(data, &email)
+		}
+		summary.Size = email.Size
+		if summary.Size == 0 {
+			summary.Size = int64(len(tx.Bucket(rawBucket).Get(k)))
+		}
+
+		data, err := json.Marshal(&summary)
 		if err != nil {
 			return err
 		}
-		
-		// Initialize next ID if it doesn't exist
-		metaBucket := tx.Bucket(metaBucket)
-		if metaBucket.Get(nextIDKey) == nil {
-			return metaBucket.Put(nextIDKey, []byte("1"))
+		updates[string(k)] = data
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Buckets cannot be modified while iterating
+	for k, data := range updates {
+		if err := summaries.Put([]byte(k), data); err != nil {
+			return err
 		}
+	}
+	return nil
+}
+
+// migrateAddressIndexes rebuilds the sender and recipient indexes, keyed
+// by whole field values before, with an entry per address and their counts
+func migrateAddressIndexes(tx *bbolt.Tx) error {
+	for _, bucket := range [][]byte{byFromBucket, byToBucket, fromCountsBucket, toCountsBucket} {
+		if tx.Bucket(bucket) != nil {
+			if err := tx.DeleteBucket(bucket); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.CreateBucket(bucket); err != nil {
+			return err
+		}
+	}
+
+	return tx.Bucket(summariesBucket).ForEach(func(k, v []byte) error {
+		var summary boltSummary
+		if err := json.Unmarshal(v, &summary); err != nil {
+			return nil // Skip corrupted entries
+		}
+		return putIndexEntries(tx, &summary)
+	})
+}
+
+// migrateHeaderRecipients records the recipients of the To and Cc headers
+// in summaries and adds them to the recipient index
+func migrateHeaderRecipients(tx *bbolt.Tx) error {
+	summaries := tx.Bucket(summariesBucket)
+	updates := make(map[string][]byte)
+	err := summaries.ForEach(func(k, v []byte) error {
+		var summary boltSummary
+		if err := json.Unmarshal(v, &summary); err != nil {
+			return nil
+		}
+		var email models.Email
+		if err := json.Unmarshal(tx.Bucket(emailsBucket).Get(k), &email); err != nil {
+			return nil
+		}
+		summary.Recipients = recipientAddresses(&email)
+
+		data, err := json.Marshal(&summary)
+		if err != nil {
+			return err
+		}
+		updates[string(k)] = data
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	for k, data := range updates {
+		if err := summaries.Put([]byte(k), data); err != nil {
+			return err
+		}
+	}
+	return migrateAddressIndexes(tx)
+}
+
+// putEmail writes an email, its summary and its index entries
+func putEmail(tx *bbolt.Tx, email *models.Email) error {
+	key := idKey(email.ID)
 
+	// Serialize email
+	data, err := json.Marshal(email)
 	if err != nil {
-		db.Close()
-		return nil, fmt.Errorf("failed to initialize buckets: %w", err)
+		return err
 	}
 
-	return storage, nil
+	// Store email; the raw message is kept byte for byte in its own
+	// bucket since JSON would mangle non UTF-8 content
+	if err := tx.Bucket(emailsBucket).Put(key, data); err != nil {
+		return err
+	}
+	if err := tx.Bucket(rawBucket).Put(key, email.Raw); err != nil {
+		return err
+	}
+
+	summary := newBoltSummary(email)
+	data, err = json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(summariesBucket).Put(key, data); err != nil {
+		return err
+	}
+
+	if err := putIndexEntries(tx, summary); err != nil {
+		return err
+	}
+	return putSearchWords(tx, email)
+}
+
+func putCount(meta *bbolt.Bucket, count int) error {
+	return meta.Put(countKey, []byte(strconv.Itoa(count)))
+}
+
+func getCount(meta *bbolt.Bucket) int {
+	count, _ := strconv.Atoi(string(meta.Get(countKey)))
+	return count
 }
 
 func (s *BoltStorage) SaveEmail(email *models.Email) error {
-	return s.db.Update(func(tx *bbolt.Tx) error {
-		emails := tx.Bucket(emailsBucket)
+	err := s.db.Update(func(tx *bbolt.Tx) error {
 		meta := tx.Bucket(metaBucket)
 
 		// Get next ID
@@ -82,16 +429,10 @@ func (s *BoltStorage) SaveEmail(email *models.Email) error {
 		}
 
 		email.ID = nextID
-
-		// Serialize email
-		data, err := json.Marshal(email)
-		if err != nil {
+		if err := putEmail(tx, email); err != nil {
 			return err
 		}
-
-		// Store email
-		key := []byte(strconv.Itoa(nextID))
-		if err := emails.Put(key, data); err != nil {
+		if err := putCount(meta, getCount(meta)+1); err != nil {
 			return err
 		}
 
@@ -99,98 +440,273 @@ func (s *BoltStorage) SaveEmail(email *models.Email) error {
 		nextID++
 		return meta.Put(nextIDKey, []byte(strconv.Itoa(nextID)))
 	})
+	return err
 }
 
-func (s *BoltStorage) GetEmails(limit int) ([]*models.EmailSummary, error) {
-	var summaries []*models.EmailSummary
+func (s *BoltStorage) GetEmails(filter EmailFilter, page PageRequest) (*EmailPage, error) {
+	if err := page.validate(); err != nil {
+		return nil, err
+	}
+
+	terms := parseQuery(filter.Text)
+	search := len(terms) > 0
+	filter.Text = ""
 
+	var result *EmailPage
 	err := s.db.View(func(tx *bbolt.Tx) error {
-		emails := tx.Bucket(emailsBucket)
-		
-		// Collect all emails first to sort by timestamp
-		var allEmails []*models.Email
-		
-		c := emails.Cursor()
-		for k, v := c.Last(); k != nil; k, v = c.Prev() {
-			var email models.Email
-			if err := json.Unmarshal(v, &email); err != nil {
-				continue // Skip corrupted entries
-			}
-			allEmails = append(allEmails, &email)
+		// Full-text queries go through the index, which also provides the
+		// snippets
+		var hits map[int]string
+		if search {
+			hits = boltSearch(tx, terms)
 		}
-
-		// Sort by created time descending
-		sort.Slice(allEmails, func(i, j int) bool {
-			return allEmails[i].CreatedAt.After(allEmails[j].CreatedAt)
-		})
-
-		// Apply limit and create summaries
-		if limit > len(allEmails) {
-			limit = len(allEmails)
+		match := func(key []byte) (*models.EmailSummary, bool) {
+			return matchSummary(tx, key, filter, hits, search)
 		}
 
-		summaries = make([]*models.EmailSummary, limit)
-		for i := 0; i < limit; i++ {
-			email := allEmails[i]
-			summaries[i] = &models.EmailSummary{
-				ID:        email.ID,
-				From:      email.From,
-				To:        email.To,
-				Subject:   email.Subject,
-				CreatedAt: email.CreatedAt,
+		if page.Sort != SortReceived {
+			// Only the receive order is indexed, so other orders sort
+			// all matching summaries
+			summaries := []*models.EmailSummary{}
+			c := tx.Bucket(summariesBucket).Cursor()
+			for k, _ := c.First(); k != nil; k, _ = c.Next() {
+				if summary, ok := match(k); ok {
+					summaries = append(summaries, summary)
+				}
 			}
+			result = paginate(summaries, page)
+			return nil
 		}
 
+		result = pageByTime(tx, filter, page, search, match)
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
 
-	return summaries, err
+	return result, nil
 }
 
-func (s *BoltStorage) GetEmail(id int) (*models.Email, error) {
-	var email models.Email
+// pageByTime reads a page of the list in receive order from the indexes,
+// looking only at the emails up to the end of the page
+func pageByTime(tx *bbolt.Tx, filter EmailFilter, page PageRequest, search bool, match func([]byte) (*models.EmailSummary, bool)) *EmailPage {
+	lo, hi := timeBounds(filter)
 
-	err := s.db.View(func(tx *bbolt.Tx) error {
-		emails := tx.Bucket(emailsBucket)
-		key := []byte(strconv.Itoa(id))
-		
-		data := emails.Get(key)
-		if data == nil {
-			return fmt.Errorf("email not found")
+	cursor, backwards := page.start()
+	desc := page.Desc != backwards
+
+	start, exclusive := lo, false
+	if desc {
+		start = hi
+	}
+	if cursor != nil {
+		start, exclusive = position(cursor.Time, cursor.ID), true
+	}
+
+	emails := []*models.EmailSummary{}
+	limit := page.readLimit()
+	stream, size, rest := streamFor(tx, filter, desc)
+	stream.walk(start, exclusive, func(pos []byte) bool {
+		if desc && bytes.Compare(pos, lo) < 0 || !desc && bytes.Compare(pos, hi) > 0 {
+			return false
+		}
+		if bytes.Compare(pos, lo) < 0 || bytes.Compare(pos, hi) > 0 {
+			return true // Before the bounds when starting from a cursor
+		}
+		if summary, ok := match(pos[8:]); ok {
+			emails = append(emails, summary)
+		}
+		return limit == 0 || len(emails) < limit
+	})
+
+	// The counts give the total when the index answers the whole filter;
+	// otherwise the matches are counted up to maxCountScan entries, on
+	// the pages that ask for the total
+	if !search && reflect.DeepEqual(rest, EmailFilter{}) {
+		return cutPage(emails, page, &size)
+	}
+	if !page.countsTotal() {
+		return cutPage(emails, page, nil)
+	}
+	stream, _, _ = streamFor(tx, filter, false)
+	scanned, total, approximate := 0, 0, false
+	stream.walk(lo, false, func(pos []byte) bool {
+		if bytes.Compare(pos, hi) > 0 {
+			return false
+		}
+		if scanned == maxCountScan {
+			approximate = true
+			return false
+		}
+		scanned++
+		if _, ok := match(pos[8:]); ok {
+			total++
+		}
+		return true
+	})
+	if approximate {
+		total = total * size / scanned
+	}
+
+	result := cutPage(emails, page, &total)
+	result.TotalApproximate = approximate
+	return result
+}
+
+// matchSummary reads the summary of an email and reports whether the email
+// passes the filter. The full record is only read for header filters.
+func matchSummary(tx *bbolt.Tx, key []byte, filter EmailFilter, hits map[int]string, search bool) (*models.EmailSummary, bool) {
+	data := tx.Bucket(summariesBucket).Get(key)
+	if data == nil {
+		return nil, false
+	}
+	var summary boltSummary
+	if err := json.Unmarshal(data, &summary); err != nil {
+		return nil, false // Skip corrupted entries
+	}
+
+	snippet, hit := hits[summary.ID]
+	if search && !hit {
+		return nil, false
+	}
+
+	headers := filter.Headers
+	filter.Headers = nil
+	if !filter.Match(summary.email()) {
+		return nil, false
+	}
+	if len(headers) > 0 {
+		var email models.Email
+		if err := json.Unmarshal(tx.Bucket(emailsBucket).Get(key), &email); err != nil {
+			return nil, false
 		}
+		if !(EmailFilter{Headers: headers}).Match(&email) {
+			return nil, false
+		}
+	}
+
+	summary.Snippet = snippet
+	return &summary.EmailSummary, true
+}
 
-		return json.Unmarshal(data, &email)
+func (s *BoltStorage) GetEmail(id int) (*models.Email, error) {
+	var email *models.Email
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		email, err = getEmail(tx, id)
+		return err
 	})
 
 	if err != nil {
 		return nil, err
 	}
 
+	return email, nil
+}
+
+// getEmail reads an email with its raw message
+func getEmail(tx *bbolt.Tx, id int) (*models.Email, error) {
+	key := idKey(id)
+
+	data := tx.Bucket(emailsBucket).Get(key)
+	if data == nil {
+		return nil, ErrNotFound
+	}
+
+	var email models.Email
+	if err := json.Unmarshal(data, &email); err != nil {
+		return nil, err
+	}
+
+	// Values are only valid during the transaction, so the raw message is
+	// copied
+	if raw := tx.Bucket(rawBucket).Get(key); raw != nil {
+		email.Raw = append([]byte(nil), raw...)
+	}
 	return &email, nil
 }
 
 func (s *BoltStorage) DeleteEmail(id int) error {
 	return s.db.Update(func(tx *bbolt.Tx) error {
-		emails := tx.Bucket(emailsBucket)
-		key := []byte(strconv.Itoa(id))
-		
-		if emails.Get(key) == nil {
-			return fmt.Errorf("email not found")
+		snapshots, err := boltSnapshots(tx)
+		if err != nil {
+			return err
 		}
-
-		return emails.Delete(key)
+		return removeEmail(tx, id, held(snapshots, id))
 	})
 }
 
+// removeEmail deletes an email with its summary and index entries, moving
+// it to the tombstones if bury is set
+func removeEmail(tx *bbolt.Tx, id int, bury bool) error {
+	key := idKey(id)
+
+	data := tx.Bucket(summariesBucket).Get(key)
+	if data == nil {
+		return ErrNotFound
+	}
+	var summary boltSummary
+	if err := json.Unmarshal(data, &summary); err != nil {
+		return err
+	}
+	var email models.Email
+	if err := json.Unmarshal(tx.Bucket(emailsBucket).Get(key), &email); err != nil {
+		return err
+	}
+	if err := deleteSearchWords(tx, &email); err != nil {
+		return err
+	}
+
+	if bury {
+		if err := tx.Bucket(tombstonesBucket).Put(key, tx.Bucket(emailsBucket).Get(key)); err != nil {
+			return err
+		}
+		if err := tx.Bucket(tombstoneRawBucket).Put(key, tx.Bucket(rawBucket).Get(key)); err != nil {
+			return err
+		}
+	}
+
+	if err := deleteIndexEntries(tx, &summary); err != nil {
+		return err
+	}
+	for _, bucket := range [][]byte{emailsBucket, rawBucket, summariesBucket} {
+		if err := tx.Bucket(bucket).Delete(key); err != nil {
+			return err
+		}
+	}
+
+	meta := tx.Bucket(metaBucket)
+	return putCount(meta, getCount(meta)-1)
+}
+
 func (s *BoltStorage) ClearEmails() error {
 	return s.db.Update(func(tx *bbolt.Tx) error {
-		// Delete the bucket and recreate it
-		if err := tx.DeleteBucket(emailsBucket); err != nil {
+		snapshots, err := boltSnapshots(tx)
+		if err != nil {
 			return err
 		}
-		
-		_, err := tx.CreateBucket(emailsBucket)
-		return err
+		if len(snapshots) > 0 {
+			// The emails snapshots hold become tombstones
+			for _, id := range boltIDs(tx.Bucket(emailsBucket)) {
+				if err := removeEmail(tx, id, held(snapshots, id)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		// Delete the buckets and recreate them
+		for _, bucket := range dataBuckets {
+			if err := tx.DeleteBucket(bucket); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(bucket); err != nil {
+				return err
+			}
+		}
+		return putCount(tx.Bucket(metaBucket), 0)
 	})
 }
 
@@ -198,9 +714,7 @@ func (s *BoltStorage) GetEmailCount() (int, error) {
 	var count int
 
 	err := s.db.View(func(tx *bbolt.Tx) error {
-		emails := tx.Bucket(emailsBucket)
-		stats := emails.Stats()
-		count = stats.KeyN
+		count = getCount(tx.Bucket(metaBucket))
 		return nil
 	})
 
@@ -209,4 +723,64 @@ func (s *BoltStorage) GetEmailCount() (int, error) {
 
 func (s *BoltStorage) Close() error {
 	return s.db.Close()
-}
\ No newline at end of file
+}
+
+// Backup writes the database as of a read transaction, which does not
+// block writers
+func (s *BoltStorage) Backup(w io.Writer) (*BackupInfo, error) {
+	info := &BackupInfo{Format: BackupFormatBolt}
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		info.Emails = getCount(tx.Bucket(metaBucket))
+		for _, bucket := range [][]byte{emailsBucket, tombstonesBucket} {
+			err := tx.Bucket(bucket).ForEach(func(k, v []byte) error {
+				var email models.Email
+				if err := json.Unmarshal(v, &email); err != nil {
+					return err
+				}
+				info.Blobs = blobKeys(info.Blobs, &email)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		_, err := tx.WriteTo(w)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// restoreBolt replaces the database of dbPath with a copy written by
+// Backup
+func restoreBolt(dbPath string, r io.Reader) error {
+	boltPath := dbPath[:len(dbPath)-len(filepath.Ext(dbPath))] + ".bolt"
+
+	// A running server holds the lock of the database
+	if _, err := os.Stat(boltPath); err == nil {
+		db, err := bbolt.Open(boltPath, 0644, &bbolt.Options{Timeout: 1 * time.Second})
+		if err != nil {
+			return fmt.Errorf("bolt database is in use: %w", err)
+		}
+		db.Close()
+	}
+
+	if err := os.MkdirAll(filepath.Dir(boltPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	return restoreFile(boltPath, r)
+}
+
+// legacyRaw returns the raw message embedded in records written before raw
+// messages were stored separately
+func legacyRaw(data []byte) []byte {
+	var record struct {
+		Raw *string `json:"raw"`
+	}
+	if err := json.Unmarshal(data, &record); err != nil || record.Raw == nil {
+		return nil
+	}
+	return []byte(*record.Raw)
+}
@@ -0,0 +1,278 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"mailcatch/internal/models"
+	"go.etcd.io/bbolt"
+)
+
+// Index keys end in a 16 byte position: the receive time, with the sign
+// bit flipped so negative times sort first, followed by the email ID.
+// Sender and recipient indexes have an entry per address of the field,
+// prefixed with the lower-cased address and a NUL byte, so the entries of
+// each address are in time order. Their count buckets hold the number of
+// entries of each address.
+const positionSize = 16
+
+// maxIndexValue bounds the address stored in index keys. Longer addresses
+// are truncated, so their entries are only candidates and their counts
+// are not used.
+const maxIndexValue = 1024
+
+// maxCountScan bounds the index entries read to count the emails matching
+// a filter the counts cannot answer; past it the total is extrapolated
+const maxCountScan = 10000
+
+var (
+	firstPosition = make([]byte, positionSize)
+	lastPosition  = bytes.Repeat([]byte{0xFF}, positionSize)
+)
+
+// boltSummary is the record of the summaries bucket: the list entry of an
+// email plus what the filters other than header and text filters look at
+type boltSummary struct {
+	models.EmailSummary
+	Warnings    []string `json:"warnings,omitempty"`
+	Attachments int      `json:"attachments,omitempty"`
+	// Recipients are the addresses of the envelope and the To and Cc
+	// headers, which the recipient index holds
+	Recipients []string `json:"recipients,omitempty"`
+}
+
+func newBoltSummary(email *models.Email) *boltSummary {
+	summary := &boltSummary{
+		EmailSummary: *summarize(email, ""),
+		Attachments:  len(email.Attachments),
+		Recipients:   recipientAddresses(email),
+	}
+	for _, warning := range email.Warnings {
+		summary.Warnings = append(summary.Warnings, warning.Code)
+	}
+	return summary
+}
+
+// email returns a partial email with the fields of the summary, enough for
+// EmailFilter.Match without header and text filters. The recipients stand
+// in for the To and Cc headers.
+func (s *boltSummary) email() *models.Email {
+	email := &models.Email{
+		ID:          s.ID,
+		From:        s.From,
+		To:          s.To,
+		Subject:     s.Subject,
+		CreatedAt:   s.CreatedAt,
+		Attachments: make([]*models.Attachment, s.Attachments),
+		Headers:     map[string][]string{"To": {strings.Join(s.Recipients, ", ")}},
+	}
+	for _, code := range s.Warnings {
+		email.Warnings = append(email.Warnings, &models.ParseWarning{Code: code})
+	}
+	return email
+}
+
+// idKey is the key of an email in the record buckets
+func idKey(id int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(id))
+	return key
+}
+
+func position(t time.Time, id int) []byte {
+	pos := make([]byte, positionSize)
+	binary.BigEndian.PutUint64(pos, uint64(t.UnixNano())^(1<<63))
+	binary.BigEndian.PutUint64(pos[8:], uint64(id))
+	return pos
+}
+
+// fieldPrefix is the part of a sender or recipient index key before the
+// position
+func fieldPrefix(address string) []byte {
+	address = strings.ReplaceAll(address, "\x00", "")
+	if len(address) > maxIndexValue {
+		address = address[:maxIndexValue]
+	}
+	return append([]byte(address), 0)
+}
+
+// addressIndexes are the sender and recipient indexes with their counts
+var addressIndexes = []struct {
+	bucket    []byte
+	counts    []byte
+	addresses func(summary *boltSummary) []string
+}{
+	{byFromBucket, fromCountsBucket, func(summary *boltSummary) []string { return fieldAddresses(summary.From) }},
+	{byToBucket, toCountsBucket, func(summary *boltSummary) []string { return summary.Recipients }},
+}
+
+// indexEntry is a key of an index bucket
+type indexEntry struct {
+	bucket []byte
+	key    []byte
+}
+
+// indexEntries returns the index keys of an email
+func indexEntries(summary *boltSummary) []indexEntry {
+	pos := position(summary.CreatedAt, summary.ID)
+	entries := []indexEntry{{byTimeBucket, pos}}
+	for _, index := range addressIndexes {
+		for _, address := range index.addresses(summary) {
+			entries = append(entries, indexEntry{index.bucket, append(fieldPrefix(address), pos...)})
+		}
+	}
+	return entries
+}
+
+// putIndexEntries adds the index entries of an email and counts them
+func putIndexEntries(tx *bbolt.Tx, summary *boltSummary) error {
+	for _, entry := range indexEntries(summary) {
+		if err := tx.Bucket(entry.bucket).Put(entry.key, nil); err != nil {
+			return err
+		}
+		if err := countEntry(tx, entry, 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteIndexEntries is the inverse of putIndexEntries
+func deleteIndexEntries(tx *bbolt.Tx, summary *boltSummary) error {
+	for _, entry := range indexEntries(summary) {
+		if err := tx.Bucket(entry.bucket).Delete(entry.key); err != nil {
+			return err
+		}
+		if err := countEntry(tx, entry, -1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countEntry adds delta to the count of the address of a sender or
+// recipient index entry; the time index is counted in the meta bucket
+func countEntry(tx *bbolt.Tx, entry indexEntry, delta int) error {
+	for _, index := range addressIndexes {
+		if !bytes.Equal(entry.bucket, index.bucket) {
+			continue
+		}
+		counts := tx.Bucket(index.counts)
+		prefix := entry.key[:len(entry.key)-positionSize]
+		count := getAddressCount(counts, prefix) + delta
+		if count <= 0 {
+			return counts.Delete(prefix)
+		}
+		return counts.Put(prefix, []byte(strconv.Itoa(count)))
+	}
+	return nil
+}
+
+func getAddressCount(counts *bbolt.Bucket, prefix []byte) int {
+	count, _ := strconv.Atoi(string(counts.Get(prefix)))
+	return count
+}
+
+// indexStream walks the entries of an index that share a prefix
+type indexStream struct {
+	cursor *bbolt.Cursor
+	prefix []byte
+	desc   bool
+	// key is the current entry, nil once the stream is exhausted
+	key []byte
+}
+
+// seek moves to the first entry at or, if exclusive, past pos in the
+// direction of the stream; a nil pos starts at the beginning
+func (st *indexStream) seek(pos []byte, exclusive bool) {
+	if pos == nil {
+		pos, exclusive = firstPosition, false
+		if st.desc {
+			pos = lastPosition
+		}
+	}
+
+	target := append(append([]byte{}, st.prefix...), pos...)
+	k, _ := st.cursor.Seek(target)
+	if st.desc {
+		// Seek lands on the first key at or after the target
+		if k == nil {
+			k, _ = st.cursor.Last()
+		} else if exclusive || !bytes.Equal(k, target) {
+			k, _ = st.cursor.Prev()
+		}
+	} else if k != nil && exclusive && bytes.Equal(k, target) {
+		k, _ = st.cursor.Next()
+	}
+	st.set(k)
+}
+
+func (st *indexStream) advance() {
+	var k []byte
+	if st.desc {
+		k, _ = st.cursor.Prev()
+	} else {
+		k, _ = st.cursor.Next()
+	}
+	st.set(k)
+}
+
+func (st *indexStream) set(k []byte) {
+	if k == nil || !bytes.HasPrefix(k, st.prefix) {
+		st.key = nil
+		return
+	}
+	st.key = k
+}
+
+func (st *indexStream) position() []byte {
+	return st.key[len(st.prefix):]
+}
+
+// walk calls visit with the positions of the stream from pos, past it if
+// exclusive, until visit returns false
+func (st *indexStream) walk(pos []byte, exclusive bool, visit func(pos []byte) bool) {
+	for st.seek(pos, exclusive); st.key != nil; st.advance() {
+		if !visit(st.position()) {
+			return
+		}
+	}
+}
+
+// streamFor picks the index serving a filter: the entries of the
+// address in the sender or recipient index when the filter names a whole
+// address, or else the time index. It returns the number of entries of
+// the stream, and the filter left for the entries to be matched against
+// to count them, empty when the stream holds exactly the matching emails.
+func streamFor(tx *bbolt.Tx, filter EmailFilter, desc bool) (*indexStream, int, EmailFilter) {
+	for i, value := range []*string{&filter.From, &filter.To} {
+		address, ok := filterAddress(*value)
+		if !ok {
+			continue
+		}
+		index := addressIndexes[i]
+		prefix := fieldPrefix(address)
+		count := getAddressCount(tx.Bucket(index.counts), prefix)
+		if len(address) < maxIndexValue {
+			*value = ""
+		}
+		return &indexStream{cursor: tx.Bucket(index.bucket).Cursor(), prefix: prefix, desc: desc}, count, filter
+	}
+	return &indexStream{cursor: tx.Bucket(byTimeBucket).Cursor(), desc: desc}, getCount(tx.Bucket(metaBucket)), filter
+}
+
+// timeBounds returns the positions bounding the Since and Until filters
+func timeBounds(filter EmailFilter) (lo, hi []byte) {
+	lo, hi = firstPosition, lastPosition
+	if !filter.Since.IsZero() {
+		lo = position(filter.Since, 0)
+	}
+	if !filter.Until.IsZero() {
+		hi = position(filter.Until, math.MaxInt64)
+	}
+	return lo, hi
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+
+	"mailcatch/internal/models"
+	"go.etcd.io/bbolt"
+)
+
+// The search bucket is an inverted index kept in the database, so it costs
+// no memory and needs no rebuild on startup. Keys are a word of an email,
+// a NUL byte and the email ID; the words of a prefix are next to each other.
+
+func searchKey(word string, id int) []byte {
+	return append(append([]byte(word), 0), idKey(id)...)
+}
+
+// putSearchWords indexes the words of an email
+func putSearchWords(tx *bbolt.Tx, email *models.Email) error {
+	bucket := tx.Bucket(searchBucket)
+	for _, word := range searchWords(email) {
+		if err := bucket.Put(searchKey(word, email.ID), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteSearchWords removes the words of an email from the index
+func deleteSearchWords(tx *bbolt.Tx, email *models.Email) error {
+	bucket := tx.Bucket(searchBucket)
+	for _, word := range searchWords(email) {
+		if err := bucket.Delete(searchKey(word, email.ID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// boltSearch returns the emails matching the terms with their snippets.
+// The index narrows them down to the emails with all the words, which are
+// then read and matched for phrases and snippets.
+func boltSearch(tx *bbolt.Tx, terms []searchTerm) map[int]string {
+	c := tx.Bucket(searchBucket).Cursor()
+	ids := candidates(terms, func(word string, prefix bool) map[int]struct{} {
+		seek := []byte(word)
+		if !prefix {
+			seek = append(seek, 0)
+		}
+		found := make(map[int]struct{})
+		for k, _ := c.Seek(seek); k != nil && bytes.HasPrefix(k, seek); k, _ = c.Next() {
+			found[int(binary.BigEndian.Uint64(k[len(k)-8:]))] = struct{}{}
+		}
+		return found
+	})
+
+	hits := make(map[int]string)
+	emails := tx.Bucket(emailsBucket)
+	for id := range ids {
+		var email models.Email
+		if err := json.Unmarshal(emails.Get(idKey(id)), &email); err != nil {
+			continue
+		}
+		if match, snippet := matchQuery(searchFields(&email), terms); match {
+			hits[id] = snippet
+		}
+	}
+	return hits
+}
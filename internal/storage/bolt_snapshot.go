@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"mailcatch/internal/models"
+	"go.etcd.io/bbolt"
+)
+
+// boltSnapshots reads the snapshots, oldest first
+func boltSnapshots(tx *bbolt.Tx) ([]*Snapshot, error) {
+	var snapshots []*Snapshot
+	err := tx.Bucket(snapshotsBucket).ForEach(func(k, v []byte) error {
+		var record snapshotRecord
+		if err := json.Unmarshal(v, &record); err != nil {
+			return err
+		}
+		snapshots = append(snapshots, record.snapshot())
+		return nil
+	})
+	sort.SliceStable(snapshots, func(i, j int) bool { return snapshots[i].CreatedAt.Before(snapshots[j].CreatedAt) })
+	return snapshots, err
+}
+
+// boltIDs returns the IDs of a bucket keyed by email ID, in order
+func boltIDs(bucket *bbolt.Bucket) []int {
+	var ids []int
+	bucket.ForEach(func(k, v []byte) error {
+		ids = append(ids, int(binary.BigEndian.Uint64(k)))
+		return nil
+	})
+	return ids
+}
+
+// boltTombstone reads a tombstone with its raw message
+func boltTombstone(tx *bbolt.Tx, id int) (*models.Email, error) {
+	key := idKey(id)
+	var email models.Email
+	if err := json.Unmarshal(tx.Bucket(tombstonesBucket).Get(key), &email); err != nil {
+		return nil, err
+	}
+	if raw := tx.Bucket(tombstoneRawBucket).Get(key); raw != nil {
+		email.Raw = append([]byte(nil), raw...)
+	}
+	return &email, nil
+}
+
+// deleteTombstone purges a tombstone and returns it
+func deleteTombstone(tx *bbolt.Tx, id int) (*models.Email, error) {
+	email, err := boltTombstone(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	for _, bucket := range [][]byte{tombstonesBucket, tombstoneRawBucket} {
+		if err := tx.Bucket(bucket).Delete(idKey(id)); err != nil {
+			return nil, err
+		}
+	}
+	return email, nil
+}
+
+func (s *BoltStorage) CreateSnapshot(name string) (*Snapshot, error) {
+	var snapshot *Snapshot
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		if tx.Bucket(snapshotsBucket).Get([]byte(name)) != nil {
+			return fmt.Errorf("snapshot %q: %w", name, ErrSnapshotExists)
+		}
+		var err error
+		snapshot, err = newSnapshot(name, boltIDs(tx.Bucket(emailsBucket)))
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(snapshot.record())
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(snapshotsBucket).Put([]byte(name), data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+func (s *BoltStorage) ListSnapshots() ([]*Snapshot, error) {
+	var snapshots []*Snapshot
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		snapshots, err = boltSnapshots(tx)
+		return err
+	})
+	return snapshots, err
+}
+
+func (s *BoltStorage) RestoreSnapshot(name string) ([]*models.Email, error) {
+	var purged []*models.Email
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		snapshots, err := boltSnapshots(tx)
+		if err != nil {
+			return err
+		}
+		target, others, err := findSnapshot(snapshots, name)
+		if err != nil {
+			return err
+		}
+
+		bury, purge, revive := restorePlan(target, others, boltIDs(tx.Bucket(emailsBucket)), boltIDs(tx.Bucket(tombstonesBucket)))
+		for _, id := range bury {
+			if err := removeEmail(tx, id, true); err != nil {
+				return err
+			}
+		}
+		for _, id := range purge {
+			email, err := getEmail(tx, id)
+			if err != nil {
+				return err
+			}
+			if err := removeEmail(tx, id, false); err != nil {
+				return err
+			}
+			purged = append(purged, email)
+		}
+
+		meta := tx.Bucket(metaBucket)
+		for _, id := range revive {
+			email, err := deleteTombstone(tx, id)
+			if err != nil {
+				return err
+			}
+			if err := putEmail(tx, email); err != nil {
+				return err
+			}
+			if err := putCount(meta, getCount(meta)+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return purged, nil
+}
+
+func (s *BoltStorage) DeleteSnapshot(name string) ([]*models.Email, error) {
+	var purged []*models.Email
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		snapshots, err := boltSnapshots(tx)
+		if err != nil {
+			return err
+		}
+		_, others, err := findSnapshot(snapshots, name)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(snapshotsBucket).Delete([]byte(name)); err != nil {
+			return err
+		}
+
+		for _, id := range released(others, boltIDs(tx.Bucket(tombstonesBucket))) {
+			email, err := deleteTombstone(tx, id)
+			if err != nil {
+				return err
+			}
+			purged = append(purged, email)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return purged, nil
+}
+
+func (s *BoltStorage) Holds(id int) (bool, error) {
+	var holds bool
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		snapshots, err := boltSnapshots(tx)
+		holds = held(snapshots, id)
+		return err
+	})
+	return holds, err
+}
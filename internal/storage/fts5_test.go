@@ -0,0 +1,21 @@
+//go:build sqlite_fts5 && cgo
+
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// Builds with the tag search with FTS5 rather than falling back to
+// substring matching
+func TestSQLiteFTS5(t *testing.T) {
+	store, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "emails.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if !store.fts {
+		t.Error("SQLite built with sqlite_fts5 does not use FTS5")
+	}
+}
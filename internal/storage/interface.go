@@ -1,14 +1,133 @@
 package storage
 
-import "mailcatch/internal/models"
+import (
+	"errors"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"mailcatch/internal/models"
+)
+
+// ErrNotFound is returned for an email ID that is not stored
+var ErrNotFound = errors.New("email not found")
 
 // Storage defines the interface for email storage implementations
 type Storage interface {
 	SaveEmail(*models.Email) error
-	GetEmails(int) ([]*models.EmailSummary, error)
+	GetEmails(EmailFilter, PageRequest) (*EmailPage, error)
 	GetEmail(int) (*models.Email, error)
 	DeleteEmail(int) error
 	ClearEmails() error
 	GetEmailCount() (int, error)
 	Close() error
-}
\ No newline at end of file
+}
+
+// Evicter is implemented by storages that drop emails on their own, such
+// as the memory backends with max_emails
+type Evicter interface {
+	// OnEvict sets the function called with each email dropped, once it
+	// left the storage, so its blobs can be deleted. Emails a snapshot
+	// holds are not dropped for good and not reported.
+	OnEvict(func(email *models.Email))
+}
+
+// EmailFilter narrows the emails returned by GetEmails; the zero value
+// matches every email. Text matches are case-insensitive substring matches.
+type EmailFilter struct {
+	// HasWarnings, when set, keeps only emails with (true) or without
+	// (false) parse warnings
+	HasWarnings *bool
+	// Warning keeps only emails with a parse warning of this code
+	Warning string
+	// From, To and Subject match the sender, the recipients and the
+	// subject. From and To match an address exactly when they name a whole
+	// one, and substrings otherwise. A whole address in To matches the
+	// recipients of the To and Cc headers too.
+	From    string
+	To      string
+	Subject string
+	// Text is a full-text query over the subject, bodies, addresses and
+	// attachment filenames: words, "phrases" and prefix* terms that must
+	// all match
+	Text string
+	// Since and Until bound the time an email was received, when set
+	Since time.Time
+	Until time.Time
+	// HasAttachments, when set, keeps only emails with (true) or without
+	// (false) attachments
+	HasAttachments *bool
+	// Headers must all match
+	Headers []HeaderMatch
+}
+
+// HeaderMatch matches a header field; an empty Value only requires the
+// field to be present
+type HeaderMatch struct {
+	Name  string
+	Value string
+}
+
+// Match reports whether an email passes the filter
+func (f EmailFilter) Match(email *models.Email) bool {
+	if f.HasWarnings != nil && *f.HasWarnings != (len(email.Warnings) > 0) {
+		return false
+	}
+
+	if f.Warning != "" {
+		found := false
+		for _, warning := range email.Warnings {
+			if warning.Code == f.Warning {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if !matchAddress(email.From, f.From) || !matchRecipient(email, f.To) || !containsFold(email.Subject, f.Subject) {
+		return false
+	}
+	if terms := parseQuery(f.Text); len(terms) > 0 {
+		if match, _ := matchQuery(searchFields(email), terms); !match {
+			return false
+		}
+	}
+
+	if !f.Since.IsZero() && email.CreatedAt.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && email.CreatedAt.After(f.Until) {
+		return false
+	}
+
+	if f.HasAttachments != nil && *f.HasAttachments != (len(email.Attachments) > 0) {
+		return false
+	}
+
+	for _, header := range f.Headers {
+		values, ok := email.Headers[textproto.CanonicalMIMEHeaderKey(header.Name)]
+		if !ok {
+			return false
+		}
+		found := false
+		for _, value := range values {
+			if containsFold(value, header.Value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// containsFold reports whether s contains substr, ignoring case
+func containsFold(s, substr string) bool {
+	return substr == "" || strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
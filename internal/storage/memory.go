@@ -3,9 +3,12 @@ package storage
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"log"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"sync"
 
 	"mailcatch/internal/models"
@@ -16,9 +19,79 @@ type MemoryStorage struct {
 	nextID   int
 	mutex    sync.RWMutex
 	filePath string
+	// rawDir holds one file per raw message, since the JSON file cannot
+	// hold non UTF-8 content
+	rawDir string
+	// lock is the exclusive lock of the JSON file
+	lock  *os.File
+	index *searchIndex
+	// unsaved is set while the last change failed to be written, for
+	// Close to try again
+	unsaved bool
+	// maxEmails bounds the emails kept, dropping the oldest; 0 for no
+	// limit, leaving eviction to the retention janitor
+	maxEmails int
+	// onEvict is called with the emails maxEmails drops
+	onEvict func(email *models.Email)
+	// tombstones are the deleted emails a snapshot holds, by ID
+	tombstones map[int]*models.Email
+	snapshots  []*Snapshot
+}
+
+func init() {
+	Register(&Backend{
+		Name:        "memory",
+		Description: "Emails kept in memory only and lost on exit, no files are written",
+		Ephemeral:   true,
+		Options:     []string{"max_emails"},
+		// Its backups are restored with memory-persistent
+		BackupFormat: BackupFormatJSON,
+		Open: func(path string, options Options) (Storage, error) {
+			maxEmails, err := options.Int("max_emails", 0)
+			if err != nil {
+				return nil, err
+			}
+			return newMemoryStorage("", maxEmails)
+		},
+	})
+	Register(&Backend{
+		Name:        "memory-persistent",
+		Description: "Emails kept in memory and saved to a JSON file next to the database path",
+		Options:     []string{"max_emails"},
+		Open: func(path string, options Options) (Storage, error) {
+			maxEmails, err := options.Int("max_emails", 0)
+			if err != nil {
+				return nil, err
+			}
+			return newMemoryStorage(path, maxEmails)
+		},
+		MigrationStatus: func(path string, options Options) (*MigrationStatus, error) {
+			return MemoryMigrationStatus(path)
+		},
+		BackupFormat: BackupFormatJSON,
+		Restore: func(path string, options Options, r io.Reader) error {
+			return restoreMemory(path, r)
+		},
+	})
 }
 
 func NewMemoryStorage(dbPath string) (*MemoryStorage, error) {
+	return newMemoryStorage(dbPath, 0)
+}
+
+// newMemoryStorage keeps the emails in memory, saved to a JSON file next to
+// dbPath unless dbPath is empty
+func newMemoryStorage(dbPath string, maxEmails int) (*MemoryStorage, error) {
+	if dbPath == "" {
+		return &MemoryStorage{
+			emails:     make([]*models.Email, 0),
+			nextID:     1,
+			index:      newSearchIndex(),
+			maxEmails:  maxEmails,
+			tombstones: make(map[int]*models.Email),
+		}, nil
+	}
+
 	// Create directory if it doesn't exist
 	dir := filepath.Dir(dbPath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
@@ -27,17 +100,29 @@ func NewMemoryStorage(dbPath string) (*MemoryStorage, error) {
 
 	// Change extension to .json
 	jsonPath := dbPath[:len(dbPath)-len(filepath.Ext(dbPath))] + ".json"
-	
+	// Two processes would assign the same IDs and overwrite each other's
+	// file, so the file has a single user
+	lock, err := ownLock(jsonPath)
+	if err != nil {
+		return nil, err
+	}
+
 	storage := &MemoryStorage{
-		emails:   make([]*models.Email, 0),
-		nextID:   1,
-		filePath: jsonPath,
+		emails:     make([]*models.Email, 0),
+		nextID:     1,
+		filePath:   jsonPath,
+		rawDir:     jsonPath[:len(jsonPath)-len(".json")] + "-raw",
+		lock:       lock,
+		index:      newSearchIndex(),
+		maxEmails:  maxEmails,
+		tombstones: make(map[int]*models.Email),
 	}
 
 	// Load existing data if file exists
 	if err := storage.loadFromFile(); err != nil {
 		// If file doesn't exist, it's not an error
 		if !os.IsNotExist(err) {
+			lock.Close()
 			return nil, fmt.Errorf("failed to load data: %w", err)
 		}
 	}
@@ -45,90 +130,314 @@ func NewMemoryStorage(dbPath string) (*MemoryStorage, error) {
 	return storage, nil
 }
 
-func (s *MemoryStorage) loadFromFile() error {
-	data, err := os.ReadFile(s.filePath)
+// memoryFile is the JSON file of MemoryStorage. Files without a schema
+// version were written before versioning, at version 1.
+type memoryFile struct {
+	SchemaVersion int               `json:"schema_version"`
+	NextID        int               `json:"next_id"`
+	Emails        []json.RawMessage `json:"emails"`
+	Tombstones    []json.RawMessage `json:"tombstones,omitempty"`
+	Snapshots     []snapshotRecord  `json:"snapshots,omitempty"`
+}
+
+// memoryMigrations is the history of the file format. Each step rewrites
+// the file, so it is only replaced once the step completed.
+var memoryMigrations = []struct {
+	Migration
+	up func(s *MemoryStorage, file *memoryFile) error
+}{
+	{Migration{1, "create the email file"}, func(s *MemoryStorage, file *memoryFile) error {
+		return nil
+	}},
+	{Migration{2, "move raw messages to separate files"}, func(s *MemoryStorage, file *memoryFile) error {
+		for i, record := range file.Emails {
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal(record, &fields); err != nil {
+				return err
+			}
+			if _, ok := fields["raw"]; !ok {
+				continue
+			}
+
+			var id int
+			if err := json.Unmarshal(fields["id"], &id); err != nil {
+				return err
+			}
+			// A file left by an interrupted migration is complete
+			if _, err := os.Stat(s.rawPath(id)); os.IsNotExist(err) {
+				if err := os.MkdirAll(s.rawDir, 0755); err != nil {
+					return err
+				}
+				if err := os.WriteFile(s.rawPath(id), legacyRaw(record), 0644); err != nil {
+					return err
+				}
+			}
+
+			delete(fields, "raw")
+			data, err := json.Marshal(fields)
+			if err != nil {
+				return err
+			}
+			file.Emails[i] = data
+		}
+		return nil
+	}},
+}
+
+func memoryMigrationList() []Migration {
+	migrations := make([]Migration, len(memoryMigrations))
+	for i, step := range memoryMigrations {
+		migrations[i] = step.Migration
+	}
+	return migrations
+}
+
+// readMemoryFile reads the JSON file and its schema version
+func readMemoryFile(path string) (*memoryFile, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	var file memoryFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	if file.SchemaVersion == 0 {
+		file.SchemaVersion = 1
 	}
+	return &file, nil
+}
 
-	var fileData struct {
-		NextID int              `json:"next_id"`
-		Emails []*models.Email  `json:"emails"`
+// MemoryMigrationStatus reports the migrations opening the JSON file of
+// dbPath would apply, without modifying it
+func MemoryMigrationStatus(dbPath string) (*MigrationStatus, error) {
+	jsonPath := dbPath[:len(dbPath)-len(filepath.Ext(dbPath))] + ".json"
+
+	current := 0
+	file, err := readMemoryFile(jsonPath)
+	if err == nil {
+		current = file.SchemaVersion
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return newMigrationStatus("JSON", current, memoryMigrationList())
+}
+
+func (s *MemoryStorage) loadFromFile() error {
+	file, err := readMemoryFile(s.filePath)
+	if err != nil {
+		return err
 	}
 
-	if err := json.Unmarshal(data, &fileData); err != nil {
+	err = runMigrations("JSON", file.SchemaVersion, memoryMigrationList(), func(migration Migration) error {
+		if err := memoryMigrations[migration.Version-1].up(s, file); err != nil {
+			return err
+		}
+		file.SchemaVersion = migration.Version
+		return writeFileAtomic(s.filePath, file)
+	})
+	if err != nil {
 		return err
 	}
 
-	s.emails = fileData.Emails
-	s.nextID = fileData.NextID
+	s.emails = make([]*models.Email, 0, len(file.Emails))
+	for _, record := range file.Emails {
+		email, err := s.loadEmail(record)
+		if err != nil {
+			return err
+		}
+		s.emails = append(s.emails, email)
+		s.index.add(email)
+	}
+	for _, record := range file.Tombstones {
+		email, err := s.loadEmail(record)
+		if err != nil {
+			return err
+		}
+		s.tombstones[email.ID] = email
+	}
+	for _, record := range file.Snapshots {
+		s.snapshots = append(s.snapshots, record.snapshot())
+	}
+	s.nextID = file.NextID
 
 	return nil
 }
 
+// loadEmail decodes an email of the JSON file and reads its raw message
+func (s *MemoryStorage) loadEmail(record json.RawMessage) (*models.Email, error) {
+	email := &models.Email{}
+	if err := json.Unmarshal(record, email); err != nil {
+		return nil, err
+	}
+
+	// Messages kept in the blob store have no raw file
+	var err error
+	email.Raw, err = os.ReadFile(s.rawPath(email.ID))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return email, nil
+}
+
 func (s *MemoryStorage) saveToFile() error {
+	if !s.persistent() {
+		return nil
+	}
+
 	fileData := struct {
-		NextID int              `json:"next_id"`
-		Emails []*models.Email  `json:"emails"`
+		SchemaVersion int              `json:"schema_version"`
+		NextID        int              `json:"next_id"`
+		Emails        []*models.Email  `json:"emails"`
+		Tombstones    []*models.Email  `json:"tombstones,omitempty"`
+		Snapshots     []snapshotRecord `json:"snapshots,omitempty"`
 	}{
-		NextID: s.nextID,
-		Emails: s.emails,
+		SchemaVersion: memoryMigrations[len(memoryMigrations)-1].Version,
+		NextID:        s.nextID,
+		Emails:        s.emails,
+		Tombstones:    s.sortedTombstones(),
+		Snapshots:     s.snapshotRecords(),
 	}
 
-	data, err := json.MarshalIndent(fileData, "", "  ")
+	err := writeFileAtomic(s.filePath, fileData)
+	s.unsaved = err != nil
+	return err
+}
+
+// writeFileAtomic writes v as JSON to a temporary file and renames it over
+// path, so readers never see a partly written file
+func writeFileAtomic(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(s.filePath, data, 0644)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// OnEvict sets the function called with the emails max_emails drops
+func (s *MemoryStorage) OnEvict(onEvict func(email *models.Email)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.onEvict = onEvict
 }
 
 func (s *MemoryStorage) SaveEmail(email *models.Email) error {
+	// Dropped emails are reported once the mutex is released
+	var evicted []*models.Email
+	var onEvict func(email *models.Email)
+	defer func() {
+		for _, dropped := range evicted {
+			onEvict(dropped)
+		}
+	}()
+
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+	onEvict = s.onEvict
 
 	email.ID = s.nextID
+	if s.persistent() {
+		err := os.MkdirAll(s.rawDir, 0755)
+		if err == nil {
+			err = os.WriteFile(s.rawPath(email.ID), email.Raw, 0644)
+		}
+		if err != nil {
+			email.ID = 0
+			return err
+		}
+	}
+	previous := s.emails
 	s.nextID++
 	s.emails = append(s.emails, email)
+	s.index.add(email)
+
+	// Keep only the latest emails to prevent unlimited growth. Their raw
+	// files are removed once the file no longer lists them.
+	var dropped []*models.Email
+	if s.maxEmails > 0 && len(s.emails) > s.maxEmails {
+		dropped = s.emails[:len(s.emails)-s.maxEmails]
+		s.emails = s.emails[len(s.emails)-s.maxEmails:]
+		for _, email := range dropped {
+			s.index.remove(email)
+			if held(s.snapshots, email.ID) {
+				s.tombstones[email.ID] = email
+			}
+		}
+	}
 
-	// Keep only last 1000 emails to prevent unlimited growth
-	if len(s.emails) > 1000 {
-		s.emails = s.emails[len(s.emails)-1000:]
+	// The caller deletes the blobs of an email it failed to store, so the
+	// email must not stay behind
+	if err := s.saveToFile(); err != nil {
+		for _, email := range dropped {
+			delete(s.tombstones, email.ID)
+			s.index.add(email)
+		}
+		s.index.remove(email)
+		s.emails = previous
+		s.nextID = email.ID
+		s.removeRaw(email.ID)
+		email.ID = 0
+		return err
 	}
 
-	return s.saveToFile()
+	if len(dropped) > 0 {
+		log.Printf("Dropped %d oldest emails over max_emails=%d", len(dropped), s.maxEmails)
+	}
+	for _, email := range dropped {
+		if _, buried := s.tombstones[email.ID]; buried {
+			continue
+		}
+		s.removeRaw(email.ID)
+		if onEvict != nil {
+			evicted = append(evicted, email)
+		}
+	}
+	return nil
 }
 
-func (s *MemoryStorage) GetEmails(limit int) ([]*models.EmailSummary, error) {
+func (s *MemoryStorage) GetEmails(filter EmailFilter, page PageRequest) (*EmailPage, error) {
+	if err := page.validate(); err != nil {
+		return nil, err
+	}
+
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
-	// Sort by created time descending
-	emailsCopy := make([]*models.Email, len(s.emails))
-	copy(emailsCopy, s.emails)
-	
-	sort.Slice(emailsCopy, func(i, j int) bool {
-		return emailsCopy[i].CreatedAt.After(emailsCopy[j].CreatedAt)
-	})
-
-	// Apply limit
-	if limit > len(emailsCopy) {
-		limit = len(emailsCopy)
+	// The index narrows full-text queries down to the emails with all
+	// their words, which are then matched for phrases and snippets
+	terms := parseQuery(filter.Text)
+	filter.Text = ""
+	var hits map[int]struct{}
+	if len(terms) > 0 {
+		hits = s.index.candidates(terms)
 	}
 
-	summaries := make([]*models.EmailSummary, limit)
-	for i := 0; i < limit; i++ {
-		email := emailsCopy[i]
-		summaries[i] = &models.EmailSummary{
-			ID:        email.ID,
-			From:      email.From,
-			To:        email.To,
-			Subject:   email.Subject,
-			CreatedAt: email.CreatedAt,
+	// Keep the emails matching the filter
+	summaries := make([]*models.EmailSummary, 0, len(s.emails))
+	for _, email := range s.emails {
+		if _, hit := hits[email.ID]; len(terms) > 0 && !hit {
+			continue
+		}
+		if !filter.Match(email) {
+			continue
 		}
+		snippet := ""
+		if len(terms) > 0 {
+			var match bool
+			if match, snippet = matchQuery(searchFields(email), terms); !match {
+				continue
+			}
+		}
+		summaries = append(summaries, summarize(email, snippet))
 	}
 
-	return summaries, nil
+	return paginate(summaries, page), nil
 }
 
 func (s *MemoryStorage) GetEmail(id int) (*models.Email, error) {
@@ -141,7 +450,7 @@ func (s *MemoryStorage) GetEmail(id int) (*models.Email, error) {
 		}
 	}
 
-	return nil, fmt.Errorf("email not found")
+	return nil, ErrNotFound
 }
 
 func (s *MemoryStorage) DeleteEmail(id int) error {
@@ -151,21 +460,59 @@ func (s *MemoryStorage) DeleteEmail(id int) error {
 	for i, email := range s.emails {
 		if email.ID == id {
 			s.emails = append(s.emails[:i], s.emails[i+1:]...)
+			s.drop(email)
 			return s.saveToFile()
 		}
 	}
 
-	return fmt.Errorf("email not found")
+	return ErrNotFound
 }
 
 func (s *MemoryStorage) ClearEmails() error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	if len(s.snapshots) > 0 {
+		for _, email := range s.emails {
+			s.drop(email)
+		}
+	} else if s.persistent() {
+		if err := os.RemoveAll(s.rawDir); err != nil {
+			return err
+		}
+	}
 	s.emails = make([]*models.Email, 0)
+	s.index.clear()
 	return s.saveToFile()
 }
 
+// persistent reports whether the emails are saved to disk
+func (s *MemoryStorage) persistent() bool {
+	return s.filePath != ""
+}
+
+// drop takes an email out of the mailbox, keeping it as a tombstone while
+// a snapshot holds it, and reports whether it is gone for good
+func (s *MemoryStorage) drop(email *models.Email) bool {
+	s.index.remove(email)
+	if held(s.snapshots, email.ID) {
+		s.tombstones[email.ID] = email
+		return false
+	}
+	s.removeRaw(email.ID)
+	return true
+}
+
+func (s *MemoryStorage) removeRaw(id int) {
+	if s.persistent() {
+		os.Remove(s.rawPath(id))
+	}
+}
+
+func (s *MemoryStorage) rawPath(id int) string {
+	return filepath.Join(s.rawDir, strconv.Itoa(id)+".eml")
+}
+
 func (s *MemoryStorage) GetEmailCount() (int, error) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
@@ -174,5 +521,213 @@ func (s *MemoryStorage) GetEmailCount() (int, error) {
 }
 
 func (s *MemoryStorage) Close() error {
+	if s.lock != nil {
+		defer s.lock.Close()
+	}
+	// Every change is saved as it is made, so a store only read is left
+	// untouched
+	if !s.unsaved {
+		return nil
+	}
 	return s.saveToFile()
-}
\ No newline at end of file
+}
+
+// memoryBackup is the backup of a MemoryStorage: its JSON file with the
+// raw messages kept inline
+type memoryBackup struct {
+	memoryFile
+	// Raw holds the raw messages by email ID
+	Raw map[int][]byte `json:"raw,omitempty"`
+}
+
+// Backup writes the emails as JSON, holding off writers meanwhile
+func (s *MemoryStorage) Backup(w io.Writer) (*BackupInfo, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	backup := memoryBackup{
+		memoryFile: memoryFile{
+			SchemaVersion: memoryMigrations[len(memoryMigrations)-1].Version,
+			NextID:        s.nextID,
+			Emails:        make([]json.RawMessage, 0, len(s.emails)),
+		},
+		Raw: make(map[int][]byte),
+	}
+	backup.Snapshots = s.snapshotRecords()
+	info := &BackupInfo{Format: BackupFormatJSON, Emails: len(s.emails)}
+	for _, list := range []struct {
+		emails []*models.Email
+		into   *[]json.RawMessage
+	}{{s.emails, &backup.Emails}, {s.sortedTombstones(), &backup.Tombstones}} {
+		for _, email := range list.emails {
+			record, err := json.Marshal(email)
+			if err != nil {
+				return nil, err
+			}
+			*list.into = append(*list.into, record)
+			if len(email.Raw) > 0 {
+				backup.Raw[email.ID] = email.Raw
+			}
+			info.Blobs = blobKeys(info.Blobs, email)
+		}
+	}
+
+	if err := json.NewEncoder(w).Encode(backup); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// restoreMemory replaces the JSON file and raw messages of dbPath with a
+// backup written by Backup
+func restoreMemory(dbPath string, r io.Reader) error {
+	var backup memoryBackup
+	if err := json.NewDecoder(r).Decode(&backup); err != nil {
+		return fmt.Errorf("invalid JSON backup: %w", err)
+	}
+
+	jsonPath := dbPath[:len(dbPath)-len(filepath.Ext(dbPath))] + ".json"
+	s := &MemoryStorage{filePath: jsonPath, rawDir: jsonPath[:len(jsonPath)-len(".json")] + "-raw"}
+	if err := os.MkdirAll(filepath.Dir(jsonPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	lock, err := restoreLock("memory-persistent", jsonPath)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	if err := os.RemoveAll(s.rawDir); err != nil {
+		return err
+	}
+	if len(backup.Raw) > 0 {
+		if err := os.MkdirAll(s.rawDir, 0755); err != nil {
+			return err
+		}
+	}
+	for id, raw := range backup.Raw {
+		if err := os.WriteFile(s.rawPath(id), raw, 0644); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(s.filePath, backup.memoryFile)
+}
+
+func (s *MemoryStorage) sortedTombstones() []*models.Email {
+	emails := make([]*models.Email, 0, len(s.tombstones))
+	for _, email := range s.tombstones {
+		emails = append(emails, email)
+	}
+	sort.Slice(emails, func(i, j int) bool { return emails[i].ID < emails[j].ID })
+	return emails
+}
+
+func (s *MemoryStorage) snapshotRecords() []snapshotRecord {
+	records := make([]snapshotRecord, len(s.snapshots))
+	for i, snapshot := range s.snapshots {
+		records[i] = snapshot.record()
+	}
+	return records
+}
+
+func (s *MemoryStorage) CreateSnapshot(name string) (*Snapshot, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, _, err := findSnapshot(s.snapshots, name); err == nil {
+		return nil, fmt.Errorf("snapshot %q: %w", name, ErrSnapshotExists)
+	}
+	ids := make([]int, len(s.emails))
+	for i, email := range s.emails {
+		ids[i] = email.ID
+	}
+	snapshot, err := newSnapshot(name, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	s.snapshots = append(s.snapshots, snapshot)
+	if err := s.saveToFile(); err != nil {
+		s.snapshots = s.snapshots[:len(s.snapshots)-1]
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+func (s *MemoryStorage) ListSnapshots() ([]*Snapshot, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return append([]*Snapshot{}, s.snapshots...), nil
+}
+
+func (s *MemoryStorage) RestoreSnapshot(name string) ([]*models.Email, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	target, others, err := findSnapshot(s.snapshots, name)
+	if err != nil {
+		return nil, err
+	}
+
+	live := make(map[int]*models.Email, len(s.emails))
+	liveIDs := make([]int, len(s.emails))
+	for i, email := range s.emails {
+		live[email.ID] = email
+		liveIDs[i] = email.ID
+	}
+	tombstoneIDs := make([]int, 0, len(s.tombstones))
+	for id := range s.tombstones {
+		tombstoneIDs = append(tombstoneIDs, id)
+	}
+	bury, purge, revive := restorePlan(target, others, liveIDs, tombstoneIDs)
+
+	var purged []*models.Email
+	for _, id := range purge {
+		purged = append(purged, live[id])
+	}
+	for _, id := range append(bury, purge...) {
+		s.drop(live[id])
+		delete(live, id)
+	}
+	for _, id := range revive {
+		live[id] = s.tombstones[id]
+		s.index.add(s.tombstones[id])
+		delete(s.tombstones, id)
+	}
+
+	s.emails = make([]*models.Email, 0, len(live))
+	for _, email := range live {
+		s.emails = append(s.emails, email)
+	}
+	sort.Slice(s.emails, func(i, j int) bool { return s.emails[i].ID < s.emails[j].ID })
+	return purged, s.saveToFile()
+}
+
+func (s *MemoryStorage) DeleteSnapshot(name string) ([]*models.Email, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, others, err := findSnapshot(s.snapshots, name)
+	if err != nil {
+		return nil, err
+	}
+	s.snapshots = others
+
+	var purged []*models.Email
+	for _, email := range s.sortedTombstones() {
+		if !held(s.snapshots, email.ID) {
+			purged = append(purged, email)
+			delete(s.tombstones, email.ID)
+			s.removeRaw(email.ID)
+		}
+	}
+	return purged, s.saveToFile()
+}
+
+func (s *MemoryStorage) Holds(id int) (bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return held(s.snapshots, id), nil
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+)
+
+// Migration is one step of a backend's schema history. Version 0 is an
+// empty database; each step upgrades from the version before it.
+type Migration struct {
+	Version     int    `json:"version"`
+	Description string `json:"description"`
+}
+
+// MigrationStatus describes the schema of an existing database
+type MigrationStatus struct {
+	Backend string      `json:"backend"`
+	Current int         `json:"current"`
+	Latest  int         `json:"latest"`
+	Pending []Migration `json:"pending"`
+}
+
+// newMigrationStatus lists the steps above the current version, refusing
+// databases written by a newer version of MailCatch
+func newMigrationStatus(backend string, current int, migrations []Migration) (*MigrationStatus, error) {
+	status := &MigrationStatus{Backend: backend, Current: current, Pending: []Migration{}}
+	if len(migrations) > 0 {
+		status.Latest = migrations[len(migrations)-1].Version
+	}
+	if current > status.Latest {
+		return nil, fmt.Errorf("%s database has schema version %d, newer than the supported version %d", backend, current, status.Latest)
+	}
+
+	for _, migration := range migrations {
+		if migration.Version > current {
+			status.Pending = append(status.Pending, migration)
+		}
+	}
+	return status, nil
+}
+
+// runMigrations applies the pending steps in order. apply must record the
+// new version together with the change, so an interrupted upgrade resumes
+// after the last completed step.
+func runMigrations(backend string, current int, migrations []Migration, apply func(Migration) error) error {
+	status, err := newMigrationStatus(backend, current, migrations)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range status.Pending {
+		log.Printf("Migrating %s database to schema version %d: %s", backend, migration.Version, migration.Description)
+		if err := apply(migration); err != nil {
+			return fmt.Errorf("migration to version %d failed: %w", migration.Version, err)
+		}
+	}
+	return nil
+}
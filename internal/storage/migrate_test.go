@@ -0,0 +1,309 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"mailcatch/internal/models"
+	"go.etcd.io/bbolt"
+)
+
+func TestRunMigrations(t *testing.T) {
+	migrations := []Migration{
+		{Version: 1, Description: "create"},
+		{Version: 2, Description: "add a column"},
+		{Version: 3, Description: "add an index"},
+	}
+	failure := errors.New("disk full")
+
+	tests := []struct {
+		name        string
+		current     int
+		failAt      int
+		wantApplied []int
+		wantErr     error
+	}{
+		{"empty database", 0, 0, []int{1, 2, 3}, nil},
+		{"partly migrated", 2, 0, []int{3}, nil},
+		{"up to date", 3, 0, nil, nil},
+		{"newer database", 4, 0, nil, errors.New("newer")},
+		{"failed step", 0, 2, []int{1, 2}, failure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var applied []int
+			err := runMigrations("test", tt.current, migrations, func(migration Migration) error {
+				applied = append(applied, migration.Version)
+				if migration.Version == tt.failAt {
+					return failure
+				}
+				return nil
+			})
+			if (err != nil) != (tt.wantErr != nil) {
+				t.Fatalf("runMigrations() = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr == failure && !errors.Is(err, failure) {
+				t.Errorf("error %v does not wrap the failure of the step", err)
+			}
+			if !reflect.DeepEqual(applied, tt.wantApplied) {
+				t.Errorf("applied %v, want %v", applied, tt.wantApplied)
+			}
+		})
+	}
+}
+
+func TestNewMigrationStatus(t *testing.T) {
+	migrations := []Migration{{Version: 1}, {Version: 2}}
+
+	status, err := newMigrationStatus("test", 1, migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Current != 1 || status.Latest != 2 || !reflect.DeepEqual(status.Pending, migrations[1:]) {
+		t.Errorf("status = %+v", status)
+	}
+
+	status, err = newMigrationStatus("test", 2, migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Pending == nil || len(status.Pending) != 0 {
+		t.Errorf("pending = %#v, want an empty list", status.Pending)
+	}
+}
+
+// writeBaselineBolt writes a database in the first Bolt layout: emails
+// under decimal string keys, sorted "1", "10", "11", "2", with the raw
+// message in the JSON record, and the next ID in the meta bucket
+func writeBaselineBolt(t *testing.T, path string, emails []map[string]any, nextID int) {
+	t.Helper()
+	db, err := bbolt.Open(path, 0644, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	err = db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucket([]byte("emails"))
+		if err != nil {
+			return err
+		}
+		for _, email := range emails {
+			data, err := json.Marshal(email)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(strconv.Itoa(email["id"].(int))), data); err != nil {
+				return err
+			}
+		}
+		// Keys of no known layout and corrupted records are skipped
+		if err := bucket.Put([]byte("junk"), []byte(`{"id": 99}`)); err != nil {
+			return err
+		}
+		if err := bucket.Put([]byte("98"), []byte("{not json")); err != nil {
+			return err
+		}
+		meta, err := tx.CreateBucket([]byte("meta"))
+		if err != nil {
+			return err
+		}
+		return meta.Put([]byte("next_id"), []byte(strconv.Itoa(nextID)))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBoltMigrateStringKeys(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var emails []map[string]any
+	for id := 1; id <= 12; id++ {
+		from, to := "alice@example.com", "bob@example.com"
+		if id%3 == 0 {
+			from, to = "carol@example.org", "bob@example.com, dave@example.net"
+		}
+		email := map[string]any{
+			"id":         id,
+			"from":       from,
+			"to":         to,
+			"subject":    fmt.Sprintf("invoice %d", id),
+			"body":       "body",
+			"html":       "",
+			"raw":        fmt.Sprintf("Subject: invoice %d\r\n\r\nbody\r\n", id),
+			"created_at": base.Add(time.Duration(id) * time.Minute),
+		}
+		if id%4 == 0 {
+			email["headers"] = map[string][]string{"Cc": {"Erin <erin@example.net>"}}
+		}
+		emails = append(emails, email)
+	}
+	writeBaselineBolt(t, filepath.Join(dir, "emails.bolt"), emails, 13)
+
+	store, err := NewBoltStorage(filepath.Join(dir, "emails.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if count, err := store.GetEmailCount(); err != nil || count != 12 {
+		t.Errorf("count %d (%v), want 12", count, err)
+	}
+
+	// Emails come in numeric order, not in the order of their string keys
+	page, err := store.GetEmails(EmailFilter{}, PageRequest{Desc: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := summaryIDs(page.Emails), []int{12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("emails %v, want %v", got, want)
+	}
+	page, err = store.GetEmails(EmailFilter{}, PageRequest{Limit: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := summaryIDs(page.Emails); !reflect.DeepEqual(got, []int{1, 2, 3, 4}) || pageTotal(page) != 12 {
+		t.Errorf("first page %v of %d, want [1 2 3 4] of 12", got, pageTotal(page))
+	}
+
+	tests := []struct {
+		filter EmailFilter
+		want   []int
+	}{
+		{EmailFilter{From: "carol@example.org"}, []int{12, 9, 6, 3}},
+		{EmailFilter{To: "dave@example.net"}, []int{12, 9, 6, 3}},
+		{EmailFilter{To: "bob@example.com"}, []int{12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1}},
+		{EmailFilter{To: "erin@example.net"}, []int{12, 8, 4}},
+		{EmailFilter{From: "alice@example.com", Since: base.Add(10 * time.Minute)}, []int{11, 10}},
+		{EmailFilter{Text: "invoice 11"}, []int{11}},
+	}
+	for _, tt := range tests {
+		page, err := store.GetEmails(tt.filter, PageRequest{Desc: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := summaryIDs(page.Emails); !reflect.DeepEqual(got, tt.want) || pageTotal(page) != len(tt.want) {
+			t.Errorf("%+v: got %v of %d, want %v", tt.filter, got, pageTotal(page), tt.want)
+		}
+	}
+
+	// The raw message moves out of the record
+	email, err := store.GetEmail(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(email.Raw) != "Subject: invoice 10\r\n\r\nbody\r\n" || email.Subject != "invoice 10" {
+		t.Errorf("email 10: subject %q raw %q", email.Subject, email.Raw)
+	}
+
+	// New emails carry on from the stored next ID
+	saved := &models.Email{From: "alice@example.com", To: "bob@example.com", Subject: "new", CreatedAt: base.Add(time.Hour)}
+	if err := store.SaveEmail(saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.ID != 13 {
+		t.Errorf("new email ID %d, want 13", saved.ID)
+	}
+	store.Close()
+
+	status, err := BoltMigrationStatus(filepath.Join(dir, "emails.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Pending) != 0 || status.Current != status.Latest {
+		t.Errorf("migration status after opening %+v", status)
+	}
+}
+
+// Databases from before versioning, and those numbered when one step added
+// the columns of several, upgrade to the latest version
+func TestSQLiteMigrateBaseline(t *testing.T) {
+	tests := []struct {
+		name    string
+		version int
+		columns string
+	}{
+		{"unversioned", 0, ""},
+		{"earlier numbering", 2, "client_ip TEXT, helo TEXT, calendar TEXT, auth TEXT, secure TEXT, attachments TEXT, warnings TEXT, raw_blob TEXT, spam TEXT, text_check TEXT, headers TEXT, size INTEGER,"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "emails.db")
+			db, err := sql.Open("sqlite3", path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = db.Exec(`
+			CREATE TABLE emails (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				from_addr TEXT NOT NULL,
+				to_addr TEXT NOT NULL,
+				subject TEXT NOT NULL,
+				body TEXT NOT NULL,
+				html TEXT,
+				raw BLOB NOT NULL,
+				` + tt.columns + `
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			);
+			INSERT INTO emails (from_addr, to_addr, subject, body, html, raw, created_at)
+			VALUES ('alice@example.com', 'bob@example.com, carol@example.org', 'old', 'body', '', 'Subject: old', '2024-01-01 12:00:00');
+			PRAGMA user_version = ` + strconv.Itoa(tt.version))
+			db.Close()
+			if err != nil {
+				// SQLite needs CGO
+				t.Skip(err)
+			}
+
+			store, err := NewSQLiteStorage(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+
+			page, err := store.GetEmails(EmailFilter{To: "carol@example.org"}, PageRequest{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := summaryIDs(page.Emails); !reflect.DeepEqual(got, []int{1}) {
+				t.Errorf("emails to carol %v, want [1]", got)
+			}
+			email, err := store.GetEmail(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if email.Subject != "old" || string(email.Raw) != "Subject: old" {
+				t.Errorf("email %q raw %q", email.Subject, email.Raw)
+			}
+			saved := &models.Email{From: "alice@example.com", To: "dave@example.net", Headers: map[string][]string{"Cc": {"carol@example.org"}}, CreatedAt: time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)}
+			if err := store.SaveEmail(saved); err != nil {
+				t.Fatal(err)
+			}
+			if saved.ID != 2 {
+				t.Errorf("new email ID %d, want 2", saved.ID)
+			}
+			page, err = store.GetEmails(EmailFilter{To: "carol@example.org"}, PageRequest{Desc: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := summaryIDs(page.Emails); !reflect.DeepEqual(got, []int{2, 1}) {
+				t.Errorf("emails to carol %v, want [2 1]", got)
+			}
+			store.Close()
+
+			status, err := SQLiteMigrationStatus(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(status.Pending) != 0 || status.Current != len(sqliteMigrations) {
+				t.Errorf("migration status after opening %+v", status)
+			}
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"mailcatch/internal/models"
+)
+
+// Sort fields of the email list; ties are broken by email ID
+const (
+	SortReceived = "received"
+	SortSubject  = "subject"
+	SortFrom     = "from"
+)
+
+// PageRequest selects a page of the email list. After and Before are
+// exclusive positions in the list; at most one of them is set.
+type PageRequest struct {
+	Limit int
+	// Sort is one of the Sort constants, SortReceived when empty
+	Sort   string
+	Desc   bool
+	After  *Cursor
+	Before *Cursor
+	// CountTotal asks for the number of matches on a page after or before
+	// a cursor. The first page always has it; backends that count with a
+	// query or scan of their own leave it out of other pages unless asked.
+	CountTotal bool
+}
+
+// EmailPage is a page of the email list. The cursors are set when there
+// are emails beyond the page in that direction.
+type EmailPage struct {
+	Emails []*models.EmailSummary `json:"emails"`
+	// Total is the number of matching emails, nil when it was not counted
+	// (see PageRequest.CountTotal)
+	Total *int `json:"total,omitempty"`
+	// TotalApproximate is set when Total is estimated, by backends that
+	// would otherwise read the whole mailbox to count the matches
+	TotalApproximate bool   `json:"total_approximate,omitempty"`
+	NextCursor       string `json:"next_cursor,omitempty"`
+	PrevCursor       string `json:"prev_cursor,omitempty"`
+}
+
+// Cursor is a position in the email list: the sort value of an email and
+// its ID
+type Cursor struct {
+	Sort string    `json:"s"`
+	Time time.Time `json:"t,omitempty"`
+	Text string    `json:"v,omitempty"`
+	ID   int       `json:"i"`
+}
+
+// CursorAt returns the position of an email in a list sorted by field
+func CursorAt(email *models.EmailSummary, field string) *Cursor {
+	cursor := &Cursor{Sort: field, ID: email.ID}
+	switch field {
+	case SortSubject:
+		cursor.Text = email.Subject
+	case SortFrom:
+		cursor.Text = email.From
+	default:
+		cursor.Sort = SortReceived
+		cursor.Time = email.CreatedAt
+	}
+	return cursor
+}
+
+// Encode returns the cursor as an opaque URL-safe string
+func (c *Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor parses a cursor returned by Encode
+func DecodeCursor(s string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	var cursor Cursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &cursor, nil
+}
+
+// validate checks the request and fills in defaults
+func (p *PageRequest) validate() error {
+	switch p.Sort {
+	case "":
+		p.Sort = SortReceived
+	case SortReceived, SortSubject, SortFrom:
+	default:
+		return fmt.Errorf("unknown sort field %q", p.Sort)
+	}
+	if p.After != nil && p.Before != nil {
+		return fmt.Errorf("after and before cannot be combined")
+	}
+	for _, cursor := range []*Cursor{p.After, p.Before} {
+		if cursor != nil && cursor.Sort != p.Sort {
+			return fmt.Errorf("cursor is for sorting by %s, not %s", cursor.Sort, p.Sort)
+		}
+	}
+	return nil
+}
+
+// start returns the cursor a page is read from and whether it is read
+// backwards from it; pages before a cursor are read backwards and reversed
+func (p *PageRequest) start() (*Cursor, bool) {
+	if p.Before != nil {
+		return p.Before, true
+	}
+	return p.After, false
+}
+
+// countsTotal reports whether backends that count the matches with a
+// query or scan of their own count them for this page
+func (p *PageRequest) countsTotal() bool {
+	return p.CountTotal || (p.After == nil && p.Before == nil)
+}
+
+// readLimit is the number of emails to read from the start of the page,
+// one more than the page size to tell whether there is a further page; 0
+// for no limit
+func (p *PageRequest) readLimit() int {
+	if p.Limit <= 0 {
+		return 0
+	}
+	return p.Limit + 1
+}
+
+// cutPage builds the page of the backends that read the list in order
+// from its start, out of the emails read up to readLimit; total is nil when
+// the matches were not counted
+func cutPage(emails []*models.EmailSummary, page PageRequest, total *int) *EmailPage {
+	cursor, backwards := page.start()
+	more := page.Limit > 0 && len(emails) > page.Limit
+	if more {
+		emails = emails[:page.Limit]
+	}
+	if backwards {
+		for i, j := 0, len(emails)-1; i < j; i, j = i+1, j-1 {
+			emails[i], emails[j] = emails[j], emails[i]
+		}
+	}
+	result := &EmailPage{Emails: emails, Total: total}
+
+	if len(emails) > 0 {
+		first, last := CursorAt(emails[0], page.Sort), CursorAt(emails[len(emails)-1], page.Sort)
+		if backwards {
+			if more {
+				result.PrevCursor = first.Encode()
+			}
+			result.NextCursor = last.Encode()
+		} else {
+			if more {
+				result.NextCursor = last.Encode()
+			}
+			if cursor != nil {
+				result.PrevCursor = first.Encode()
+			}
+		}
+	}
+	return result
+}
+
+// compareAt orders an email relative to a cursor in ascending order
+func compareAt(email *models.EmailSummary, cursor *Cursor) int {
+	var c int
+	switch cursor.Sort {
+	case SortSubject:
+		c = strings.Compare(email.Subject, cursor.Text)
+	case SortFrom:
+		c = strings.Compare(email.From, cursor.Text)
+	default:
+		switch {
+		case email.CreatedAt.Before(cursor.Time):
+			c = -1
+		case email.CreatedAt.After(cursor.Time):
+			c = 1
+		}
+	}
+	if c != 0 {
+		return c
+	}
+	switch {
+	case email.ID < cursor.ID:
+		return -1
+	case email.ID > cursor.ID:
+		return 1
+	}
+	return 0
+}
+
+// paginate sorts the matching emails of the backends that filter in Go
+// and cuts the requested page out of them
+func paginate(emails []*models.EmailSummary, page PageRequest) *EmailPage {
+	less := func(i, j int) bool {
+		c := compareAt(emails[i], CursorAt(emails[j], page.Sort))
+		if page.Desc {
+			return c > 0
+		}
+		return c < 0
+	}
+	sort.Slice(emails, less)
+
+	// position reports whether an email comes after the cursor in the
+	// requested order
+	after := func(email *models.EmailSummary, cursor *Cursor) bool {
+		c := compareAt(email, cursor)
+		if page.Desc {
+			return c < 0
+		}
+		return c > 0
+	}
+
+	start, end := 0, len(emails)
+	switch {
+	case page.After != nil:
+		start = sort.Search(len(emails), func(i int) bool { return after(emails[i], page.After) })
+		if page.Limit > 0 && start+page.Limit < end {
+			end = start + page.Limit
+		}
+	case page.Before != nil:
+		end = sort.Search(len(emails), func(i int) bool {
+			return after(emails[i], page.Before) || compareAt(emails[i], page.Before) == 0
+		})
+		if page.Limit > 0 && end-page.Limit > start {
+			start = end - page.Limit
+		}
+	default:
+		if page.Limit > 0 && page.Limit < end {
+			end = page.Limit
+		}
+	}
+
+	total := len(emails)
+	result := &EmailPage{Emails: emails[start:end], Total: &total}
+	if start > 0 && start < len(emails) {
+		result.PrevCursor = CursorAt(emails[start], page.Sort).Encode()
+	}
+	if end < len(emails) && end > 0 {
+		result.NextCursor = CursorAt(emails[end-1], page.Sort).Encode()
+	}
+	return result
+}
+
+// summarize returns the list entry of an email
+func summarize(email *models.Email, snippet string) *models.EmailSummary {
+	// Emails stored before sizes were recorded only have their raw message
+	size := email.Size
+	if size == 0 {
+		size = int64(len(email.Raw))
+	}
+
+	return &models.EmailSummary{
+		ID:           email.ID,
+		From:         email.From,
+		To:           email.To,
+		Subject:      email.Subject,
+		WarningCount: len(email.Warnings),
+		Size:         size,
+		Snippet:      snippet,
+		CreatedAt:    email.CreatedAt,
+	}
+}
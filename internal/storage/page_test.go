@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"mailcatch/internal/models"
+)
+
+func TestCursorEncoding(t *testing.T) {
+	cursors := []*Cursor{
+		{Sort: SortReceived, Time: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), ID: 3},
+		{Sort: SortSubject, Text: "Résumé & \"quotes\"", ID: 1},
+		{Sort: SortFrom, Text: "", ID: 0},
+	}
+	for _, cursor := range cursors {
+		decoded, err := DecodeCursor(cursor.Encode())
+		if err != nil {
+			t.Fatalf("DecodeCursor(%+v): %v", cursor, err)
+		}
+		if !reflect.DeepEqual(decoded, cursor) {
+			t.Errorf("decoded %+v, want %+v", decoded, cursor)
+		}
+	}
+}
+
+func TestDecodeInvalidCursor(t *testing.T) {
+	for _, s := range []string{"not base64!", "bm90IGpzb24", "W10"} {
+		if _, err := DecodeCursor(s); err == nil {
+			t.Errorf("DecodeCursor(%q) succeeded", s)
+		}
+	}
+}
+
+func TestPageRequestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		page     PageRequest
+		wantSort string
+		wantErr  bool
+	}{
+		{"default sort", PageRequest{}, SortReceived, false},
+		{"subject", PageRequest{Sort: SortSubject}, SortSubject, false},
+		{"unknown sort", PageRequest{Sort: "size"}, "", true},
+		{"after and before", PageRequest{After: &Cursor{Sort: SortReceived}, Before: &Cursor{Sort: SortReceived}}, "", true},
+		{"cursor of the default sort", PageRequest{After: &Cursor{Sort: SortReceived}}, SortReceived, false},
+		{"cursor of another sort", PageRequest{Sort: SortFrom, Before: &Cursor{Sort: SortSubject}}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.page.validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validate() = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && tt.page.Sort != tt.wantSort {
+				t.Errorf("sort = %q, want %q", tt.page.Sort, tt.wantSort)
+			}
+		})
+	}
+}
+
+func TestCutPage(t *testing.T) {
+	summaries := func(ids ...int) []*models.EmailSummary {
+		emails := make([]*models.EmailSummary, len(ids))
+		for i, id := range ids {
+			emails[i] = &models.EmailSummary{ID: id, Subject: "email"}
+		}
+		return emails
+	}
+	cursor := &Cursor{Sort: SortSubject, Text: "email", ID: 5}
+
+	tests := []struct {
+		name     string
+		read     []int
+		page     PageRequest
+		want     []int
+		wantPrev int
+		wantNext int
+	}{
+		{"first page", []int{1, 2, 3, 4}, PageRequest{Limit: 3}, []int{1, 2, 3}, 0, 3},
+		{"last page", []int{6, 7}, PageRequest{Limit: 3, After: cursor}, []int{6, 7}, 6, 0},
+		{"middle page", []int{6, 7, 8, 9}, PageRequest{Limit: 3, After: cursor}, []int{6, 7, 8}, 6, 8},
+		{"page before", []int{4, 3, 2, 1}, PageRequest{Limit: 3, Before: cursor}, []int{2, 3, 4}, 2, 4},
+		{"first page before", []int{4, 3}, PageRequest{Limit: 3, Before: cursor}, []int{3, 4}, 0, 4},
+		{"no limit", []int{1, 2, 3, 4}, PageRequest{}, []int{1, 2, 3, 4}, 0, 0},
+		{"empty", nil, PageRequest{Limit: 3, After: cursor}, []int{}, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.page.Sort = SortSubject
+			total := 9
+			result := cutPage(summaries(tt.read...), tt.page, &total)
+			if got := summaryIDs(result.Emails); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("emails = %v, want %v", got, tt.want)
+			}
+			if total := pageTotal(result); total != 9 {
+				t.Errorf("total = %d, want 9", total)
+			}
+			if got := cursorID(t, result.PrevCursor); got != tt.wantPrev {
+				t.Errorf("prev cursor at %d, want %d", got, tt.wantPrev)
+			}
+			if got := cursorID(t, result.NextCursor); got != tt.wantNext {
+				t.Errorf("next cursor at %d, want %d", got, tt.wantNext)
+			}
+		})
+	}
+}
+
+// cursorID returns the email ID of an encoded cursor, 0 for no cursor
+func cursorID(t *testing.T, encoded string) int {
+	t.Helper()
+	if encoded == "" {
+		return 0
+	}
+	cursor, err := DecodeCursor(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cursor.ID
+}
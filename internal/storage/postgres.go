@@ -0,0 +1,645 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"mailcatch/internal/models"
+	"github.com/lib/pq"
+)
+
+// PostgresStorage keeps emails in PostgreSQL, for instances shared by a
+// team. Full-text queries use a tsvector column.
+type PostgresStorage struct {
+	db *sql.DB
+	*sqlSnapshots
+}
+
+// PostgresOptions are the connection settings of PostgresStorage
+type PostgresOptions struct {
+	// DSN is a connection URL or key=value string; libpq environment
+	// variables such as PGHOST and PGPASSWORD fill in what it leaves out
+	DSN             string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+// pgAdvisoryLock serializes migrations of instances sharing a database
+const pgAdvisoryLock = 0x6d61696c // "mail"
+
+// pgSnapshotLock serializes the snapshot operations and email deletions of
+// instances sharing a database
+const pgSnapshotLock = 0x736e6170 // "snap"
+
+func init() {
+	Register(&Backend{
+		Name:        "postgres",
+		Description: "PostgreSQL database given by the dsn option or the PG* environment variables",
+		Options:     []string{"dsn", "max_open_conns", "max_idle_conns", "conn_max_lifetime", "conn_max_idle_time"},
+		Open: func(path string, options Options) (Storage, error) {
+			pgOptions, err := postgresOptions(options)
+			if err != nil {
+				return nil, err
+			}
+			return NewPostgresStorage(pgOptions)
+		},
+		MigrationStatus: func(path string, options Options) (*MigrationStatus, error) {
+			return PostgresMigrationStatus(options["dsn"])
+		},
+	})
+}
+
+func postgresOptions(options Options) (PostgresOptions, error) {
+	pgOptions := PostgresOptions{DSN: options["dsn"]}
+	var err error
+	if pgOptions.MaxOpenConns, err = options.Int("max_open_conns", 10); err != nil {
+		return pgOptions, err
+	}
+	if pgOptions.MaxIdleConns, err = options.Int("max_idle_conns", 2); err != nil {
+		return pgOptions, err
+	}
+	if pgOptions.ConnMaxLifetime, err = options.Duration("conn_max_lifetime", 30*time.Minute); err != nil {
+		return pgOptions, err
+	}
+	if pgOptions.ConnMaxIdleTime, err = options.Duration("conn_max_idle_time", 5*time.Minute); err != nil {
+		return pgOptions, err
+	}
+	return pgOptions, nil
+}
+
+func NewPostgresStorage(options PostgresOptions) (*PostgresStorage, error) {
+	db, err := sql.Open("postgres", options.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	db.SetMaxOpenConns(options.MaxOpenConns)
+	db.SetMaxIdleConns(options.MaxIdleConns)
+	db.SetConnMaxLifetime(options.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(options.ConnMaxIdleTime)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	storage := &PostgresStorage{db: db}
+	storage.sqlSnapshots = &sqlSnapshots{
+		db:     db,
+		rebind: pgRebind,
+		begin: func() (*sql.Tx, error) {
+			tx, err := db.Begin()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, pgSnapshotLock); err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+			return tx, nil
+		},
+		getEmail: func(q sqlQuerier, id int) (*models.Email, error) {
+			return getPostgresEmail(q, `WHERE id = $1`, id)
+		},
+	}
+	if err := storage.createTables(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create tables: %w", err)
+	}
+
+	return storage, nil
+}
+
+// postgresMigrations is the schema history. The version is kept in the
+// single row of mailcatch_schema.
+var postgresMigrations = []struct {
+	Migration
+	up func(tx *sql.Tx) error
+}{
+	{Migration{1, "create the emails table and its full-text index"}, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+		CREATE TABLE emails (
+			id BIGSERIAL PRIMARY KEY,
+			from_addr TEXT NOT NULL,
+			to_addr TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			body TEXT NOT NULL,
+			html TEXT NOT NULL DEFAULT '',
+			raw BYTEA NOT NULL,
+			client_ip TEXT,
+			helo TEXT,
+			calendar JSONB,
+			auth JSONB,
+			secure JSONB,
+			attachments JSONB,
+			warnings JSONB,
+			raw_blob TEXT,
+			size BIGINT,
+			spam JSONB,
+			text_check JSONB,
+			headers JSONB,
+			search_fields TEXT[],
+			search TSVECTOR,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+
+		CREATE INDEX emails_created_at ON emails (created_at, id);
+		CREATE INDEX emails_search ON emails USING GIN (search);
+		`)
+		return err
+	}},
+	{Migration{2, "add the import source column"}, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`ALTER TABLE emails ADD COLUMN IF NOT EXISTS source JSONB`)
+		return err
+	}},
+	{Migration{3, "add the snapshots table and the tombstone column"}, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+		ALTER TABLE emails ADD COLUMN IF NOT EXISTS tombstone BOOLEAN NOT NULL DEFAULT false;
+		CREATE TABLE IF NOT EXISTS snapshots (name TEXT PRIMARY KEY, record TEXT NOT NULL);
+		`)
+		return err
+	}},
+	{Migration{4, "add the recipients column and its index"}, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+		ALTER TABLE emails ADD COLUMN IF NOT EXISTS recipients TEXT[];
+		CREATE INDEX IF NOT EXISTS emails_recipients ON emails USING GIN (recipients);
+		`)
+		if err != nil {
+			return err
+		}
+		return backfillPostgresRecipients(tx)
+	}},
+}
+
+// backfillPostgresRecipients fills the recipients column of emails stored
+// before it existed
+func backfillPostgresRecipients(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT id, to_addr, headers FROM emails WHERE recipients IS NULL`)
+	if err != nil {
+		return err
+	}
+	recipients := make(map[int][]string)
+	for rows.Next() {
+		var (
+			id      int
+			email   models.Email
+			headers sql.NullString
+		)
+		if err := rows.Scan(&id, &email.To, &headers); err != nil {
+			rows.Close()
+			return err
+		}
+		decodeJSON(headers, &email.Headers)
+		recipients[id] = pgRecipients(&email)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, list := range recipients {
+		if _, err := tx.Exec(`UPDATE emails SET recipients = $1 WHERE id = $2`, pq.Array(list), id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pgRecipients lists the recipients of an email for the recipients column,
+// empty rather than NULL for an email without any
+func pgRecipients(email *models.Email) []string {
+	recipients := recipientAddresses(email)
+	if recipients == nil {
+		recipients = []string{}
+	}
+	for i, address := range recipients {
+		recipients[i] = pgText(address)
+	}
+	return recipients
+}
+
+func postgresMigrationList() []Migration {
+	migrations := make([]Migration, len(postgresMigrations))
+	for i, step := range postgresMigrations {
+		migrations[i] = step.Migration
+	}
+	return migrations
+}
+
+// pgQuerier is implemented by *sql.DB and *sql.Tx
+type pgQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// pgSchemaVersion reads the schema version, 0 for an empty database
+func pgSchemaVersion(q pgQuerier) (int, error) {
+	var exists bool
+	if err := q.QueryRow(`SELECT to_regclass('mailcatch_schema') IS NOT NULL`).Scan(&exists); err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, nil
+	}
+
+	var version int
+	err := q.QueryRow(`SELECT version FROM mailcatch_schema`).Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return version, err
+}
+
+// PostgresMigrationStatus reports the migrations opening the database
+// would apply, without modifying it
+func PostgresMigrationStatus(dsn string) (*MigrationStatus, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	current, err := pgSchemaVersion(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return newMigrationStatus("PostgreSQL", current, postgresMigrationList())
+}
+
+// createTables brings the schema up to date. Each step runs in a
+// transaction holding an advisory lock, so when several instances start
+// at once only one applies it.
+func (s *PostgresStorage) createTables() error {
+	current, err := pgSchemaVersion(s.db)
+	if err != nil {
+		return err
+	}
+
+	return runMigrations("PostgreSQL", current, postgresMigrationList(), func(migration Migration) error {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, pgAdvisoryLock); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS mailcatch_schema (version INTEGER NOT NULL)`); err != nil {
+			return err
+		}
+		version, err := pgSchemaVersion(tx)
+		if err != nil {
+			return err
+		}
+		if version >= migration.Version {
+			return nil // Applied by another instance meanwhile
+		}
+
+		if err := postgresMigrations[migration.Version-1].up(tx); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM mailcatch_schema`); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO mailcatch_schema (version) VALUES ($1)`, migration.Version); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+// pgText makes a string storable in a TEXT column, which must be valid
+// UTF-8 without NUL characters
+func pgText(s string) string {
+	return strings.ReplaceAll(strings.ToValidUTF8(s, "\uFFFD"), "\x00", "")
+}
+
+// pgJSON is encodeJSON for JSONB columns, which reject the NUL character
+// even when escaped
+func pgJSON(v interface{}) (sql.NullString, error) {
+	column, err := encodeJSON(v)
+	if err != nil || !strings.Contains(column.String, `\u0000`) {
+		return column, err
+	}
+
+	// Escapes are copied in pairs so an escaped backslash followed by
+	// "u0000" is left alone
+	var b strings.Builder
+	s := column.String
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], `\u0000`):
+			b.WriteString(`\ufffd`)
+			i += len(`\u0000`) - 1
+		case s[i] == '\\' && i+1 < len(s):
+			b.WriteString(s[i : i+2])
+			i++
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	column.String = b.String()
+	return column, nil
+}
+
+// pgArgs collects query arguments and hands out their placeholders
+type pgArgs []interface{}
+
+func (a *pgArgs) add(v interface{}) string {
+	*a = append(*a, v)
+	return "$" + strconv.Itoa(len(*a))
+}
+
+// tsText reduces text to the words tokenize finds, so the tsvector splits
+// words the way queries and the other backends do
+func tsText(fields []string) string {
+	var words []string
+	for _, field := range fields {
+		for _, token := range tokenize(field) {
+			words = append(words, token.word)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// tsQuery renders terms as a tsquery; the words are quoted lexemes, so
+// user input cannot form operators
+func tsQuery(terms []searchTerm) string {
+	parts := make([]string, len(terms))
+	for i, term := range terms {
+		words := make([]string, len(term.words))
+		for j, word := range term.words {
+			words[j] = "'" + word + "'"
+		}
+		if term.prefix {
+			words[len(words)-1] += ":*"
+		}
+		parts[i] = "(" + strings.Join(words, " <-> ") + ")"
+	}
+	return strings.Join(parts, " & ")
+}
+
+func (s *PostgresStorage) SaveEmail(email *models.Email) error {
+	query := `
+		INSERT INTO emails (from_addr, to_addr, subject, body, html, raw, client_ip, helo, calendar, auth, secure, attachments, warnings, raw_blob, size, spam, text_check, headers, source, search_fields, search, recipients, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, to_tsvector('simple', $21), $22, $23)
+		RETURNING id
+	`
+
+	var columns []sql.NullString
+	for _, v := range []interface{}{email.Calendar, email.Auth, email.Secure, email.Attachments, email.Warnings, email.Spam, email.TextCheck, email.Headers, email.Source} {
+		column, err := pgJSON(v)
+		if err != nil {
+			return err
+		}
+		columns = append(columns, column)
+	}
+
+	fields := searchFields(email)
+	for i, field := range fields {
+		fields[i] = pgText(field)
+	}
+
+	raw := email.Raw
+	if raw == nil {
+		raw = []byte{}
+	}
+
+	var id int
+	err := s.db.QueryRow(query, pgText(email.From), pgText(email.To), pgText(email.Subject),
+		pgText(email.Body), pgText(email.HTML), raw, pgText(email.ClientIP), pgText(email.Helo),
+		columns[0], columns[1], columns[2], columns[3], columns[4], email.RawBlob, email.Size,
+		columns[5], columns[6], columns[7], columns[8], pq.Array(fields), tsText(fields), pq.Array(pgRecipients(email)), email.CreatedAt,
+	).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	email.ID = id
+	return nil
+}
+
+// postgresSortExpressions are the ORDER BY expressions of the sort fields.
+// The C collation sorts text by bytes, as the other backends do.
+var postgresSortExpressions = map[string]string{
+	SortReceived: "created_at",
+	SortSubject:  `subject COLLATE "C"`,
+	SortFrom:     `from_addr COLLATE "C"`,
+}
+
+func (s *PostgresStorage) GetEmails(filter EmailFilter, page PageRequest) (*EmailPage, error) {
+	if err := page.validate(); err != nil {
+		return nil, err
+	}
+
+	args := pgArgs{}
+	conditions := postgresFilterConditions(filter, &args)
+
+	// Counting takes a query of its own, so pages after the first count
+	// the matches only when asked to
+	var total *int
+	if page.countsTotal() {
+		total = new(int)
+		countQuery := `SELECT COUNT(*) FROM emails ` + whereClause(conditions)
+		if err := s.db.QueryRow(countQuery, args...).Scan(total); err != nil {
+			return nil, err
+		}
+	}
+
+	cursor, backwards := page.start()
+	ascending := page.Desc == backwards
+	direction, op := "ASC", ">"
+	if !ascending {
+		direction, op = "DESC", "<"
+	}
+
+	expr := postgresSortExpressions[page.Sort]
+	if cursor != nil {
+		var value interface{} = cursor.Text
+		if page.Sort == SortReceived {
+			value = cursor.Time
+		}
+		conditions = append(conditions, "("+expr+", id) "+op+" ("+args.add(value)+", "+args.add(cursor.ID)+")")
+	}
+
+	// Full-text matches come with a snippet, made from the stored fields
+	terms := parseQuery(filter.Text)
+	fields := "NULL::TEXT[]"
+	if len(terms) > 0 {
+		fields = "search_fields"
+	}
+
+	limit := "ALL"
+	if readLimit := page.readLimit(); readLimit > 0 {
+		limit = args.add(readLimit)
+	}
+
+	query := `
+		SELECT id, from_addr, to_addr, subject, COALESCE(jsonb_array_length(warnings), 0), COALESCE(NULLIF(size, 0), length(raw)), ` + fields + `, created_at
+		FROM emails
+		` + whereClause(conditions) + `
+		ORDER BY ` + expr + ` ` + direction + `, id ` + direction + `
+		LIMIT ` + limit
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	emails := []*models.EmailSummary{}
+	for rows.Next() {
+		email := &models.EmailSummary{}
+		var searchFields []string
+		err := rows.Scan(&email.ID, &email.From, &email.To,
+			&email.Subject, &email.WarningCount, &email.Size, pq.Array(&searchFields), &email.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if len(terms) > 0 {
+			_, email.Snippet = matchQuery(searchFields, terms)
+		}
+		emails = append(emails, email)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return cutPage(emails, page, total), nil
+}
+
+// postgresFilterConditions translates a filter into the conditions of a
+// WHERE clause, adding their arguments to args
+func postgresFilterConditions(filter EmailFilter, args *pgArgs) []string {
+	conditions := []string{"NOT tombstone"}
+
+	if filter.HasWarnings != nil {
+		if *filter.HasWarnings {
+			conditions = append(conditions, "COALESCE(jsonb_array_length(warnings), 0) > 0")
+		} else {
+			conditions = append(conditions, "COALESCE(jsonb_array_length(warnings), 0) = 0")
+		}
+	}
+	if filter.Warning != "" {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM jsonb_array_elements(warnings) w WHERE w->>'code' = "+args.add(filter.Warning)+")")
+	}
+	for _, match := range []struct{ column, value string }{
+		{"from_addr", filter.From},
+		{"to_addr", filter.To},
+		{"subject", filter.Subject},
+	} {
+		if match.value == "" {
+			continue
+		}
+		// The address columns hold bare addresses separated by commas; a
+		// whole recipient address also matches the To and Cc headers
+		if address, ok := filterAddress(match.value); ok && match.column == "to_addr" {
+			conditions = append(conditions, "recipients @> ARRAY["+args.add(pgText(address))+"]::text[]")
+			continue
+		}
+		if address, ok := filterAddress(match.value); ok && match.column != "subject" {
+			conditions = append(conditions, "strpos(',' || replace(lower("+match.column+"), ' ', '') || ',', "+args.add(pgText(","+address+","))+") > 0")
+			continue
+		}
+		conditions = append(conditions, "strpos(lower("+match.column+"), lower("+args.add(pgText(match.value))+")) > 0")
+	}
+	if terms := parseQuery(filter.Text); len(terms) > 0 {
+		conditions = append(conditions, "search @@ to_tsquery('simple', "+args.add(tsQuery(terms))+")")
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "created_at >= "+args.add(filter.Since))
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "created_at <= "+args.add(filter.Until))
+	}
+	if filter.HasAttachments != nil {
+		if *filter.HasAttachments {
+			conditions = append(conditions, "COALESCE(jsonb_array_length(attachments), 0) > 0")
+		} else {
+			conditions = append(conditions, "COALESCE(jsonb_array_length(attachments), 0) = 0")
+		}
+	}
+	for _, header := range filter.Headers {
+		// Header names are stored canonicalized, as JSON object keys
+		name := args.add(textproto.CanonicalMIMEHeaderKey(header.Name))
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM jsonb_array_elements_text(headers -> "+name+") v WHERE strpos(lower(v), lower("+args.add(pgText(header.Value))+")) > 0)")
+	}
+
+	return conditions
+}
+
+func (s *PostgresStorage) GetEmail(id int) (*models.Email, error) {
+	return getPostgresEmail(s.db, `WHERE id = $1 AND NOT tombstone`, id)
+}
+
+// getPostgresEmail reads the email matching a WHERE clause
+func getPostgresEmail(q sqlQuerier, where string, args ...interface{}) (*models.Email, error) {
+	query := `
+		SELECT id, from_addr, to_addr, subject, body, html, raw, client_ip, helo, calendar, auth, secure, attachments, warnings, raw_blob, size, spam, text_check, headers, source, created_at
+		FROM emails
+		` + where
+
+	email := &models.Email{}
+	var clientIP, helo, calendar, auth, secure, attachments, warnings, rawBlob, spam, textCheck, headers, source sql.NullString
+	var size sql.NullInt64
+	err := q.QueryRow(query, args...).Scan(
+		&email.ID, &email.From, &email.To, &email.Subject,
+		&email.Body, &email.HTML, &email.Raw, &clientIP, &helo,
+		&calendar, &auth, &secure, &attachments, &warnings, &rawBlob, &size, &spam, &textCheck, &headers, &source, &email.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	email.ClientIP = clientIP.String
+	email.Helo = helo.String
+	email.RawBlob = rawBlob.String
+	email.Size = size.Int64
+
+	for _, column := range []struct {
+		value sql.NullString
+		v     interface{}
+	}{
+		{calendar, &email.Calendar},
+		{auth, &email.Auth},
+		{secure, &email.Secure},
+		{attachments, &email.Attachments},
+		{warnings, &email.Warnings},
+		{spam, &email.Spam},
+		{textCheck, &email.TextCheck},
+		{headers, &email.Headers},
+		{source, &email.Source},
+	} {
+		if err := decodeJSON(column.value, column.v); err != nil {
+			return nil, err
+		}
+	}
+
+	return email, nil
+}
+
+func (s *PostgresStorage) DeleteEmail(id int) error {
+	return s.deleteEmail(id)
+}
+
+func (s *PostgresStorage) ClearEmails() error {
+	return s.clearEmails()
+}
+
+func (s *PostgresStorage) GetEmailCount() (int, error) {
+	var count int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM emails WHERE NOT tombstone`).Scan(&count)
+	return count, err
+}
+
+func (s *PostgresStorage) Close() error {
+	return s.db.Close()
+}
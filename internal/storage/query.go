@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseFilter reads a filter from the search parameters of the email list
+// API, which the export command accepts as well
+func ParseFilter(query url.Values) (EmailFilter, error) {
+	filter := EmailFilter{
+		Warning: query.Get("warning"),
+		From:    query.Get("from"),
+		To:      query.Get("to"),
+		Subject: query.Get("subject"),
+		Text:    query.Get("q"),
+	}
+
+	for name, target := range map[string]**bool{
+		"has_warnings":    &filter.HasWarnings,
+		"has_attachments": &filter.HasAttachments,
+	} {
+		if param := query.Get(name); param != "" {
+			value, err := strconv.ParseBool(param)
+			if err != nil {
+				return filter, fmt.Errorf("Invalid %s parameter", name)
+			}
+			*target = &value
+		}
+	}
+
+	var err error
+	if filter.Since, err = parseTimeParam(query.Get("since"), false); err != nil {
+		return filter, fmt.Errorf("Invalid since parameter")
+	}
+	if filter.Until, err = parseTimeParam(query.Get("until"), true); err != nil {
+		return filter, fmt.Errorf("Invalid until parameter")
+	}
+
+	// header=Name or header=Name:value, repeatable
+	for _, param := range query["header"] {
+		name, value, _ := strings.Cut(param, ":")
+		name = strings.TrimSpace(name)
+		if !validHeaderName(name) {
+			return filter, fmt.Errorf("Invalid header parameter")
+		}
+		filter.Headers = append(filter.Headers, HeaderMatch{Name: name, Value: strings.TrimSpace(value)})
+	}
+
+	return filter, nil
+}
+
+// parseTimeParam accepts RFC 3339 times and dates; a date used as an upper
+// bound covers the whole day
+func parseTimeParam(param string, endOfDay bool) (time.Time, error) {
+	if param == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, param); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", param, time.Local)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	return t, nil
+}
+
+// validHeaderName reports whether name is a header field name as defined
+// by RFC 5322, printable ASCII except the colon. Quotes and backslashes are
+// rejected as well since the SQLite backend looks names up through JSON paths.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c <= ' ' || c > '~' || c == ':' || c == '"' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
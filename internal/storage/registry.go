@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Backend is a storage implementation selectable by name
+type Backend struct {
+	Name        string
+	Description string
+	// Ephemeral backends keep everything in memory and write nothing to
+	// disk
+	Ephemeral bool
+	// Options lists the backend-specific options Open accepts
+	Options []string
+	// Open opens the database at path, which backends give their own
+	// extension
+	Open func(path string, options Options) (Storage, error)
+	// MigrationStatus reports the migrations Open would apply without
+	// modifying the database; nil for backends without a schema on disk
+	MigrationStatus func(path string, options Options) (*MigrationStatus, error)
+	// BackupFormat is the format of the backups written by the storages
+	// of the backend, empty if they cannot be backed up
+	BackupFormat string
+	// Restore replaces the database at path with a backup of its format,
+	// nil for backends that cannot be restored. The database must not be
+	// in use.
+	Restore func(path string, options Options, r io.Reader) error
+}
+
+var backends = make(map[string]*Backend)
+
+// Register makes a backend available by name. It panics if the name is
+// already taken.
+func Register(backend *Backend) {
+	if _, dup := backends[backend.Name]; dup {
+		panic("storage: backend registered twice: " + backend.Name)
+	}
+	backends[backend.Name] = backend
+}
+
+// Backends returns the registered backends sorted by name
+func Backends() []*Backend {
+	list := make([]*Backend, 0, len(backends))
+	for _, backend := range backends {
+		list = append(list, backend)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Lookup returns the backend registered under name
+func Lookup(name string) (*Backend, error) {
+	backend, ok := backends[name]
+	if !ok {
+		var names []string
+		for _, backend := range Backends() {
+			names = append(names, backend.Name)
+		}
+		return nil, fmt.Errorf("unknown storage backend %q (available: %s)", name, strings.Join(names, ", "))
+	}
+	return backend, nil
+}
+
+// Open opens the database at path with the named backend, rejecting
+// options the backend does not know
+func Open(name, path string, options Options) (Storage, error) {
+	backend, err := Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	for key := range options {
+		known := false
+		for _, option := range backend.Options {
+			known = known || option == key
+		}
+		if !known {
+			return nil, fmt.Errorf("storage backend %s has no option %q (options: %s)", name, key, strings.Join(backend.Options, ", "))
+		}
+	}
+
+	return backend.Open(path, options)
+}
+
+// Options are backend-specific settings given as key=value pairs
+type Options map[string]string
+
+// ParseOptions parses comma-separated key=value pairs
+func ParseOptions(s string) (Options, error) {
+	options := make(Options)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("invalid storage option %q, expected key=value", pair)
+		}
+		options[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return options, nil
+}
+
+// Int returns an integer option, or def when it is not set
+func (o Options) Int(key string, def int) (int, error) {
+	value, ok := o[key]
+	if !ok {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q for storage option %s", value, key)
+	}
+	return n, nil
+}
+
+// Bool returns a boolean option, or def when it is not set
+func (o Options) Bool(key string, def bool) (bool, error) {
+	value, ok := o[key]
+	if !ok {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid value %q for storage option %s", value, key)
+	}
+	return b, nil
+}
+
+// Duration returns a duration option, or def when it is not set
+func (o Options) Duration(key string, def time.Duration) (time.Duration, error) {
+	value, ok := o[key]
+	if !ok {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q for storage option %s", value, key)
+	}
+	return d, nil
+}
@@ -0,0 +1,368 @@
+package storage
+
+import (
+	"html"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"mailcatch/internal/models"
+	"mailcatch/internal/textcheck"
+)
+
+// Full-text queries are a list of terms that must all match: words,
+// "quoted phrases" and prefixes ending in *. SQLite evaluates them with
+// FTS5; the other backends use searchIndex.
+
+// snippetTokens is the number of words around a match shown in a snippet
+const snippetTokens = 12
+
+// Highlight markers put around matches before a snippet is HTML-escaped
+const (
+	markStart = "\x01"
+	markEnd   = "\x02"
+)
+
+// searchTerm is a word or phrase of a full-text query
+type searchTerm struct {
+	words []string
+	// prefix is set when the last word only has to start a word
+	prefix bool
+}
+
+// token is a word of an indexed text and its byte offsets
+type token struct {
+	word       string
+	start, end int
+}
+
+// parseQuery splits a full-text query into terms. Words are normalized
+// the way indexed text is, so "e-mail" searches for the phrase "e mail".
+func parseQuery(query string) []searchTerm {
+	var terms []searchTerm
+	for query != "" {
+		query = strings.TrimLeftFunc(query, unicode.IsSpace)
+		if query == "" {
+			break
+		}
+
+		var raw string
+		if query[0] == '"' {
+			end := strings.IndexByte(query[1:], '"')
+			if end == -1 {
+				raw, query = query[1:], ""
+			} else {
+				raw, query = query[1:end+1], query[end+2:]
+			}
+		} else {
+			end := strings.IndexFunc(query, unicode.IsSpace)
+			if end == -1 {
+				end = len(query)
+			}
+			raw, query = query[:end], query[end:]
+		}
+
+		// A trailing * makes the last word a prefix, also right after a
+		// closing quote
+		prefix := strings.HasSuffix(raw, "*")
+		if strings.HasPrefix(query, "*") {
+			prefix = true
+			query = query[1:]
+		}
+
+		var words []string
+		for _, t := range tokenize(raw) {
+			words = append(words, t.word)
+		}
+		if len(words) > 0 {
+			terms = append(terms, searchTerm{words: words, prefix: prefix})
+		}
+	}
+	return terms
+}
+
+// tokenize splits text into lower case words of letters and digits
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWord && start == -1 {
+			start = i
+		} else if !isWord && start != -1 {
+			tokens = append(tokens, token{strings.ToLower(text[start:i]), start, i})
+			start = -1
+		}
+	}
+	if start != -1 {
+		tokens = append(tokens, token{strings.ToLower(text[start:]), start, len(text)})
+	}
+	return tokens
+}
+
+// ftsQuery renders terms as an FTS5 query; every word is quoted so user
+// input cannot form FTS5 operators
+func ftsQuery(terms []searchTerm) string {
+	parts := make([]string, len(terms))
+	for i, term := range terms {
+		parts[i] = `"` + strings.Join(term.words, " ") + `"`
+		if term.prefix {
+			parts[i] += "*"
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// searchFields returns the indexed texts of an email: subject, text body,
+// HTML body as text, addresses and attachment filenames
+func searchFields(email *models.Email) []string {
+	htmlText := ""
+	if email.TextCheck != nil {
+		htmlText = email.TextCheck.Generated
+	} else if email.HTML != "" {
+		htmlText = textcheck.Render(email.HTML)
+	}
+
+	filenames := make([]string, 0, len(email.Attachments))
+	for _, attachment := range email.Attachments {
+		filenames = append(filenames, attachment.Filename)
+	}
+
+	return []string{
+		email.Subject,
+		email.Body,
+		htmlText,
+		email.From + " " + email.To,
+		strings.Join(filenames, " "),
+	}
+}
+
+// searchWords returns the distinct words of the indexed texts of an email
+func searchWords(email *models.Email) []string {
+	seen := make(map[string]bool)
+	var words []string
+	for _, field := range searchFields(email) {
+		for _, t := range tokenize(field) {
+			if !seen[t.word] {
+				seen[t.word] = true
+				words = append(words, t.word)
+			}
+		}
+	}
+	return words
+}
+
+// formatSnippet collapses the whitespace of a snippet, HTML-escapes it and
+// turns the highlight markers into <mark> elements
+func formatSnippet(snippet string) string {
+	snippet = html.EscapeString(strings.Join(strings.Fields(snippet), " "))
+	snippet = strings.ReplaceAll(snippet, markStart, "<mark>")
+	return strings.ReplaceAll(snippet, markEnd, "</mark>")
+}
+
+// matchQuery reports whether fields match every term and returns a
+// snippet of the first field with a match
+func matchQuery(fields []string, terms []searchTerm) (bool, string) {
+	tokenized := make([][]token, len(fields))
+	for i, field := range fields {
+		tokenized[i] = tokenize(field)
+	}
+
+	// matched[field] marks the tokens that are part of a match
+	matched := make([]map[int]bool, len(fields))
+	for _, term := range terms {
+		found := false
+		for i, tokens := range tokenized {
+			for start := range tokens {
+				if termAt(tokens, start, term) {
+					if matched[i] == nil {
+						matched[i] = make(map[int]bool)
+					}
+					for n := range term.words {
+						matched[i][start+n] = true
+					}
+					found = true
+				}
+			}
+		}
+		if !found {
+			return false, ""
+		}
+	}
+
+	// The snippet comes from the field with the most matching words
+	best := -1
+	for i, marks := range matched {
+		if best == -1 && marks != nil || best != -1 && len(marks) > len(matched[best]) {
+			best = i
+		}
+	}
+	if best == -1 {
+		return true, ""
+	}
+	return true, snippet(fields[best], tokenized[best], matched[best])
+}
+
+// termAt reports whether a term matches the tokens from start on
+func termAt(tokens []token, start int, term searchTerm) bool {
+	if start+len(term.words) > len(tokens) {
+		return false
+	}
+	for n, word := range term.words {
+		actual := tokens[start+n].word
+		if term.prefix && n == len(term.words)-1 {
+			if !strings.HasPrefix(actual, word) {
+				return false
+			}
+		} else if actual != word {
+			return false
+		}
+	}
+	return true
+}
+
+// snippet returns the text around the first highlighted token
+func snippet(text string, tokens []token, marks map[int]bool) string {
+	first := len(tokens)
+	for i := range marks {
+		if i < first {
+			first = i
+		}
+	}
+
+	from := first - snippetTokens/4
+	if from < 0 {
+		from = 0
+	}
+	to := from + snippetTokens
+	if to > len(tokens) {
+		to = len(tokens)
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	pos := tokens[from].start
+	for i := from; i < to; i++ {
+		b.WriteString(text[pos:tokens[i].start])
+		if marks[i] {
+			b.WriteString(markStart + text[tokens[i].start:tokens[i].end] + markEnd)
+		} else {
+			b.WriteString(text[tokens[i].start:tokens[i].end])
+		}
+		pos = tokens[i].end
+	}
+	if to < len(tokens) {
+		b.WriteString("…")
+	}
+	return formatSnippet(b.String())
+}
+
+// candidates intersects the postings of the words of the terms. postings
+// returns the IDs of the emails with a word, or with a word starting with
+// it when prefix is set.
+func candidates(terms []searchTerm, postings func(word string, prefix bool) map[int]struct{}) map[int]struct{} {
+	var ids map[int]struct{}
+	for _, term := range terms {
+		for n, word := range term.words {
+			found := postings(word, term.prefix && n == len(term.words)-1)
+			if ids == nil {
+				ids = found
+				continue
+			}
+			for id := range ids {
+				if _, ok := found[id]; !ok {
+					delete(ids, id)
+				}
+			}
+		}
+	}
+	return ids
+}
+
+// searchIndex is an in-memory inverted index from words to email IDs for
+// the memory backend. It only narrows down the candidates, which are then
+// matched word by word against their fields for phrases and snippets.
+type searchIndex struct {
+	mutex    sync.Mutex
+	postings map[string]map[int]struct{}
+	words    []string // sorted keys of postings, for prefix lookups
+	sorted   bool
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{postings: make(map[string]map[int]struct{})}
+}
+
+func (x *searchIndex) add(email *models.Email) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	for _, word := range searchWords(email) {
+		ids, ok := x.postings[word]
+		if !ok {
+			ids = make(map[int]struct{})
+			x.postings[word] = ids
+			x.sorted = false
+		}
+		ids[email.ID] = struct{}{}
+	}
+}
+
+func (x *searchIndex) remove(email *models.Email) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	for _, word := range searchWords(email) {
+		if ids, ok := x.postings[word]; ok {
+			delete(ids, email.ID)
+			if len(ids) == 0 {
+				delete(x.postings, word)
+				x.sorted = false
+			}
+		}
+	}
+}
+
+func (x *searchIndex) clear() {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	x.postings = make(map[string]map[int]struct{})
+	x.words = nil
+	x.sorted = false
+}
+
+// candidates returns the IDs of the emails with all the words of the terms
+func (x *searchIndex) candidates(terms []searchTerm) map[int]struct{} {
+	// Searches take the write lock since the word list is sorted lazily
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	if !x.sorted {
+		x.words = x.words[:0]
+		for word := range x.postings {
+			x.words = append(x.words, word)
+		}
+		sort.Strings(x.words)
+		x.sorted = true
+	}
+
+	return candidates(terms, func(word string, prefix bool) map[int]struct{} {
+		ids := make(map[int]struct{})
+		if !prefix {
+			for id := range x.postings[word] {
+				ids[id] = struct{}{}
+			}
+			return ids
+		}
+		for i := sort.SearchStrings(x.words, word); i < len(x.words) && strings.HasPrefix(x.words[i], word); i++ {
+			for id := range x.postings[x.words[i]] {
+				ids[id] = struct{}{}
+			}
+		}
+		return ids
+	})
+}
@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"mailcatch/internal/models"
+)
+
+func TestFormatSnippet(t *testing.T) {
+	tests := []struct {
+		snippet, want string
+	}{
+		{"code \x01123456\x02\r\n", "code <mark>123456</mark>"},
+		{"…  Hello\r\n\r\n  <b>world</b>\t…", "… Hello &lt;b&gt;world&lt;/b&gt; …"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := formatSnippet(test.snippet); got != test.want {
+			t.Errorf("formatSnippet(%q) = %q, want %q", test.snippet, got, test.want)
+		}
+	}
+}
+
+// searchStorages opens the backends that search with searchIndex, the
+// search bucket or SQLite, which uses FTS5 when built with the sqlite_fts5
+// tag and substring matching otherwise
+func searchStorages(t *testing.T) map[string]Storage {
+	memory, err := NewMemoryStorage("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bolt, err := NewBoltStorage(filepath.Join(t.TempDir(), "emails.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bolt.Close() })
+	storages := map[string]Storage{"memory": memory, "bolt": bolt}
+
+	// SQLite needs CGO
+	if sqlite, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "sqlite.db")); err == nil {
+		t.Cleanup(func() { sqlite.Close() })
+		storages["sqlite"] = sqlite
+	}
+	return storages
+}
+
+func searchIDs(t *testing.T, store Storage, query string) []int {
+	t.Helper()
+	page, err := store.GetEmails(EmailFilter{Text: query}, PageRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Substring matching has no snippets
+	sqlite, ok := store.(*SQLiteStorage)
+	snippets := !ok || sqlite.fts
+
+	ids := []int{}
+	for _, email := range page.Emails {
+		ids = append(ids, email.ID)
+		if snippets && email.Snippet == "" {
+			t.Errorf("%q: email %d has no snippet", query, email.ID)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func TestSearchIndex(t *testing.T) {
+	for name, store := range searchStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			for _, email := range []*models.Email{
+				{From: "app@example.com", To: "bob@example.com", Subject: "Welcome aboard", Body: "Your verification code is 123456\r\n"},
+				{From: "app@example.com", To: "alice@example.com", Subject: "Password reset", Body: "Reset your password with code 654321"},
+				{From: "news@example.com", To: "bob@example.com", Subject: "Weekly news", Body: "Code review tips and the verification of welcome emails"},
+			} {
+				if err := store.SaveEmail(email); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			tests := []struct {
+				query string
+				want  []int
+			}{
+				{"code", []int{1, 2, 3}},
+				{"verification code", []int{1, 3}},
+				{`"verification code"`, []int{1}},
+				{"welc*", []int{1, 3}},
+				{`"code review"`, []int{3}},
+				{"alice", []int{2}},
+				{"missing", []int{}},
+			}
+			for _, test := range tests {
+				if got := searchIDs(t, store, test.query); !reflect.DeepEqual(got, test.want) {
+					t.Errorf("%q: got %v, want %v", test.query, got, test.want)
+				}
+			}
+
+			snapshotter := store.(Snapshotter)
+			if _, err := snapshotter.CreateSnapshot("fixtures"); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.DeleteEmail(1); err != nil {
+				t.Fatal(err)
+			}
+			if got := searchIDs(t, store, "verification"); !reflect.DeepEqual(got, []int{3}) {
+				t.Errorf("after delete: got %v, want [3]", got)
+			}
+			if _, err := snapshotter.RestoreSnapshot("fixtures"); err != nil {
+				t.Fatal(err)
+			}
+			if got := searchIDs(t, store, "verification"); !reflect.DeepEqual(got, []int{1, 3}) {
+				t.Errorf("after restore: got %v, want [1 3]", got)
+			}
+
+			if err := store.ClearEmails(); err != nil {
+				t.Fatal(err)
+			}
+			if got := searchIDs(t, store, "code"); len(got) != 0 {
+				t.Errorf("after clear: got %v", got)
+			}
+		})
+	}
+}
+
+// An email SQLite fails to index is not stored either
+func TestSQLiteIndexFailureStoresNothing(t *testing.T) {
+	store, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "emails.db"))
+	if err != nil {
+		t.Skip(err)
+	}
+	defer store.Close()
+	if !store.fts {
+		t.Skip("SQLite built without FTS5")
+	}
+
+	if _, err := store.db.Exec(`DROP TABLE emails_fts`); err != nil {
+		t.Fatal(err)
+	}
+	email := &models.Email{From: "a@example.com", To: "b@example.com", Subject: "lost"}
+	if err := store.SaveEmail(email); err == nil {
+		t.Fatal("SaveEmail succeeded without an index")
+	}
+	if email.ID != 0 {
+		t.Errorf("failed save set ID %d", email.ID)
+	}
+	var count int
+	if err := store.db.QueryRow(`SELECT COUNT(*) FROM emails`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("%d rows stored, want none", count)
+	}
+}
@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"mailcatch/internal/models"
+)
+
+var (
+	ErrSnapshotNotFound    = errors.New("snapshot not found")
+	ErrSnapshotExists      = errors.New("snapshot already exists")
+	ErrInvalidSnapshotName = errors.New("invalid snapshot name")
+)
+
+// Snapshotter is implemented by storages that can save named states of the
+// mailbox and roll back to them. A snapshot records the IDs of the emails
+// up to a watermark; emails deleted while a snapshot holds them are kept
+// out of sight, as tombstones, until no snapshot holds them any more.
+type Snapshotter interface {
+	CreateSnapshot(name string) (*Snapshot, error)
+	// ListSnapshots returns the snapshots, oldest first
+	ListSnapshots() ([]*Snapshot, error)
+	// RestoreSnapshot makes the emails of the snapshot the current ones.
+	// Emails received since are deleted and, unless another snapshot
+	// holds them, returned so their blobs can be deleted.
+	RestoreSnapshot(name string) ([]*models.Email, error)
+	// DeleteSnapshot returns the deleted emails no other snapshot holds,
+	// which are gone for good
+	DeleteSnapshot(name string) ([]*models.Email, error)
+	// Holds reports whether a snapshot holds an email. The blobs of a
+	// deleted email a snapshot holds must be kept.
+	Holds(id int) (bool, error)
+}
+
+// HoldsEmail reports whether a snapshot of the storage holds an email, if
+// it supports snapshots
+func HoldsEmail(store Storage, id int) (bool, error) {
+	snapshotter, ok := store.(Snapshotter)
+	if !ok {
+		return false, nil
+	}
+	return snapshotter.Holds(id)
+}
+
+// Snapshot is a named state of the mailbox
+type Snapshot struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	// Emails is the number of emails in the snapshot
+	Emails int `json:"emails"`
+	// Watermark is the highest email ID in the snapshot
+	Watermark int `json:"watermark"`
+	ids       idRanges
+}
+
+// Contains reports whether the email with this ID is in the snapshot
+func (s *Snapshot) Contains(id int) bool {
+	return s.ids.contains(id)
+}
+
+// snapshotRecord is a snapshot as stored by the backends
+type snapshotRecord struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	Emails    int       `json:"emails"`
+	IDs       idRanges  `json:"ids"`
+}
+
+func (s *Snapshot) record() snapshotRecord {
+	return snapshotRecord{Name: s.Name, CreatedAt: s.CreatedAt, Emails: s.Emails, IDs: s.ids}
+}
+
+func (r snapshotRecord) snapshot() *Snapshot {
+	return &Snapshot{Name: r.Name, CreatedAt: r.CreatedAt, Emails: r.Emails, Watermark: r.IDs.max(), ids: r.IDs}
+}
+
+// idRanges is a set of IDs as sorted, disjoint, inclusive ranges. Emails
+// arrive with increasing IDs and are deleted oldest first, so a mailbox
+// of thousands of emails takes a handful of ranges.
+type idRanges [][2]int
+
+// newIDRanges returns the ranges of sorted IDs
+func newIDRanges(ids []int) idRanges {
+	ranges := idRanges{}
+	for _, id := range ids {
+		if n := len(ranges); n > 0 && ranges[n-1][1]+1 >= id {
+			ranges[n-1][1] = id
+			continue
+		}
+		ranges = append(ranges, [2]int{id, id})
+	}
+	return ranges
+}
+
+func (r idRanges) contains(id int) bool {
+	i := sort.Search(len(r), func(i int) bool { return r[i][1] >= id })
+	return i < len(r) && r[i][0] <= id
+}
+
+func (r idRanges) max() int {
+	if len(r) == 0 {
+		return 0
+	}
+	return r[len(r)-1][1]
+}
+
+// snapshotNamePattern keeps snapshot names usable in URL paths
+var snapshotNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// newSnapshot returns a snapshot of the emails with the given IDs
+func newSnapshot(name string, ids []int) (*Snapshot, error) {
+	if !snapshotNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("%w %q: use up to 64 letters, digits, '.', '_' and '-'", ErrInvalidSnapshotName, name)
+	}
+	sort.Ints(ids)
+	ranges := newIDRanges(ids)
+	return &Snapshot{Name: name, CreatedAt: time.Now(), Emails: len(ids), Watermark: ranges.max(), ids: ranges}, nil
+}
+
+// findSnapshot returns the snapshot named name and the others
+func findSnapshot(snapshots []*Snapshot, name string) (*Snapshot, []*Snapshot, error) {
+	for i, snapshot := range snapshots {
+		if snapshot.Name == name {
+			others := append(append([]*Snapshot(nil), snapshots[:i]...), snapshots[i+1:]...)
+			return snapshot, others, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("snapshot %q: %w", name, ErrSnapshotNotFound)
+}
+
+// held reports whether one of the snapshots holds the email with this ID
+func held(snapshots []*Snapshot, id int) bool {
+	for _, snapshot := range snapshots {
+		if snapshot.Contains(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// restorePlan sorts the emails for a restore of target: current emails
+// outside it become tombstones when another snapshot holds them and are
+// purged otherwise, and tombstones in it are revived
+func restorePlan(target *Snapshot, others []*Snapshot, live, tombstones []int) (bury, purge, revive []int) {
+	for _, id := range live {
+		switch {
+		case target.Contains(id):
+		case held(others, id):
+			bury = append(bury, id)
+		default:
+			purge = append(purge, id)
+		}
+	}
+	for _, id := range tombstones {
+		if target.Contains(id) {
+			revive = append(revive, id)
+		}
+	}
+	return bury, purge, revive
+}
+
+// released returns the tombstones none of the snapshots hold any more
+func released(snapshots []*Snapshot, tombstones []int) []int {
+	var ids []int
+	for _, id := range tombstones {
+		if !held(snapshots, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,296 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"mailcatch/internal/models"
+)
+
+// sqlQuerier is implemented by *sql.DB and *sql.Tx
+type sqlQuerier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// sqlSnapshots implements Snapshotter for the SQL backends. Snapshots are
+// rows of the snapshots table, and deleted emails a snapshot holds stay in
+// the emails table with the tombstone column set.
+type sqlSnapshots struct {
+	db *sql.DB
+	// rebind rewrites the ? placeholders of a query for the driver
+	rebind func(query string) string
+	// begin starts a transaction that excludes the other snapshot and
+	// delete operations
+	begin func() (*sql.Tx, error)
+	// getEmail reads an email, tombstone or not
+	getEmail func(q sqlQuerier, id int) (*models.Email, error)
+}
+
+func (s *sqlSnapshots) exec(tx *sql.Tx, query string, args ...interface{}) error {
+	_, err := tx.Exec(s.rebind(query), args...)
+	return err
+}
+
+// snapshots reads the snapshots, oldest first
+func (s *sqlSnapshots) snapshots(q sqlQuerier) ([]*Snapshot, error) {
+	rows, err := q.Query(`SELECT record FROM snapshots`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snapshots []*Snapshot
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var record snapshotRecord
+		if err := json.Unmarshal([]byte(data), &record); err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, record.snapshot())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(snapshots, func(i, j int) bool { return snapshots[i].CreatedAt.Before(snapshots[j].CreatedAt) })
+	return snapshots, nil
+}
+
+// ids returns the IDs of the current emails, or of the tombstones
+func (s *sqlSnapshots) ids(q sqlQuerier, tombstone bool) ([]int, error) {
+	rows, err := q.Query(s.rebind(`SELECT id FROM emails WHERE tombstone = ? ORDER BY id`), tombstone)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// remove deletes a current email, leaving a tombstone if a snapshot holds
+// it
+func (s *sqlSnapshots) remove(tx *sql.Tx, snapshots []*Snapshot, id int) error {
+	query, args := `DELETE FROM emails WHERE id = ? AND NOT tombstone`, []interface{}{id}
+	if held(snapshots, id) {
+		query, args = `UPDATE emails SET tombstone = ? WHERE id = ? AND NOT tombstone`, []interface{}{true, id}
+	}
+	result, err := tx.Exec(s.rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqlSnapshots) deleteEmail(id int) error {
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	snapshots, err := s.snapshots(tx)
+	if err != nil {
+		return err
+	}
+	if err := s.remove(tx, snapshots, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlSnapshots) clearEmails() error {
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	snapshots, err := s.snapshots(tx)
+	if err != nil {
+		return err
+	}
+	if len(snapshots) == 0 {
+		if err := s.exec(tx, `DELETE FROM emails`); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	ids, err := s.ids(tx, false)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := s.remove(tx, snapshots, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlSnapshots) CreateSnapshot(name string) (*Snapshot, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var exists int
+	err = tx.QueryRow(s.rebind(`SELECT COUNT(*) FROM snapshots WHERE name = ?`), name).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if exists > 0 {
+		return nil, fmt.Errorf("snapshot %q: %w", name, ErrSnapshotExists)
+	}
+
+	ids, err := s.ids(tx, false)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := newSnapshot(name, ids)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(snapshot.record())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.exec(tx, `INSERT INTO snapshots (name, record) VALUES (?, ?)`, name, string(data)); err != nil {
+		return nil, err
+	}
+	return snapshot, tx.Commit()
+}
+
+func (s *sqlSnapshots) ListSnapshots() ([]*Snapshot, error) {
+	return s.snapshots(s.db)
+}
+
+func (s *sqlSnapshots) RestoreSnapshot(name string) ([]*models.Email, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	snapshots, err := s.snapshots(tx)
+	if err != nil {
+		return nil, err
+	}
+	target, others, err := findSnapshot(snapshots, name)
+	if err != nil {
+		return nil, err
+	}
+	live, err := s.ids(tx, false)
+	if err != nil {
+		return nil, err
+	}
+	tombstones, err := s.ids(tx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	bury, purge, revive := restorePlan(target, others, live, tombstones)
+	for _, id := range bury {
+		if err := s.exec(tx, `UPDATE emails SET tombstone = ? WHERE id = ?`, true, id); err != nil {
+			return nil, err
+		}
+	}
+	purged, err := s.purge(tx, purge)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range revive {
+		if err := s.exec(tx, `UPDATE emails SET tombstone = ? WHERE id = ?`, false, id); err != nil {
+			return nil, err
+		}
+	}
+	return purged, tx.Commit()
+}
+
+func (s *sqlSnapshots) DeleteSnapshot(name string) ([]*models.Email, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	snapshots, err := s.snapshots(tx)
+	if err != nil {
+		return nil, err
+	}
+	_, others, err := findSnapshot(snapshots, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.exec(tx, `DELETE FROM snapshots WHERE name = ?`, name); err != nil {
+		return nil, err
+	}
+
+	tombstones, err := s.ids(tx, true)
+	if err != nil {
+		return nil, err
+	}
+	purged, err := s.purge(tx, released(others, tombstones))
+	if err != nil {
+		return nil, err
+	}
+	return purged, tx.Commit()
+}
+
+// purge deletes emails for good and returns them
+func (s *sqlSnapshots) purge(tx *sql.Tx, ids []int) ([]*models.Email, error) {
+	var purged []*models.Email
+	for _, id := range ids {
+		email, err := s.getEmail(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.exec(tx, `DELETE FROM emails WHERE id = ?`, id); err != nil {
+			return nil, err
+		}
+		purged = append(purged, email)
+	}
+	return purged, nil
+}
+
+func (s *sqlSnapshots) Holds(id int) (bool, error) {
+	snapshots, err := s.snapshots(s.db)
+	if err != nil {
+		return false, err
+	}
+	return held(snapshots, id), nil
+}
+
+// pgRebind numbers the ? placeholders of a query as PostgreSQL expects
+func pgRebind(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
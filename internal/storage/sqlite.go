@@ -2,66 +2,497 @@ package storage
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"io"
+	"log"
 	"os"
+	"net/textproto"
+	"net/url"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"mailcatch/internal/models"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// sqliteTimeFormat is a time format SQLite date functions understand
+const sqliteTimeFormat = "2006-01-02 15:04:05.000"
+
+// sqliteCursorFormat keeps all fractional digits, as the driver does when
+// storing times, so julianday rounds cursors and rows alike
+const sqliteCursorFormat = "2006-01-02 15:04:05.999999999-07:00"
+
 type SQLiteStorage struct {
 	db *sql.DB
+	// lock is the shared lock of the database file
+	lock *os.File
+	// fts is set when the driver supports FTS5 and emails_fts is in use
+	fts bool
+	*sqlSnapshots
+}
+
+func init() {
+	Register(&Backend{
+		Name:        "sqlite",
+		Description: "SQLite database at the database path, requires CGO",
+		Options:     []string{"journal_mode", "busy_timeout"},
+		Open: func(path string, options Options) (Storage, error) {
+			// The driver applies these to every connection it opens
+			params := url.Values{}
+			if mode := options["journal_mode"]; mode != "" {
+				params.Set("_journal_mode", mode)
+			}
+			timeout, err := options.Int("busy_timeout", 0)
+			if err != nil {
+				return nil, err
+			}
+			if timeout > 0 {
+				params.Set("_busy_timeout", strconv.Itoa(timeout))
+			}
+			return openSQLite(path, params)
+		},
+		MigrationStatus: func(path string, options Options) (*MigrationStatus, error) {
+			return SQLiteMigrationStatus(path)
+		},
+		BackupFormat: BackupFormatSQLite,
+		Restore: func(path string, options Options, r io.Reader) error {
+			return restoreSQLite(path, r)
+		},
+	})
 }
 
 func NewSQLiteStorage(dbPath string) (*SQLiteStorage, error) {
+	return openSQLite(dbPath, nil)
+}
+
+// openSQLite opens the database with driver parameters added to its DSN
+func openSQLite(dbPath string, params url.Values) (*SQLiteStorage, error) {
 	// Create directory if it doesn't exist
 	dir := filepath.Dir(dbPath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create directory: %w", err)
 	}
 
-	db, err := sql.Open("sqlite3", dbPath)
+	lock, err := useLock(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Transactions take the write lock upfront, so those reading before
+	// they write cannot fail on a concurrent commit
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("_txlock", "immediate")
+	db, err := sql.Open("sqlite3", dbPath+"?"+params.Encode())
 	if err != nil {
+		lock.Close()
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	storage := &SQLiteStorage{db: db}
+	storage := &SQLiteStorage{db: db, lock: lock}
+	storage.sqlSnapshots = &sqlSnapshots{
+		db:     db,
+		rebind: func(query string) string { return query },
+		begin:  db.Begin,
+		getEmail: func(q sqlQuerier, id int) (*models.Email, error) {
+			return getSQLiteEmail(q, `WHERE id = ?`, id)
+		},
+	}
 	if err := storage.createTables(); err != nil {
+		storage.Close()
 		return nil, fmt.Errorf("failed to create tables: %w", err)
 	}
 
 	return storage, nil
 }
 
+// sqliteMigrations is the schema history of the emails table, one step per
+// schema change. A released step is never edited; changes get a new step
+// at the end. Databases created before versioning report version 0 and go
+// through every step, which is why the steps tolerate changes that are
+// already present. The same goes for databases numbered when step 2 added
+// the columns of steps 2 to 11: they replay the steps after their version.
+var sqliteMigrations = []struct {
+	Migration
+	up func(tx *sql.Tx) error
+}{
+	{Migration{1, "create the emails table"}, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS emails (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			from_addr TEXT NOT NULL,
+			to_addr TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			body TEXT NOT NULL,
+			html TEXT,
+			raw BLOB NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_emails_created_at ON emails(created_at DESC);
+		`)
+		return err
+	}},
+	{Migration{2, "add the calendar column"}, func(tx *sql.Tx) error {
+		return addColumnIfMissing(tx, "emails", "calendar", "TEXT")
+	}},
+	{Migration{3, "add the authentication results column"}, func(tx *sql.Tx) error {
+		return addColumnIfMissing(tx, "emails", "auth", "TEXT")
+	}},
+	{Migration{4, "add the client IP and HELO columns"}, func(tx *sql.Tx) error {
+		if err := addColumnIfMissing(tx, "emails", "client_ip", "TEXT"); err != nil {
+			return err
+		}
+		return addColumnIfMissing(tx, "emails", "helo", "TEXT")
+	}},
+	{Migration{5, "add the signature and encryption column"}, func(tx *sql.Tx) error {
+		return addColumnIfMissing(tx, "emails", "secure", "TEXT")
+	}},
+	{Migration{6, "add the attachments column"}, func(tx *sql.Tx) error {
+		return addColumnIfMissing(tx, "emails", "attachments", "TEXT")
+	}},
+	{Migration{7, "add the raw blob and size columns"}, func(tx *sql.Tx) error {
+		if err := addColumnIfMissing(tx, "emails", "raw_blob", "TEXT"); err != nil {
+			return err
+		}
+		return addColumnIfMissing(tx, "emails", "size", "INTEGER")
+	}},
+	{Migration{8, "add the warnings column"}, func(tx *sql.Tx) error {
+		return addColumnIfMissing(tx, "emails", "warnings", "TEXT")
+	}},
+	{Migration{9, "add the spam score column"}, func(tx *sql.Tx) error {
+		return addColumnIfMissing(tx, "emails", "spam", "TEXT")
+	}},
+	{Migration{10, "add the text check column"}, func(tx *sql.Tx) error {
+		return addColumnIfMissing(tx, "emails", "text_check", "TEXT")
+	}},
+	{Migration{11, "add the headers column"}, func(tx *sql.Tx) error {
+		return addColumnIfMissing(tx, "emails", "headers", "TEXT")
+	}},
+	{Migration{12, "add the import source column"}, func(tx *sql.Tx) error {
+		return addColumnIfMissing(tx, "emails", "source", "TEXT")
+	}},
+	{Migration{13, "add the snapshots table and the tombstone column"}, func(tx *sql.Tx) error {
+		if err := addColumnIfMissing(tx, "emails", "tombstone", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS snapshots (name TEXT PRIMARY KEY, record TEXT NOT NULL)`)
+		return err
+	}},
+	{Migration{14, "index emails in receive order"}, func(tx *sql.Tx) error {
+		// The list sorts on julianday(created_at), which an index of the
+		// column as stored cannot serve
+		_, err := tx.Exec(`
+		DROP INDEX IF EXISTS idx_emails_created_at;
+		CREATE INDEX IF NOT EXISTS idx_emails_received ON emails(julianday(created_at), id);
+		`)
+		return err
+	}},
+	{Migration{15, "add the recipients column"}, func(tx *sql.Tx) error {
+		if err := addColumnIfMissing(tx, "emails", "recipients", "TEXT"); err != nil {
+			return err
+		}
+		return backfillRecipients(tx)
+	}},
+}
+
+func sqliteMigrationList() []Migration {
+	migrations := make([]Migration, len(sqliteMigrations))
+	for i, step := range sqliteMigrations {
+		migrations[i] = step.Migration
+	}
+	return migrations
+}
+
+// SQLiteMigrationStatus reports the migrations opening the database at
+// dbPath would apply, without modifying it
+func SQLiteMigrationStatus(dbPath string) (*MigrationStatus, error) {
+	// A missing database is checked in memory, which still tells whether
+	// the driver works
+	dsn := "file::memory:"
+	if _, err := os.Stat(dbPath); err == nil {
+		dsn = "file:" + dbPath + "?mode=ro"
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	var current int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&current); err != nil {
+		return nil, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return newMigrationStatus("SQLite", current, sqliteMigrationList())
+}
+
+// createTables brings the schema up to date, one transaction per step with
+// the version kept in PRAGMA user_version
 func (s *SQLiteStorage) createTables() error {
-	query := `
-	CREATE TABLE IF NOT EXISTS emails (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		from_addr TEXT NOT NULL,
-		to_addr TEXT NOT NULL,
-		subject TEXT NOT NULL,
-		body TEXT NOT NULL,
-		html TEXT,
-		raw TEXT NOT NULL,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_emails_created_at ON emails(created_at DESC);
-	`
-	
-	_, err := s.db.Exec(query)
+	var current int
+	if err := s.db.QueryRow("PRAGMA user_version").Scan(&current); err != nil {
+		return err
+	}
+
+	err := runMigrations("SQLite", current, sqliteMigrationList(), func(migration Migration) error {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if err := sqliteMigrations[migration.Version-1].up(tx); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", migration.Version)); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.createSearchIndex()
+}
+
+// createSearchIndex sets up the FTS5 index over the searchable fields. The
+// sqlite3 driver only includes FTS5 when built with the sqlite_fts5 tag;
+// without it full-text queries fall back to substring matching.
+func (s *SQLiteStorage) createSearchIndex() error {
+	_, err := s.db.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS emails_fts USING fts5(subject, body, html_text, addresses, attachments)`)
+	if err != nil {
+		if strings.Contains(err.Error(), "no such module: fts5") {
+			log.Printf("Warning: SQLite built without FTS5, full-text search falls back to substring matching without snippets; build with -tags sqlite_fts5 to enable it")
+			return nil
+		}
+		return err
+	}
+	s.fts = true
+
+	// Deletes are mirrored by a trigger, which runs within the deleting
+	// statement; inserts need the HTML rendered as text and are indexed by
+	// SaveEmail in its transaction
+	_, err = s.db.Exec(`
+	CREATE TRIGGER IF NOT EXISTS emails_fts_delete AFTER DELETE ON emails BEGIN
+		DELETE FROM emails_fts WHERE rowid = old.id;
+	END;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Index emails stored before the index existed, all or none
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT id FROM emails WHERE id NOT IN (SELECT rowid FROM emails_fts)`)
+	if err != nil {
+		return err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		email, err := getSQLiteEmail(tx, `WHERE id = ?`, id)
+		if err != nil {
+			return err
+		}
+		if err := indexEmail(tx, id, email); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// indexEmail adds an email to the FTS5 index in the transaction that
+// stores it
+func indexEmail(tx *sql.Tx, id int, email *models.Email) error {
+	fields := searchFields(email)
+	_, err := tx.Exec(`
+		INSERT INTO emails_fts (rowid, subject, body, html_text, addresses, attachments)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, id, fields[0], fields[1], fields[2], fields[3], fields[4])
+	return err
+}
+
+// sqliteRecipients lists the recipients of an email for the recipients
+// column, enclosed in commas so a whole address is matched with instr
+func sqliteRecipients(email *models.Email) string {
+	return "," + strings.Join(recipientAddresses(email), ",") + ","
+}
+
+// backfillRecipients fills the recipients column of emails stored before it
+// existed
+func backfillRecipients(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT id, to_addr, headers FROM emails WHERE recipients IS NULL`)
+	if err != nil {
+		return err
+	}
+	recipients := make(map[int]string)
+	for rows.Next() {
+		var (
+			id      int
+			email   models.Email
+			headers sql.NullString
+		)
+		if err := rows.Scan(&id, &email.To, &headers); err != nil {
+			rows.Close()
+			return err
+		}
+		decodeJSON(headers, &email.Headers)
+		recipients[id] = sqliteRecipients(&email)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, list := range recipients {
+		if _, err := tx.Exec(`UPDATE emails SET recipients = ? WHERE id = ?`, list, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addColumnIfMissing adds a column to an existing table unless it is
+// already present
+func addColumnIfMissing(tx *sql.Tx, table, column, definition string) error {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    int
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &primaryKey); err != nil {
+			rows.Close()
+			return err
+		}
+		if name == column {
+			found = true
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || found {
+		return err
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
 	return err
 }
 
+// encodeJSON serializes structured email fields stored in TEXT columns,
+// storing NULL for empty values
+func encodeJSON(v interface{}) (sql.NullString, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	if string(data) == "null" {
+		return sql.NullString{}, nil
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+// decodeJSON is the inverse of encodeJSON
+func decodeJSON(column sql.NullString, v interface{}) error {
+	if !column.Valid || column.String == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(column.String), v)
+}
+
 func (s *SQLiteStorage) SaveEmail(email *models.Email) error {
 	query := `
-		INSERT INTO emails (from_addr, to_addr, subject, body, html, raw, created_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO emails (from_addr, to_addr, subject, body, html, raw, client_ip, helo, calendar, auth, secure, attachments, warnings, raw_blob, size, spam, text_check, headers, source, recipients, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
+
+	calendar, err := encodeJSON(email.Calendar)
+	if err != nil {
+		return err
+	}
+	auth, err := encodeJSON(email.Auth)
+	if err != nil {
+		return err
+	}
+	secure, err := encodeJSON(email.Secure)
+	if err != nil {
+		return err
+	}
+	attachments, err := encodeJSON(email.Attachments)
+	if err != nil {
+		return err
+	}
+	warnings, err := encodeJSON(email.Warnings)
+	if err != nil {
+		return err
+	}
+	spam, err := encodeJSON(email.Spam)
+	if err != nil {
+		return err
+	}
+	textCheck, err := encodeJSON(email.TextCheck)
+	if err != nil {
+		return err
+	}
+	headers, err := encodeJSON(email.Headers)
+	if err != nil {
+		return err
+	}
+	source, err := encodeJSON(email.Source)
+	if err != nil {
+		return err
+	}
 	
-	result, err := s.db.Exec(query, email.From, email.To, email.Subject, 
-		email.Body, email.HTML, email.Raw, email.CreatedAt)
+	// Raw is stored as a BLOB so 8-bit and binary content round-trips
+	// exactly; messages kept in the blob store have no inline copy
+	raw := email.Raw
+	if raw == nil {
+		raw = []byte{}
+	}
+	
+	// The row and its index entry are written together, so a failed save
+	// leaves neither an unsearchable email nor one whose blobs the caller
+	// removes
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(query, email.From, email.To, email.Subject, 
+		email.Body, email.HTML, raw, email.ClientIP, email.Helo, calendar, auth, secure, attachments, warnings, email.RawBlob, email.Size, spam, textCheck, headers, source, sqliteRecipients(email), email.CreatedAt)
 	if err != nil {
 		return err
 	}
@@ -71,77 +502,369 @@ func (s *SQLiteStorage) SaveEmail(email *models.Email) error {
 		return err
 	}
 	
+	if s.fts {
+		if err := indexEmail(tx, int(id), email); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
 	email.ID = int(id)
 	return nil
 }
 
-func (s *SQLiteStorage) GetEmails(limit int) ([]*models.EmailSummary, error) {
-	query := `
-		SELECT id, from_addr, to_addr, subject, created_at 
-		FROM emails 
-		ORDER BY created_at DESC
-		LIMIT ?
-	`
-	
-	rows, err := s.db.Query(query, limit)
+// sortExpressions are the ORDER BY expressions of the sort fields.
+// julianday makes times stored with different UTC offsets comparable, and
+// idx_emails_received indexes it.
+var sortExpressions = map[string]string{
+	SortReceived: "julianday(created_at)",
+	SortSubject:  "subject",
+	SortFrom:     "from_addr",
+}
+
+func (s *SQLiteStorage) GetEmails(filter EmailFilter, page PageRequest) (*EmailPage, error) {
+	if err := page.validate(); err != nil {
+		return nil, err
+	}
+
+	conditions, args := s.filterConditions(filter)
+
+	// Counting takes a query of its own, so pages after the first count
+	// the matches only when asked to
+	var total *int
+	if page.countsTotal() {
+		total = new(int)
+		countQuery := `SELECT COUNT(*) FROM emails ` + whereClause(conditions)
+		if err := s.db.QueryRow(countQuery, args...).Scan(total); err != nil {
+			return nil, err
+		}
+	}
+
+	query, args := s.pageQuery(filter, page, conditions, args)
+	rows, err := s.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	
-	var emails []*models.EmailSummary
+	emails := []*models.EmailSummary{}
 	for rows.Next() {
 		email := &models.EmailSummary{}
+		var snippet sql.NullString
 		err := rows.Scan(&email.ID, &email.From, &email.To, 
-			&email.Subject, &email.CreatedAt)
+			&email.Subject, &email.WarningCount, &email.Size, &snippet, &email.CreatedAt)
 		if err != nil {
 			return nil, err
 		}
+		if snippet.Valid {
+			email.Snippet = formatSnippet(snippet.String)
+		}
 		emails = append(emails, email)
 	}
-	
-	return emails, nil
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return cutPage(emails, page, total), nil
 }
 
-func (s *SQLiteStorage) GetEmail(id int) (*models.Email, error) {
+// pageQuery returns the query of a page of the emails matching conditions
+func (s *SQLiteStorage) pageQuery(filter EmailFilter, page PageRequest, conditions []string, args []interface{}) (string, []interface{}) {
+	cursor, backwards := page.start()
+	ascending := page.Desc == backwards
+	direction, op := "ASC", ">"
+	if !ascending {
+		direction, op = "DESC", "<"
+	}
+
+	// Row values let the receive order index seek to the cursor
+	expr := sortExpressions[page.Sort]
+	if cursor != nil {
+		value := "?"
+		var cursorValue interface{} = cursor.Text
+		if page.Sort == SortReceived {
+			value = "julianday(?)"
+			cursorValue = cursor.Time.UTC().Format(sqliteCursorFormat)
+		}
+		conditions = append(conditions, "("+expr+", id) "+op+" ("+value+", ?)")
+		args = append(args, cursorValue, cursor.ID)
+	}
+
+	// Full-text matches come with a snippet
+	snippet := "NULL"
+	var snippetArgs []interface{}
+	if terms := parseQuery(filter.Text); s.fts && len(terms) > 0 {
+		snippet = `(SELECT snippet(emails_fts, -1, char(1), char(2), '…', 12) FROM emails_fts WHERE emails_fts MATCH ? AND rowid = emails.id)`
+		snippetArgs = append(snippetArgs, ftsQuery(terms))
+	}
+
+	limit := page.readLimit()
+	if limit == 0 {
+		limit = -1
+	}
+
 	query := `
-		SELECT id, from_addr, to_addr, subject, body, html, raw, created_at
+		SELECT id, from_addr, to_addr, subject, COALESCE(json_array_length(warnings), 0), COALESCE(NULLIF(size, 0), length(raw)), ` + snippet + `, created_at 
 		FROM emails 
-		WHERE id = ?
+		` + whereClause(conditions) + `
+		ORDER BY ` + expr + ` ` + direction + `, id ` + direction + `
+		LIMIT ?
 	`
-	
+
+	return query, append(append(snippetArgs, args...), limit)
+}
+
+// filterConditions translates a filter into the conditions of a WHERE
+// clause and their arguments
+func (s *SQLiteStorage) filterConditions(filter EmailFilter) ([]string, []interface{}) {
+	conditions := []string{"NOT tombstone"}
+	var args []interface{}
+
+	if filter.HasWarnings != nil {
+		if *filter.HasWarnings {
+			conditions = append(conditions, "COALESCE(json_array_length(warnings), 0) > 0")
+		} else {
+			conditions = append(conditions, "COALESCE(json_array_length(warnings), 0) = 0")
+		}
+	}
+	if filter.Warning != "" {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM json_each(emails.warnings) WHERE json_extract(value, '$.code') = ?)")
+		args = append(args, filter.Warning)
+	}
+	for _, match := range []struct{ column, value string }{
+		{"from_addr", filter.From},
+		{"to_addr", filter.To},
+		{"subject", filter.Subject},
+	} {
+		if match.value == "" {
+			continue
+		}
+		// The address columns hold bare addresses separated by commas; a
+		// whole recipient address also matches the To and Cc headers
+		if address, ok := filterAddress(match.value); ok && match.column == "to_addr" {
+			conditions = append(conditions, "instr(recipients, ?) > 0")
+			args = append(args, ","+address+",")
+			continue
+		}
+		if address, ok := filterAddress(match.value); ok && match.column != "subject" {
+			conditions = append(conditions, "instr(',' || replace(lower("+match.column+"), ' ', '') || ',', ?) > 0")
+			args = append(args, ","+address+",")
+			continue
+		}
+		conditions = append(conditions, "instr(lower("+match.column+"), lower(?)) > 0")
+		args = append(args, match.value)
+	}
+	if terms := parseQuery(filter.Text); len(terms) > 0 {
+		if s.fts {
+			conditions = append(conditions, "id IN (SELECT rowid FROM emails_fts WHERE emails_fts MATCH ?)")
+			args = append(args, ftsQuery(terms))
+		} else {
+			// Without FTS5 every term is matched as a substring
+			for _, term := range terms {
+				conditions = append(conditions, "instr(lower(subject || ' ' || body || ' ' || COALESCE(html, '') || ' ' || from_addr || ' ' || to_addr), ?) > 0")
+				args = append(args, strings.Join(term.words, " "))
+			}
+		}
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "julianday(created_at) >= julianday(?)")
+		args = append(args, filter.Since.UTC().Format(sqliteTimeFormat))
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "julianday(created_at) <= julianday(?)")
+		args = append(args, filter.Until.UTC().Format(sqliteTimeFormat))
+	}
+	if filter.HasAttachments != nil {
+		if *filter.HasAttachments {
+			conditions = append(conditions, "COALESCE(json_array_length(attachments), 0) > 0")
+		} else {
+			conditions = append(conditions, "COALESCE(json_array_length(attachments), 0) = 0")
+		}
+	}
+	for _, header := range filter.Headers {
+		// Header names are stored canonicalized, as JSON object keys
+		path := `$."` + textproto.CanonicalMIMEHeaderKey(header.Name) + `"`
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM json_each(emails.headers, ?) WHERE instr(lower(value), lower(?)) > 0)")
+		args = append(args, path, header.Value)
+	}
+
+	return conditions, args
+}
+
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(conditions, " AND ")
+}
+
+func (s *SQLiteStorage) GetEmail(id int) (*models.Email, error) {
+	return getSQLiteEmail(s.db, `WHERE id = ? AND NOT tombstone`, id)
+}
+
+// getSQLiteEmail reads the email matching a WHERE clause
+func getSQLiteEmail(q sqlQuerier, where string, args ...interface{}) (*models.Email, error) {
+	query := `
+		SELECT id, from_addr, to_addr, subject, body, html, raw, client_ip, helo, calendar, auth, secure, attachments, warnings, raw_blob, size, spam, text_check, headers, source, created_at
+		FROM emails 
+		` + where
+
 	email := &models.Email{}
-	err := s.db.QueryRow(query, id).Scan(
+	var clientIP, helo, calendar, auth, secure, attachments, warnings, rawBlob, spam, textCheck, headers, source sql.NullString
+	var size sql.NullInt64
+	err := q.QueryRow(query, args...).Scan(
 		&email.ID, &email.From, &email.To, &email.Subject,
-		&email.Body, &email.HTML, &email.Raw, &email.CreatedAt,
+		&email.Body, &email.HTML, &email.Raw, &clientIP, &helo,
+		&calendar, &auth, &secure, &attachments, &warnings, &rawBlob, &size, &spam, &textCheck, &headers, &source, &email.CreatedAt,
 	)
 	
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
+
+	email.ClientIP = clientIP.String
+	email.Helo = helo.String
+	email.RawBlob = rawBlob.String
+	email.Size = size.Int64
+
+	if err := decodeJSON(calendar, &email.Calendar); err != nil {
+		return nil, err
+	}
+	if err := decodeJSON(auth, &email.Auth); err != nil {
+		return nil, err
+	}
+	if err := decodeJSON(secure, &email.Secure); err != nil {
+		return nil, err
+	}
+	if err := decodeJSON(attachments, &email.Attachments); err != nil {
+		return nil, err
+	}
+	if err := decodeJSON(warnings, &email.Warnings); err != nil {
+		return nil, err
+	}
+	if err := decodeJSON(spam, &email.Spam); err != nil {
+		return nil, err
+	}
+	if err := decodeJSON(textCheck, &email.TextCheck); err != nil {
+		return nil, err
+	}
+	if err := decodeJSON(headers, &email.Headers); err != nil {
+		return nil, err
+	}
+	if err := decodeJSON(source, &email.Source); err != nil {
+		return nil, err
+	}
 	
 	return email, nil
 }
 
 func (s *SQLiteStorage) DeleteEmail(id int) error {
-	query := `DELETE FROM emails WHERE id = ?`
-	_, err := s.db.Exec(query, id)
-	return err
+	return s.deleteEmail(id)
 }
 
 func (s *SQLiteStorage) ClearEmails() error {
-	query := `DELETE FROM emails`
-	_, err := s.db.Exec(query)
-	return err
+	return s.clearEmails()
 }
 
 func (s *SQLiteStorage) GetEmailCount() (int, error) {
-	query := `SELECT COUNT(*) FROM emails`
+	query := `SELECT COUNT(*) FROM emails WHERE NOT tombstone`
 	var count int
 	err := s.db.QueryRow(query).Scan(&count)
 	return count, err
 }
 
 func (s *SQLiteStorage) Close() error {
+	defer s.lock.Close()
 	return s.db.Close()
-}
\ No newline at end of file
+}
+
+// Backup copies the database with the SQLite online backup API, which
+// reads a consistent snapshot without blocking writers in WAL mode
+func (s *SQLiteStorage) Backup(w io.Writer) (*BackupInfo, error) {
+	tmp, err := os.CreateTemp("", "mailcatch-backup-*.db")
+	if err != nil {
+		return nil, err
+	}
+	tmp.Close()
+	defer func() {
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			os.Remove(tmp.Name() + suffix)
+		}
+	}()
+
+	copyDB, err := sql.Open("sqlite3", tmp.Name())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup database: %w", err)
+	}
+	defer copyDB.Close()
+	if err := sqliteBackup(copyDB, s.db); err != nil {
+		return nil, fmt.Errorf("failed to back up database: %w", err)
+	}
+
+	// The blobs are those of the copy, not of the database in use
+	info := &BackupInfo{Format: BackupFormatSQLite}
+	rows, err := copyDB.Query(`SELECT raw_blob, attachments, tombstone FROM emails`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var email models.Email
+		var rawBlob, attachments sql.NullString
+		var tombstone bool
+		if err := rows.Scan(&rawBlob, &attachments, &tombstone); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		email.RawBlob = rawBlob.String
+		if err := decodeJSON(attachments, &email.Attachments); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if !tombstone {
+			info.Emails++
+		}
+		info.Blobs = blobKeys(info.Blobs, &email)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := copyDB.Close(); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(tmp.Name())
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if _, err := io.Copy(w, file); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// restoreSQLite replaces the database at dbPath with a copy written by
+// Backup
+func restoreSQLite(dbPath string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	lock, err := restoreLock("sqlite", dbPath)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	// A write-ahead log left next to the database belongs to the old one
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(dbPath + suffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return restoreFile(dbPath, r)
+}
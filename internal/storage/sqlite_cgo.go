@@ -0,0 +1,53 @@
+//go:build cgo
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// SQLiteAvailable reports whether the build includes the SQLite library
+const SQLiteAvailable = true
+
+// sqliteBackup copies the main database of src into dest with the SQLite
+// online backup API. The copy is made in one step, from a read snapshot
+// of src.
+func sqliteBackup(dest, src *sql.DB) error {
+	ctx := context.Background()
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return destConn.Raw(func(destDriver interface{}) error {
+		return srcConn.Raw(func(srcDriver interface{}) error {
+			backup, err := destDriver.(*sqlite3.SQLiteConn).Backup("main", srcDriver.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+			for {
+				// Step retries when the source is locked by a writer
+				// outside WAL mode
+				done, err := backup.Step(-1)
+				if err != nil {
+					backup.Finish()
+					return err
+				}
+				if done {
+					return backup.Finish()
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	})
+}
@@ -0,0 +1,17 @@
+//go:build !cgo
+
+package storage
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// SQLiteAvailable reports whether the build includes the SQLite library,
+// which needs CGO
+const SQLiteAvailable = false
+
+// sqliteBackup needs the SQLite library, which builds without CGO lack
+func sqliteBackup(dest, src *sql.DB) error {
+	return errors.New("SQLite backups require CGO")
+}
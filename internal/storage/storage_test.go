@@ -0,0 +1,681 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"mailcatch/internal/models"
+)
+
+// testStorages opens an empty storage of each backend. PostgreSQL is
+// tested when MAILCATCH_TEST_POSTGRES_DSN names a database to test in.
+func testStorages(t *testing.T) map[string]Storage {
+	dir := t.TempDir()
+	storages := map[string]Storage{}
+
+	memory, err := NewMemoryStorage("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	storages["memory"] = memory
+
+	persistent, err := NewMemoryStorage(filepath.Join(dir, "memory.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	storages["memory-persistent"] = persistent
+
+	bolt, err := NewBoltStorage(filepath.Join(dir, "bolt.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	storages["bolt"] = bolt
+
+	// SQLite needs CGO
+	if sqlite, err := NewSQLiteStorage(filepath.Join(dir, "sqlite.db")); err == nil {
+		storages["sqlite"] = sqlite
+	}
+
+	if dsn := os.Getenv("MAILCATCH_TEST_POSTGRES_DSN"); dsn != "" {
+		storages["postgres"] = testPostgres(t, dsn)
+	}
+
+	t.Cleanup(func() {
+		for _, store := range storages {
+			store.Close()
+		}
+	})
+	return storages
+}
+
+func TestDeleteMissingEmail(t *testing.T) {
+	for name, store := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := store.SaveEmail(&models.Email{From: "a@example.com", To: "b@example.com", Subject: "kept"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.DeleteEmail(42); !errors.Is(err, ErrNotFound) {
+				t.Errorf("DeleteEmail(42) = %v, want ErrNotFound", err)
+			}
+			if _, err := store.GetEmail(42); !errors.Is(err, ErrNotFound) {
+				t.Errorf("GetEmail(42) = %v, want ErrNotFound", err)
+			}
+			if count, _ := store.GetEmailCount(); count != 1 {
+				t.Errorf("count = %d, want 1", count)
+			}
+		})
+	}
+}
+
+// The memory backends keep every email unless max_emails is set, leaving
+// eviction to the retention janitor
+func TestMemoryMaxEmails(t *testing.T) {
+	tests := []struct {
+		options Options
+		want    int
+	}{
+		{nil, 1200},
+		{Options{"max_emails": "1000"}, 1000},
+	}
+	for _, tt := range tests {
+		store, err := Open("memory", "", tt.options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1200; i++ {
+			if err := store.SaveEmail(&models.Email{From: "a@example.com", To: "b@example.com"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if count, _ := store.GetEmailCount(); count != tt.want {
+			t.Errorf("options %v: count = %d, want %d", tt.options, count, tt.want)
+		}
+		store.Close()
+	}
+}
+
+func TestGetEmailsPages(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for name, store := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 7; i++ {
+				email := &models.Email{From: "a@example.com", To: "b@example.com", Subject: "email", CreatedAt: base.Add(time.Duration(i) * time.Minute)}
+				if err := store.SaveEmail(email); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for _, desc := range []bool{true, false} {
+				// Walk forwards through the pages, then back
+				var pages [][]int
+				page := PageRequest{Limit: 3, Desc: desc}
+				for {
+					result, err := store.GetEmails(EmailFilter{}, page)
+					if err != nil {
+						t.Fatal(err)
+					}
+					// Pages after the first may leave the total out
+					if total := pageTotal(result); total != 7 && (len(pages) == 0 || total != -1) {
+						t.Errorf("desc=%v: page %d total %d, want 7", desc, len(pages)+1, total)
+					}
+					pages = append(pages, summaryIDs(result.Emails))
+					if (len(pages) > 1) != (result.PrevCursor != "") {
+						t.Errorf("desc=%v: page %d prev cursor %q", desc, len(pages), result.PrevCursor)
+					}
+					if result.NextCursor == "" {
+						break
+					}
+					if page.After, err = DecodeCursor(result.NextCursor); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				want := [][]int{{1, 2, 3}, {4, 5, 6}, {7}}
+				if desc {
+					want = [][]int{{7, 6, 5}, {4, 3, 2}, {1}}
+				}
+				if !reflect.DeepEqual(pages, want) {
+					t.Fatalf("desc=%v: pages %v, want %v", desc, pages, want)
+				}
+
+				counted, err := store.GetEmails(EmailFilter{}, PageRequest{Limit: 3, Desc: desc, After: cursorOf(t, store, want[0][2]), CountTotal: true})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if total := pageTotal(counted); total != 7 {
+					t.Errorf("desc=%v: counted total %d, want 7", desc, total)
+				}
+
+				last := cursorOf(t, store, want[2][0])
+				past, err := store.GetEmails(EmailFilter{}, PageRequest{Limit: 3, Desc: desc, After: last})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(past.Emails) != 0 || past.NextCursor != "" {
+					t.Errorf("desc=%v: page after the last email: %v", desc, summaryIDs(past.Emails))
+				}
+
+				back, err := store.GetEmails(EmailFilter{}, PageRequest{Limit: 3, Desc: desc, Before: last})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := summaryIDs(back.Emails); !reflect.DeepEqual(got, want[1]) {
+					t.Errorf("desc=%v: page before %d is %v, want %v", desc, want[2][0], got, want[1])
+				}
+				if back.PrevCursor == "" || back.NextCursor == "" {
+					t.Errorf("desc=%v: middle page cursors %q %q", desc, back.PrevCursor, back.NextCursor)
+				}
+			}
+		})
+	}
+}
+
+// From and To filters naming a whole address match it exactly among the
+// addresses of the field, and other values as substrings. A whole To
+// address also matches the recipients of the To and Cc headers.
+func TestAddressFilters(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	emails := []struct {
+		from, to string
+		headers  map[string][]string
+	}{
+		{"alice@example.com", "bob@example.com", nil},
+		{"alice@example.com", "jimbob@example.com, carol@example.org", nil},
+		{"Dave@Example.com", "carol@example.org, bob@example.com", nil},
+		{"alice@example.com", "erin@example.net", nil},
+		{"app@example.net", "list@example.net", map[string][]string{
+			"To": {"Frank <frank@example.net>"},
+			"Cc": {"Bob <BOB@example.com>, jimcarol@example.org"},
+		}},
+	}
+	tests := []struct {
+		filter EmailFilter
+		want   []int
+	}{
+		{EmailFilter{To: "bob@example.com"}, []int{5, 3, 1}},
+		{EmailFilter{To: "BOB@example.com"}, []int{5, 3, 1}},
+		{EmailFilter{To: "bob@"}, []int{3, 2, 1}},
+		{EmailFilter{To: "carol@example.org"}, []int{3, 2}},
+		{EmailFilter{To: "frank@example.net"}, []int{5}},
+		{EmailFilter{To: "list@example.net"}, []int{5}},
+		{EmailFilter{To: "frank@"}, []int{}},
+		{EmailFilter{From: "dave@example.com"}, []int{3}},
+		{EmailFilter{From: "alice@example.com"}, []int{2, 1}},
+		{EmailFilter{From: "alice@example.com", To: "bob@example.com"}, []int{1}},
+		{EmailFilter{From: "example.com", Since: base.Add(time.Minute)}, []int{3, 2}},
+		{EmailFilter{To: "erin@example.net"}, []int{}},
+	}
+
+	for name, store := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			for i, e := range emails {
+				email := &models.Email{From: e.from, To: e.to, Subject: "email", Headers: e.headers, CreatedAt: base.Add(time.Duration(i) * time.Minute)}
+				if err := store.SaveEmail(email); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Deleted emails leave the indexes and their counts
+			if err := store.DeleteEmail(4); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, tt := range tests {
+				for _, limit := range []int{0, 1} {
+					result, err := store.GetEmails(tt.filter, PageRequest{Limit: limit, Desc: true})
+					if err != nil {
+						t.Fatal(err)
+					}
+					want := tt.want
+					if limit > 0 && len(want) > limit {
+						want = want[:limit]
+					}
+					if got := summaryIDs(result.Emails); !reflect.DeepEqual(got, want) {
+						t.Errorf("%+v limit %d: got %v, want %v", tt.filter, limit, got, want)
+					}
+					if pageTotal(result) != len(tt.want) || result.TotalApproximate {
+						t.Errorf("%+v: total %d (approximate %v), want %d", tt.filter, pageTotal(result), result.TotalApproximate, len(tt.want))
+					}
+				}
+			}
+		})
+	}
+}
+
+func summaryIDs(emails []*models.EmailSummary) []int {
+	ids := []int{}
+	for _, email := range emails {
+		ids = append(ids, email.ID)
+	}
+	return ids
+}
+
+// pageTotal returns the total of a page, -1 when it was left out
+func pageTotal(page *EmailPage) int {
+	if page.Total == nil {
+		return -1
+	}
+	return *page.Total
+}
+
+// cursorOf returns the receive order cursor of a stored email
+func cursorOf(t *testing.T, store Storage, id int) *Cursor {
+	t.Helper()
+	email, err := store.GetEmail(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return CursorAt(summarize(email, ""), SortReceived)
+}
+
+// testPostgres opens the storage in a new schema of the test database,
+// dropped when the test ends
+func testPostgres(t *testing.T, dsn string) Storage {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("mailcatch_test_%d", time.Now().UnixNano())
+	if _, err := db.Exec("CREATE SCHEMA " + schema); err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec("DROP SCHEMA " + schema + " CASCADE")
+		db.Close()
+	})
+
+	// Connection parameters are added to URLs as query parameters
+	switch {
+	case strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://"):
+		separator := "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
+		dsn += separator + "search_path=" + schema
+	default:
+		dsn += " search_path=" + schema
+	}
+
+	store, err := NewPostgresStorage(PostgresOptions{DSN: dsn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// Restoring refuses to replace a database that is open
+func TestRestoreInUse(t *testing.T) {
+	tests := []struct {
+		backend string
+		open    func(path string) (Storage, error)
+		restore func(path string) error
+	}{
+		{"memory-persistent",
+			func(path string) (Storage, error) { return NewMemoryStorage(path) },
+			func(path string) error { return restoreMemory(path, strings.NewReader(`{"next_id": 1, "emails": []}`)) }},
+		{"sqlite",
+			func(path string) (Storage, error) { return NewSQLiteStorage(path) },
+			func(path string) error { return restoreSQLite(path, strings.NewReader("")) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.backend, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "emails.db")
+			store, err := tt.open(path)
+			if err != nil {
+				t.Skip(err)
+			}
+			if err := tt.restore(path); err == nil || !strings.Contains(err.Error(), "in use") {
+				t.Errorf("restore with the database open = %v, want in use", err)
+			}
+			store.Close()
+			if err := tt.restore(path); err != nil {
+				t.Errorf("restore with the database closed: %v", err)
+			}
+		})
+	}
+}
+
+// Emails max_emails drops are reported for their blobs to be deleted,
+// unless a snapshot holds them
+func TestMemoryMaxEmailsEvicts(t *testing.T) {
+	store, err := newMemoryStorage("", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var evicted []int
+	store.OnEvict(func(email *models.Email) { evicted = append(evicted, email.ID) })
+
+	save := func() {
+		t.Helper()
+		if err := store.SaveEmail(&models.Email{From: "a@example.com", To: "b@example.com", RawBlob: "blob"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	save()
+	save()
+	if _, err := store.CreateSnapshot("two"); err != nil {
+		t.Fatal(err)
+	}
+	save()
+	save()
+	save()
+
+	// 1 and 2 are held by the snapshot, 3 is not
+	if !reflect.DeepEqual(evicted, []int{3}) {
+		t.Errorf("evicted %v, want [3]", evicted)
+	}
+}
+
+// An email whose file write fails is not kept, and the emails it would
+// have pushed out stay
+func TestMemorySaveFailureKeepsNothing(t *testing.T) {
+	dir := t.TempDir()
+	store, err := newMemoryStorage(filepath.Join(dir, "emails.db"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	var evicted []int
+	store.OnEvict(func(email *models.Email) { evicted = append(evicted, email.ID) })
+
+	for i := 0; i < 2; i++ {
+		if err := store.SaveEmail(&models.Email{From: "a@example.com", To: "b@example.com", Subject: "kept", Raw: []byte("raw")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A directory in the way of the temporary file fails the write
+	tmp := filepath.Join(dir, "emails.json.tmp")
+	if err := os.Mkdir(tmp, 0755); err != nil {
+		t.Fatal(err)
+	}
+	email := &models.Email{From: "a@example.com", To: "b@example.com", Subject: "lost", Raw: []byte("raw")}
+	if err := store.SaveEmail(email); err == nil {
+		t.Fatal("SaveEmail succeeded")
+	}
+	if email.ID != 0 {
+		t.Errorf("failed email has ID %d", email.ID)
+	}
+	page, err := store.GetEmails(EmailFilter{Text: "kept"}, PageRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := summaryIDs(page.Emails); !reflect.DeepEqual(ids, []int{1, 2}) {
+		t.Errorf("emails %v, want [1 2]", ids)
+	}
+	if _, err := os.Stat(store.rawPath(3)); !os.IsNotExist(err) {
+		t.Errorf("raw file of the failed email: %v", err)
+	}
+	if _, err := os.Stat(store.rawPath(1)); err != nil {
+		t.Errorf("raw file of email 1: %v", err)
+	}
+	if len(evicted) != 0 {
+		t.Errorf("evicted %v", evicted)
+	}
+
+	// The next email takes the ID and pushes out email 1
+	if err := os.Remove(tmp); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveEmail(email); err != nil {
+		t.Fatal(err)
+	}
+	if email.ID != 3 || !reflect.DeepEqual(evicted, []int{1}) {
+		t.Errorf("saved as %d evicting %v, want 3 evicting [1]", email.ID, evicted)
+	}
+	if _, err := os.Stat(store.rawPath(1)); !os.IsNotExist(err) {
+		t.Errorf("raw file of evicted email 1: %v", err)
+	}
+}
+
+// A memory-persistent file has a single user, and closing a store that
+// was only read leaves its file untouched
+func TestMemoryPersistentSingleUser(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "emails.db")
+	store, err := NewMemoryStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveEmail(&models.Email{From: "a@example.com", To: "b@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if second, err := NewMemoryStorage(path); !errors.Is(err, ErrInUse) {
+		if second != nil {
+			second.Close()
+		}
+		t.Fatalf("second open = %v, want ErrInUse", err)
+	}
+	store.Close()
+
+	reader, err := NewMemoryStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.GetEmails(EmailFilter{}, PageRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	jsonPath := filepath.Join(filepath.Dir(path), "emails.json")
+	if err := os.Remove(jsonPath); err != nil {
+		t.Fatal(err)
+	}
+	reader.Close()
+	if _, err := os.Stat(jsonPath); !os.IsNotExist(err) {
+		t.Errorf("closing an unchanged store rewrote its file: %v", err)
+	}
+}
+
+// Snapshots bring deleted emails back on restore, and the blobs of emails
+// a snapshot holds outlive their deletion. The test keeps the blobs the way
+// the web handlers do: an email's blobs go with it unless a snapshot holds
+// it, and with the emails restores and snapshot deletes return.
+func TestSnapshots(t *testing.T) {
+	for name, store := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			snapshotter, ok := store.(Snapshotter)
+			if !ok {
+				t.Skip("no snapshots")
+			}
+
+			blobs := map[string]bool{}
+			save := func(n int) {
+				t.Helper()
+				for i := 0; i < n; i++ {
+					email := &models.Email{From: "a@example.com", To: "b@example.com", Subject: "email"}
+					email.RawBlob = fmt.Sprintf("blob-%d", len(blobs)+1)
+					if err := store.SaveEmail(email); err != nil {
+						t.Fatal(err)
+					}
+					blobs[email.RawBlob] = true
+				}
+			}
+			deleteBlobs := func(emails []*models.Email) {
+				for _, email := range emails {
+					delete(blobs, email.RawBlob)
+				}
+			}
+			remove := func(id int) {
+				t.Helper()
+				email, err := store.GetEmail(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := store.DeleteEmail(id); err != nil {
+					t.Fatal(err)
+				}
+				if held, err := snapshotter.Holds(id); err != nil {
+					t.Fatal(err)
+				} else if !held {
+					deleteBlobs([]*models.Email{email})
+				}
+			}
+			check := func(step string, ids []int, keys ...string) {
+				t.Helper()
+				page, err := store.GetEmails(EmailFilter{}, PageRequest{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := summaryIDs(page.Emails); !reflect.DeepEqual(got, ids) {
+					t.Errorf("%s: emails %v, want %v", step, got, ids)
+				}
+				if count, _ := store.GetEmailCount(); count != len(ids) {
+					t.Errorf("%s: count %d, want %d", step, count, len(ids))
+				}
+				want := map[string]bool{}
+				for _, key := range keys {
+					want[key] = true
+				}
+				if !reflect.DeepEqual(blobs, want) {
+					t.Errorf("%s: blobs %v, want %v", step, blobs, want)
+				}
+			}
+
+			save(3)
+			base, err := snapshotter.CreateSnapshot("base")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if base.Emails != 3 || base.Watermark != 3 {
+				t.Errorf("snapshot of %d emails up to %d, want 3 up to 3", base.Emails, base.Watermark)
+			}
+			if _, err := snapshotter.CreateSnapshot("base"); !errors.Is(err, ErrSnapshotExists) {
+				t.Errorf("second snapshot named base = %v, want ErrSnapshotExists", err)
+			}
+			if _, err := snapshotter.CreateSnapshot("../base"); !errors.Is(err, ErrInvalidSnapshotName) {
+				t.Errorf("snapshot named ../base = %v, want ErrInvalidSnapshotName", err)
+			}
+
+			// Email 2 is deleted but base holds it, so its blob stays
+			remove(2)
+			if _, err := store.GetEmail(2); !errors.Is(err, ErrNotFound) {
+				t.Errorf("GetEmail(2) after delete = %v, want ErrNotFound", err)
+			}
+			save(2)
+			check("after delete and save", []int{1, 3, 4, 5}, "blob-1", "blob-2", "blob-3", "blob-4", "blob-5")
+
+			// later holds 4 and 5; 6 is in no snapshot
+			if _, err := snapshotter.CreateSnapshot("later"); err != nil {
+				t.Fatal(err)
+			}
+			remove(4)
+			save(1)
+			check("after later", []int{1, 3, 5, 6}, "blob-1", "blob-2", "blob-3", "blob-4", "blob-5", "blob-6")
+
+			purged, err := snapshotter.RestoreSnapshot("base")
+			if err != nil {
+				t.Fatal(err)
+			}
+			deleteBlobs(purged)
+			var purgedIDs []int
+			for _, email := range purged {
+				purgedIDs = append(purgedIDs, email.ID)
+			}
+			if !reflect.DeepEqual(purgedIDs, []int{6}) {
+				t.Errorf("restore purged %v, want [6]", purgedIDs)
+			}
+			check("after restore", []int{1, 2, 3}, "blob-1", "blob-2", "blob-3", "blob-4", "blob-5")
+			if email, err := store.GetEmail(2); err != nil || email.RawBlob != "blob-2" {
+				t.Errorf("restored email 2: %v (%v)", email, err)
+			}
+			for id, want := range map[int]bool{2: true, 4: true, 5: true, 6: false} {
+				if got, err := snapshotter.Holds(id); err != nil || got != want {
+					t.Errorf("Holds(%d) = %v (%v), want %v", id, got, err, want)
+				}
+			}
+
+			// Only later held 4 and 5, so they go with it
+			purged, err = snapshotter.DeleteSnapshot("later")
+			if err != nil {
+				t.Fatal(err)
+			}
+			deleteBlobs(purged)
+			check("after deleting later", []int{1, 2, 3}, "blob-1", "blob-2", "blob-3")
+			if _, err := store.GetEmail(5); !errors.Is(err, ErrNotFound) {
+				t.Errorf("GetEmail(5) after deleting later = %v, want ErrNotFound", err)
+			}
+
+			snapshots, err := snapshotter.ListSnapshots()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(snapshots) != 1 || snapshots[0].Name != "base" {
+				t.Errorf("snapshots %v, want base", snapshots)
+			}
+			if _, err := snapshotter.RestoreSnapshot("later"); !errors.Is(err, ErrSnapshotNotFound) {
+				t.Errorf("restoring the deleted snapshot = %v, want ErrSnapshotNotFound", err)
+			}
+
+			// Nothing deleted is left for base to release
+			purged, err = snapshotter.DeleteSnapshot("base")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(purged) != 0 {
+				t.Errorf("deleting base purged %d emails", len(purged))
+			}
+			check("after deleting base", []int{1, 2, 3}, "blob-1", "blob-2", "blob-3")
+		})
+	}
+}
+
+// SQLite reads pages in receive order from idx_emails_received, without
+// sorting the mailbox, and counts the matches only when asked to past the
+// first page
+func TestSQLiteReceivedOrderIndex(t *testing.T) {
+	store, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "emails.db"))
+	if err != nil {
+		t.Skip(err)
+	}
+	defer store.Close()
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.FixedZone("", 2*3600))
+	for i := 0; i < 5; i++ {
+		email := &models.Email{From: "a@example.com", To: "b@example.com", Subject: "email", CreatedAt: base.Add(time.Duration(i) * time.Minute)}
+		if err := store.SaveEmail(email); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cursor := cursorOf(t, store, 3)
+
+	for _, page := range []PageRequest{
+		{Limit: 2, Sort: SortReceived, Desc: true},
+		{Limit: 2, Sort: SortReceived, Desc: true, After: cursor},
+		{Limit: 2, Sort: SortReceived, Before: cursor},
+		{Limit: 2, Sort: SortReceived, After: cursor},
+	} {
+		conditions, args := store.filterConditions(EmailFilter{})
+		query, args := store.pageQuery(EmailFilter{}, page, conditions, args)
+		rows, err := store.db.Query("EXPLAIN QUERY PLAN "+query, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var plan []string
+		for rows.Next() {
+			var id, parent, unused int
+			var detail string
+			if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+				t.Fatal(err)
+			}
+			plan = append(plan, detail)
+		}
+		rows.Close()
+		joined := strings.Join(plan, "; ")
+		if !strings.Contains(joined, "idx_emails_received") || strings.Contains(joined, "TEMP B-TREE") {
+			t.Errorf("desc=%v after=%v before=%v: plan %q", page.Desc, page.After != nil, page.Before != nil, joined)
+		}
+	}
+
+	result, err := store.GetEmails(EmailFilter{}, PageRequest{Limit: 2, Desc: true, After: cursor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := summaryIDs(result.Emails); !reflect.DeepEqual(got, []int{2, 1}) || result.Total != nil {
+		t.Errorf("page after 3: %v total %d, want [2 1] without a total", got, pageTotal(result))
+	}
+}
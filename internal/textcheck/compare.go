@@ -0,0 +1,190 @@
+package textcheck
+
+import (
+	"math"
+	"strings"
+	"unicode"
+
+	"mailcatch/internal/models"
+)
+
+// DivergenceThreshold is the similarity under which the plain text part is
+// reported as diverged from the HTML part
+const DivergenceThreshold = 0.8
+
+// maxDiffCells bounds the word diff table; longer texts are only scored
+const maxDiffCells = 4 << 20
+
+// Check compares the plain text part of the email with its HTML part and
+// stores the result on the email. Emails without HTML are left alone.
+func Check(email *models.Email) {
+	if strings.TrimSpace(email.HTML) == "" {
+		return
+	}
+
+	check := &models.TextCheck{Generated: Render(email.HTML)}
+	if strings.TrimSpace(email.Body) == "" {
+		check.Status = "missing"
+		email.TextCheck = check
+		return
+	}
+
+	check.Similarity, check.Diff = compare(strings.Fields(email.Body), strings.Fields(check.Generated))
+	check.Similarity = math.Round(check.Similarity*1000) / 1000
+	check.Status = "ok"
+	if check.Similarity < DivergenceThreshold {
+		check.Status = "diverged"
+	}
+	email.TextCheck = check
+}
+
+// compare returns the similarity of two word lists and a diff from a to b.
+// Words are compared case-insensitively, ignoring punctuation around them.
+func compare(a, b []string) (float64, []*models.DiffChunk) {
+	if len(a)+len(b) == 0 {
+		return 1, nil
+	}
+	keysA, keysB := wordKeys(a), wordKeys(b)
+
+	// Common prefix and suffix are trimmed before the quadratic part
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && keysA[prefix] == keysB[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		keysA[len(a)-1-suffix] == keysB[len(b)-1-suffix] {
+		suffix++
+	}
+
+	midA, midB := keysA[prefix:len(a)-suffix], keysB[prefix:len(b)-suffix]
+	if len(midA)*len(midB) > maxDiffCells {
+		common := prefix + suffix + bagOverlap(midA, midB)
+		return dice(common, len(a), len(b)), nil
+	}
+
+	ops := diffWords(midA, midB)
+	common := prefix + suffix
+	for _, op := range ops {
+		if op == opEqual {
+			common++
+		}
+	}
+
+	var diff []*models.DiffChunk
+	add := func(op string, word string) {
+		if n := len(diff); n > 0 && diff[n-1].Op == op {
+			diff[n-1].Text += " " + word
+			return
+		}
+		diff = append(diff, &models.DiffChunk{Op: op, Text: word})
+	}
+
+	for _, word := range a[:prefix] {
+		add("equal", word)
+	}
+	i, j := prefix, prefix
+	for _, op := range ops {
+		switch op {
+		case opEqual:
+			add("equal", b[j])
+			i++
+			j++
+		case opDelete:
+			add("delete", a[i])
+			i++
+		case opInsert:
+			add("insert", b[j])
+			j++
+		}
+	}
+	for _, word := range b[len(b)-suffix:] {
+		add("equal", word)
+	}
+
+	return dice(common, len(a), len(b)), diff
+}
+
+const (
+	opEqual = iota
+	opDelete
+	opInsert
+)
+
+// diffWords computes an edit script from a to b through their longest
+// common subsequence
+func diffWords(a, b []string) []int {
+	// lcs[i][j] is the LCS length of a[i:] and b[j:]
+	width := len(b) + 1
+	lcs := make([]int32, (len(a)+1)*width)
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i*width+j] = lcs[(i+1)*width+j+1] + 1
+			} else if down, right := lcs[(i+1)*width+j], lcs[i*width+j+1]; down >= right {
+				lcs[i*width+j] = down
+			} else {
+				lcs[i*width+j] = right
+			}
+		}
+	}
+
+	ops := make([]int, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, opEqual)
+			i++
+			j++
+		case lcs[(i+1)*width+j] >= lcs[i*width+j+1]:
+			ops = append(ops, opDelete)
+			i++
+		default:
+			ops = append(ops, opInsert)
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, opDelete)
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, opInsert)
+	}
+	return ops
+}
+
+// bagOverlap counts the words two lists share, ignoring order
+func bagOverlap(a, b []string) int {
+	counts := make(map[string]int, len(a))
+	for _, word := range a {
+		counts[word]++
+	}
+	common := 0
+	for _, word := range b {
+		if counts[word] > 0 {
+			counts[word]--
+			common++
+		}
+	}
+	return common
+}
+
+// dice is the Dice coefficient of two word lists with common words shared
+func dice(common, lenA, lenB int) float64 {
+	if lenA+lenB == 0 {
+		return 1
+	}
+	return 2 * float64(common) / float64(lenA+lenB)
+}
+
+// wordKeys normalizes words for comparison
+func wordKeys(words []string) []string {
+	keys := make([]string, len(words))
+	for i, word := range words {
+		keys[i] = strings.ToLower(strings.TrimFunc(word, func(r rune) bool {
+			return unicode.IsPunct(r) && r != '/' && r != '%'
+		}))
+	}
+	return keys
+}
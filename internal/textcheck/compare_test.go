@@ -0,0 +1,67 @@
+package textcheck
+
+import (
+	"reflect"
+	"testing"
+
+	"mailcatch/internal/models"
+)
+
+func TestCheckDiff(t *testing.T) {
+	email := &models.Email{
+		Body: "Welcome Alice!\r\n\r\nYour code is 654321.\r\n\r\nIt expires in 15 minutes.\r\n",
+		HTML: `<h1>Welcome, Alice!</h1><p>Your code is <b>123456</b>.</p><p>It expires in 10 minutes.</p>` +
+			`<p><a href="https://example.com/help">Get help</a></p>`,
+	}
+	Check(email)
+
+	check := email.TextCheck
+	if want := "Welcome, Alice!\n\nYour code is 123456.\n\nIt expires in 10 minutes.\n\nGet help (https://example.com/help)"; check.Generated != want {
+		t.Errorf("generated %q, want %q", check.Generated, want)
+	}
+	// Words match regardless of case and the punctuation around them
+	want := []*models.DiffChunk{
+		{Op: "equal", Text: "Welcome Alice! Your code is"},
+		{Op: "delete", Text: "654321."},
+		{Op: "insert", Text: "123456."},
+		{Op: "equal", Text: "It expires in"},
+		{Op: "delete", Text: "15"},
+		{Op: "insert", Text: "10"},
+		{Op: "equal", Text: "minutes."},
+		{Op: "insert", Text: "Get help (https://example.com/help)"},
+	}
+	if !reflect.DeepEqual(check.Diff, want) {
+		t.Errorf("diff:")
+		for _, chunk := range check.Diff {
+			t.Errorf("  %s %q", chunk.Op, chunk.Text)
+		}
+	}
+	// 9 of the 11 and 14 words are shared
+	if check.Similarity != 0.72 || check.Status != "diverged" {
+		t.Errorf("similarity %v, status %s, want 0.72 diverged", check.Similarity, check.Status)
+	}
+}
+
+func TestCheckStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		email  models.Email
+		status string
+	}{
+		{"matching", models.Email{Body: "Hello  world\r\n", HTML: "<p>Hello <i>world</i></p>"}, "ok"},
+		{"no text part", models.Email{HTML: "<p>Hello</p>"}, "missing"},
+		{"no HTML part", models.Email{Body: "Hello"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Check(&tt.email)
+			status := ""
+			if tt.email.TextCheck != nil {
+				status = tt.email.TextCheck.Status
+			}
+			if status != tt.status {
+				t.Errorf("status %q, want %q", status, tt.status)
+			}
+		})
+	}
+}
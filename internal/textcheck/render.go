@@ -0,0 +1,214 @@
+// Package textcheck renders HTML bodies to plain text and compares the
+// result with the plain text alternative of an email.
+package textcheck
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// blockElements start and end a paragraph in the rendered text
+var blockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true,
+	"div": true, "dl": true, "dt": true, "dd": true, "fieldset": true,
+	"figure": true, "footer": true, "form": true, "h1": true, "h2": true,
+	"h3": true, "h4": true, "h5": true, "h6": true, "header": true,
+	"hr": true, "main": true, "nav": true, "ol": true,
+	"p": true, "pre": true, "section": true, "table": true, "tr": true,
+	"ul": true,
+}
+
+// hiddenElements have no visible text
+var hiddenElements = map[string]bool{
+	"head": true, "script": true, "style": true, "title": true, "template": true,
+}
+
+// renderer accumulates the text of an HTML document
+type renderer struct {
+	out    strings.Builder
+	line   strings.Builder
+	hidden int
+	pre    int
+	// links are the anchors being rendered, innermost last
+	links []*anchor
+}
+
+type anchor struct {
+	href  string
+	start int
+}
+
+// Render converts an HTML body to plain text the way text alternatives
+// are usually written: one paragraph per block, list items prefixed with
+// "- " and links followed by their URL in parentheses
+func Render(document string) string {
+	r := &renderer{}
+	tokenizer := html.NewTokenizer(strings.NewReader(document))
+
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			r.paragraph()
+			return strings.TrimSpace(r.out.String())
+		case html.TextToken:
+			if r.hidden == 0 {
+				r.text(string(tokenizer.Text()))
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			r.start(token, token.Type == html.SelfClosingTagToken)
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			r.end(string(name))
+		}
+	}
+}
+
+func (r *renderer) start(token html.Token, selfClosing bool) {
+	name := token.Data
+	if hiddenElements[name] {
+		if !selfClosing {
+			r.hidden++
+		}
+		return
+	}
+	if r.hidden > 0 {
+		return
+	}
+
+	switch {
+	case name == "br":
+		r.newline()
+	case name == "img":
+		if alt := attr(token, "alt"); alt != "" {
+			r.text(alt)
+		}
+	case name == "a" && !selfClosing:
+		r.links = append(r.links, &anchor{href: attr(token, "href"), start: r.line.Len()})
+	case name == "td" || name == "th":
+		r.text(" ")
+	case name == "li":
+		// List items are kept on consecutive lines
+		if strings.TrimSpace(r.line.String()) != "" {
+			r.newline()
+		}
+		r.line.Reset()
+		r.line.WriteString("- ")
+	case blockElements[name]:
+		r.paragraph()
+		if name == "pre" && !selfClosing {
+			r.pre++
+		}
+	}
+}
+
+func (r *renderer) end(name string) {
+	if hiddenElements[name] {
+		if r.hidden > 0 {
+			r.hidden--
+		}
+		return
+	}
+	if r.hidden > 0 {
+		return
+	}
+
+	switch {
+	case name == "a" && len(r.links) > 0:
+		link := r.links[len(r.links)-1]
+		r.links = r.links[:len(r.links)-1]
+
+		text := ""
+		if link.start <= r.line.Len() {
+			text = strings.TrimSpace(r.line.String()[link.start:])
+		}
+		href := link.href
+		if strings.HasPrefix(href, "mailto:") {
+			href = strings.TrimPrefix(href, "mailto:")
+		}
+		if href != "" && !strings.HasPrefix(href, "#") && text != href {
+			r.text(" (" + href + ")")
+		}
+	case name == "li":
+		if strings.TrimSpace(r.line.String()) != "" {
+			r.newline()
+		}
+	case blockElements[name]:
+		if name == "pre" && r.pre > 0 {
+			r.pre--
+		}
+		r.paragraph()
+	}
+}
+
+// text appends text to the current line, collapsing whitespace outside
+// of <pre>
+func (r *renderer) text(s string) {
+	if r.pre > 0 {
+		lines := strings.Split(s, "\n")
+		for i, line := range lines {
+			if i > 0 {
+				r.newline()
+			}
+			r.line.WriteString(line)
+		}
+		return
+	}
+
+	if s != "" && isSpace(s[0]) && r.line.Len() > 0 && !strings.HasSuffix(r.line.String(), " ") {
+		r.line.WriteByte(' ')
+	}
+	words := strings.Fields(s)
+	for i, word := range words {
+		if i > 0 {
+			r.line.WriteByte(' ')
+		}
+		r.line.WriteString(word)
+	}
+	if len(words) > 0 && isSpace(s[len(s)-1]) {
+		r.line.WriteByte(' ')
+	}
+}
+
+// newline ends the current line
+func (r *renderer) newline() {
+	r.out.WriteString(strings.TrimRight(r.line.String(), " "))
+	r.out.WriteByte('\n')
+	r.line.Reset()
+	r.resetLinks()
+}
+
+// paragraph ends the current line and leaves an empty line after it,
+// unless the output already ends with one
+func (r *renderer) paragraph() {
+	if strings.TrimSpace(r.line.String()) != "" {
+		r.newline()
+	} else {
+		r.line.Reset()
+		r.resetLinks()
+	}
+	if out := r.out.String(); out != "" && !strings.HasSuffix(out, "\n\n") {
+		r.out.WriteByte('\n')
+	}
+}
+
+// resetLinks moves open anchors to the start of the new line
+func (r *renderer) resetLinks() {
+	for _, link := range r.links {
+		link.start = 0
+	}
+}
+
+func attr(token html.Token, key string) string {
+	for _, a := range token.Attr {
+		if a.Key == key {
+			return strings.TrimSpace(a.Val)
+		}
+	}
+	return ""
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
@@ -0,0 +1,103 @@
+package tnef
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Compressed RTF formats (MS-OXRTFCP)
+const (
+	rtfCompressed   = 0x75465A4C // "LZFu"
+	rtfUncompressed = 0x414C454D // "MELA"
+)
+
+// rtfPrebuf initializes the LZFu dictionary
+const rtfPrebuf = "{\\rtf1\\ansi\\mac\\deff0\\deftab720{\\fonttbl;}{\\f0\\fnil \\froman " +
+	"\\fswiss \\fmodern \\fscript \\fdecor MS Sans SerifSymbolArialTimes New RomanCourier" +
+	"{\\colortbl\\red0\\green0\\blue0\r\n\\par \\pard\\plain\\f0\\fs20\\b\\i\\u\\tab\\tx"
+
+var errRTFTruncated = errors.New("tnef: truncated compressed RTF")
+
+// rtfMaxExpansion bounds how much LZFu can grow its input: a control byte
+// and eight two byte references (17 bytes) expand to at most 8*17 bytes
+const rtfMaxExpansion = 8
+
+// DecompressRTF expands a PR_RTF_COMPRESSED property value
+func DecompressRTF(data []byte) ([]byte, error) {
+	if len(data) < 16 {
+		return nil, errRTFTruncated
+	}
+
+	compSize := uint64(binary.LittleEndian.Uint32(data[0:]))
+	rawSize := uint64(binary.LittleEndian.Uint32(data[4:]))
+	compType := binary.LittleEndian.Uint32(data[8:])
+
+	// compSize counts the bytes following the size field itself
+	end := len(data)
+	if compSize+4 >= 16 && compSize+4 < uint64(len(data)) {
+		end = int(compSize + 4)
+	}
+	input := data[16:end]
+
+	// The header is untrusted: never size buffers from it beyond what the
+	// input can actually produce
+	if rawSize > uint64(len(input))*rtfMaxExpansion+rtfMaxExpansion {
+		return nil, fmt.Errorf("tnef: RTF size %d exceeds what %d compressed bytes can hold", rawSize, len(input))
+	}
+
+	switch compType {
+	case rtfUncompressed:
+		if rawSize < uint64(len(input)) {
+			input = input[:rawSize]
+		}
+		return input, nil
+	case rtfCompressed:
+	default:
+		return nil, fmt.Errorf("tnef: unknown RTF compression %#x", compType)
+	}
+
+	const dictSize = 4096
+	var dict [dictSize]byte
+	copy(dict[:], rtfPrebuf)
+	writePos := len(rtfPrebuf)
+
+	out := make([]byte, 0, int(rawSize))
+	pos := 0
+	for pos < len(input) {
+		control := input[pos]
+		pos++
+
+		for bit := 0; bit < 8 && pos < len(input); bit++ {
+			if control&(1<<bit) == 0 {
+				b := input[pos]
+				pos++
+				out = append(out, b)
+				dict[writePos] = b
+				writePos = (writePos + 1) % dictSize
+				continue
+			}
+
+			if pos+2 > len(input) {
+				return out, errRTFTruncated
+			}
+			token := int(input[pos])<<8 | int(input[pos+1])
+			pos += 2
+
+			offset := token >> 4
+			length := token&0xF + 2
+			if offset == writePos {
+				return out, nil
+			}
+
+			for i := 0; i < length; i++ {
+				b := dict[(offset+i)%dictSize]
+				out = append(out, b)
+				dict[writePos] = b
+				writePos = (writePos + 1) % dictSize
+			}
+		}
+	}
+
+	return out, nil
+}
@@ -0,0 +1,82 @@
+package tnef
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+// specRTF is the compressed example from MS-OXRTFCP section 3.1.1.1
+const specRTF = "2d0000002b0000004c5a4675f1c5c7a703000a00726370673132354232" +
+	"0af32068656c090020627705b06c647d0a800fa0"
+
+func decodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDecompressRTF(t *testing.T) {
+	out, err := DecompressRTF(decodeHex(t, specRTF))
+	if err != nil {
+		t.Fatalf("DecompressRTF: %v", err)
+	}
+	if want := "{\\rtf1\\ansi\\ansicpg1252\\pard hello world}\r\n"; string(out) != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+}
+
+func TestDecompressRTFUncompressed(t *testing.T) {
+	data := make([]byte, 16)
+	binary.LittleEndian.PutUint32(data[0:], 12+5)
+	binary.LittleEndian.PutUint32(data[4:], 5)
+	binary.LittleEndian.PutUint32(data[8:], rtfUncompressed)
+	data = append(data, "{\\rtf}"...)
+
+	out, err := DecompressRTF(data)
+	if err != nil {
+		t.Fatalf("DecompressRTF: %v", err)
+	}
+	if string(out) != "{\\rtf" {
+		t.Errorf("got %q, want the declared 5 bytes", out)
+	}
+}
+
+func TestDecompressRTFHostileHeader(t *testing.T) {
+	spec := decodeHex(t, specRTF)
+
+	huge := append([]byte(nil), spec...)
+	binary.LittleEndian.PutUint32(huge[4:], 0xFFFFFFFF)
+
+	hugeComp := append([]byte(nil), spec...)
+	binary.LittleEndian.PutUint32(hugeComp[0:], 0xFFFFFFFF)
+
+	unknown := append([]byte(nil), spec...)
+	binary.LittleEndian.PutUint32(unknown[8:], 0x12345678)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{"short header", spec[:10], true},
+		{"4 GiB raw size", huge, true},
+		{"compressed size past the end", hugeComp, false},
+		{"truncated reference", spec[:len(spec)-1], true},
+		{"unknown compression", unknown, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := DecompressRTF(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecompressRTF() = %v, want error %v", err, tt.wantErr)
+			}
+			if cap(out) > len(tt.data)*rtfMaxExpansion+rtfMaxExpansion {
+				t.Errorf("output capacity %d is not bounded by the input", cap(out))
+			}
+		})
+	}
+}
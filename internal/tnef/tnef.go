@@ -0,0 +1,369 @@
+// Package tnef decodes Transport Neutral Encapsulation Format streams, the
+// winmail.dat / application/ms-tnef parts produced by Outlook and Exchange.
+package tnef
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// Signature is the magic number every TNEF stream starts with
+const Signature = 0x223E9F78
+
+// Attribute levels
+const (
+	levelMessage    = 0x01
+	levelAttachment = 0x02
+)
+
+// Attribute IDs (lower 16 bits of the attribute tag)
+const (
+	attBody           = 0x800C
+	attAttachData     = 0x800F
+	attAttachTitle    = 0x8010
+	attAttachRendData = 0x9002
+	attMAPIProps      = 0x9003
+	attAttachment     = 0x9005
+)
+
+// MAPI property IDs
+const (
+	propBody               = 0x1000
+	propRTFCompressed      = 0x1009
+	propBodyHTML           = 0x1013
+	propDisplayName        = 0x3001
+	propAttachDataBinary   = 0x3701
+	propAttachFilename     = 0x3704
+	propAttachLongFilename = 0x3707
+	propAttachMIMETag      = 0x370E
+	propAttachContentID    = 0x3712
+)
+
+// ErrNotTNEF is returned when the data lacks the TNEF signature
+var ErrNotTNEF = errors.New("tnef: invalid signature")
+
+// Message is the decoded content of a TNEF stream
+type Message struct {
+	Body string
+	HTML string
+	// RTF is the decompressed rich text body, if any
+	RTF         []byte
+	Attachments []*Attachment
+}
+
+// Attachment is a file embedded in a TNEF stream
+type Attachment struct {
+	Filename  string
+	MIMEType  string
+	ContentID string
+	Data      []byte
+}
+
+// Decode parses a TNEF stream
+func Decode(data []byte) (*Message, error) {
+	if len(data) < 6 || binary.LittleEndian.Uint32(data) != Signature {
+		return nil, ErrNotTNEF
+	}
+
+	msg := &Message{}
+	var current *Attachment
+	pos := 6
+
+	for pos < len(data) {
+		// level (1) + tag (4) + length (4) ... data ... checksum (2)
+		if pos+9 > len(data) {
+			return msg, fmt.Errorf("tnef: truncated attribute header at offset %d", pos)
+		}
+		level := data[pos]
+		tag := binary.LittleEndian.Uint32(data[pos+1:])
+		size := binary.LittleEndian.Uint32(data[pos+5:])
+		pos += 9
+
+		if uint64(size)+2 > uint64(len(data)-pos) {
+			return msg, fmt.Errorf("tnef: truncated attribute %#x", tag)
+		}
+		length := int(size)
+		value := data[pos : pos+length]
+		pos += length + 2
+
+		switch id := tag & 0xFFFF; {
+		case level == levelAttachment && id == attAttachRendData:
+			current = &Attachment{}
+			msg.Attachments = append(msg.Attachments, current)
+		case level == levelAttachment && id == attAttachTitle && current != nil:
+			if current.Filename == "" {
+				current.Filename = cString(value)
+			}
+		case level == levelAttachment && id == attAttachData && current != nil:
+			current.Data = value
+		case level == levelAttachment && id == attAttachment && current != nil:
+			props, err := decodeProps(value)
+			if err != nil {
+				return msg, err
+			}
+			applyAttachmentProps(current, props)
+		case level == levelMessage && id == attBody:
+			msg.Body = cString(value)
+		case level == levelMessage && id == attMAPIProps:
+			props, err := decodeProps(value)
+			if err != nil {
+				return msg, err
+			}
+			if err := applyMessageProps(msg, props); err != nil {
+				return msg, err
+			}
+		}
+	}
+
+	return msg, nil
+}
+
+func applyMessageProps(msg *Message, props map[uint16]interface{}) error {
+	if body, ok := props[propBody].(string); ok && msg.Body == "" {
+		msg.Body = body
+	}
+
+	switch html := props[propBodyHTML].(type) {
+	case string:
+		msg.HTML = html
+	case []byte:
+		msg.HTML = string(bytes.TrimRight(html, "\x00"))
+	}
+
+	if compressed, ok := props[propRTFCompressed].([]byte); ok {
+		rtf, err := DecompressRTF(compressed)
+		if err != nil {
+			return err
+		}
+		msg.RTF = rtf
+	}
+
+	return nil
+}
+
+func applyAttachmentProps(attachment *Attachment, props map[uint16]interface{}) {
+	if name, ok := props[propAttachLongFilename].(string); ok && name != "" {
+		attachment.Filename = name
+	} else if name, ok := props[propAttachFilename].(string); ok && name != "" && attachment.Filename == "" {
+		attachment.Filename = name
+	} else if name, ok := props[propDisplayName].(string); ok && name != "" && attachment.Filename == "" {
+		attachment.Filename = name
+	}
+
+	if mimeType, ok := props[propAttachMIMETag].(string); ok {
+		attachment.MIMEType = mimeType
+	}
+	if contentID, ok := props[propAttachContentID].(string); ok {
+		attachment.ContentID = contentID
+	}
+	if data, ok := props[propAttachDataBinary].([]byte); ok && len(attachment.Data) == 0 {
+		attachment.Data = data
+	}
+}
+
+// MAPI property types
+const (
+	ptShort    = 0x0002
+	ptLong     = 0x0003
+	ptFloat    = 0x0004
+	ptDouble   = 0x0005
+	ptCurrency = 0x0006
+	ptAppTime  = 0x0007
+	ptError    = 0x000A
+	ptBoolean  = 0x000B
+	ptObject   = 0x000D
+	ptInt64    = 0x0014
+	ptString8  = 0x001E
+	ptUnicode  = 0x001F
+	ptSysTime  = 0x0040
+	ptCLSID    = 0x0048
+	ptBinary   = 0x0102
+	ptNull     = 0x0001
+	mvFlag     = 0x1000
+)
+
+// decodeProps parses an encoded MAPI property list. Only single valued
+// string and binary properties are returned; others are skipped.
+func decodeProps(data []byte) (map[uint16]interface{}, error) {
+	r := &reader{data: data}
+	props := make(map[uint16]interface{})
+
+	count, err := r.uint32()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := uint32(0); i < count; i++ {
+		propType, err := r.uint16()
+		if err != nil {
+			return props, err
+		}
+		propID, err := r.uint16()
+		if err != nil {
+			return props, err
+		}
+
+		// Named properties carry a GUID and either a numeric ID or a name
+		if propID >= 0x8000 {
+			if err := r.skip(16); err != nil {
+				return props, err
+			}
+			kind, err := r.uint32()
+			if err != nil {
+				return props, err
+			}
+			if kind == 0 {
+				err = r.skip(4)
+			} else {
+				var nameLen uint32
+				if nameLen, err = r.uint32(); err == nil {
+					err = r.skip(padded(int(nameLen)))
+				}
+			}
+			if err != nil {
+				return props, err
+			}
+		}
+
+		multi := propType&mvFlag != 0
+		baseType := propType &^ mvFlag
+
+		values := uint32(1)
+		if multi || isVariableLength(baseType) {
+			if values, err = r.uint32(); err != nil {
+				return props, err
+			}
+		}
+
+		for v := uint32(0); v < values; v++ {
+			value, err := r.value(baseType)
+			if err != nil {
+				return props, err
+			}
+			if !multi && v == 0 && value != nil {
+				props[propID] = value
+			}
+		}
+	}
+
+	return props, nil
+}
+
+func isVariableLength(propType uint16) bool {
+	switch propType {
+	case ptString8, ptUnicode, ptBinary, ptObject:
+		return true
+	}
+	return false
+}
+
+type reader struct {
+	data []byte
+	pos  int
+}
+
+var errShort = errors.New("tnef: truncated property list")
+
+func (r *reader) next(n int) ([]byte, error) {
+	if n < 0 || n > len(r.data)-r.pos {
+		return nil, errShort
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *reader) skip(n int) error {
+	_, err := r.next(n)
+	return err
+}
+
+func (r *reader) uint16() (uint16, error) {
+	b, err := r.next(2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(b), nil
+}
+
+func (r *reader) uint32() (uint32, error) {
+	b, err := r.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+// value reads one property value; variable length values are padded to
+// a multiple of four bytes
+func (r *reader) value(propType uint16) (interface{}, error) {
+	switch propType {
+	case ptShort, ptLong, ptFloat, ptError, ptBoolean, ptNull:
+		return nil, r.skip(4)
+	case ptDouble, ptCurrency, ptAppTime, ptInt64, ptSysTime:
+		return nil, r.skip(8)
+	case ptCLSID:
+		return nil, r.skip(16)
+	case ptString8, ptUnicode, ptBinary, ptObject:
+		length, err := r.uint32()
+		if err != nil {
+			return nil, err
+		}
+		if uint64(length) > uint64(len(r.data)-r.pos) {
+			return nil, errShort
+		}
+		b, err := r.next(int(length))
+		if err != nil {
+			return nil, err
+		}
+		if err := r.skip(padded(int(length)) - int(length)); err != nil {
+			return nil, err
+		}
+
+		switch propType {
+		case ptString8:
+			return cString(b), nil
+		case ptUnicode:
+			return utf16String(b), nil
+		case ptObject:
+			// Embedded objects are prefixed with the interface ID
+			if len(b) >= 16 {
+				return b[16:], nil
+			}
+			return nil, nil
+		default:
+			return b, nil
+		}
+	default:
+		return nil, fmt.Errorf("tnef: unsupported property type %#x", propType)
+	}
+}
+
+func padded(n int) int {
+	return (n + 3) &^ 3
+}
+
+// cString returns b up to its first NUL byte
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i != -1 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// utf16String decodes NUL terminated UTF-16LE text
+func utf16String(b []byte) string {
+	units := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		unit := binary.LittleEndian.Uint16(b[i:])
+		if unit == 0 {
+			break
+		}
+		units = append(units, unit)
+	}
+	return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+}
@@ -0,0 +1,71 @@
+package tnef
+
+import (
+	"encoding/binary"
+	"os"
+	"testing"
+)
+
+func readFixture(t *testing.T) []byte {
+	t.Helper()
+	data, err := os.ReadFile("testdata/winmail.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDecodeWinmail(t *testing.T) {
+	msg, err := Decode(readFixture(t))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	if msg.Body != "hello world" {
+		t.Errorf("Body = %q, want %q", msg.Body, "hello world")
+	}
+	if want := "{\\rtf1\\ansi\\ansicpg1252\\pard hello world}\r\n"; string(msg.RTF) != want {
+		t.Errorf("RTF = %q, want %q", msg.RTF, want)
+	}
+
+	if len(msg.Attachments) != 1 {
+		t.Fatalf("got %d attachments, want 1", len(msg.Attachments))
+	}
+	attachment := msg.Attachments[0]
+	if attachment.Filename != "quarterly report.txt" {
+		t.Errorf("Filename = %q, want the long filename", attachment.Filename)
+	}
+	if attachment.MIMEType != "text/plain" {
+		t.Errorf("MIMEType = %q, want text/plain", attachment.MIMEType)
+	}
+	if string(attachment.Data) != "quarterly numbers\r\n" {
+		t.Errorf("Data = %q", attachment.Data)
+	}
+}
+
+func TestDecodeRejectsDamagedStreams(t *testing.T) {
+	data := readFixture(t)
+
+	// Claim a 4 GiB attribute right after the signature and key
+	hostile := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint32(hostile[6+5:], 0xFFFFFFFF)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"truncated", data[:len(data)-7]},
+		{"huge attribute length", hostile},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decode(tt.data); err == nil {
+				t.Error("Decode succeeded")
+			}
+		})
+	}
+
+	if _, err := Decode([]byte("not a tnef stream")); err != ErrNotTNEF {
+		t.Errorf("Decode of plain text = %v, want ErrNotTNEF", err)
+	}
+}
@@ -1,23 +1,51 @@
 package web
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
 	"net/http"
+	"net/mail"
 	"strconv"
+	"strings"
+	"time"
 
+	"mailcatch/internal/backup"
+	"mailcatch/internal/blob"
+	"mailcatch/internal/compat"
+	"mailcatch/internal/export"
+	"mailcatch/internal/extract"
+	"mailcatch/internal/importer"
+	"mailcatch/internal/links"
 	"mailcatch/internal/models"
+	"mailcatch/internal/retention"
 	"mailcatch/internal/storage"
 	"github.com/gin-gonic/gin"
 )
 
 type Handler struct {
-	storage storage.Storage
-	hub     *WebSocketHub
+	storage   storage.Storage
+	blobs     *blob.Store
+	linter    *compat.Linter
+	checker   *links.Checker
+	extractor *extract.Extractor
+	janitor   *retention.Janitor
+	importer  *importer.Importer
+	hub       *WebSocketHub
 }
 
-func NewHandler(storage storage.Storage, hub *WebSocketHub) *Handler {
+func NewHandler(storage storage.Storage, blobs *blob.Store, linter *compat.Linter, checker *links.Checker, extractor *extract.Extractor, janitor *retention.Janitor, importer *importer.Importer, hub *WebSocketHub) *Handler {
 	return &Handler{
-		storage: storage,
-		hub:     hub,
+		storage:   storage,
+		blobs:     blobs,
+		linter:    linter,
+		checker:   checker,
+		extractor: extractor,
+		janitor:   janitor,
+		importer:  importer,
+		hub:       hub,
 	}
 }
 
@@ -29,13 +57,102 @@ func (h *Handler) GetEmails(c *gin.Context) {
 		return
 	}
 	
-	emails, err := h.storage.GetEmails(limit)
+	filter, err := storage.ParseFilter(c.Request.URL.Query())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := h.parsePageRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	page.Limit = limit
+	
+	result, err := h.storage.GetEmails(filter, page)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 	
-	c.JSON(http.StatusOK, emails)
+	c.JSON(http.StatusOK, result)
+}
+
+// parsePageRequest reads the ordering and cursor parameters of the email
+// list
+func (h *Handler) parsePageRequest(c *gin.Context) (storage.PageRequest, error) {
+	page := storage.PageRequest{Sort: c.DefaultQuery("sort", storage.SortReceived)}
+	switch page.Sort {
+	case storage.SortReceived, storage.SortSubject, storage.SortFrom:
+	default:
+		return page, fmt.Errorf("Invalid sort parameter")
+	}
+
+	// Newest first unless asked otherwise
+	switch c.DefaultQuery("order", "desc") {
+	case "desc":
+		page.Desc = true
+	case "asc":
+	default:
+		return page, fmt.Errorf("Invalid order parameter")
+	}
+
+	var err error
+	if page.After, err = h.parseCursor(c.Query("after"), page.Sort); err != nil {
+		return page, fmt.Errorf("Invalid after parameter: %v", err)
+	}
+	if page.Before, err = h.parseCursor(c.Query("before"), page.Sort); err != nil {
+		return page, fmt.Errorf("Invalid before parameter: %v", err)
+	}
+	if page.After != nil && page.Before != nil {
+		return page, fmt.Errorf("Only one of after and before can be given")
+	}
+
+	// Pages after the first count the matches only when asked to
+	if param := c.Query("total"); param != "" {
+		if page.CountTotal, err = strconv.ParseBool(param); err != nil {
+			return page, fmt.Errorf("Invalid total parameter")
+		}
+	}
+	return page, nil
+}
+
+// parseCursor accepts a cursor from a previous page, an email ID, or an
+// RFC 3339 time when sorting by received time
+func (h *Handler) parseCursor(param, sort string) (*storage.Cursor, error) {
+	if param == "" {
+		return nil, nil
+	}
+
+	if id, err := strconv.Atoi(param); err == nil {
+		email, err := h.storage.GetEmail(id)
+		if err != nil {
+			return nil, fmt.Errorf("email %d not found", id)
+		}
+		return storage.CursorAt(&models.EmailSummary{
+			ID:        email.ID,
+			From:      email.From,
+			Subject:   email.Subject,
+			CreatedAt: email.CreatedAt,
+		}, sort), nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, param); err == nil {
+		if sort != storage.SortReceived {
+			return nil, fmt.Errorf("times can only be used when sorting by received time")
+		}
+		return &storage.Cursor{Sort: sort, Time: t}, nil
+	}
+
+	cursor, err := storage.DecodeCursor(param)
+	if err != nil {
+		return nil, err
+	}
+	if cursor.Sort != sort {
+		return nil, fmt.Errorf("cursor was issued for sorting by %s", cursor.Sort)
+	}
+	return cursor, nil
 }
 
 func (h *Handler) GetEmail(c *gin.Context) {
@@ -52,7 +169,344 @@ func (h *Handler) GetEmail(c *gin.Context) {
 		return
 	}
 	
-	c.JSON(http.StatusOK, email)
+	c.JSON(http.StatusOK, withoutAttachmentContent(email))
+}
+
+// GetRawEmail serves the message exactly as it was received
+func (h *Handler) GetRawEmail(c *gin.Context) {
+	h.serveRaw(c, "inline")
+}
+
+// DownloadEmail serves the message exactly as it was received as a .eml
+// file to save
+func (h *Handler) DownloadEmail(c *gin.Context) {
+	h.serveRaw(c, "attachment")
+}
+
+func (h *Handler) serveRaw(c *gin.Context, disposition string) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email ID"})
+		return
+	}
+
+	email, err := h.storage.GetEmail(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Email not found"})
+		return
+	}
+
+	raw, err := h.blobs.OpenRaw(email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer raw.Close()
+
+	size := int64(len(email.Raw))
+	if email.RawBlob != "" {
+		size = email.Size
+	}
+
+	c.DataFromReader(http.StatusOK, size, "message/rfc822", raw, map[string]string{
+		"Content-Disposition": mime.FormatMediaType(disposition, map[string]string{"filename": fmt.Sprintf("email-%d.eml", id)}),
+	})
+}
+
+// ExportEmails streams the emails matching the list filters as mbox, a
+// zip of a Maildir or a zip of .eml files
+func (h *Handler) ExportEmails(c *gin.Context) {
+	filter, err := storage.ParseFilter(c.Request.URL.Query())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	format := c.DefaultQuery("format", export.FormatMbox)
+	writer, err := export.NewWriter(format, c.Writer)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", export.ContentType(format))
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": export.Filename(format, time.Now())}))
+
+	count, err := export.Export(h.storage, h.blobs, filter, writer)
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		// Once the export started streaming, the response can only be cut
+		// short
+		if !c.Writer.Written() {
+			c.Header("Content-Type", "")
+			c.Header("Content-Disposition", "")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Error exporting emails after %d emails: %v", count, err)
+		c.Abort()
+	}
+}
+
+// Backup streams a backup of the database and the blobs of its emails,
+// copied consistently while the server keeps receiving mail
+func (h *Handler) Backup(c *gin.Context) {
+	if _, ok := h.storage.(storage.Backuper); !ok {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": storage.ErrBackupUnsupported.Error()})
+		return
+	}
+
+	c.Header("Content-Type", backup.ContentType)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": backup.Filename(time.Now())}))
+
+	if _, err := backup.Write(c.Writer, h.storage, h.blobs); err != nil {
+		if !c.Writer.Written() {
+			c.Header("Content-Type", "")
+			c.Header("Content-Disposition", "")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Error writing backup: %v", err)
+		c.Abort()
+	}
+}
+
+// GetCompat reports the HTML and CSS features of the HTML body that common
+// email clients do not support
+func (h *Handler) GetCompat(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email ID"})
+		return
+	}
+
+	email, err := h.storage.GetEmail(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Email not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, h.linter.Check(email.HTML))
+}
+
+// GetLinks lists the URLs of an email. With check=true the links under the
+// configured base URLs are requested and their status reported.
+func (h *Handler) GetLinks(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email ID"})
+		return
+	}
+
+	check := false
+	if value := c.Query("check"); value != "" {
+		if check, err = strconv.ParseBool(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid check parameter"})
+			return
+		}
+	}
+	if check && h.checker == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Link checking is not enabled, set --link-check-base"})
+		return
+	}
+
+	email, err := h.storage.GetEmail(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Email not found"})
+		return
+	}
+
+	// List-Unsubscribe is only kept in the raw message
+	var header mail.Header
+	if raw, err := h.blobs.OpenRaw(email); err == nil {
+		if msg, err := mail.ReadMessage(raw); err == nil {
+			header = msg.Header
+		}
+		raw.Close()
+	}
+
+	found := links.Extract(header, email.Body, email.HTML)
+	if check {
+		h.checker.Check(c.Request.Context(), found)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"links":  found,
+		"broken": links.Broken(found),
+	})
+}
+
+// GetExtractPresets lists the extractors available to the extract endpoints
+func (h *Handler) GetExtractPresets(c *gin.Context) {
+	c.JSON(http.StatusOK, h.extractor.Presets())
+}
+
+// ExtractFromEmail extracts a value such as a one-time code from an email
+func (h *Handler) ExtractFromEmail(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email ID"})
+		return
+	}
+
+	preset, ok := h.extractPreset(c)
+	if !ok {
+		return
+	}
+
+	email, err := h.storage.GetEmail(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Email not found"})
+		return
+	}
+
+	h.respondExtract(c, email, preset)
+}
+
+// ExtractLatest extracts a value from the latest email sent to a recipient
+func (h *Handler) ExtractLatest(c *gin.Context) {
+	to := c.Query("to")
+	if to == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing to parameter"})
+		return
+	}
+	// Anything but a bare address would be matched as a substring
+	if address, err := mail.ParseAddress(to); err != nil || !strings.EqualFold(address.Address, strings.TrimSpace(to)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to parameter: not an email address"})
+		return
+	}
+
+	preset, ok := h.extractPreset(c)
+	if !ok {
+		return
+	}
+
+	email, err := h.latestTo(to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if email == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No email to " + to})
+		return
+	}
+
+	h.respondExtract(c, email, preset)
+}
+
+// latestTo returns the latest email addressed to exactly this recipient,
+// or nil. The storage To filter matches a whole address against the
+// envelope and the To and Cc headers, so the first email of the newest
+// first list is the one.
+func (h *Handler) latestTo(to string) (*models.Email, error) {
+	result, err := h.storage.GetEmails(storage.EmailFilter{To: to}, storage.PageRequest{Limit: 1, Desc: true})
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Emails) == 0 {
+		return nil, nil
+	}
+	email, err := h.storage.GetEmail(result.Emails[0].ID)
+	if errors.Is(err, storage.ErrNotFound) {
+		// Deleted meanwhile
+		return nil, nil
+	}
+	return email, err
+}
+
+// extractPreset resolves the preset or regex query parameter, responding
+// with an error when neither is usable
+func (h *Handler) extractPreset(c *gin.Context) (*extract.Preset, bool) {
+	if pattern := c.Query("regex"); pattern != "" {
+		preset, err := extract.Custom(pattern, c.Query("source") == "links")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid regex parameter: " + err.Error()})
+			return nil, false
+		}
+		return preset, true
+	}
+
+	name := c.Query("preset")
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing preset or regex parameter"})
+		return nil, false
+	}
+	preset, ok := h.extractor.Preset(name)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown preset " + name})
+		return nil, false
+	}
+	return preset, true
+}
+
+func (h *Handler) respondExtract(c *gin.Context, email *models.Email, preset *extract.Preset) {
+	result := extract.Extract(email, preset, c.Query("match"))
+	if result == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Nothing matched", "email_id": email.ID})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (h *Handler) DownloadAttachment(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email ID"})
+		return
+	}
+
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid attachment index"})
+		return
+	}
+
+	email, err := h.storage.GetEmail(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Email not found"})
+		return
+	}
+
+	if index < 0 || index >= len(email.Attachments) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return
+	}
+
+	attachment := email.Attachments[index]
+	filename := attachment.Filename
+	if filename == "" {
+		filename = fmt.Sprintf("attachment-%d", index)
+	}
+
+	content, err := h.blobs.OpenAttachment(attachment)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer content.Close()
+
+	c.DataFromReader(http.StatusOK, attachment.Size, attachment.ContentType, content, map[string]string{
+		"Content-Disposition": mime.FormatMediaType("attachment", map[string]string{"filename": filename}),
+	})
+}
+
+// withoutAttachmentContent returns a copy of the email whose attachments
+// omit their content, which is served by DownloadAttachment instead
+func withoutAttachmentContent(email *models.Email) *models.Email {
+	if len(email.Attachments) == 0 {
+		return email
+	}
+
+	stripped := *email
+	stripped.Attachments = make([]*models.Attachment, len(email.Attachments))
+	for i, attachment := range email.Attachments {
+		copied := *attachment
+		copied.Content = nil
+		stripped.Attachments[i] = &copied
+	}
+	return &stripped
 }
 
 func (h *Handler) DeleteEmail(c *gin.Context) {
@@ -63,7 +517,11 @@ func (h *Handler) DeleteEmail(c *gin.Context) {
 		return
 	}
 	
-	err = h.storage.DeleteEmail(id)
+	err = h.deleteEmail(id)
+	if errors.Is(err, storage.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Email not found"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -72,16 +530,316 @@ func (h *Handler) DeleteEmail(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Email deleted successfully"})
 }
 
+// deleteEmail deletes an email and its blobs, unless a snapshot holds it
+func (h *Handler) deleteEmail(id int) error {
+	// Look the email up first so its blobs can be removed with it
+	email, _ := h.storage.GetEmail(id)
+
+	if err := h.storage.DeleteEmail(id); err != nil {
+		return err
+	}
+	if email == nil {
+		return nil
+	}
+
+	held, err := storage.HoldsEmail(h.storage, id)
+	if err != nil {
+		log.Printf("Error checking the snapshots of email %d: %v", id, err)
+		return nil
+	}
+	if !held {
+		if err := h.blobs.DeleteEmail(email); err != nil {
+			log.Printf("Error deleting blobs of email %d: %v", id, err)
+		}
+	}
+	return nil
+}
+
 func (h *Handler) ClearEmails(c *gin.Context) {
-	err := h.storage.ClearEmails()
+	// The blobs of the emails snapshots hold must be kept, so the emails
+	// are deleted one by one while there are snapshots
+	if snapshotter, ok := h.storage.(storage.Snapshotter); ok {
+		snapshots, err := snapshotter.ListSnapshots()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if len(snapshots) > 0 {
+			if err := h.clearEmails(); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"message": "All emails cleared"})
+			return
+		}
+	}
+
+	// Only the blobs of the cleared emails are deleted: a message still
+	// being received has committed blobs of its own
+	keys, err := h.currentBlobs()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = h.storage.ClearEmails()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+
+	if err := h.blobs.DeleteKeys(keys); err != nil {
+		log.Printf("Error clearing blobs: %v", err)
+	}
 	
 	c.JSON(http.StatusOK, gin.H{"message": "All emails cleared"})
 }
 
+// currentBlobs returns the keys of the blobs the current emails reference
+func (h *Handler) currentBlobs() ([]string, error) {
+	var keys []string
+	page := storage.PageRequest{Limit: 500}
+	for {
+		result, err := h.storage.GetEmails(storage.EmailFilter{}, page)
+		if err != nil {
+			return nil, err
+		}
+		for _, summary := range result.Emails {
+			email, err := h.storage.GetEmail(summary.ID)
+			if err != nil {
+				// Deleted meanwhile
+				continue
+			}
+			keys = append(keys, blob.Keys(email)...)
+		}
+		if result.NextCursor == "" {
+			return keys, nil
+		}
+		if page.After, err = storage.DecodeCursor(result.NextCursor); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// clearEmails deletes the current emails one by one
+func (h *Handler) clearEmails() error {
+	var ids []int
+	page := storage.PageRequest{Limit: 500}
+	for {
+		result, err := h.storage.GetEmails(storage.EmailFilter{}, page)
+		if err != nil {
+			return err
+		}
+		for _, email := range result.Emails {
+			ids = append(ids, email.ID)
+		}
+		if result.NextCursor == "" {
+			break
+		}
+		if page.After, err = storage.DecodeCursor(result.NextCursor); err != nil {
+			return err
+		}
+	}
+
+	for _, id := range ids {
+		// Emails deleted meanwhile are gone already
+		if err := h.deleteEmail(id); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return fmt.Errorf("failed to delete email %d: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// CreateSnapshot saves the current emails under a name, given as the name
+// query parameter or JSON field, to restore them later
+func (h *Handler) CreateSnapshot(c *gin.Context) {
+	snapshotter, ok := h.snapshotter(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Name string `json:"name"`
+	}
+	request.Name = c.Query("name")
+	if request.Name == "" && c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if request.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing snapshot name"})
+		return
+	}
+
+	snapshot, err := snapshotter.CreateSnapshot(request.Name)
+	if err != nil {
+		h.snapshotError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, snapshot)
+}
+
+func (h *Handler) ListSnapshots(c *gin.Context) {
+	snapshotter, ok := h.snapshotter(c)
+	if !ok {
+		return
+	}
+
+	snapshots, err := snapshotter.ListSnapshots()
+	if err != nil {
+		h.snapshotError(c, err)
+		return
+	}
+	if snapshots == nil {
+		snapshots = []*storage.Snapshot{}
+	}
+	c.JSON(http.StatusOK, gin.H{"snapshots": snapshots})
+}
+
+// RestoreSnapshot rolls the emails back to a snapshot, which is kept
+func (h *Handler) RestoreSnapshot(c *gin.Context) {
+	snapshotter, ok := h.snapshotter(c)
+	if !ok {
+		return
+	}
+
+	name := c.Param("name")
+	purged, err := snapshotter.RestoreSnapshot(name)
+	if err != nil {
+		h.snapshotError(c, err)
+		return
+	}
+	h.deleteBlobs(purged)
+
+	// Restored emails count towards the retention limits again
+	if h.janitor != nil {
+		h.janitor.Notify()
+	}
+	h.hub.Broadcast("snapshot_restored", gin.H{"name": name})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Snapshot restored", "purged": len(purged)})
+}
+
+// DeleteSnapshot deletes a snapshot, along with the deleted emails only it
+// held
+func (h *Handler) DeleteSnapshot(c *gin.Context) {
+	snapshotter, ok := h.snapshotter(c)
+	if !ok {
+		return
+	}
+
+	purged, err := snapshotter.DeleteSnapshot(c.Param("name"))
+	if err != nil {
+		h.snapshotError(c, err)
+		return
+	}
+	h.deleteBlobs(purged)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Snapshot deleted", "purged": len(purged)})
+}
+
+// snapshotter returns the storage as a Snapshotter, or responds that it
+// does not support snapshots
+func (h *Handler) snapshotter(c *gin.Context) (storage.Snapshotter, bool) {
+	snapshotter, ok := h.storage.(storage.Snapshotter)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "the storage backend does not support snapshots"})
+	}
+	return snapshotter, ok
+}
+
+func (h *Handler) snapshotError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, storage.ErrSnapshotNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, storage.ErrSnapshotExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, storage.ErrInvalidSnapshotName):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// deleteBlobs deletes the blobs of emails gone for good
+func (h *Handler) deleteBlobs(emails []*models.Email) {
+	for _, email := range emails {
+		if err := h.blobs.DeleteEmail(email); err != nil {
+			log.Printf("Error deleting blobs of email %d: %v", email.ID, err)
+		}
+	}
+}
+
+// ImportEmails loads mbox, .eml and zip files uploaded as multipart file
+// fields, or a single file sent as the request body
+func (h *Handler) ImportEmails(c *gin.Context) {
+	options := importer.Options{Format: c.Query("format")}
+	if param := c.Query("preserve_dates"); param != "" {
+		value, err := strconv.ParseBool(param)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid preserve_dates parameter"})
+			return
+		}
+		options.PreserveDates = value
+	}
+
+	total := &importer.Result{IDs: []int{}}
+	add := func(result *importer.Result) {
+		if result != nil {
+			total.Imported += result.Imported
+			total.IDs = append(total.IDs, result.IDs...)
+			total.Failed = append(total.Failed, result.Failed...)
+		}
+	}
+	defer func() {
+		if total.Imported > 0 && h.janitor != nil {
+			h.janitor.Notify()
+		}
+	}()
+
+	if !strings.HasPrefix(c.ContentType(), "multipart/") {
+		result, err := h.importer.Import(c.Request.Body, c.DefaultQuery("name", "upload"), options, h.announce)
+		add(result)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "imported": total.Imported, "ids": total.IDs})
+			return
+		}
+		c.JSON(http.StatusOK, total)
+		return
+	}
+
+	// Parts are streamed, so uploads are not buffered before importing
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "imported": total.Imported, "ids": total.IDs})
+			return
+		}
+		if part.FileName() == "" {
+			continue
+		}
+
+		result, err := h.importer.Import(part, part.FileName(), options, h.announce)
+		add(result)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s: %v", part.FileName(), err), "imported": total.Imported, "ids": total.IDs})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, total)
+}
+
 func (h *Handler) GetStats(c *gin.Context) {
 	count, err := h.storage.GetEmailCount()
 	if err != nil {
@@ -93,6 +851,9 @@ func (h *Handler) GetStats(c *gin.Context) {
 		"total_emails": count,
 		"connected_clients": h.hub.GetClientCount(),
 	}
+	if h.janitor != nil {
+		stats["retention"] = h.janitor.Stats()
+	}
 	
 	c.JSON(http.StatusOK, stats)
 }
@@ -105,16 +866,29 @@ func (h *Handler) OnNewEmail(email *models.Email) {
 	// Store email in database
 	err := h.storage.SaveEmail(email)
 	if err != nil {
+		log.Printf("Error saving email: %v", err)
+		h.blobs.DeleteEmail(email)
 		return
 	}
 	
-	// Broadcast to all connected WebSocket clients
+	h.announce(email)
+	
+	// Enforce the retention limits now that there is one more email
+	if h.janitor != nil {
+		h.janitor.Added(email.To, email.Size)
+	}
+}
+
+// announce broadcasts a new email to all connected WebSocket clients
+func (h *Handler) announce(email *models.Email) {
 	summary := &models.EmailSummary{
-		ID:        email.ID,
-		From:      email.From,
-		To:        email.To,
-		Subject:   email.Subject,
-		CreatedAt: email.CreatedAt,
+		ID:           email.ID,
+		From:         email.From,
+		To:           email.To,
+		Subject:      email.Subject,
+		WarningCount: len(email.Warnings),
+		Size:         email.Size,
+		CreatedAt:    email.CreatedAt,
 	}
 	
 	h.hub.Broadcast("new_email", summary)
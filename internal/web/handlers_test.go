@@ -0,0 +1,292 @@
+package web
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"mailcatch/internal/blob"
+	"mailcatch/internal/extract"
+	"mailcatch/internal/models"
+	"mailcatch/internal/smtp"
+	"mailcatch/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+func TestExtractLatestMatchesWholeAddress(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := storage.NewMemoryStorage("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The latest email goes to an address containing bob's
+	for _, email := range []*models.Email{
+		{From: "app@example.com", To: "bob@example.com", Subject: "Your code", Body: "Your code is 123456"},
+		{From: "app@example.com", To: "jimbob@example.com", Subject: "Your code", Body: "Your code is 999999"},
+		{From: "app@example.com", To: "bob@example.com.test", Subject: "Your code", Body: "Your code is 555555"},
+	} {
+		if err := store.SaveEmail(email); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	handler := NewHandler(store, blob.NewMemoryStore(), nil, nil, extract.NewExtractor(), nil, nil, NewWebSocketHub())
+	router := gin.New()
+	router.GET("/api/extract", handler.ExtractLatest)
+
+	tests := []struct {
+		to     string
+		status int
+		value  string
+	}{
+		{"bob@example.com", http.StatusOK, "123456"},
+		{"BOB@example.com", http.StatusOK, "123456"},
+		{"jimbob@example.com", http.StatusOK, "999999"},
+		{"bob@example.com.test", http.StatusOK, "555555"},
+		{"ob@example.com", http.StatusNotFound, ""},
+		{"bob", http.StatusBadRequest, ""},
+		{"Bob <bob@example.com>", http.StatusBadRequest, ""},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/extract?preset=otp&to="+url.QueryEscape(test.to), nil))
+		if recorder.Code != test.status {
+			t.Errorf("to=%s: status %d, want %d: %s", test.to, recorder.Code, test.status, recorder.Body)
+			continue
+		}
+		if test.value == "" {
+			continue
+		}
+		var result extract.Result
+		if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		if result.Value != test.value {
+			t.Errorf("to=%s: value %q, want %q", test.to, result.Value, test.value)
+		}
+	}
+}
+
+// Recipients only named in the To or Cc header, as in imported mail, are
+// found too
+func TestExtractLatestHeaderRecipients(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := storage.NewMemoryStorage("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i, email := range []*models.Email{
+		{From: "app@example.com", To: "alice@example.com", Body: "Your code is 111111",
+			Headers: map[string][]string{"To": {"Alice <alice@example.com>"}, "Cc": {"Carol <carol@example.com>"}}},
+		{From: "app@example.com", To: "dave@example.com", Body: "Your code is 222222",
+			Headers: map[string][]string{"To": {"dave@example.com"}, "Cc": {"jimcarol@example.com"}}},
+		{From: "app@example.com", To: "erin@example.com", Body: "Your code is 333333",
+			Headers: map[string][]string{"To": {"Frank <frank@example.com>"}}},
+	} {
+		email.CreatedAt = base.Add(time.Duration(i) * time.Minute)
+		if err := store.SaveEmail(email); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	handler := NewHandler(store, blob.NewMemoryStore(), nil, nil, extract.NewExtractor(), nil, nil, NewWebSocketHub())
+	router := gin.New()
+	router.GET("/api/extract", handler.ExtractLatest)
+
+	tests := []struct {
+		to     string
+		status int
+		value  string
+	}{
+		{"carol@example.com", http.StatusOK, "111111"},
+		{"frank@example.com", http.StatusOK, "333333"},
+		{"erin@example.com", http.StatusOK, "333333"},
+		{"arol@example.com", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/extract?preset=otp&to="+test.to, nil))
+		if recorder.Code != test.status {
+			t.Errorf("to=%s: status %d, want %d: %s", test.to, recorder.Code, test.status, recorder.Body)
+			continue
+		}
+		if test.value == "" {
+			continue
+		}
+		var result extract.Result
+		if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		if result.Value != test.value {
+			t.Errorf("to=%s: value %q, want %q", test.to, result.Value, test.value)
+		}
+	}
+}
+
+// Clearing deletes the blobs of the cleared emails only, keeping those of
+// a message still being received
+func TestClearEmailsKeepsUnreferencedBlobs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := storage.NewMemoryStorage("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs := blob.NewMemoryStore()
+	put := func(content string) string {
+		t.Helper()
+		key, _, err := blobs.Put(strings.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+
+	raw := put("stored message")
+	attachment := put("stored attachment")
+	receiving := put("attachment of a message in DATA")
+	err = store.SaveEmail(&models.Email{From: "a@example.com", To: "b@example.com", RawBlob: raw,
+		Attachments: []*models.Attachment{{Filename: "report.pdf", Blob: attachment}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := NewHandler(store, blobs, nil, nil, extract.NewExtractor(), nil, nil, NewWebSocketHub())
+	router := gin.New()
+	router.DELETE("/api/emails", handler.ClearEmails)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodDelete, "/api/emails", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body)
+	}
+
+	for key, want := range map[string]bool{raw: false, attachment: false, receiving: true} {
+		r, err := blobs.Open(key)
+		if err == nil {
+			r.Close()
+		}
+		if (err == nil) != want {
+			t.Errorf("blob %s kept = %v, want %v", key, err == nil, want)
+		}
+	}
+}
+
+// Messages received over SMTP are served byte for byte, 8-bit and binary
+// content included
+func TestRawEmailByteExact(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := storage.NewMemoryStorage("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs, err := blob.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keep the message and the attachment in the blob store
+	received := make(chan *models.Email, 1)
+	server := smtp.NewServer("", smtp.Options{Blobs: blobs, InlineLimit: 32}, func(email *models.Email) {
+		if err := store.SaveEmail(email); err != nil {
+			t.Error(err)
+		}
+		received <- email
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+	defer server.Stop()
+
+	attachment := "NUL \x00, bare CR \r in a line, 8-bit \xe9\xff\xfe and UTF-8 \xc3\xa9"
+	message := "From: a@example.com\r\n" +
+		"To: b@example.com\r\n" +
+		"Subject: caf\xc3\xa9 \xe9t\xe9\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=b\r\n" +
+		"\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Transfer-Encoding: 8bit\r\n" +
+		"\r\n" +
+		"Gr\xc3\xbc\xc3\x9fe \x00 and a bare\rCR\r\n" +
+		"--b\r\n" +
+		"Content-Type: application/octet-stream\r\n" +
+		"Content-Disposition: attachment; filename=data.bin\r\n" +
+		"Content-Transfer-Encoding: binary\r\n" +
+		"\r\n" +
+		attachment + "\r\n" +
+		"--b--\r\n"
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	replies := bufio.NewReader(conn)
+	expect := func(code string) {
+		t.Helper()
+		for {
+			line, err := replies.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(line, code) {
+				t.Fatalf("reply %q, want %s", line, code)
+			}
+			if line[3] == ' ' {
+				return
+			}
+		}
+	}
+	expect("220")
+	for _, command := range []struct{ line, code string }{
+		{"EHLO client.example.com", "250"},
+		{"MAIL FROM:<a@example.com> BODY=8BITMIME", "250"},
+		{"RCPT TO:<b@example.com>", "250"},
+		{"DATA", "354"},
+		{message + ".", "250"},
+		{"QUIT", "221"},
+	} {
+		fmt.Fprintf(conn, "%s\r\n", command.line)
+		expect(command.code)
+	}
+
+	var email *models.Email
+	select {
+	case email = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email received")
+	}
+	if email.RawBlob == "" || len(email.Attachments) != 1 || email.Attachments[0].Blob == "" {
+		t.Fatalf("message and attachment not in the blob store: %q %+v", email.RawBlob, email.Attachments)
+	}
+
+	handler := NewHandler(store, blobs, nil, nil, extract.NewExtractor(), nil, nil, NewWebSocketHub())
+	router := gin.New()
+	router.GET("/api/emails/:id/raw", handler.GetRawEmail)
+	router.GET("/api/emails/:id/attachments/:index", handler.DownloadAttachment)
+
+	for path, want := range map[string]string{
+		fmt.Sprintf("/api/emails/%d/raw", email.ID):           message,
+		fmt.Sprintf("/api/emails/%d/attachments/0", email.ID): attachment,
+	} {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		if recorder.Code != http.StatusOK {
+			t.Errorf("%s: status %d", path, recorder.Code)
+			continue
+		}
+		if got := recorder.Body.String(); got != want {
+			t.Errorf("%s:\n%q\nwant\n%q", path, got, want)
+		}
+	}
+}
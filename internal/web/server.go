@@ -4,7 +4,13 @@ import (
 	"io/fs"
 	"net/http"
 
+	"mailcatch/internal/blob"
+	"mailcatch/internal/compat"
+	"mailcatch/internal/extract"
+	"mailcatch/internal/importer"
+	"mailcatch/internal/links"
 	"mailcatch/internal/models"
+	"mailcatch/internal/retention"
 	"mailcatch/internal/storage"
 	"github.com/gin-gonic/gin"
 )
@@ -16,12 +22,12 @@ type Server struct {
 	hub     *WebSocketHub
 }
 
-func NewServer(storage storage.Storage) *Server {
+func NewServer(storage storage.Storage, blobs *blob.Store, linter *compat.Linter, checker *links.Checker, extractor *extract.Extractor, janitor *retention.Janitor, importer *importer.Importer) *Server {
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.Default()
 	
 	hub := NewWebSocketHub()
-	handler := NewHandler(storage, hub)
+	handler := NewHandler(storage, blobs, linter, checker, extractor, janitor, importer, hub)
 	
 	server := &Server{
 		router:  router,
@@ -41,6 +47,21 @@ func (s *Server) setupRoutes() {
 	{
 		api.GET("/emails", s.handler.GetEmails)
 		api.GET("/emails/:id", s.handler.GetEmail)
+		api.GET("/emails/:id/raw", s.handler.GetRawEmail)
+		api.GET("/emails/:id/download", s.handler.DownloadEmail)
+		api.GET("/emails/:id/attachments/:index", s.handler.DownloadAttachment)
+		api.GET("/emails/:id/compat", s.handler.GetCompat)
+		api.GET("/emails/:id/links", s.handler.GetLinks)
+		api.GET("/emails/:id/extract", s.handler.ExtractFromEmail)
+		api.GET("/extract", s.handler.ExtractLatest)
+		api.GET("/extract/presets", s.handler.GetExtractPresets)
+		api.GET("/export", s.handler.ExportEmails)
+		api.POST("/import", s.handler.ImportEmails)
+		api.GET("/admin/backup", s.handler.Backup)
+		api.POST("/snapshots", s.handler.CreateSnapshot)
+		api.GET("/snapshots", s.handler.ListSnapshots)
+		api.POST("/snapshots/:name/restore", s.handler.RestoreSnapshot)
+		api.DELETE("/snapshots/:name", s.handler.DeleteSnapshot)
 		api.DELETE("/emails/:id", s.handler.DeleteEmail)
 		api.DELETE("/emails", s.handler.ClearEmails)
 		api.GET("/stats", s.handler.GetStats)